@@ -0,0 +1,445 @@
+package config
+
+import (
+    "io"
+    "os"
+    "fmt"
+    "net"
+    "flag"
+    "errors"
+    "reflect"
+    "strings"
+
+    "github.com/zmarcantel/phonebook/server/logging"
+)
+
+// what the binary does when neither flags nor the file say otherwise
+const (
+    DEFAULT_LISTEN      string      = "127.0.0.1:53"
+    DEFAULT_LOG_LEVEL   string      = "info"
+    DEFAULT_BACKEND     string      = "memory"
+    DEFAULT_METRICS     string      = ""                    // metrics are opt-in
+)
+
+var ErrNoListeners      error       = errors.New("ERROR: At least one listen address is required")
+var ErrNoBackend        error       = errors.New("ERROR: A storage backend is required")
+var ErrListener         error       = errors.New("ERROR: Listen addresses look like host:port, udp://host:port, or tcp://host:port")
+var ErrZoneFile         error       = errors.New("ERROR: Zones need both an origin and a file (origin=path on the command line)")
+var ErrOption           error       = errors.New("ERROR: Storage options look like key=value")
+
+//----------------------------------------------
+// Configuration Structures
+//----------------------------------------------
+
+//
+// Everything the phonebook binary needs to start, read from a TOML file and flags (see FromArgs)
+//
+//    log_level = "info"
+//    listen = [ "127.0.0.1:53", "tcp://[::1]:53" ]
+//
+//    [storage]
+//    backend = "memory"              # any other keys are options for the backend
+//
+//    [metrics]
+//    listen = "127.0.0.1:9153"       # unset or "" serves no metrics
+//
+//    [api]
+//    listen = "127.0.0.1:8053"
+//    token = "secret"
+//
+//    [[zone]]
+//    origin = "zed.io"
+//    file = "/etc/phonebook/zed.io.zone"
+//
+type Config struct {
+    Listen          []Listener
+    LogLevel        string
+    Storage         Storage
+    Zones           []ZoneFile
+    Metrics         string                  // address to serve Prometheus metrics at (empty serves none)
+    API             string                  // address to serve the management API at (empty serves none)
+    APIToken        string                  // bearer token the API requires
+}
+
+//
+// An address to take queries at, over one protocol or (by default) both
+//
+type Listener struct {
+    Protocol        string                  // "udp", "tcp", or empty for both
+    Address         string                  // host:port
+}
+
+//
+// The store records are kept in, and its backend-specific options
+//
+type Storage struct {
+    Backend         string
+    Options         map[string]string
+}
+
+//
+// A master file to load and serve as a zone
+//
+type ZoneFile struct {
+    Origin          string
+    Path            string
+}
+
+//
+// The configuration used when nothing is given
+//
+func Default() *Config {
+    return &Config{
+        Listen:     []Listener{ { Address: DEFAULT_LISTEN } },
+        LogLevel:   DEFAULT_LOG_LEVEL,
+        Storage:    Storage{ Backend: DEFAULT_BACKEND, Options: make(map[string]string, 0) },
+        Zones:      make([]ZoneFile, 0),
+        Metrics:    DEFAULT_METRICS,
+    }
+}
+
+//
+// Read a listen address: "host:port" for both protocols, or "udp://host:port" and "tcp://host:port" for one
+//
+func ParseListener(source string) (Listener, error) {
+    var result = Listener{ Address: source }
+
+    if index := strings.Index(source, "://") ; index >= 0 {
+        result.Protocol = strings.ToLower(source[:index])
+        result.Address = source[index + 3:]
+    }
+
+    if result.Protocol != "" && result.Protocol != "udp" && result.Protocol != "tcp" {
+        return result, ErrListener
+    }
+    if _, _, err := net.SplitHostPort(result.Address) ; err != nil {
+        return result, ErrListener
+    }
+
+    return result, nil
+}
+
+//
+// The protocols to listen with
+//
+func (self Listener) Protocols() []string {
+    if self.Protocol == "" { return []string{ "udp", "tcp" } }
+    return []string{ self.Protocol }
+}
+
+func (self Listener) String() string {
+    if self.Protocol == "" { return self.Address }
+    return self.Protocol + "://" + self.Address
+}
+
+//
+// Check the configuration is complete and consistent
+//
+func (self *Config) Validate() error {
+    if len(self.Listen) == 0 { return ErrNoListeners }
+
+    for _, listener := range self.Listen {
+        if _, err := ParseListener(listener.String()) ; err != nil { return err }
+    }
+
+    if _, err := logging.ParseLevel(self.LogLevel) ; err != nil { return err }
+    if self.Storage.Backend == "" { return ErrNoBackend }
+
+    for _, zone := range self.Zones {
+        if zone.Origin == "" || zone.Path == "" { return ErrZoneFile }
+    }
+
+    return nil
+}
+
+//
+// Name the settings that differ in another configuration and cannot change while running (listeners and storage)
+// Zones and the log level can, so are not compared
+//
+func (self *Config) Changed(other *Config) []string {
+    var result = make([]string, 0)
+
+    if !reflect.DeepEqual(self.Listen, other.Listen) { result = append(result, "listen") }
+    if self.Storage.Backend != other.Storage.Backend || !equalOptions(self.Storage.Options, other.Storage.Options) {
+        result = append(result, "storage")
+    }
+    if self.Metrics != other.Metrics { result = append(result, "metrics") }
+    if self.API != other.API || self.APIToken != other.APIToken { result = append(result, "api") }
+
+    return result
+}
+
+// options compare by content, none and empty alike
+func equalOptions(left, right map[string]string) bool {
+    if len(left) != len(right) { return false }
+    for key, value := range left {
+        if other, exists := right[key] ; !exists || other != value { return false }
+    }
+    return true
+}
+
+//----------------------------------------------
+// Config Files
+//----------------------------------------------
+
+//
+// Read a TOML config file over the defaults
+//
+func Load(path string) (*Config, error) {
+    var result = Default()
+    if err := result.LoadFile(path) ; err != nil { return nil, err }
+
+    return result, result.Validate()
+}
+
+//
+// Read a TOML config file over the current settings
+//
+func (self *Config) LoadFile(path string) error {
+    file, err := os.Open(path)
+    if err != nil { return err }
+    defer file.Close()
+
+    return self.Read(file)
+}
+
+//
+// Read a TOML document over the current settings -- unknown keys are errors, so typos are not silently ignored
+//
+func (self *Config) Read(source io.Reader) error {
+    content, err := io.ReadAll(source)
+    if err != nil { return err }
+
+    document, err := parseTOML(string(content))
+    if err != nil { return err }
+
+    for key, value := range document {
+        switch key {
+            case "log_level":
+                self.LogLevel, err = stringValue(key, value)
+
+            case "listen":
+                self.Listen, err = listenValue(key, value)
+
+            case "storage":
+                err = self.readStorage(value)
+
+            case "metrics":
+                err = readTable(key, value, map[string]*string{ "listen": &self.Metrics })
+
+            case "api":
+                err = readTable(key, value, map[string]*string{ "listen": &self.API, "token": &self.APIToken })
+
+            case "zone":
+                err = self.readZones(value)
+
+            default:
+                err = unknownKey(key)
+        }
+
+        if err != nil { return err }
+    }
+
+    return nil
+}
+
+//
+// The [storage] table: the backend, and any other keys as its options
+//
+func (self *Config) readStorage(value interface{}) error {
+    var table, isTable = value.(map[string]interface{})
+    if !isTable { return typeError("storage", "a table") }
+
+    if self.Storage.Options == nil { self.Storage.Options = make(map[string]string, 0) }
+
+    for key, option := range table {
+        if key == "backend" {
+            backend, err := stringValue("storage.backend", option)
+            if err != nil { return err }
+            self.Storage.Backend = backend
+            continue
+        }
+
+        switch option.(type) {
+            case string, int64, bool:
+                self.Storage.Options[key] = fmt.Sprint(option)
+            default:
+                return typeError("storage." + key, "a string, integer, or boolean")
+        }
+    }
+
+    return nil
+}
+
+//
+// The [[zone]] tables, each with an origin and a file
+//
+func (self *Config) readZones(value interface{}) error {
+    var tables, isArray = value.([]map[string]interface{})
+    if !isArray { return typeError("zone", "an array of tables ([[zone]])") }
+
+    for _, table := range tables {
+        var zone ZoneFile
+        if err := readTable("zone", table, map[string]*string{ "origin": &zone.Origin, "file": &zone.Path }) ; err != nil {
+            return err
+        }
+        if zone.Origin == "" || zone.Path == "" { return ErrZoneFile }
+
+        self.Zones = append(self.Zones, zone)
+    }
+
+    return nil
+}
+
+//
+// Read a table of string values into the given fields
+//
+func readTable(name string, value interface{}, fields map[string]*string) error {
+    var table, isTable = value.(map[string]interface{})
+    if !isTable { return typeError(name, "a table") }
+
+    for key, field := range table {
+        var into, known = fields[key]
+        if !known { return unknownKey(name + "." + key) }
+
+        var err error
+        *into, err = stringValue(name + "." + key, field)
+        if err != nil { return err }
+    }
+
+    return nil
+}
+
+func stringValue(key string, value interface{}) (string, error) {
+    var result, isString = value.(string)
+    if !isString { return "", typeError(key, "a string") }
+    return result, nil
+}
+
+//
+// A single listen address, or an array of them
+//
+func listenValue(key string, value interface{}) ([]Listener, error) {
+    var addresses = make([]string, 0)
+
+    switch typed := value.(type) {
+        case string:
+            addresses = append(addresses, typed)
+        case []interface{}:
+            for _, item := range typed {
+                address, err := stringValue(key, item)
+                if err != nil { return nil, err }
+                addresses = append(addresses, address)
+            }
+        default:
+            return nil, typeError(key, "a string or array of strings")
+    }
+
+    var result = make([]Listener, 0, len(addresses))
+    for _, address := range addresses {
+        listener, err := ParseListener(address)
+        if err != nil { return nil, err }
+        result = append(result, listener)
+    }
+
+    return result, nil
+}
+
+func typeError(key, expected string) error {
+    return errors.New(fmt.Sprintf("ERROR: config %q must be %s", key, expected))
+}
+
+func unknownKey(key string) error {
+    return errors.New(fmt.Sprintf("ERROR: config %q is not a known setting", key))
+}
+
+//----------------------------------------------
+// Command Line Flags
+//----------------------------------------------
+
+//
+// A flag that may be given more than once
+//
+type listFlag []string
+
+func (self *listFlag) String() string {
+    return strings.Join(*self, ", ")
+}
+
+func (self *listFlag) Set(value string) error {
+    *self = append(*self, value)
+    return nil
+}
+
+//
+// Build the configuration from the command line -- the defaults, then the file named by -config, then the other flags
+// Usage and errors are written to output; asking for -help returns flag.ErrHelp
+//
+func FromArgs(name string, args []string, output io.Writer) (*Config, error) {
+    var flags = flag.NewFlagSet(name, flag.ContinueOnError)
+    flags.SetOutput(output)
+
+    var file = flags.String("config", "", "TOML config file to read before the other flags")
+    var listen, zones, options listFlag
+    flags.Var(&listen, "listen", "address to take queries at: host:port, udp://host:port, or tcp://host:port (repeatable, replaces the file's)")
+    flags.Var(&zones, "zone", "origin=path of a zone file to serve (repeatable, adds to the file's)")
+    flags.Var(&options, "storage-option", "key=value option for the storage backend (repeatable)")
+    var level = flags.String("log-level", DEFAULT_LOG_LEVEL, "least severe level logged: debug, info, warn, or error")
+    var backend = flags.String("storage", DEFAULT_BACKEND, "storage backend")
+    var metrics = flags.String("metrics", DEFAULT_METRICS, "address to serve Prometheus metrics at (\"\" for none, the default)")
+    var api = flags.String("api", "", "address to serve the management API at (\"\" for none)")
+    var token = flags.String("api-token", "", "bearer token the management API requires (better kept in the config file)")
+
+    if err := flags.Parse(args) ; err != nil { return nil, err }
+    if flags.NArg() > 0 { return nil, errors.New(fmt.Sprintf("ERROR: unexpected argument %q", flags.Arg(0))) }
+
+    var result = Default()
+    if *file != "" {
+        if err := result.LoadFile(*file) ; err != nil { return nil, err }
+    }
+
+    // only the flags actually given override the file
+    var err error
+    flags.Visit(func(given *flag.Flag) {
+        if err != nil { return }
+
+        switch given.Name {
+            case "listen":
+                result.Listen = make([]Listener, 0, len(listen))
+                for _, address := range listen {
+                    var listener Listener
+                    if listener, err = ParseListener(address) ; err != nil { return }
+                    result.Listen = append(result.Listen, listener)
+                }
+
+            case "zone":
+                for _, zone := range zones {
+                    var origin, path, found = strings.Cut(zone, "=")
+                    if !found || origin == "" || path == "" {
+                        err = ErrZoneFile
+                        return
+                    }
+                    result.Zones = append(result.Zones, ZoneFile{ Origin: origin, Path: path })
+                }
+
+            case "storage-option":
+                for _, option := range options {
+                    var key, value, found = strings.Cut(option, "=")
+                    if !found || key == "" {
+                        err = ErrOption
+                        return
+                    }
+                    result.Storage.Options[key] = value
+                }
+
+            case "log-level":       result.LogLevel = *level
+            case "storage":         result.Storage.Backend = *backend
+            case "metrics":         result.Metrics = *metrics
+            case "api":             result.API = *api
+            case "api-token":       result.APIToken = *token
+        }
+    })
+    if err != nil { return nil, err }
+
+    return result, result.Validate()
+}
@@ -0,0 +1,286 @@
+package config
+
+import (
+    "os"
+    "flag"
+    "bytes"
+    "reflect"
+    "strings"
+    "testing"
+    "path/filepath"
+)
+
+var testConfigFile = `# a full config
+log_level = "debug"
+listen = [
+    "127.0.0.1:5353",       # both protocols
+    "tcp://[::1]:5353",
+]
+
+[storage]
+backend = "bolt"
+path = '/var/lib/phonebook/records.db'
+timeout = 5
+readonly = false
+
+[metrics]
+listen = ""
+
+[api]
+listen = "127.0.0.1:8053"
+token = "s3cr\u00e9t"
+
+[[zone]]
+origin = "zed.io"
+file = "/etc/phonebook/zed.io.zone"
+
+[[zone]]
+origin = "example.com"
+file = "/etc/phonebook/example.com.zone"
+`
+
+//----------------------------------------------
+// TOML Tests
+//----------------------------------------------
+
+func TestParseTOML(t *testing.T) {
+    document, err := parseTOML(testConfigFile)
+    if err != nil { t.Fatal(err) }
+
+    var expected = map[string]interface{}{
+        "log_level":    "debug",
+        "listen":       []interface{}{ "127.0.0.1:5353", "tcp://[::1]:5353" },
+        "storage":      map[string]interface{}{ "backend": "bolt", "path": "/var/lib/phonebook/records.db", "timeout": int64(5), "readonly": false },
+        "metrics":      map[string]interface{}{ "listen": "" },
+        "api":          map[string]interface{}{ "listen": "127.0.0.1:8053", "token": "s3crét" },
+        "zone":         []map[string]interface{}{
+            { "origin": "zed.io", "file": "/etc/phonebook/zed.io.zone" },
+            { "origin": "example.com", "file": "/etc/phonebook/example.com.zone" },
+        },
+    }
+
+    if !reflect.DeepEqual(document, expected) {
+        t.Errorf("Incorrect Document:\n\tExpected: %+v\n\tGot: %+v\n", expected, document)
+    }
+}
+
+func TestParseTOML_Nested(t *testing.T) {
+    document, err := parseTOML("[a.b]\nc = 1_000\n[a]\nd = [ [ 1, 2 ], [] ]\n[[a.e]]\nf = -3\n[[a.e]]\n\"g.h\" = true\n")
+    if err != nil { t.Fatal(err) }
+
+    var expected = map[string]interface{}{
+        "a": map[string]interface{}{
+            "b": map[string]interface{}{ "c": int64(1000) },
+            "d": []interface{}{ []interface{}{ int64(1), int64(2) }, []interface{}{} },
+            "e": []map[string]interface{}{ { "f": int64(-3) }, { "g.h": true } },
+        },
+    }
+
+    if !reflect.DeepEqual(document, expected) {
+        t.Errorf("Incorrect Document:\n\tExpected: %+v\n\tGot: %+v\n", expected, document)
+    }
+}
+
+func TestParseTOML_Errors(t *testing.T) {
+    var cases = map[string]string{
+        "no value":             "key =\n",
+        "no equals":            "key \"value\"\n",
+        "unterminated string":  "key = \"value\n",
+        "unterminated array":   "key = [ 1, 2\n",
+        "bad escape":           "key = \"\\q\"\n",
+        "float":                "key = 1.5\n",
+        "inline table":         "key = { a = 1 }\n",
+        "duplicate key":        "key = 1\nkey = 2\n",
+        "duplicate table":      "[a]\nb = 1\n[a]\nc = 2\n",
+        "table over value":     "a = 1\n[a]\n",
+        "trailing junk":        "key = 1 2\n",
+        "unclosed header":      "[a\n",
+    }
+
+    for name, source := range cases {
+        if _, err := parseTOML(source) ; err == nil {
+            t.Errorf("Expected Error:\n\tCase: %s\n", name)
+        }
+    }
+
+    // errors say where
+    if _, err := parseTOML("a = 1\n\nb = ?\n") ; err == nil || !strings.Contains(err.Error(), "line 3") {
+        t.Errorf("Incorrect Error:\n\tExpected: %s\n\tGot: %v\n", "line 3", err)
+    }
+}
+
+//----------------------------------------------
+// Config Tests
+//----------------------------------------------
+
+func TestConfig_Read(t *testing.T) {
+    var config = Default()
+    if err := config.Read(strings.NewReader(testConfigFile)) ; err != nil { t.Fatal(err) }
+
+    var expected = &Config{
+        Listen:     []Listener{ { "", "127.0.0.1:5353" }, { "tcp", "[::1]:5353" } },
+        LogLevel:   "debug",
+        Storage:    Storage{ "bolt", map[string]string{ "path": "/var/lib/phonebook/records.db", "timeout": "5", "readonly": "false" } },
+        Zones:      []ZoneFile{ { "zed.io", "/etc/phonebook/zed.io.zone" }, { "example.com", "/etc/phonebook/example.com.zone" } },
+        Metrics:    "",
+        API:        "127.0.0.1:8053",
+        APIToken:   "s3crét",
+    }
+
+    if !reflect.DeepEqual(config, expected) {
+        t.Errorf("Incorrect Config:\n\tExpected: %+v\n\tGot: %+v\n", expected, config)
+    }
+    if err := config.Validate() ; err != nil {
+        t.Errorf("Valid config rejected: %v\n", err)
+    }
+}
+
+func TestConfig_ReadErrors(t *testing.T) {
+    var cases = map[string]string{
+        "unknown key":          "colour = \"blue\"\n",
+        "unknown table key":    "[api]\nport = 8053\n",
+        "wrong type":           "log_level = 3\n",
+        "bad listener":         "listen = \"sctp://127.0.0.1:53\"\n",
+        "listener without port": "listen = [ \"127.0.0.1\" ]\n",
+        "zone without file":    "[[zone]]\norigin = \"zed.io\"\n",
+        "zone as a table":      "[zone]\norigin = \"zed.io\"\n",
+        "array option":         "[storage]\nhosts = [ \"a\", \"b\" ]\n",
+    }
+
+    for name, source := range cases {
+        if err := Default().Read(strings.NewReader(source)) ; err == nil {
+            t.Errorf("Expected Error:\n\tCase: %s\n", name)
+        }
+    }
+}
+
+func TestConfig_Validate(t *testing.T) {
+    if err := Default().Validate() ; err != nil {
+        t.Errorf("Default config rejected: %v\n", err)
+    }
+
+    var cases = []struct {
+        name        string
+        change      func(*Config)
+    }{
+        { "no listeners", func(c *Config) { c.Listen = nil } },
+        { "bad level", func(c *Config) { c.LogLevel = "loud" } },
+        { "no backend", func(c *Config) { c.Storage.Backend = "" } },
+        { "half a zone", func(c *Config) { c.Zones = []ZoneFile{ { Origin: "zed.io" } } } },
+    }
+
+    for _, test := range cases {
+        var config = Default()
+        test.change(config)
+        if err := config.Validate() ; err == nil {
+            t.Errorf("Expected Error:\n\tCase: %s\n", test.name)
+        }
+    }
+}
+
+func TestParseListener(t *testing.T) {
+    var cases = map[string]Listener{
+        "127.0.0.1:53":         { "", "127.0.0.1:53" },
+        "UDP://0.0.0.0:53":     { "udp", "0.0.0.0:53" },
+        "tcp://[::1]:5353":     { "tcp", "[::1]:5353" },
+        ":53":                  { "", ":53" },
+    }
+
+    for source, expected := range cases {
+        if listener, err := ParseListener(source) ; err != nil || listener != expected {
+            t.Errorf("Incorrect Listener:\n\tCase: %s\n\tExpected: %+v\n\tGot: %+v %v\n", source, expected, listener, err)
+        }
+    }
+
+    if protocols := (Listener{ Address: ":53" }).Protocols() ; len(protocols) != 2 {
+        t.Errorf("Incorrect Protocols:\n\tExpected: %s\n\tGot: %v\n", "[udp tcp]", protocols)
+    }
+}
+
+//----------------------------------------------
+// Flag Tests
+//----------------------------------------------
+
+func TestFromArgs(t *testing.T) {
+    var path = filepath.Join(t.TempDir(), "phonebook.toml")
+    if err := os.WriteFile(path, []byte(testConfigFile), 0600) ; err != nil { t.Fatal(err) }
+
+    // flags override the file, which overrides the defaults
+    config, err := FromArgs("phonebook", []string{
+        "-config", path,
+        "-listen", "udp://0.0.0.0:53", "-listen", "tcp://0.0.0.0:53",
+        "-zone", "example.org=/etc/phonebook/example.org.zone",
+        "-storage-option", "path=/tmp/records.db",
+        "-log-level", "warn",
+    }, &bytes.Buffer{})
+    if err != nil { t.Fatal(err) }
+
+    if !reflect.DeepEqual(config.Listen, []Listener{ { "udp", "0.0.0.0:53" }, { "tcp", "0.0.0.0:53" } }) {
+        t.Errorf("Incorrect Listen:\n\tExpected: %s\n\tGot: %+v\n", "the flags' addresses", config.Listen)
+    }
+    if len(config.Zones) != 3 || config.Zones[2].Origin != "example.org" {
+        t.Errorf("Incorrect Zones:\n\tExpected: %s\n\tGot: %+v\n", "the file's zones and example.org", config.Zones)
+    }
+    if config.Storage.Backend != "bolt" || config.Storage.Options["path"] != "/tmp/records.db" || config.Storage.Options["timeout"] != "5" {
+        t.Errorf("Incorrect Storage:\n\tExpected: %s\n\tGot: %+v\n", "bolt at /tmp/records.db", config.Storage)
+    }
+    if config.LogLevel != "warn" || config.API != "127.0.0.1:8053" || config.Metrics != "" {
+        t.Errorf("Incorrect Config:\n\tExpected: %s\n\tGot: %+v\n", "warn, the file's listeners", config)
+    }
+
+    // with nothing given, the defaults
+    config, err = FromArgs("phonebook", nil, &bytes.Buffer{})
+    if err != nil || !reflect.DeepEqual(config, Default()) {
+        t.Errorf("Incorrect Default:\n\tExpected: %+v\n\tGot: %+v %v\n", Default(), config, err)
+    }
+
+    // which open nothing beyond the DNS listeners
+    if config.Metrics != "" || config.API != "" {
+        t.Errorf("Incorrect Default:\n\tExpected: no metrics or API listeners\n\tGot: %q, %q\n", config.Metrics, config.API)
+    }
+}
+
+func TestFromArgs_Errors(t *testing.T) {
+    var cases = map[string][]string{
+        "missing file":     { "-config", "/nonexistent/phonebook.toml" },
+        "bad listener":     { "-listen", "127.0.0.1" },
+        "bad zone":         { "-zone", "zed.io" },
+        "bad option":       { "-storage-option", "path" },
+        "bad level":        { "-log-level", "loud" },
+        "unknown flag":     { "-colour", "blue" },
+        "stray argument":   { "serve" },
+    }
+
+    for name, args := range cases {
+        if _, err := FromArgs("phonebook", args, &bytes.Buffer{}) ; err == nil {
+            t.Errorf("Expected Error:\n\tCase: %s\n", name)
+        }
+    }
+
+    var usage bytes.Buffer
+    if _, err := FromArgs("phonebook", []string{ "-help" }, &usage) ; err != flag.ErrHelp || !strings.Contains(usage.String(), "-listen") {
+        t.Errorf("Incorrect Help:\n\tExpected: %v and usage\n\tGot: %v %q\n", flag.ErrHelp, err, usage.String())
+    }
+}
+
+func TestConfig_Changed(t *testing.T) {
+    var base = Default()
+    if changed := base.Changed(Default()) ; len(changed) != 0 {
+        t.Errorf("Incorrect Changes:\n\tExpected: %s\n\tGot: %v\n", "none", changed)
+    }
+
+    // zones and the log level may change while running
+    var reloaded = Default()
+    reloaded.LogLevel = "debug"
+    reloaded.Zones = []ZoneFile{ { "zed.io", "zed.io.zone" } }
+    if changed := base.Changed(reloaded) ; len(changed) != 0 {
+        t.Errorf("Incorrect Changes:\n\tExpected: %s\n\tGot: %v\n", "none", changed)
+    }
+
+    reloaded.Listen = []Listener{ { "udp", "0.0.0.0:53" } }
+    reloaded.Storage.Options["path"] = "records.db"
+    reloaded.APIToken = "new"
+    if changed := base.Changed(reloaded) ; !reflect.DeepEqual(changed, []string{ "listen", "storage", "api" }) {
+        t.Errorf("Incorrect Changes:\n\tExpected: %v\n\tGot: %v\n", []string{ "listen", "storage", "api" }, changed)
+    }
+}
@@ -0,0 +1,355 @@
+package config
+
+import (
+    "fmt"
+    "errors"
+    "strconv"
+    "strings"
+    "unicode/utf8"
+)
+
+//----------------------------------------------
+// TOML Subset
+//----------------------------------------------
+
+//
+// The part of TOML (v1.0) a config file needs:
+//
+//    key = value                 bare ("log_level") or quoted ("a.b") keys
+//    [table], [table.sub]        tables, holding the keys after them
+//    [[table]]                   arrays of tables, one more element per header
+//
+// Values are strings ("basic" with escapes or 'literal'), integers, booleans, and arrays of them
+// (which may span lines). Floats, dates, inline tables, dotted keys, and multi-line strings are not supported
+//
+// Tables decode to map[string]interface{}, arrays of tables to []map[string]interface{},
+// arrays to []interface{}, integers to int64
+//
+func parseTOML(source string) (map[string]interface{}, error) {
+    var parser = &tomlParser{ source: source, line: 1 }
+    var root = make(map[string]interface{}, 0)
+    var current = root
+
+    for {
+        parser.skipSpace(true)
+        if parser.done() { break }
+
+        var c = parser.peek()
+        if c == '[' {
+            table, err := parser.header(root)
+            if err != nil { return nil, err }
+            current = table
+        } else {
+            if err := parser.keyValue(current) ; err != nil { return nil, err }
+        }
+
+        // nothing else may follow on the line
+        parser.skipSpace(false)
+        if !parser.done() && parser.peek() != '\n' {
+            return nil, parser.errorf("unexpected %q after value", parser.peek())
+        }
+    }
+
+    return root, nil
+}
+
+type tomlParser struct {
+    source          string
+    offset          int
+    line            int
+}
+
+func (self *tomlParser) done() bool {
+    return self.offset >= len(self.source)
+}
+
+func (self *tomlParser) peek() rune {
+    var c, _ = utf8.DecodeRuneInString(self.source[self.offset:])
+    return c
+}
+
+func (self *tomlParser) next() rune {
+    var c, size = utf8.DecodeRuneInString(self.source[self.offset:])
+    self.offset += size
+    if c == '\n' { self.line += 1 }
+    return c
+}
+
+func (self *tomlParser) errorf(format string, args ...interface{}) error {
+    return errors.New(fmt.Sprintf("ERROR: config line %d: %s", self.line, fmt.Sprintf(format, args...)))
+}
+
+//
+// Skip spaces and comments -- and line breaks too, if told to
+//
+func (self *tomlParser) skipSpace(newlines bool) {
+    for !self.done() {
+        switch self.peek() {
+            case ' ', '\t', '\r':
+                self.next()
+            case '\n':
+                if !newlines { return }
+                self.next()
+            case '#':
+                for !self.done() && self.peek() != '\n' { self.next() }
+            default:
+                return
+        }
+    }
+}
+
+//
+// Read a [table] or [[array]] header, returning the table the keys after it go in
+//
+func (self *tomlParser) header(root map[string]interface{}) (map[string]interface{}, error) {
+    self.next()
+    var array = !self.done() && self.peek() == '['
+    if array { self.next() }
+
+    var path = make([]string, 0)
+    for {
+        self.skipSpace(false)
+        key, err := self.key()
+        if err != nil { return nil, err }
+        path = append(path, key)
+
+        self.skipSpace(false)
+        if self.done() { return nil, self.errorf("unterminated table header") }
+        if self.peek() == '.' {
+            self.next()
+            continue
+        }
+        if self.next() != ']' { return nil, self.errorf("expected ']' to close the table header") }
+        if array && (self.done() || self.next() != ']') { return nil, self.errorf("expected ']]' to close the table header") }
+        break
+    }
+
+    // walk down to the parent, through the latest element of any arrays of tables
+    var table = root
+    for _, key := range path[:len(path) - 1] {
+        switch existing := table[key].(type) {
+            case nil:
+                var created = make(map[string]interface{}, 0)
+                table[key] = created
+                table = created
+            case map[string]interface{}:
+                table = existing
+            case []map[string]interface{}:
+                table = existing[len(existing) - 1]
+            default:
+                return nil, self.errorf("%q is already a value", key)
+        }
+    }
+
+    var last = path[len(path) - 1]
+    var created = make(map[string]interface{}, 0)
+    switch existing := table[last].(type) {
+        case nil:
+            if array {
+                table[last] = []map[string]interface{}{ created }
+            } else {
+                table[last] = created
+            }
+        case []map[string]interface{}:
+            if !array { return nil, self.errorf("%q is already an array of tables", last) }
+            table[last] = append(existing, created)
+        case map[string]interface{}:
+            // a table implicitly created by a deeper header may be defined once
+            if array || len(existing) > 0 && !implicit(existing) { return nil, self.errorf("table %q is defined twice", last) }
+            created = existing
+        default:
+            return nil, self.errorf("%q is already a value", last)
+    }
+
+    return created, nil
+}
+
+//
+// Check if a table holds nothing but other tables (it was only created on the way to one)
+//
+func implicit(table map[string]interface{}) bool {
+    for _, value := range table {
+        switch value.(type) {
+            case map[string]interface{}, []map[string]interface{}:
+            default:
+                return false
+        }
+    }
+    return true
+}
+
+//
+// Read a "key = value" line into the table
+//
+func (self *tomlParser) keyValue(table map[string]interface{}) error {
+    key, err := self.key()
+    if err != nil { return err }
+
+    self.skipSpace(false)
+    if self.done() || self.next() != '=' { return self.errorf("expected '=' after %q", key) }
+    self.skipSpace(false)
+
+    if _, exists := table[key] ; exists { return self.errorf("%q is defined twice", key) }
+
+    value, err := self.value()
+    if err != nil { return err }
+
+    table[key] = value
+    return nil
+}
+
+//
+// Read a bare or quoted key
+//
+func (self *tomlParser) key() (string, error) {
+    if self.done() { return "", self.errorf("expected a key") }
+
+    switch self.peek() {
+        case '"':
+            return self.basicString()
+        case '\'':
+            return self.literalString()
+    }
+
+    var start = self.offset
+    for !self.done() {
+        var c = self.peek()
+        if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-') { break }
+        self.next()
+    }
+
+    if self.offset == start { return "", self.errorf("expected a key, found %q", self.peek()) }
+    return self.source[start:self.offset], nil
+}
+
+//
+// Read a string, integer, boolean, or array
+//
+func (self *tomlParser) value() (interface{}, error) {
+    if self.done() { return nil, self.errorf("expected a value") }
+
+    switch c := self.peek() ; {
+        case c == '"':
+            if strings.HasPrefix(self.source[self.offset:], `"""`) { return nil, self.errorf("multi-line strings are not supported") }
+            return self.basicString()
+
+        case c == '\'':
+            if strings.HasPrefix(self.source[self.offset:], `'''`) { return nil, self.errorf("multi-line strings are not supported") }
+            return self.literalString()
+
+        case c == '[':
+            return self.array()
+
+        case c == '{':
+            return nil, self.errorf("inline tables are not supported")
+    }
+
+    // a bare word: true, false, or an integer
+    var start = self.offset
+    for !self.done() && !strings.ContainsRune(" \t\r\n,]#", self.peek()) { self.next() }
+    var word = self.source[start:self.offset]
+
+    switch word {
+        case "true":    return true, nil
+        case "false":   return false, nil
+    }
+
+    if strings.HasPrefix(word, "_") || strings.HasSuffix(word, "_") || strings.Contains(word, "__") {
+        return nil, self.errorf("invalid integer %q", word)
+    }
+
+    number, err := strconv.ParseInt(strings.Replace(word, "_", "", -1), 0, 64)
+    if err != nil { return nil, self.errorf("unsupported value %q", word) }
+    return number, nil
+}
+
+//
+// Read an array, which may span lines and end with a trailing comma
+//
+func (self *tomlParser) array() ([]interface{}, error) {
+    self.next()
+    var result = make([]interface{}, 0)
+
+    for {
+        self.skipSpace(true)
+        if self.done() { return nil, self.errorf("unterminated array") }
+        if self.peek() == ']' {
+            self.next()
+            return result, nil
+        }
+
+        value, err := self.value()
+        if err != nil { return nil, err }
+        result = append(result, value)
+
+        self.skipSpace(true)
+        if self.done() { return nil, self.errorf("unterminated array") }
+
+        switch self.next() {
+            case ',':
+                continue
+            case ']':
+                return result, nil
+            default:
+                return nil, self.errorf("expected ',' or ']' in array")
+        }
+    }
+}
+
+//
+// Read a "basic string" with its escapes
+//
+func (self *tomlParser) basicString() (string, error) {
+    self.next()
+    var result strings.Builder
+
+    for {
+        if self.done() || self.peek() == '\n' { return "", self.errorf("unterminated string") }
+
+        var c = self.next()
+        switch c {
+            case '"':
+                return result.String(), nil
+
+            case '\\':
+                if self.done() { return "", self.errorf("unterminated string") }
+
+                switch escaped := self.next() ; escaped {
+                    case '"', '\\':     result.WriteRune(escaped)
+                    case 'n':           result.WriteRune('\n')
+                    case 't':           result.WriteRune('\t')
+                    case 'r':           result.WriteRune('\r')
+                    case 'b':           result.WriteRune('\b')
+                    case 'f':           result.WriteRune('\f')
+                    case 'u', 'U':
+                        var size = 4
+                        if escaped == 'U' { size = 8 }
+                        if self.offset + size > len(self.source) { return "", self.errorf("short unicode escape") }
+
+                        code, err := strconv.ParseUint(self.source[self.offset:self.offset + size], 16, 32)
+                        if err != nil || !utf8.ValidRune(rune(code)) { return "", self.errorf("invalid unicode escape") }
+
+                        self.offset += size
+                        result.WriteRune(rune(code))
+                    default:
+                        return "", self.errorf("invalid escape '\\%c'", escaped)
+                }
+
+            default:
+                result.WriteRune(c)
+        }
+    }
+}
+
+//
+// Read a 'literal string' -- taken exactly as written
+//
+func (self *tomlParser) literalString() (string, error) {
+    self.next()
+    var start = self.offset
+
+    for {
+        if self.done() || self.peek() == '\n' { return "", self.errorf("unterminated string") }
+        if self.next() == '\'' { return self.source[start:self.offset - 1], nil }
+    }
+}
@@ -1,8 +1,13 @@
 package dns
 
 import (
+    "net"
+    "time"
     "bytes"
     "testing"
+    "encoding/binary"
+
+    "github.com/zmarcantel/phonebook/dns/record"
 )
 
 //----------------------------------------------
@@ -65,6 +70,23 @@ func TestMessage_HeaderNil(t *testing.T) {
     testHeaderSerialize(t, header, knownID, knownLowOpts, knownHighOpts, knownQuery, knownAnswer, knownNS, knownAdditional)
 }
 
+func TestMessage_HeaderOpcode(t *testing.T) {
+    var header = MessageHeader{ ID: 1, Opcode: OPCODE_UPDATE, QDCount: 1 }
+
+    var serialized = header.Serialize()
+
+    // the opcode sits in bits 1-4 of the first flags byte
+    if serialized[2] != 0x28 {
+        t.Errorf("Incorrect Opcode Byte:\n\tExpected: %08b\n\tGot: %08b\n", 0x28, serialized[2])
+    }
+
+    unpacked, _, err := UnpackHeader(serialized)
+    if err != nil { t.Fatal(err) }
+    if unpacked.Opcode != OPCODE_UPDATE {
+        t.Errorf("Incorrect Opcode:\n\tExpected: %d\n\tGot: %d\n", OPCODE_UPDATE, unpacked.Opcode)
+    }
+}
+
 func TestMessage_HeaderZeroIgnored(t *testing.T) {
     var header = MessageHeader{
         ID:                1234,
@@ -210,6 +232,522 @@ func testQuestionSerialization(t *testing.T, question Question, known []byte) {
 }
 
 
+//----------------------------------------------
+// Message Unpacking Tests
+//----------------------------------------------
+
+func TestMessage_UnpackSections(t *testing.T) {
+    var a, _ = record.A("zed.io", 10 * time.Second, net.ParseIP("127.0.0.1"))
+    var srv, _ = record.SRV("_phonebook._tcp.zed.io", "zed.io", 10 * time.Second, 10, 5, 8053)
+    var mx, _ = record.MX("zed.io", "mail.zed.io", 5, 10 * time.Second)
+    var txt, _ = record.TXT("zed.io", 10 * time.Second, "admin email -- zach@zed.io")
+
+    var message = Message{
+        Header:    MessageHeader{ ID: 1234, Response: true, QDCount: 2, ANCount: 2, NSCount: 1, ARCount: 1 },
+        Questions: testQuestions[:2],
+        Answers:   record.RecordCollection{ a, srv },
+        Ns:        record.RecordCollection{ mx },
+        Extra:     record.RecordCollection{ txt },
+    }
+
+    serialized, err := message.Serialize()
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    unpacked, err := UnpackMessage(serialized)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    if unpacked.Header.ID != 1234 || !unpacked.Header.Response {
+        t.Errorf("Incorrect Header:\n\tExpected: %+v\n\tGot: %+v\n", message.Header, unpacked.Header)
+    }
+
+    if len(unpacked.Questions) != 2 || unpacked.Questions[1].Name != "zed.io" || unpacked.Questions[1].Type != 255 || unpacked.Questions[1].Class != 1 {
+        t.Errorf("Incorrect Questions:\n\tExpected: %+v\n\tGot: %+v\n", message.Questions, unpacked.Questions)
+    }
+
+    testUnpackedSection(t, "Answers", unpacked.Answers, message.Answers)
+    testUnpackedSection(t, "NS", unpacked.Ns, message.Ns)
+    testUnpackedSection(t, "Extra", unpacked.Extra, message.Extra)
+}
+
+func TestMessage_UnpackTruncated(t *testing.T) {
+    var a, _ = record.A("zed.io", 10 * time.Second, net.ParseIP("127.0.0.1"))
+    var message = Message{
+        Header:    MessageHeader{ ID: 1234, Response: true, QDCount: 1, ANCount: 1 },
+        Questions: testQuestions[:1],
+        Answers:   record.RecordCollection{ a },
+    }
+
+    serialized, err := message.Serialize()
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    // cut into the answer record
+    if _, err := UnpackMessage(serialized[:len(serialized) - 2]); err == nil {
+        t.Errorf("Didn't catch truncated answer:\n\tExpected: %s\n\tGot: %+v\n", "non-nil", err)
+    }
+}
+
+func TestMessage_UnpackCompressedQuestions(t *testing.T) {
+    var packet = []byte{
+        0x04, 0xD2, 0x01, 0x00, 0x00, 0x02, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+        3, 0x7A, 0x65, 0x64, 2, 0x69, 0x6F, 0x00,        // zed.io (offset 12)
+        0x00, 1, 0x00, 1,
+        4, 0x6d, 0x61, 0x69, 0x6c, 0xC0, 12,             // mail + pointer to zed.io
+        0x00, 15, 0x00, 1,
+    }
+
+    unpacked, err := UnpackMessage(packet)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    if len(unpacked.Questions) != 2 || unpacked.Questions[1].Name != "mail.zed.io" || unpacked.Questions[1].Type != 15 {
+        t.Errorf("Incorrect Questions:\n\tExpected: %s\n\tGot: %+v\n", "[zed.io mail.zed.io]", unpacked.Questions)
+    }
+}
+
+func TestMessage_SerializeWithin(t *testing.T) {
+    var answers = make(record.RecordCollection, 0)
+    for port := uint16(8000) ; port < 8040 ; port++ {
+        var srv, _ = record.SRV("_phonebook._tcp.zed.io", "app.production.zed.io", 10 * time.Second, 10, 5, port)
+        answers = append(answers, srv)
+    }
+    var txt, _ = record.TXT("zed.io", 10 * time.Second, "admin email -- zach@zed.io")
+
+    var message = Message{
+        Header:    MessageHeader{ ID: 1234, Response: true, QDCount: 1, ANCount: uint16(len(answers)), ARCount: 1 },
+        Questions: testQuestions[:1],
+        Answers:   answers,
+        Extra:     record.RecordCollection{ txt },
+    }
+
+    serialized, err := message.SerializeWithin(UDP_PAYLOAD_SIZE)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    if len(serialized) > UDP_PAYLOAD_SIZE {
+        t.Errorf("Message too large:\n\tExpected: <= %d\n\tGot: %d\n", UDP_PAYLOAD_SIZE, len(serialized))
+    }
+
+    unpacked, err := UnpackMessage(serialized)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    if !unpacked.Header.Truncated {
+        t.Errorf("TC bit not set:\n\tExpected: %v\n\tGot: %v\n", true, unpacked.Header.Truncated)
+    }
+
+    if len(unpacked.Extra) != 0 || len(unpacked.Answers) == 0 || len(unpacked.Answers) >= len(answers) {
+        t.Errorf("Incorrect Records Dropped:\n\tExpected: %s\n\tGot: %d answers, %d extra\n", "some answers, no extra", len(unpacked.Answers), len(unpacked.Extra))
+    }
+}
+
+func TestMessage_SerializeWithinExtraOnly(t *testing.T) {
+    var a, _ = record.A("zed.io", 10 * time.Second, net.ParseIP("127.0.0.1"))
+    var extra = make(record.RecordCollection, 0)
+    for i := 0 ; i < 20 ; i++ {
+        var txt, _ = record.TXT("zed.io", 10 * time.Second, "admin email -- zach@zed.io")
+        extra = append(extra, txt)
+    }
+
+    var message = Message{
+        Header:    MessageHeader{ ID: 1234, Response: true, QDCount: 1, ANCount: 1, ARCount: uint16(len(extra)) },
+        Questions: testQuestions[:1],
+        Answers:   record.RecordCollection{ a },
+        Extra:     extra,
+    }
+
+    serialized, err := message.SerializeWithin(UDP_PAYLOAD_SIZE)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    unpacked, err := UnpackMessage(serialized)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    // additional data is optional -- losing it does not truncate the answer
+    if unpacked.Header.Truncated || len(unpacked.Answers) != 1 || len(unpacked.Extra) >= len(extra) {
+        t.Errorf("Incorrect Truncation:\n\tExpected: %s\n\tGot: TC=%v, %d answers, %d extra\n", "TC=false, 1 answer, fewer extra", unpacked.Header.Truncated, len(unpacked.Answers), len(unpacked.Extra))
+    }
+}
+
+func TestMessage_ExtendedRcode(t *testing.T) {
+    var opt, _ = record.OPT(4096)
+    var message = Message{
+        Header:    MessageHeader{ ID: 1234, Response: true, QDCount: 1, ARCount: 1 },
+        Questions: testQuestions[:1],
+        Extra:     record.RecordCollection{ opt },
+    }
+    message.SetExtendedRcode(ERR_BADVERS)
+
+    serialized, err := message.Serialize()
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    unpacked, err := UnpackMessage(serialized)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    if unpacked.ExtendedRcode() != ERR_BADVERS || unpacked.Header.Rcode != 0 {
+        t.Errorf("Incorrect RCODE:\n\tExpected: %d\n\tGot: %d (header %d)\n", ERR_BADVERS, unpacked.ExtendedRcode(), unpacked.Header.Rcode)
+    }
+
+    if unpacked.UDPSize() != 4096 {
+        t.Errorf("Incorrect UDP Size:\n\tExpected: %d\n\tGot: %d\n", 4096, unpacked.UDPSize())
+    }
+}
+
+func TestMessage_SerializeWithinKeepsOPT(t *testing.T) {
+    var opt, _ = record.OPT(4096)
+    var extra = record.RecordCollection{ opt }
+    for i := 0 ; i < 20 ; i++ {
+        var txt, _ = record.TXT("zed.io", 10 * time.Second, "admin email -- zach@zed.io")
+        extra = append(extra, txt)
+    }
+
+    var message = Message{
+        Header:    MessageHeader{ ID: 1234, Response: true, QDCount: 1, ARCount: uint16(len(extra)) },
+        Questions: testQuestions[:1],
+        Extra:     extra,
+    }
+
+    serialized, err := message.SerializeWithin(UDP_PAYLOAD_SIZE)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    unpacked, err := UnpackMessage(serialized)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    if unpacked.OPT() == nil {
+        t.Errorf("OPT record was dropped:\n\tExpected: %s\n\tGot: %+v\n", "OPT", unpacked.Extra)
+    }
+}
+
+func testUnpackedSection(t *testing.T, section string, got, expected record.RecordCollection) {
+    if len(got) != len(expected) {
+        t.Errorf("Incorrect %s Count:\n\tExpected: %d\n\tGot: %d\n", section, len(expected), len(got))
+        return
+    }
+
+    for i := range expected {
+        if got[i].GetLabel() != expected[i].GetLabel() || got[i].GetType() != expected[i].GetType() {
+            t.Errorf("Incorrect %s Record:\n\tExpected: %s (%d)\n\tGot: %s (%d)\n", section, expected[i].GetLabel(), expected[i].GetType(), got[i].GetLabel(), got[i].GetType())
+        }
+    }
+}
+
+
 //--------------------------------------------------------------
 // Per-Record Serializing Tests included in record package
 //--------------------------------------------------------------
+
+//----------------------------------------------
+// Malformed Message Tests
+//----------------------------------------------
+
+func TestMessage_UnpackShortHeader(t *testing.T) {
+    for i := 0 ; i < HEADER_LENGTH ; i++ {
+        if _, err := UnpackMessage(make([]byte, i)); err != ErrShortHeader {
+            t.Errorf("Didn't catch short header:\n\tLength: %d\n\tExpected: %s\n\tGot: %+v\n", i, ErrShortHeader, err)
+        }
+    }
+}
+
+func TestMessage_UnpackImpossibleCounts(t *testing.T) {
+    // a bare header claiming the largest sections possible
+    for _, field := range []int{ 4, 6, 8, 10 } {
+        var header = make([]byte, HEADER_LENGTH)
+        binary.BigEndian.PutUint16(header[field:], 0xFFFF)
+
+        if _, err := UnpackMessage(header); err == nil {
+            t.Errorf("Didn't catch impossible count:\n\tHeader: %+v\n\tGot: %+v\n", header, err)
+        }
+    }
+}
+
+func TestMessage_UnpackEveryPrefix(t *testing.T) {
+    var serialized = testFuzzSeeds(t)[0]
+
+    // every cut short message must be rejected (without panicking)
+    for i := 0 ; i < len(serialized) ; i++ {
+        if _, err := UnpackMessage(serialized[:i]); err == nil {
+            t.Errorf("Didn't catch truncated message:\n\tLength: %d of %d\n\tGot: %+v\n", i, len(serialized), err)
+        }
+    }
+}
+
+//
+// Well formed messages to start fuzzing from
+//
+func testFuzzSeeds(t testing.TB) [][]byte {
+    var a, _ = record.A("zed.io", 10 * time.Second, net.ParseIP("127.0.0.1"))
+    var mx, _ = record.MX("zed.io", "mail.zed.io", 5, 10 * time.Second)
+    var srv, _ = record.SRV("_phonebook._tcp.zed.io", "zed.io", 10 * time.Second, 10, 5, 8053)
+    var txt, _ = record.TXT("zed.io", 10 * time.Second, "phonebook")
+    var soa, _ = record.SOA("zed.io", "ns1.zed.io", "admin.zed.io", time.Hour, 1, time.Hour, time.Minute, 24 * time.Hour, time.Minute)
+    var opt, _ = record.OPT(1232)
+
+    var messages = []Message{
+        {
+            Header:    MessageHeader{ ID: 1234, Response: true, QDCount: 1, ANCount: 3, NSCount: 1, ARCount: 1 },
+            Questions: testQuestions[:1],
+            Answers:   record.RecordCollection{ a, mx, srv },
+            Ns:        record.RecordCollection{ soa },
+            Extra:     record.RecordCollection{ opt },
+        },
+        {
+            Header:    MessageHeader{ ID: 1, RecursionDesired: true, QDCount: 2, ARCount: 1 },
+            Questions: testQuestions[1:],
+            Extra:     record.RecordCollection{ txt },
+        },
+    }
+
+    var result = make([][]byte, 0, len(messages))
+    for _, message := range messages {
+        serialized, err := message.Serialize()
+        if err != nil { t.Fatal(err) }
+        result = append(result, serialized)
+    }
+
+    return result
+}
+
+func FuzzUnpackMessage(f *testing.F) {
+    for _, seed := range testFuzzSeeds(f) {
+        f.Add(seed)
+    }
+    f.Add([]byte{})
+    f.Add(make([]byte, HEADER_LENGTH))
+    f.Add([]byte{ 0, 0, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0xC0, 0x0C, 0, 1, 0, 1 })
+
+    f.Fuzz(func(t *testing.T, source []byte) {
+        var message, err = UnpackMessage(source)
+        if err != nil { return }
+
+        // whatever unpacks must agree with its header
+        if len(message.Questions) != int(message.Header.QDCount) ||
+           len(message.Answers) != int(message.Header.ANCount) ||
+           len(message.Ns) != int(message.Header.NSCount) ||
+           len(message.Extra) != int(message.Header.ARCount) {
+            t.Errorf("Section counts disagree with the header:\n\tHeader: %+v\n\tGot: %d %d %d %d\n", message.Header,
+                len(message.Questions), len(message.Answers), len(message.Ns), len(message.Extra))
+        }
+    })
+}
+
+//----------------------------------------------
+// TSIG Tests
+//----------------------------------------------
+
+//
+// A key ring holding one key, and a query signed with it at the given time
+//
+func testSigned(t *testing.T, signed time.Time) (KeyRing, *record.TSIGRecord, []byte) {
+    var keys = make(KeyRing)
+    key, err := NewTSIGKey("update.zed.io", HMAC_SHA256, "c2VjcmV0LWtleS1mb3ItdGVzdGluZy1wdXJwb3Nlcw==")
+    if err != nil { t.Fatal(err) }
+    if err = keys.Add(key) ; err != nil { t.Fatal(err) }
+
+    var message = Message{
+        Header:    MessageHeader{ ID: 4321, Opcode: OPCODE_UPDATE, QDCount: 1 },
+        Questions: QuestionCollection{ { Name: "zed.io", Type: record.SOA_RECORD, Class: record.CLASS_IN } },
+    }
+    serialized, err := message.Serialize()
+    if err != nil { t.Fatal(err) }
+
+    tsig, err := record.TSIG("update.zed.io", HMAC_SHA256, signed, TSIG_FUDGE)
+    if err != nil { t.Fatal(err) }
+
+    serialized, err = SignTSIG(serialized, tsig, keys.Find("update.zed.io"), nil)
+    if err != nil { t.Fatal(err) }
+
+    return keys, tsig, serialized
+}
+
+func TestTSIG_SignAndVerify(t *testing.T) {
+    var now = time.Now()
+    var keys, signature, request = testSigned(t, now)
+
+    // the record joins the message and unpacks as the last additional record
+    message, err := UnpackMessage(request)
+    if err != nil { t.Fatal(err) }
+    if tsig := message.TSIG() ; tsig == nil || !bytes.Equal(tsig.MAC, signature.MAC) || len(tsig.MAC) != 32 {
+        t.Fatalf("Incorrect TSIG:\n\tExpected: %+v\n\tGot: %+v\n", signature, message.Extra)
+    }
+
+    tsig, key, err := VerifyTSIG(request, keys, nil, now)
+    if err != nil || key == nil || key.Name != "update.zed.io" {
+        t.Fatalf("Could not verify:\n\tGot: %+v %v\n", key, err)
+    }
+
+    // key names compare case-insensitively
+    var cased = make(KeyRing)
+    cased.Add(TSIGKey{ "UPDATE.Zed.io.", key.Algorithm, key.Secret })
+    if _, _, err = VerifyTSIG(request, cased, nil, now) ; err != nil {
+        t.Errorf("Could not verify with a differently cased key ring:\n\tGot: %v\n", err)
+    }
+
+    // responses chain to the request's MAC, so they cannot be replayed against another request
+    var answer = Message{ Header: MessageHeader{ ID: 4321, Response: true, Opcode: OPCODE_UPDATE } }
+    response, _ := answer.Serialize()
+    signed, _ := record.TSIG(key.Name, key.Algorithm, now, TSIG_FUDGE)
+    response, err = SignTSIG(response, signed, key, tsig.MAC)
+    if err != nil { t.Fatal(err) }
+
+    if _, _, err = VerifyTSIG(response, keys, tsig.MAC, now) ; err != nil {
+        t.Errorf("Could not verify response:\n\tGot: %v\n", err)
+    }
+    if _, _, err = VerifyTSIG(response, keys, make([]byte, 32), now) ; err != TSIG_BADSIG {
+        t.Errorf("Incorrect Error:\n\tExpected: %v\n\tGot: %v\n", TSIG_BADSIG, err)
+    }
+
+    // unsigned messages are neither accepted nor refused
+    unsigned, _ := answer.Serialize()
+    if tsig, key, err = VerifyTSIG(unsigned, keys, nil, now) ; tsig != nil || key != nil || err != nil {
+        t.Errorf("Incorrect Unsigned Result:\n\tGot: %+v %+v %v\n", tsig, key, err)
+    }
+}
+
+func TestTSIG_Continued(t *testing.T) {
+    var now = time.Now()
+    var keys, request, _ = testSigned(t, now)
+    var key = keys.Find("update.zed.io")
+
+    // each message after the first of a transfer is signed over the MAC before it, without the full variables
+    var answer = Message{ Header: MessageHeader{ ID: 4321, Response: true } }
+    var previous = request.MAC
+    for i := 0 ; i < 3 ; i++ {
+        response, _ := answer.Serialize()
+        signed, _ := record.TSIG(key.Name, key.Algorithm, now, TSIG_FUDGE)
+
+        var err error
+        if i == 0 {
+            response, err = SignTSIG(response, signed, key, previous)
+        } else {
+            response, err = SignTSIGContinued(response, signed, key, previous)
+        }
+        if err != nil { t.Fatal(err) }
+
+        var verify = VerifyTSIGContinued
+        if i == 0 { verify = VerifyTSIG }
+        if _, _, err = verify(response, keys, previous, now) ; err != nil {
+            t.Errorf("Could not verify message %d:\n\tGot: %v\n", i, err)
+        }
+
+        // the two forms do not verify as each other
+        var other = VerifyTSIG
+        if i == 0 { other = VerifyTSIGContinued }
+        if _, _, err = other(response, keys, previous, now) ; err != TSIG_BADSIG {
+            t.Errorf("Incorrect Error:\n\tMessage: %d\n\tExpected: %v\n\tGot: %v\n", i, TSIG_BADSIG, err)
+        }
+
+        previous = signed.MAC
+    }
+}
+
+func TestTSIG_Failures(t *testing.T) {
+    var now = time.Now()
+    var keys, _, request = testSigned(t, now)
+
+    var tampered = append([]byte(nil), request...)
+    tampered[HEADER_LENGTH + 1] ^= 0x20
+
+    var other = make(KeyRing)
+    other.Add(TSIGKey{ "update.zed.io", HMAC_SHA512, []byte("another secret") })
+
+    var cases = []struct {
+        name        string
+        packet      []byte
+        keys        KeyRing
+        now         time.Time
+        expected    error
+    }{
+        { "tampered", tampered, keys, now, TSIG_BADSIG },
+        { "unknown key", request, make(KeyRing), now, TSIG_BADKEY },
+        { "wrong algorithm", request, other, now, TSIG_BADKEY },
+        { "too late", request, keys, now.Add(TSIG_FUDGE + time.Minute), TSIG_BADTIME },
+        { "too early", request, keys, now.Add(-TSIG_FUDGE - time.Minute), TSIG_BADTIME },
+    }
+
+    for _, test := range cases {
+        if _, _, err := VerifyTSIG(test.packet, test.keys, nil, test.now) ; err != test.expected {
+            t.Errorf("Incorrect Error:\n\tCase: %s\n\tExpected: %v\n\tGot: %v\n", test.name, test.expected, err)
+        }
+    }
+}
+
+func TestTSIG_Truncated(t *testing.T) {
+    var now = time.Now()
+    var keys, tsig, request = testSigned(t, now)
+
+    // rebuild the request with the MAC cut to the given length
+    var truncate = func(length int) []byte {
+        var cut = *tsig
+        cut.MAC = tsig.MAC[:length]
+
+        var unsigned = request[:len(request) - len(mustSerialize(t, tsig))]
+        return append(append([]byte(nil), unsigned...), mustSerialize(t, &cut)...)
+    }
+
+    // a correct MAC cut within the allowed bounds is not accepted by us
+    if _, _, err := VerifyTSIG(truncate(16), keys, nil, now) ; err != TSIG_BADTRUNC {
+        t.Errorf("Incorrect Error:\n\tExpected: %v\n\tGot: %v\n", TSIG_BADTRUNC, err)
+    }
+
+    // and one cut beyond them is malformed
+    if _, _, err := VerifyTSIG(truncate(8), keys, nil, now) ; err != ErrTSIGFormat {
+        t.Errorf("Incorrect Error:\n\tExpected: %v\n\tGot: %v\n", ErrTSIGFormat, err)
+    }
+}
+
+func TestTSIG_Misplaced(t *testing.T) {
+    var tsig, _ = record.TSIG("update.zed.io", HMAC_SHA256, time.Now(), TSIG_FUDGE)
+    var opt, _ = record.OPT(1232)
+
+    // a TSIG record anywhere but last is malformed
+    var message = Message{
+        Header: MessageHeader{ ID: 1, ARCount: 2 },
+        Extra:  record.RecordCollection{ tsig, opt },
+    }
+    serialized, err := message.Serialize()
+    if err != nil { t.Fatal(err) }
+
+    if _, _, err = VerifyTSIG(serialized, make(KeyRing), nil, time.Now()) ; err != ErrTSIGFormat {
+        t.Errorf("Incorrect Error:\n\tExpected: %v\n\tGot: %v\n", ErrTSIGFormat, err)
+    }
+}
+
+func TestTSIGKey_Invalid(t *testing.T) {
+    var invalid = [][]string{
+        { "update.zed.io", "hmac-md5", "c2VjcmV0" },
+        { "update.zed.io", HMAC_SHA256, "not base64!" },
+        { "update.zed.io", HMAC_SHA256, "" },
+        { "update..zed.io", HMAC_SHA256, "c2VjcmV0" },
+    }
+
+    for _, source := range invalid {
+        if key, err := NewTSIGKey(source[0], source[1], source[2]) ; err == nil {
+            t.Errorf("Didn't catch invalid key:\n\tSource: %v\n\tGot: %+v\n", source, key)
+        }
+    }
+}
+
+func mustSerialize(t *testing.T, rec record.Record) []byte {
+    serialized, err := rec.Serialize()
+    if err != nil { t.Fatal(err) }
+    return serialized
+}
@@ -0,0 +1,63 @@
+package dns
+
+import (
+    "github.com/zmarcantel/phonebook/dns/record"
+)
+
+const (
+    EDNS_VERSION    uint8   = 0         // the only EDNS version we speak
+    ERR_BADVERS     int     = 16        // extended RCODE for an unsupported EDNS version
+)
+
+//----------------------------------------------
+// EDNS(0) Message Helpers
+//----------------------------------------------
+
+//
+// Return the OPT pseudo-record from the additional section, or nil if the
+// sender does not speak EDNS
+//
+func (self *Message) OPT() *record.OPTRecord {
+    for _, rec := range self.Extra {
+        if opt, ok := rec.(*record.OPTRecord) ; ok {
+            return opt
+        }
+    }
+
+    return nil
+}
+
+//
+// Return the full 12 bit RCODE -- the header's 4 bits extended by the OPT record's 8
+//
+func (self *Message) ExtendedRcode() int {
+    var rcode = self.Header.Rcode & 0x0F
+    if opt := self.OPT() ; opt != nil {
+        rcode |= int(opt.ExtendedRcode) << 4
+    }
+
+    return rcode
+}
+
+//
+// Set the full 12 bit RCODE, splitting it between the header and the OPT record
+// Codes above 15 require an OPT record to already be present
+//
+func (self *Message) SetExtendedRcode(rcode int) {
+    self.Header.Rcode = rcode & 0x0F
+    if opt := self.OPT() ; opt != nil {
+        opt.ExtendedRcode = uint8(rcode >> 4)
+    }
+}
+
+//
+// Return the largest UDP response the sender of this message will accept
+//
+func (self *Message) UDPSize() int {
+    var opt = self.OPT()
+    if opt == nil || int(opt.UDPSize) < UDP_PAYLOAD_SIZE {
+        return UDP_PAYLOAD_SIZE
+    }
+
+    return int(opt.UDPSize)
+}
@@ -9,6 +9,20 @@ import (
     "github.com/zmarcantel/phonebook/dns/record"
 )
 
+// the largest response that may be sent over UDP without EDNS (RFC 1035 4.2.1)
+const UDP_PAYLOAD_SIZE int = 512
+
+// every message begins with a fixed size header (RFC 1035 4.1.1)
+const HEADER_LENGTH int = 12
+
+// constants representing the kind of message (the header's opcode)
+const (
+    OPCODE_QUERY   uint32 = 0
+    OPCODE_UPDATE  uint32 = 5       // RFC 2136
+)
+
+var ErrShortHeader = errors.New("ERROR: Message is shorter than its header")
+
 const (
     RAW_RESPONSE   uint8 = 0x80
     RAW_OPCODE     uint8 = 0x78
@@ -53,7 +67,7 @@ type MessageHeaderRaw struct {
 func (self *MessageHeader) Serialize() []byte {
     var raw MessageHeaderRaw
     raw.LowOpts             = Btoi(self.Response) << 7
-    raw.LowOpts            |= uint8(self.Opcode << 6)
+    raw.LowOpts            |= uint8(self.Opcode << 3) & RAW_OPCODE
     raw.LowOpts            |= Btoi(self.Authoritative) << 2
     raw.LowOpts            |= Btoi(self.Truncated) << 1
     raw.LowOpts            |= Btoi(self.RecursionDesired)
@@ -72,7 +86,7 @@ func (self *MessageHeader) Serialize() []byte {
     buffer.Write(Uint16ToBytes(self.NSCount))
     buffer.Write(Uint16ToBytes(self.ARCount))
 
-    return bytes.TrimSpace(buffer.Bytes())
+    return buffer.Bytes()
 }
 
 //----------------------------------------------
@@ -136,33 +150,83 @@ func (self *Message) Serialize() ([]byte, error) {
     var result = make([]byte, 0)
     var buffer = bytes.NewBuffer(result)
 
+    // names are compressed across the entire message
+    var table = make(record.Compression)
+
     // first, grab the header
     var header = self.Header.Serialize()
     buffer.Write(header)
 
     // then copy over any questions
-    que, err := self.Questions.Serialize()
+    que, err := self.Questions.SerializeCompressed(table, buffer.Len())
     if err != nil { return nil, errors.New("ERROR: Could not serialize Questions:\n" + err.Error()) }
     buffer.Write(que)
 
     // fill in our answers
-    ans, err := self.Answers.Serialize()
+    ans, err := self.Answers.SerializeCompressed(table, buffer.Len())
     if err != nil { return nil, errors.New("ERROR: Could not serialize Answers:\n" + err.Error()) }
     buffer.Write(ans)
 
     // we won't have any nameserver records, but do that just in case
-    ns, err := self.Ns.Serialize()
+    ns, err := self.Ns.SerializeCompressed(table, buffer.Len())
     if err != nil { return nil, errors.New("ERROR: Could not serialize NS:\n" + err.Error()) }
     buffer.Write(ns)
 
     // and any additional features, records, etc that need to be communicated
-    extra, err := self.Extra.Serialize()
+    extra, err := self.Extra.SerializeCompressed(table, buffer.Len())
     if err != nil { return nil, errors.New("ERROR: Could not serialize Extra:\n" + err.Error()) }
     buffer.Write(extra)
 
     return buffer.Bytes(), nil
 }
 
+//
+// Transform the message into a DNS packet no larger than limit bytes
+// Whole RRs are dropped from the end of the additional (sparing any OPT record),
+// authority, and then answer sections until it fits. Losing anything but additional data sets the TC bit so
+// the client knows to retry over TCP (RFC 2181 9)
+//
+func (self *Message) SerializeWithin(limit int) ([]byte, error) {
+    for {
+        serialized, err := self.Serialize()
+        if err != nil { return nil, err }
+        if len(serialized) <= limit { return serialized, nil }
+
+        // drop the last record of the least important non-empty section
+        // the OPT record describes the message itself, so it is never dropped
+        if droppable := lastDroppable(self.Extra) ; droppable >= 0 {
+            self.Extra = append(self.Extra[:droppable:droppable], self.Extra[droppable + 1:]...)
+            self.Header.ARCount = uint16(len(self.Extra))
+        } else if len(self.Ns) > 0 {
+            self.Ns = self.Ns[:len(self.Ns) - 1]
+            self.Header.NSCount = uint16(len(self.Ns))
+            self.Header.Truncated = true
+        } else if len(self.Answers) > 0 {
+            self.Answers = self.Answers[:len(self.Answers) - 1]
+            self.Header.ANCount = uint16(len(self.Answers))
+            self.Header.Truncated = true
+        } else {
+            // nothing left to drop -- the questions alone overflow
+            self.Header.Truncated = true
+            return serialized, nil
+        }
+    }
+}
+
+//
+// Return the index of the last record in the section that is not an OPT record,
+// or -1 if there is none
+//
+func lastDroppable(section record.RecordCollection) int {
+    for i := len(section) - 1 ; i >= 0 ; i-- {
+        if section[i].GetType() != record.OPT_RECORD {
+            return i
+        }
+    }
+
+    return -1
+}
+
 //----------------------------------------------
 // Functions
 //----------------------------------------------
@@ -170,25 +234,41 @@ func (self *Message) Serialize() ([]byte, error) {
 //
 // Translate a DNS packet into a readable message
 //
-func UnpackMessage(source []byte) *Message {
-    header, offset := UnpackHeader(source)
-    questions, offset := UnpackQuestions(source[offset:], int(header.QDCount))
+func UnpackMessage(source []byte) (*Message, error) {
+    header, offset, err := UnpackHeader(source)
+    if err != nil { return nil, err }
+
+    questions, offset, err := UnpackQuestions(source, offset, int(header.QDCount))
+    if err != nil { return nil, errors.New("ERROR: Could not unpack Questions:\n" + err.Error()) }
+
+    answers, offset, err := record.UnpackRecords(source, offset, int(header.ANCount))
+    if err != nil { return nil, errors.New("ERROR: Could not unpack Answers:\n" + err.Error()) }
+
+    ns, offset, err := record.UnpackRecords(source, offset, int(header.NSCount))
+    if err != nil { return nil, errors.New("ERROR: Could not unpack NS:\n" + err.Error()) }
+
+    extra, offset, err := record.UnpackRecords(source, offset, int(header.ARCount))
+    if err != nil { return nil, errors.New("ERROR: Could not unpack Extra:\n" + err.Error()) }
 
     return &Message{
         header,
         questions,
-        []record.Record{},
-        []record.Record{},
-        []record.Record{},
-    }
+        answers,
+        ns,
+        extra,
+    }, nil
 }
 
 //
 // Extract the header from the DNS packet
+// Returns the header and the offset of the first byte following it
 //
-func UnpackHeader(source []byte) (MessageHeader, int) {
+func UnpackHeader(source []byte) (MessageHeader, int, error) {
     var raw MessageHeaderRaw
-    binary.Read(bytes.NewReader(source), binary.BigEndian, &raw)
+    if len(source) < HEADER_LENGTH { return MessageHeader{}, 0, ErrShortHeader }
+
+    var err = binary.Read(bytes.NewReader(source[:HEADER_LENGTH]), binary.BigEndian, &raw)
+    if err != nil { return MessageHeader{}, 0, err }
 
     var header MessageHeader
     header.ID = raw.ID
@@ -204,6 +284,6 @@ func UnpackHeader(source []byte) (MessageHeader, int) {
     header.NSCount             = raw.NSCount
     header.ARCount             = raw.ARCount
 
-    return header, 12 // TODO: non-hardcoded length
+    return header, HEADER_LENGTH, nil
 }
 
@@ -3,11 +3,17 @@ package dns
 import (
     "fmt"
     "bytes"
+    "errors"
     "encoding/binary"
 
     "github.com/zmarcantel/phonebook/dns/record"
 )
 
+var ErrShortQuestion = errors.New("ERROR: Question runs past the end of the message")
+
+// the smallest question on the wire: the root name, type, and class
+const MIN_QUESTION_LENGTH int = 5
+
 //----------------------------------------------
 // Question Structures
 //----------------------------------------------
@@ -62,7 +68,27 @@ func (self QuestionCollection) Serialize() ([]byte, error) {
         buffer.Write(serialized)
     }
 
-    return bytes.TrimSpace(buffer.Bytes()), nil
+    return buffer.Bytes(), nil
+}
+
+//
+// Serializes a set of questions starting at offset within a message,
+// sharing the message's compression table
+//
+func (self QuestionCollection) SerializeCompressed(table record.Compression, offset int) ([]byte, error) {
+    var result = make([]byte, 0)
+    var buffer = bytes.NewBuffer(result)
+
+    for _, q := range self {
+        label, err := table.Label(q.Name, offset + buffer.Len())
+        if err != nil { return nil, err }
+        buffer.Write(label)
+
+        buffer.Write(Uint16ToBytes(q.Type))
+        buffer.Write(Uint16ToBytes(q.Class))
+    }
+
+    return buffer.Bytes(), nil
 }
 
 //
@@ -80,25 +106,34 @@ func (self QuestionCollection) Print(indent int) {
 
 //
 // Transform the queries in a DNS packet into a question structure
+// Returns the questions and the offset of the first byte following them
 //
-func UnpackQuestions(source []byte, count int) ([]Question, int) {
+func UnpackQuestions(source []byte, offset int, count int) ([]Question, int, error) {
+    // a count the message cannot possibly hold is rejected before allocating for it
+    if offset > len(source) || count > (len(source) - offset) / MIN_QUESTION_LENGTH {
+        return nil, 0, ErrShortQuestion
+    }
+
     var result = make([]Question, count)
 
-    var offset int
     for i := 0 ; i < count ; i++ {
-        name, offset := GetMessageLabel(source)
+        name, next, err := GetMessageLabel(source, offset)
+        if err != nil { return nil, 0, err }
+        offset = next
+
+        if offset + 4 > len(source) { return nil, 0, ErrShortQuestion }
 
         var qType, qClass uint16
         binary.Read(bytes.NewReader(source[offset : offset + 2]), binary.BigEndian, &qType)
-        binary.Read(bytes.NewReader(source[offset + 2 : offset + 5]), binary.BigEndian, &qClass)
+        binary.Read(bytes.NewReader(source[offset + 2 : offset + 4]), binary.BigEndian, &qClass)
+        offset += 4
 
         result[i] = Question {
             Name:        name,
             Type:        uint16(qType),
             Class:       uint16(qClass),
         }
-        source = source[offset:]
     }
 
-    return result, offset
+    return result, offset, nil
 }
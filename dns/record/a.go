@@ -84,11 +84,14 @@ func A(hostname string, ttl time.Duration, target net.IP) (*ARecord, error) {
     if len(hostname) <= 0 {
         return nil, errors.New(fmt.Sprintf("The record must contain a hostname. Received: '%s'.", hostname))
     } else if ttl.Seconds() < 5 { // TODO: get actual max class int
-        return nil, errors.New(fmt.Sprintf("TTL of <5s is not supported. Received: %d", ttl.Seconds))
+        return nil, errors.New(fmt.Sprintf("TTL of <5s is not supported. Received: %v", ttl))
     } else if target == nil || target.DefaultMask() == nil {
         return nil, ErrInvalidIP
     }
 
+    // the hostname must be a name we can send
+    if _, err := ParseName(hostname) ; err != nil { return nil, err }
+
     // TODO: add checks on target -- are we remapping the current IP and some other security stuff
 
     return &ARecord{
@@ -181,11 +184,14 @@ func AAAA(hostname string, ttl time.Duration, target net.IP) (*AAAARecord, error
     if len(hostname) <= 0 {
         return nil, errors.New(fmt.Sprintf("The record must contain a hostname. Received: '%s'.", hostname))
     } else if ttl.Seconds() < 5 { // TODO: get actual max class int
-        return nil, errors.New(fmt.Sprintf("TTL of <5s is not supported. Received: %d", ttl.Seconds))
+        return nil, errors.New(fmt.Sprintf("TTL of <5s is not supported. Received: %v", ttl))
     } else if target.DefaultMask() != nil || target == nil {
         return nil, ErrInvalidIP
     }
 
+    // the hostname must be a name we can send
+    if _, err := ParseName(hostname) ; err != nil { return nil, err }
+
     // TODO: add checks on target -- are we remapping the current IP and some other security stuff
 
     return &AAAARecord{
@@ -199,3 +205,24 @@ func AAAA(hostname string, ttl time.Duration, target net.IP) (*AAAARecord, error
         target,
     }, nil
 }
+
+//
+// Decode the RDATA of a wire-format A record
+//
+func unpackA(header RecordHeader, message []byte, offset int) (Record, error) {
+    if header.RDataLength != 4 { return nil, ErrShortRecord }
+
+    var data = message[offset:offset + 4]
+    return &ARecord{ header, net.IPv4(data[0], data[1], data[2], data[3]) }, nil
+}
+
+//
+// Decode the RDATA of a wire-format AAAA record
+//
+func unpackAAAA(header RecordHeader, message []byte, offset int) (Record, error) {
+    if header.RDataLength != 16 { return nil, ErrShortRecord }
+
+    var ip = make(net.IP, 16)
+    copy(ip, message[offset:offset + 16])
+    return &AAAARecord{ header, ip }, nil
+}
@@ -0,0 +1,105 @@
+package record
+
+import (
+    "bytes"
+)
+
+// the largest offset a compression pointer can hold (14 bits)
+const MAX_POINTER_OFFSET int = 0x3FFF
+
+//----------------------------------------------
+// Name Compression
+//----------------------------------------------
+
+//
+// Tracks where each name (and every suffix of it) was written within a message
+// so that later occurrences can be replaced by a pointer (RFC 1035 4.1.4)
+//
+// Keys are canonical names -- names compare case-insensitively
+//
+type Compression map[string]int
+
+//
+// Records whose RDATA holds names that may be compressed
+// Per RFC 3597 this is limited to the types defined by RFC 1035 (CNAME, PTR, MX, ...)
+// and must NOT include newer types such as SRV
+//
+type CompressibleRecord interface {
+    Record
+
+    //
+    // Serialize the RDATA given the table and the message offset the RDATA begins at
+    //
+    CompressedData(table Compression, offset int) ([]byte, error)
+}
+
+//
+// Transform a label string into the DNS label-packing format, pointing at
+// a previously written suffix where possible
+//    offset: position in the message the label will be written at
+//
+func (self Compression) Label(source string, offset int) ([]byte, error) {
+    var result = make([]byte, 0)
+    var buffer = bytes.NewBuffer(result)
+
+    labels, err := SplitName(source)
+    if err != nil { return nil, err }
+
+    for i, label := range labels {
+        var suffix = string(joinLabels(labels[i:], true))
+
+        // the rest of the name has been written before -- point at it and stop
+        if position, exists := self[suffix] ; exists {
+            buffer.Write(Uint16ToBytes(uint16(0xC000 | position)))
+            return buffer.Bytes(), nil
+        }
+
+        // remember where this suffix starts if a pointer can reach it
+        var position = offset + buffer.Len()
+        if position <= MAX_POINTER_OFFSET {
+            self[suffix] = position
+        }
+
+        buffer.WriteByte(byte(len(label)))
+        buffer.Write(label)
+    }
+
+    buffer.Write([]byte{0})
+    return buffer.Bytes(), nil
+}
+
+//
+// Translate the record into a byte array to be placed in a DNS packet at offset,
+// compressing the owner name and (for CompressibleRecords) any names in the RDATA
+//
+func SerializeCompressed(rec Record, table Compression, offset int) ([]byte, error) {
+    // the uncompressed form gives us type, class, TTL, and the plain RDATA
+    serialized, err := rec.Serialize()
+    if err != nil { return nil, err }
+
+    _, fixed, err := ReadMessageLabel(serialized, 0)
+    if err != nil { return nil, err }
+    if fixed + 10 > len(serialized) { return nil, ErrShortRecord }
+
+    var result = make([]byte, 0)
+    var buffer = bytes.NewBuffer(result)
+
+    label, err := table.Label(rec.GetLabel(), offset)
+    if err != nil { return nil, err }
+    buffer.Write(label)
+
+    // type, class, and TTL are unaffected by compression
+    buffer.Write(serialized[fixed:fixed + 8])
+
+    var data = serialized[fixed + 10:]
+    if compressible, ok := rec.(CompressibleRecord) ; ok {
+        // RDATA begins after the data length
+        data, err = compressible.CompressedData(table, offset + buffer.Len() + 2)
+        if err != nil { return nil, err }
+    }
+
+    buffer.Write(Uint16ToBytes(uint16(len(data))))
+    buffer.Write(data)
+
+    return buffer.Bytes(), nil
+}
@@ -0,0 +1,120 @@
+package record
+
+import (
+    "time"
+    "encoding/binary"
+)
+
+// the smallest resource record on the wire: the root name, type, class, TTL, and data length
+const MIN_RECORD_LENGTH int = 11
+
+//----------------------------------------------
+// Record Decoder Registry
+//----------------------------------------------
+
+//
+// Decoders turn the RDATA of a wire-format RR back into a Record
+//    header:  the RR header that has already been read (name, type, class, TTL, length)
+//    message: the DNS message, ending at the last byte of this record's RDATA
+//    offset:  position of the first RDATA byte within message
+//
+type Decoder func(header RecordHeader, message []byte, offset int) (Record, error)
+
+// map from record type value to the decoder that understands its RDATA
+var Decoders = map[uint16]Decoder {
+    A_RECORD:           unpackA,
+    AAAA_RECORD:        unpackAAAA,
+    SRV_RECORD:         unpackSRV,
+    CNAME_RECORD:       unpackCNAME,
+    PTR_RECORD:         unpackPTR,
+    MX_RECORD:          unpackMX,
+    TXT_RECORD:         unpackTXT,
+    OPT_RECORD:         unpackOPT,
+    SOA_RECORD:         unpackSOA,
+    NS_RECORD:          unpackNS,
+    TSIG_RECORD:        unpackTSIG,
+}
+
+//
+// Register (or replace) the decoder used for a record type
+// Not safe to call while messages are being unpacked -- register during init
+//
+func RegisterDecoder(rType uint16, decoder Decoder) {
+    Decoders[rType] = decoder
+}
+
+//
+// Read a single resource record out of a DNS message starting at offset
+// Returns the record and the offset of the first byte following it
+//
+// Types without a registered decoder, and ANY/NONE class records carrying no
+// RDATA at all (as UPDATE prerequisites and deletions do), are returned as *UnknownRecord
+//
+func UnpackRecord(message []byte, offset int) (Record, int, error) {
+    name, offset, err := ReadMessageLabel(message, offset)
+    if err != nil { return nil, 0, err }
+
+    // type, class, TTL, and data length make up 10 bytes
+    if offset + 10 > len(message) { return nil, 0, ErrShortRecord }
+
+    var header = RecordHeader{
+        Name:        name,
+        Type:        binary.BigEndian.Uint16(message[offset:]),
+        Class:       binary.BigEndian.Uint16(message[offset + 2:]),
+        TTL:         time.Duration(binary.BigEndian.Uint32(message[offset + 4:])) * time.Second,
+        RDataLength: binary.BigEndian.Uint16(message[offset + 8:]),
+    }
+    offset += 10
+
+    var finish = offset + int(header.RDataLength)
+    if finish > len(message) { return nil, 0, ErrShortRecord }
+
+    var decoder, known = Decoders[header.Type]
+    // OPT re-purposes the class field, so it never counts as ANY/NONE
+    var empty = header.RDataLength == 0 && header.Type != OPT_RECORD && (header.Class == CLASS_ANY || header.Class == CLASS_NONE)
+    if !known || empty {
+        var data = make([]byte, header.RDataLength)
+        copy(data, message[offset:finish])
+        return &UnknownRecord{ header, data }, finish, nil
+    }
+
+    // hide everything after this record so a decoder cannot read into its neighbor
+    rec, err := decoder(header, message[:finish], offset)
+    if err != nil { return nil, 0, err }
+
+    return rec, finish, nil
+}
+
+//
+// Read count consecutive resource records out of a DNS message starting at offset
+// Returns the records and the offset of the first byte following the last one
+//
+func UnpackRecords(message []byte, offset int, count int) (RecordCollection, int, error) {
+    // a count the message cannot possibly hold is rejected before allocating for it
+    if offset > len(message) || count > (len(message) - offset) / MIN_RECORD_LENGTH {
+        return nil, 0, ErrShortRecord
+    }
+
+    var result = make(RecordCollection, 0, count)
+
+    for i := 0 ; i < count ; i++ {
+        rec, next, err := UnpackRecord(message, offset)
+        if err != nil { return nil, 0, err }
+
+        result = append(result, rec)
+        offset = next
+    }
+
+    return result, offset, nil
+}
+
+//
+// Read a packed label that must fill the remainder of a record's RDATA
+//
+func unpackTargetLabel(message []byte, offset int) (string, error) {
+    target, offset, err := ReadMessageLabel(message, offset)
+    if err != nil { return "", err }
+    if offset != len(message) { return "", ErrShortRecord }
+
+    return target, nil
+}
@@ -5,6 +5,7 @@ import (
     "time"
     "bytes"
     "errors"
+    "strings"
 )
 
 // constants representing record type values
@@ -16,6 +17,17 @@ const (
     PTR_RECORD uint16      = 12
     MX_RECORD uint16       = 15
     TXT_RECORD uint16      = 16
+    OPT_RECORD uint16      = 41
+    SOA_RECORD uint16      = 6
+    NS_RECORD uint16       = 2
+    TSIG_RECORD uint16     = 250
+)
+
+// constants representing record class values
+const (
+    CLASS_IN uint16        = 1
+    CLASS_NONE uint16      = 254
+    CLASS_ANY uint16       = 255
 )
 
 
@@ -28,9 +40,27 @@ var TypeIntToString = map[uint16]string {
     PTR_RECORD:         "PTR",
     MX_RECORD:          "MX",
     TXT_RECORD:         "TXT",
+    OPT_RECORD:         "OPT",
+    SOA_RECORD:         "SOA",
+    NS_RECORD:          "NS",
+    TSIG_RECORD:        "TSIG",
+}
+
+//
+// Look up a record type value by its name (case-insensitive)
+//
+func TypeFromString(name string) (uint16, bool) {
+    for rType, candidate := range TypeIntToString {
+        if strings.EqualFold(candidate, name) { return rType, true }
+    }
+
+    return 0, false
 }
 
 var ErrInvalidIP = errors.New("Invalid IP type for record")
+var ErrShortRecord = errors.New("Record is shorter than its header claims")
+var ErrShortLabel = errors.New("Label runs past the end of the message")
+var ErrLabelPointer = errors.New("Label contains an invalid compression pointer")
 
 //----------------------------------------------
 // Record Header Structures
@@ -99,6 +129,27 @@ func (self RecordCollection) Serialize() ([]byte, error) {
     return buffer.Bytes(), nil
 }
 
+//
+// Serialize the records back to back starting at offset within a message,
+// sharing the message's compression table
+//
+func (self RecordCollection) SerializeCompressed(table Compression, offset int) ([]byte, error) {
+    if len(self) < 1 {
+        return nil, nil
+    }
+
+    var result = make([]byte, 0)
+    var buffer = bytes.NewBuffer(result)
+
+    for _, rec := range self {
+        data, err := SerializeCompressed(rec, table, offset + buffer.Len())
+        if err != nil { return nil, err }
+        buffer.Write(data)
+    }
+
+    return buffer.Bytes(), nil
+}
+
 func (self RecordCollection) Print(indent int) {
     for _, a := range self {
         a.Print(indent)
@@ -5,6 +5,7 @@ import (
     "time"
     "bytes"
     "errors"
+    "encoding/binary"
 )
 
 //----------------------------------------------
@@ -25,7 +26,7 @@ func (self *MXRecord) Print(indent int) {
     var indentString string
     for i := 0 ; i < indent; i++ { indentString += "\t" }
 
-    fmt.Printf("%MX:\n", indentString)
+    fmt.Printf("%sMX:\n", indentString)
     fmt.Printf("%s\t   Label: %s\n", indentString, self.Name)
     fmt.Printf("%s\t     TTL: %+v\n", indentString, self.TTL)
     fmt.Printf("%s\tPriority: %+v\n", indentString, self.Priority)
@@ -62,6 +63,23 @@ func (self *MXRecord) Data() ([]byte, error) {
     return buffer.Bytes(), nil
 }
 
+//
+// Return the data with the target compressed against the message's table
+//
+func (self *MXRecord) CompressedData(table Compression, offset int) ([]byte, error) {
+    var result = make([]byte, 0)
+    var buffer = bytes.NewBuffer(result)
+
+    buffer.Write(Uint16ToBytes(self.Priority))
+
+    // the target follows the 2 byte priority
+    label, err := table.Label(self.Target, offset + 2)
+    if err != nil { return nil, err }
+    buffer.Write(label)
+
+    return buffer.Bytes(), nil
+}
+
 //
 // Translate the record into a byte array to be placed in a DNS packet
 //
@@ -97,7 +115,7 @@ func MX(name, target string, priority uint16, ttl time.Duration) (*MXRecord, err
     } else if len(target) <= 0 {
         return nil, errors.New("The MX record must contain a target mail server.")
     } else if ttl.Seconds() < 5 { // TODO: get actual max class int
-        return nil, errors.New(fmt.Sprintf("TTL of <5s is not supported. Received: %d", ttl.Seconds))
+        return nil, errors.New(fmt.Sprintf("TTL of <5s is not supported. Received: %v", ttl))
     }
 
     var result = &MXRecord{
@@ -115,3 +133,18 @@ func MX(name, target string, priority uint16, ttl time.Duration) (*MXRecord, err
     _, err := result.Serialize()
     return result, err
 }
+
+//
+// Decode the RDATA of a wire-format MX record
+//
+func unpackMX(header RecordHeader, message []byte, offset int) (Record, error) {
+    // the preference precedes the target
+    if header.RDataLength < 3 { return nil, ErrShortRecord }
+
+    var priority = binary.BigEndian.Uint16(message[offset:])
+
+    target, err := unpackTargetLabel(message, offset + 2)
+    if err != nil { return nil, err }
+
+    return &MXRecord{ header, priority, target }, nil
+}
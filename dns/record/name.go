@@ -0,0 +1,211 @@
+package record
+
+import (
+    "bytes"
+    "errors"
+    "strings"
+)
+
+const (
+    MAX_LABEL_LENGTH        int     = 63        // bytes in a single label (RFC 1035 2.3.4)
+    MAX_NAME_LENGTH         int     = 255       // bytes in a whole name on the wire, length bytes and root included
+)
+
+var ErrEmptyLabel       error   = errors.New("ERROR: Names may not contain empty labels")
+var ErrLabelTooLong     error   = errors.New("ERROR: Labels may not exceed 63 bytes")
+var ErrNameTooLong      error   = errors.New("ERROR: Names may not exceed 255 bytes")
+var ErrInvalidEscape    error   = errors.New("ERROR: Invalid escape sequence in name")
+
+//----------------------------------------------
+// Domain Names
+//----------------------------------------------
+
+//
+// A domain name in canonical form:
+//    lowercase (ASCII only -- RFC 4343), without the trailing dot,
+//    and with dots, backslashes, and unprintable bytes within labels escaped
+//
+// Two names are the same name exactly when their canonical forms are equal,
+// which makes a Name suitable as a map key. The root is the empty Name
+//
+type Name string
+
+//
+// Parse a name in presentation format (RFC 1035 5.1), validating escapes and lengths
+//
+func ParseName(source string) (Name, error) {
+    labels, err := SplitName(source)
+    if err != nil { return "", err }
+
+    return joinLabels(labels, true), nil
+}
+
+//
+// Return the canonical form of a name for comparisons and lookups
+// Invalid names are only lowercased and stripped of their trailing dot -- they can never
+// match a valid name, so lookups of them simply miss
+//
+func Canonical(source string) string {
+    name, err := ParseName(source)
+    if err != nil {
+        return strings.ToLower(strings.TrimSuffix(source, "."))
+    }
+
+    return string(name)
+}
+
+//
+// Check if two names in presentation format are the same name
+//
+func NamesEqual(left, right string) bool {
+    return Canonical(left) == Canonical(right)
+}
+
+//
+// Split a name in presentation format into its raw labels, resolving escapes
+// (\X is the literal character X, \DDD a decimal byte value)
+//
+func SplitName(source string) ([][]byte, error) {
+    var labels = make([][]byte, 0)
+    if source == "" || source == "." { return labels, nil }
+
+    var current = make([]byte, 0)
+    var length = 1                  // the root label
+
+    var finish = func() error {
+        if len(current) == 0 { return ErrEmptyLabel }
+        if len(current) > MAX_LABEL_LENGTH { return ErrLabelTooLong }
+
+        length += len(current) + 1
+        if length > MAX_NAME_LENGTH { return ErrNameTooLong }
+
+        labels = append(labels, current)
+        current = make([]byte, 0)
+        return nil
+    }
+
+    for i := 0 ; i < len(source) ; i++ {
+        var c = source[i]
+
+        switch {
+            case c == '.':
+                if err := finish() ; err != nil { return nil, err }
+
+                // only the final dot may end the name
+                if i == len(source) - 1 { return labels, nil }
+
+            case c == '\\':
+                if i + 1 >= len(source) { return nil, ErrInvalidEscape }
+
+                if isDigit(source[i + 1]) {
+                    if i + 3 >= len(source) { return nil, ErrInvalidEscape }
+                    if !isDigit(source[i + 2]) || !isDigit(source[i + 3]) { return nil, ErrInvalidEscape }
+
+                    var value = int(source[i + 1] - '0') * 100 + int(source[i + 2] - '0') * 10 + int(source[i + 3] - '0')
+                    if value > 255 { return nil, ErrInvalidEscape }
+
+                    current = append(current, byte(value))
+                    i += 3
+                } else {
+                    current = append(current, source[i + 1])
+                    i += 1
+                }
+
+            default:
+                current = append(current, c)
+        }
+    }
+
+    if err := finish() ; err != nil { return nil, err }
+    return labels, nil
+}
+
+//
+// Return the name's raw labels, left to right
+//
+func (self Name) Labels() [][]byte {
+    labels, _ := SplitName(string(self))
+    return labels
+}
+
+//
+// Return the name one label up, or the root for single label names
+//
+func (self Name) Parent() Name {
+    var labels = self.Labels()
+    if len(labels) <= 1 { return "" }
+
+    return joinLabels(labels[1:], false)
+}
+
+//
+// Check if the name is a wildcard owner ("*" as its first label)
+//
+func (self Name) IsWildcard() bool {
+    var labels = self.Labels()
+    return len(labels) > 0 && string(labels[0]) == "*"
+}
+
+//
+// Check if the name is at or beneath parent
+//
+func (self Name) IsSubdomain(parent Name) bool {
+    var labels = self.Labels()
+    var parentLabels = parent.Labels()
+    if len(parentLabels) > len(labels) { return false }
+
+    var offset = len(labels) - len(parentLabels)
+    for i := range parentLabels {
+        if !bytes.Equal(labels[offset + i], parentLabels[i]) { return false }
+    }
+
+    return true
+}
+
+func (self Name) String() string {
+    return string(self)
+}
+
+//
+// Write raw labels back out in presentation format, escaping as needed
+//
+func joinLabels(labels [][]byte, lower bool) Name {
+    var parts = make([]string, len(labels))
+
+    for i, label := range labels {
+        if lower { label = bytes.ToLower(label) }
+        parts[i] = escapeLabel(label)
+    }
+
+    return Name(strings.Join(parts, "."))
+}
+
+//
+// Write a single raw label in presentation format
+//
+func escapeLabel(label []byte) string {
+    var buffer bytes.Buffer
+
+    for _, c := range label {
+        switch {
+            case c == '.' || c == '\\' || c == '"' || c == '(' || c == ')' || c == ';' || c == '@' || c == '$':
+                buffer.WriteByte('\\')
+                buffer.WriteByte(c)
+
+            case c <= ' ' || c >= 0x7F:
+                buffer.WriteByte('\\')
+                buffer.WriteByte('0' + c / 100)
+                buffer.WriteByte('0' + c / 10 % 10)
+                buffer.WriteByte('0' + c % 10)
+
+            default:
+                buffer.WriteByte(c)
+        }
+    }
+
+    return buffer.String()
+}
+
+func isDigit(c byte) bool {
+    return c >= '0' && c <= '9'
+}
@@ -0,0 +1,129 @@
+package record
+
+import (
+    "fmt"
+    "time"
+    "bytes"
+    "errors"
+)
+
+//----------------------------------------------
+//  NS Record
+//      Zone -> Authoritative Name Server
+//----------------------------------------------
+
+type NSRecord struct {
+    RecordHeader
+    Target                  string
+}
+
+//
+// Print the record to stdout (convenience function)
+//
+func (self *NSRecord) Print(indent int) {
+    var indentString string
+    for i := 0 ; i < indent; i++ { indentString += "\t" }
+
+    fmt.Printf("%sNS:\n", indentString)
+    fmt.Printf("%s\t   Label: %s\n", indentString, self.Name)
+    fmt.Printf("%s\t     TTL: %+v\n", indentString, self.TTL)
+    fmt.Printf("%s\t  Target: %+v\n", indentString, self.Target)
+}
+
+//
+// Return the record type
+//
+func (self *NSRecord) GetType() uint16 {
+    return self.Type
+}
+
+//
+// Return the record label
+//
+func (self *NSRecord) GetLabel() string {
+    return self.Name
+}
+
+//
+// Return (serialized) any data that affect the record's "Data Length" property
+//
+func (self *NSRecord) Data() ([]byte, error) {
+    var result = make([]byte, 0)
+    var buffer = bytes.NewBuffer(result)
+
+    label, err := CreateMessageLabel(self.Target)
+    if err != nil { return nil, err }
+    buffer.Write(label)
+
+    return buffer.Bytes(), nil
+}
+
+//
+// Return the data with the target compressed against the message's table
+//
+func (self *NSRecord) CompressedData(table Compression, offset int) ([]byte, error) {
+    return table.Label(self.Target, offset)
+}
+
+//
+// Translate the record into a byte array to be placed in a DNS packet
+//
+func (self *NSRecord) Serialize() ([]byte, error) {
+    var result = make([]byte, 0)
+    var buffer = bytes.NewBuffer(result)
+
+    label, err := CreateMessageLabel(self.Name)
+    if err != nil { return nil, err }
+    buffer.Write(label)
+
+    buffer.Write(Uint16ToBytes(self.Type))
+    buffer.Write(Uint16ToBytes(self.Class))
+    buffer.Write(Uint32ToBytes(uint32(self.TTL.Seconds())))
+
+    data, err := self.Data()
+    if err != nil { return nil, err }
+
+    self.RDataLength = uint16(len(data))
+    buffer.Write(Uint16ToBytes(self.RDataLength))
+
+    buffer.Write(data)
+
+    return buffer.Bytes(), nil
+}
+
+//
+// Create an NS record given the zone, name server, and TTL
+//
+func NS(zone, target string, ttl time.Duration) (*NSRecord, error) {
+    if len(zone) <= 0 {
+        return nil, errors.New("A zone is required.")
+    } else if len(target) <= 0 {
+        return nil, errors.New("The record must contain a name server hostname.")
+    } else if ttl.Seconds() < 5 { // TODO: get actual max class int
+        return nil, errors.New(fmt.Sprintf("TTL of <5s is not supported. Received: %v", ttl))
+    }
+
+    var result = &NSRecord{
+        RecordHeader{
+            Name:        zone,
+            Type:        NS_RECORD,
+            Class:       uint16( 1 ),                 // 'IN' class
+            TTL:         ttl,
+        },
+        target,
+    }
+
+    // serialize to catch errors
+    _, err := result.Serialize()
+    return result, err
+}
+
+//
+// Decode the RDATA of a wire-format NS record
+//
+func unpackNS(header RecordHeader, message []byte, offset int) (Record, error) {
+    target, err := unpackTargetLabel(message, offset)
+    if err != nil { return nil, err }
+
+    return &NSRecord{ header, target }, nil
+}
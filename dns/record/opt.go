@@ -0,0 +1,156 @@
+package record
+
+import (
+    "fmt"
+    "bytes"
+    "errors"
+    "encoding/binary"
+)
+
+//----------------------------------------------
+//  OPT Pseudo-Record
+//      EDNS(0) capabilities of the sender (RFC 6891)
+//----------------------------------------------
+
+// a single {code, data} pair carried in an OPT record
+type EDNSOption struct {
+    Code            uint16
+    Data            []byte
+}
+
+//
+// The OPT record re-purposes the header fields:
+//    CLASS holds the sender's UDP payload size
+//    TTL holds the extended RCODE, EDNS version, and flags (the DO bit)
+// The header Class and TTL are ignored in favor of the fields below
+//
+type OPTRecord struct {
+    RecordHeader
+    UDPSize                 uint16
+    ExtendedRcode           uint8       // upper 8 bits of the 12 bit RCODE
+    Version                 uint8
+    DNSSECOK                bool        // the DO bit
+    Options                 []EDNSOption
+}
+
+//
+// Print the record to stdout (convenience function)
+//
+func (self *OPTRecord) Print(indent int) {
+    var indentString string
+    for i := 0 ; i < indent; i++ { indentString += "\t" }
+
+    fmt.Printf("%sOPT:\n", indentString)
+    fmt.Printf("%s\t UDP Size: %d\n", indentString, self.UDPSize)
+    fmt.Printf("%s\tExt RCode: %d\n", indentString, self.ExtendedRcode)
+    fmt.Printf("%s\t  Version: %d\n", indentString, self.Version)
+    fmt.Printf("%s\t   DO Bit: %v\n", indentString, self.DNSSECOK)
+    fmt.Printf("%s\t  Options: %d\n", indentString, len(self.Options))
+}
+
+//
+// Return the record type
+//
+func (self *OPTRecord) GetType() uint16 {
+    return self.Type
+}
+
+//
+// Return the record label
+//
+func (self *OPTRecord) GetLabel() string {
+    return self.Name
+}
+
+//
+// Return (serialized) any data that affect the record's "Data Length" property
+//
+func (self *OPTRecord) Data() ([]byte, error) {
+    var result = make([]byte, 0)
+    var buffer = bytes.NewBuffer(result)
+
+    for _, option := range self.Options {
+        buffer.Write(Uint16ToBytes(option.Code))
+        buffer.Write(Uint16ToBytes(uint16(len(option.Data))))
+        buffer.Write(option.Data)
+    }
+
+    return buffer.Bytes(), nil
+}
+
+//
+// Translate the record into a byte array to be placed in a DNS packet
+//
+func (self *OPTRecord) Serialize() ([]byte, error) {
+    var result = make([]byte, 0)
+    var buffer = bytes.NewBuffer(result)
+
+    label, err := CreateMessageLabel(self.Name)
+    if err != nil { return nil, err }
+    buffer.Write(label)
+
+    buffer.Write(Uint16ToBytes(self.Type))
+    buffer.Write(Uint16ToBytes(self.UDPSize))
+
+    var flags = uint32(self.ExtendedRcode) << 24 | uint32(self.Version) << 16
+    if self.DNSSECOK { flags |= 0x8000 }
+    buffer.Write(Uint32ToBytes(flags))
+
+    data, err := self.Data()
+    if err != nil { return nil, err }
+
+    self.RDataLength = uint16(len(data))
+    buffer.Write(Uint16ToBytes(self.RDataLength))
+    buffer.Write(data)
+
+    return buffer.Bytes(), nil
+}
+
+//
+// Create an OPT record advertising the given UDP payload size
+//
+func OPT(udpSize uint16) (*OPTRecord, error) {
+    if udpSize < 512 {
+        return nil, errors.New(fmt.Sprintf("UDP payload sizes below 512 are not allowed. Received: %d", udpSize))
+    }
+
+    return &OPTRecord{
+        RecordHeader: RecordHeader{
+            Name:        "",                          // always the root
+            Type:        OPT_RECORD,
+            Class:       udpSize,
+        },
+        UDPSize:        udpSize,
+    }, nil
+}
+
+//
+// Decode the RDATA of a wire-format OPT record
+//
+func unpackOPT(header RecordHeader, message []byte, offset int) (Record, error) {
+    var flags = uint32(header.TTL.Seconds())
+    var result = &OPTRecord{
+        RecordHeader:   header,
+        UDPSize:        header.Class,
+        ExtendedRcode:  uint8(flags >> 24),
+        Version:        uint8(flags >> 16),
+        DNSSECOK:       flags & 0x8000 != 0,
+    }
+
+    for offset < len(message) {
+        if offset + 4 > len(message) { return nil, ErrShortRecord }
+
+        var code = binary.BigEndian.Uint16(message[offset:])
+        var start = offset + 4
+        var finish = start + int(binary.BigEndian.Uint16(message[offset + 2:]))
+        if finish > len(message) { return nil, ErrShortRecord }
+
+        var data = make([]byte, finish - start)
+        copy(data, message[start:finish])
+
+        result.Options = append(result.Options, EDNSOption{ code, data })
+        offset = finish
+    }
+
+    return result, nil
+}
@@ -58,6 +58,13 @@ func (self *PTRRecord) Data() ([]byte, error) {
     return buffer.Bytes(), nil
 }
 
+//
+// Return the data with the target compressed against the message's table
+//
+func (self *PTRRecord) CompressedData(table Compression, offset int) ([]byte, error) {
+    return table.Label(self.Target, offset)
+}
+
 //
 // Translate the record into a byte array to be placed in a DNS packet
 //
@@ -92,7 +99,7 @@ func PTR(name, target string, ttl time.Duration) (*PTRRecord, error) {
     } else if len(target) <= 0 {
         return nil, errors.New("The record must contain a target hostname.")
     } else if ttl.Seconds() < 5 { // TODO: get actual max class int
-        return nil, errors.New(fmt.Sprintf("TTL of <5s is not supported. Received: %d", ttl.Seconds))
+        return nil, errors.New(fmt.Sprintf("TTL of <5s is not supported. Received: %v", ttl))
     }
 
     var result = &PTRRecord{
@@ -109,3 +116,13 @@ func PTR(name, target string, ttl time.Duration) (*PTRRecord, error) {
     _, err := result.Serialize()
     return result, err
 }
+
+//
+// Decode the RDATA of a wire-format PTR record
+//
+func unpackPTR(header RecordHeader, message []byte, offset int) (Record, error) {
+    target, err := unpackTargetLabel(message, offset)
+    if err != nil { return nil, err }
+
+    return &PTRRecord{ header, target }, nil
+}
@@ -4,6 +4,7 @@ import (
     "net"
     "time"
     "bytes"
+    "strings"
     "testing"
 )
 
@@ -389,7 +390,7 @@ func TestSRV_Serialize(t *testing.T) {
     var target = "zed.io"
 
     testSerializeSRV(t, label, target, TTL, priority, weight, port, []byte{
-        0x5f, 0x70, 0x68, 0x6f, 0x6e, 0x65, 0x62, 0x6f, 0x6f, 0x6b,
+        10, 0x5f, 0x70, 0x68, 0x6f, 0x6e, 0x65, 0x62, 0x6f, 0x6f, 0x6b,
         4, 0x5f, 0x74, 0x63, 0x70,
         3, 0x7a, 0x65, 0x64, 2, 0x69, 0x6f, 0x00,
         0x00, 0x21,                                      // type
@@ -412,7 +413,7 @@ func TestSRV_SerializeSubdomain(t *testing.T) {
     var target = "zed.io"
 
     testSerializeSRV(t, label, target, TTL, priority, weight, port, []byte{
-        0x5f, 0x70, 0x68, 0x6f, 0x6e, 0x65, 0x62, 0x6f, 0x6f, 0x6b,
+        10, 0x5f, 0x70, 0x68, 0x6f, 0x6e, 0x65, 0x62, 0x6f, 0x6f, 0x6b,
         4, 0x5f, 0x74, 0x63, 0x70,
         7, 0x77, 0x65, 0x73, 0x74, 0x2d, 0x31, 0x61,
         10, 0x70, 0x72, 0x6f, 0x64, 0x75, 0x63, 0x74, 0x69, 0x6f, 0x6e,
@@ -453,7 +454,7 @@ func TestCNAME_CreateValid(t *testing.T) {
     var label = "mongo-1.testing.zed.io"
     var TTL = 10 * time.Second
     var target = "10-0-2-15.east-1b.zed.io"
-    var knownLength = uint16(len(target) + 1)
+    var knownLength = uint16(len(target) + 2)
 
     testCNAME(t, false, label, target, TTL, knownLength)
 }
@@ -989,3 +990,587 @@ func testSerializeTXT(t *testing.T, label, text string, TTL time.Duration, known
         t.Errorf("Incorrect Record Serialization:\n\tExpected: %+v\n\t     Got: %+v\n", known, serialized)
     }
 }
+
+
+//----------------------------------------------
+// NS Tests
+//----------------------------------------------
+
+func TestNS_CreateValid(t *testing.T) {
+    var record, err = NS("zed.io", "ns1.zed.io", 10 * time.Second)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    if record.GetLabel() != "zed.io" || record.GetType() != NS_RECORD || record.Target != "ns1.zed.io" {
+        t.Errorf("Incorrect NS Record:\n\tExpected: %s\n\tGot: %+v\n", "zed.io NS ns1.zed.io", record)
+    }
+
+    if record.RDataLength != uint16(len("ns1.zed.io") + 2) {
+        t.Errorf("Incorrect Data Length:\n\tExpected: %d\n\tGot: %d\n", len("ns1.zed.io") + 2, record.RDataLength)
+    }
+}
+
+func TestNS_CreateInvalid_EmptyTarget(t *testing.T) {
+    if _, err := NS("zed.io", "", 10 * time.Second); err == nil {
+        t.Errorf("Didn't catch empty target error:\n\tExpected: %s\n\tGot: %+v\n", "non-nil", err)
+    }
+}
+
+
+//----------------------------------------------
+// SOA Tests
+//----------------------------------------------
+
+func TestSOA_CreateValid(t *testing.T) {
+    var record, err = SOA("zed.io", "ns1.zed.io", "admin.zed.io", 60 * time.Second, 2015010101, time.Hour, 10 * time.Minute, 24 * time.Hour, 30 * time.Second)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    if record.GetLabel() != "zed.io" || record.GetType() != SOA_RECORD || record.Serial != 2015010101 {
+        t.Errorf("Incorrect SOA Record:\n\tExpected: %s\n\tGot: %+v\n", "zed.io SOA 2015010101", record)
+    }
+
+    // two names plus five 32 bit timers
+    var length = uint16(len("ns1.zed.io") + 2 + len("admin.zed.io") + 2 + 20)
+    if record.RDataLength != length {
+        t.Errorf("Incorrect Data Length:\n\tExpected: %d\n\tGot: %d\n", length, record.RDataLength)
+    }
+
+    if record.NegativeTTL() != 30 * time.Second {
+        t.Errorf("Incorrect Negative TTL:\n\tExpected: %v\n\tGot: %v\n", 30 * time.Second, record.NegativeTTL())
+    }
+}
+
+func TestSOA_CreateInvalid_EmptyMailbox(t *testing.T) {
+    if _, err := SOA("zed.io", "ns1.zed.io", "", 60 * time.Second, 1, time.Hour, time.Hour, time.Hour, time.Hour); err == nil {
+        t.Errorf("Didn't catch empty mailbox error:\n\tExpected: %s\n\tGot: %+v\n", "non-nil", err)
+    }
+}
+
+
+//----------------------------------------------
+// Decoding Tests
+//----------------------------------------------
+
+func TestUnpack_A(t *testing.T) {
+    var original, _ = A("app.production.zed.io", 10 * time.Second, net.ParseIP("10.0.8.15"))
+    var decoded = testUnpack(t, original).(*ARecord)
+
+    if !decoded.IP.Equal(original.IP) {
+        t.Errorf("Incorrect IP:\n\tExpected: %s\n\tGot: %s\n", original.IP, decoded.IP)
+    }
+}
+
+func TestUnpack_AAAA(t *testing.T) {
+    var original, _ = AAAA("zed.io", 10 * time.Second, net.ParseIP("2001:0db8:85a3:0042:1000:8a2e:0370:7334"))
+    var decoded = testUnpack(t, original).(*AAAARecord)
+
+    if !decoded.IP.Equal(original.IP) {
+        t.Errorf("Incorrect IP:\n\tExpected: %s\n\tGot: %s\n", original.IP, decoded.IP)
+    }
+}
+
+func TestUnpack_SRV(t *testing.T) {
+    var original, _ = SRV("_phonebook._tcp.zed.io", "zed.io", 10 * time.Second, 10, 5, 8053)
+    var decoded = testUnpack(t, original).(*SRVRecord)
+
+    if decoded.Priority != 10 || decoded.Weight != 5 || decoded.Port != 8053 || decoded.Target != "zed.io" {
+        t.Errorf("Incorrect SRV Data:\n\tExpected: %+v\n\tGot: %+v\n", original, decoded)
+    }
+}
+
+func TestUnpack_CNAME(t *testing.T) {
+    var original, _ = CNAME("app.production", "zed.io", 10 * time.Second)
+    var decoded = testUnpack(t, original).(*CNAMERecord)
+
+    if decoded.Target != original.Target {
+        t.Errorf("Incorrect Target:\n\tExpected: %s\n\tGot: %s\n", original.Target, decoded.Target)
+    }
+}
+
+func TestUnpack_PTR(t *testing.T) {
+    var original, _ = PTR("1.0.0.127.in-addr.arpa", "zed.io", 10 * time.Second)
+    var decoded = testUnpack(t, original).(*PTRRecord)
+
+    if decoded.Target != original.Target {
+        t.Errorf("Incorrect Target:\n\tExpected: %s\n\tGot: %s\n", original.Target, decoded.Target)
+    }
+}
+
+func TestUnpack_MX(t *testing.T) {
+    var original, _ = MX("mail.production", "mail.zed.io", 5, 10 * time.Second)
+    var decoded = testUnpack(t, original).(*MXRecord)
+
+    if decoded.Priority != 5 || decoded.Target != original.Target {
+        t.Errorf("Incorrect MX Data:\n\tExpected: %+v\n\tGot: %+v\n", original, decoded)
+    }
+}
+
+func TestUnpack_TXT(t *testing.T) {
+    var original, _ = TXT("mail.production", 10 * time.Second, "admin email -- zach@zed.io")
+    var decoded = testUnpack(t, original).(*TXTRecord)
+
+    if decoded.Text != original.Text {
+        t.Errorf("Incorrect Text Data:\n\tExpected: %s\n\tGot: %s\n", original.Text, decoded.Text)
+    }
+}
+
+func TestUnpack_TXTLong(t *testing.T) {
+    // longer than a single character-string, as split DKIM keys are
+    var text = strings.Repeat("k", 400)
+    var original, err = TXT("mail._domainkey.zed.io", 10 * time.Second, text)
+    if err != nil { t.Fatal(err) }
+
+    data, _ := original.Data()
+    if len(data) != 402 || data[0] != 255 || data[256] != 145 {
+        t.Errorf("Incorrect Text Strings:\n\tExpected: %s\n\tGot: %d bytes, %d then %d\n", "402 bytes, 255 then 145", len(data), data[0], data[256])
+    }
+
+    var decoded = testUnpack(t, original).(*TXTRecord)
+    if decoded.Text != text {
+        t.Errorf("Incorrect Text Data:\n\tExpected: %d bytes\n\tGot: %d bytes\n", len(text), len(decoded.Text))
+    }
+
+    // but the whole must still fit the data length
+    if _, err = TXT("zed.io", 10 * time.Second, strings.Repeat("k", 65280)) ; err != ErrTextTooLong {
+        t.Errorf("Incorrect Error:\n\tExpected: %v\n\tGot: %v\n", ErrTextTooLong, err)
+    }
+}
+
+func TestUnpack_OPT(t *testing.T) {
+    var original, _ = OPT(4096)
+    original.ExtendedRcode = 1
+    original.DNSSECOK = true
+    original.Options = []EDNSOption{ { 10, []byte{ 1, 2, 3, 4, 5, 6, 7, 8 } } }
+
+    var decoded = testUnpack(t, original).(*OPTRecord)
+
+    if decoded.UDPSize != 4096 || decoded.ExtendedRcode != 1 || decoded.Version != 0 || !decoded.DNSSECOK {
+        t.Errorf("Incorrect OPT Header:\n\tExpected: %+v\n\tGot: %+v\n", original, decoded)
+    }
+
+    if len(decoded.Options) != 1 || decoded.Options[0].Code != 10 || bytes.Compare(decoded.Options[0].Data, original.Options[0].Data) != 0 {
+        t.Errorf("Incorrect OPT Options:\n\tExpected: %+v\n\tGot: %+v\n", original.Options, decoded.Options)
+    }
+}
+
+func TestOPT_CreateInvalid_SmallPayload(t *testing.T) {
+    if _, err := OPT(511); err == nil {
+        t.Errorf("Didn't catch small payload size:\n\tExpected: %s\n\tGot: %+v\n", "non-nil", err)
+    }
+}
+
+func TestUnpack_NS(t *testing.T) {
+    var original, _ = NS("zed.io", "ns1.zed.io", 10 * time.Second)
+    var decoded = testUnpack(t, original).(*NSRecord)
+
+    if decoded.Target != original.Target {
+        t.Errorf("Incorrect Target:\n\tExpected: %s\n\tGot: %s\n", original.Target, decoded.Target)
+    }
+}
+
+func TestUnpack_SOA(t *testing.T) {
+    var original, _ = SOA("zed.io", "ns1.zed.io", "admin.zed.io", 60 * time.Second, 2015010101, time.Hour, 10 * time.Minute, 24 * time.Hour, 30 * time.Second)
+    var decoded = testUnpack(t, original).(*SOARecord)
+
+    if decoded.MName != original.MName || decoded.RName != original.RName || decoded.Serial != original.Serial {
+        t.Errorf("Incorrect SOA Names:\n\tExpected: %+v\n\tGot: %+v\n", original, decoded)
+    }
+
+    if decoded.Refresh != original.Refresh || decoded.Retry != original.Retry || decoded.Expire != original.Expire || decoded.Minimum != original.Minimum {
+        t.Errorf("Incorrect SOA Timers:\n\tExpected: %+v\n\tGot: %+v\n", original, decoded)
+    }
+}
+
+func TestUnpack_TSIG(t *testing.T) {
+    var original, _ = TSIG("update.zed.io", "hmac-sha256", time.Unix(1700000000, 0), 300 * time.Second)
+    original.MAC = []byte{ 1, 2, 3, 4 }
+    original.OriginalID = 1234
+    original.Error = 18
+    original.OtherData = TSIGTime(time.Unix(1700000100, 0))
+
+    var decoded = testUnpack(t, original).(*TSIGRecord)
+
+    if decoded.Algorithm != original.Algorithm || !decoded.TimeSigned.Equal(original.TimeSigned) || decoded.Fudge != original.Fudge {
+        t.Errorf("Incorrect TSIG Variables:\n\tExpected: %+v\n\tGot: %+v\n", original, decoded)
+    }
+
+    if bytes.Compare(decoded.MAC, original.MAC) != 0 || decoded.OriginalID != 1234 || decoded.Error != 18 || bytes.Compare(decoded.OtherData, original.OtherData) != 0 {
+        t.Errorf("Incorrect TSIG Data:\n\tExpected: %+v\n\tGot: %+v\n", original, decoded)
+    }
+}
+
+func TestTSIG_CreateInvalid(t *testing.T) {
+    if _, err := TSIG("", "hmac-sha256", time.Now(), time.Minute); err == nil {
+        t.Errorf("Didn't catch empty key name:\n\tExpected: %s\n\tGot: %+v\n", "non-nil", err)
+    }
+    if _, err := TSIG("update.zed.io", "hmac-sha256", time.Now(), 24 * time.Hour); err == nil {
+        t.Errorf("Didn't catch large fudge:\n\tExpected: %s\n\tGot: %+v\n", "non-nil", err)
+    }
+}
+
+func TestUnpack_UnknownType(t *testing.T) {
+    var original = &UnknownRecord{ RecordHeader{ Name: "zed.io", Type: 99, Class: 1, TTL: 10 * time.Second }, []byte{ 1, 2, 3 } }
+    var decoded = testUnpack(t, original).(*UnknownRecord)
+
+    if bytes.Compare(decoded.RData, original.RData) != 0 {
+        t.Errorf("Incorrect Data:\n\tExpected: %+v\n\tGot: %+v\n", original.RData, decoded.RData)
+    }
+}
+
+func TestUnpack_EmptyRData(t *testing.T) {
+    // UPDATE prerequisites and deletes carry a known type but no data
+    var original = &UnknownRecord{ RecordHeader{ Name: "zed.io", Type: A_RECORD, Class: 255 }, nil }
+    var decoded = testUnpack(t, original)
+
+    if _, ok := decoded.(*UnknownRecord); !ok {
+        t.Errorf("Incorrect Decoding:\n\tExpected: %s\n\tGot: %T\n", "*UnknownRecord", decoded)
+    }
+}
+
+func TestUnpack_Truncated(t *testing.T) {
+    var original, _ = SRV("_phonebook._tcp.zed.io", "zed.io", 10 * time.Second, 10, 5, 8053)
+    var serialized, _ = original.Serialize()
+
+    for i := 0 ; i < len(serialized) ; i++ {
+        if _, _, err := UnpackRecord(serialized[:i], 0); err == nil {
+            t.Errorf("Didn't catch truncated record:\n\tLength: %d\n\tGot: %+v\n", i, err)
+        }
+    }
+}
+
+func TestUnpack_Collection(t *testing.T) {
+    var a, _ = A("zed.io", 10 * time.Second, net.ParseIP("127.0.0.1"))
+    var mx, _ = MX("zed.io", "mail.zed.io", 5, 10 * time.Second)
+    var serialized, _ = RecordCollection{ a, mx }.Serialize()
+
+    var decoded, offset, err = UnpackRecords(serialized, 0, 2)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    if offset != len(serialized) {
+        t.Errorf("Incorrect Offset:\n\tExpected: %d\n\tGot: %d\n", len(serialized), offset)
+    }
+
+    if len(decoded) != 2 || decoded[0].GetType() != A_RECORD || decoded[1].GetType() != MX_RECORD {
+        t.Errorf("Incorrect Records:\n\tExpected: %s\n\tGot: %+v\n", "[A MX]", decoded)
+    }
+}
+
+func TestIndexOfData(t *testing.T) {
+    var a, _ = A("zed.io", 10 * time.Second, net.ParseIP("10.0.0.1"))
+    var mx, _ = MX("zed.io", "mail.zed.io", 10, 10 * time.Second)
+    var soa, _ = SOA("zed.io", "ns1.zed.io", "admin.zed.io", time.Hour, 7, time.Hour, time.Minute, 24 * time.Hour, time.Minute)
+    var records = []Record{ a, mx, soa }
+
+    // names in the data compare in any case, everything else exactly
+    var upper, _ = MX("ZED.io", "Mail.ZED.io.", 10, 20 * time.Second)
+    var other, _ = MX("zed.io", "mail.zed.io", 20, 10 * time.Second)
+    var renamed, _ = SOA("zed.io", "NS1.zed.io", "Admin.Zed.Io", time.Hour, 7, time.Hour, time.Minute, 24 * time.Hour, time.Minute)
+
+    if index := IndexOfData(records, upper) ; index != 1 {
+        t.Errorf("Incorrect Index:\n\tExpected: %d\n\tGot: %d\n", 1, index)
+    }
+    if index := IndexOfData(records, renamed) ; index != 2 {
+        t.Errorf("Incorrect Index:\n\tExpected: %d\n\tGot: %d\n", 2, index)
+    }
+    if index := IndexOfData(records, other) ; index != -1 {
+        t.Errorf("Incorrect Index:\n\tExpected: %d\n\tGot: %d\n", -1, index)
+    }
+
+    // and the records themselves are left alone
+    if mx.Target != "mail.zed.io" || upper.Target != "Mail.ZED.io." {
+        t.Errorf("Record modified:\n\tGot: %s, %s\n", mx.Target, upper.Target)
+    }
+}
+
+func testUnpack(t *testing.T, original Record) Record {
+    serialized, err := original.Serialize()
+    if err != nil {
+        t.Fatalf("Error while serializing:\n\t%s\n", err)
+    }
+
+    decoded, offset, err := UnpackRecord(serialized, 0)
+    if err != nil {
+        t.Fatalf("Error while unpacking:\n\t%s\n", err)
+    }
+
+    if offset != len(serialized) {
+        t.Errorf("Incorrect Offset:\n\tExpected: %d\n\tGot: %d\n", len(serialized), offset)
+    }
+
+    if decoded.GetLabel() != original.GetLabel() || decoded.GetType() != original.GetType() {
+        t.Errorf("Incorrect Header:\n\tExpected: %s (%d)\n\tGot: %s (%d)\n", original.GetLabel(), original.GetType(), decoded.GetLabel(), decoded.GetType())
+    }
+
+    reserialized, err := decoded.Serialize()
+    if err != nil {
+        t.Errorf("Error while re-serializing:\n\t%s\n", err)
+    }
+
+    if bytes.Compare(serialized, reserialized) != 0 {
+        t.Errorf("Incorrect Round Trip:\n\tExpected: %+v\n\t     Got: %+v\n", serialized, reserialized)
+    }
+
+    return decoded
+}
+
+
+//----------------------------------------------
+// Compression Tests
+//----------------------------------------------
+
+func TestCompression_Label(t *testing.T) {
+    var table = make(Compression)
+
+    // the first occurrence is written in full
+    first, err := table.Label("zed.io", 12)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    var knownFirst = []byte{ 3, 0x7A, 0x65, 0x64, 2, 0x69, 0x6F, 0x00 }
+    if bytes.Compare(first, knownFirst) != 0 {
+        t.Errorf("Incorrect Label:\n\tExpected: %+v\n\t     Got: %+v\n", knownFirst, first)
+    }
+
+    // the shared suffix is replaced with a pointer to offset 12
+    second, err := table.Label("mail.ZED.io.", 20)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    var knownSecond = []byte{ 4, 0x6d, 0x61, 0x69, 0x6c, 0xC0, 12 }
+    if bytes.Compare(second, knownSecond) != 0 {
+        t.Errorf("Incorrect Compressed Label:\n\tExpected: %+v\n\t     Got: %+v\n", knownSecond, second)
+    }
+
+    // suffixes of the second name are now known too
+    third, err := table.Label("smtp.mail.zed.io", 27)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    var knownThird = []byte{ 4, 0x73, 0x6d, 0x74, 0x70, 0xC0, 20 }
+    if bytes.Compare(third, knownThird) != 0 {
+        t.Errorf("Incorrect Compressed Label:\n\tExpected: %+v\n\t     Got: %+v\n", knownThird, third)
+    }
+}
+
+func TestCompression_Collection(t *testing.T) {
+    var a, _ = A("zed.io", 10 * time.Second, net.ParseIP("127.0.0.1"))
+    var mx, _ = MX("zed.io", "mail.zed.io", 5, 10 * time.Second)
+    var srv, _ = SRV("_phonebook._tcp.zed.io", "app.zed.io", 10 * time.Second, 10, 5, 8053)
+    var collection = RecordCollection{ a, mx, srv }
+
+    plain, _ := collection.Serialize()
+    compressed, err := collection.SerializeCompressed(make(Compression), 0)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    if len(compressed) >= len(plain) {
+        t.Errorf("Compression did not save space:\n\tPlain: %d\n\tCompressed: %d\n", len(plain), len(compressed))
+    }
+
+    decoded, offset, err := UnpackRecords(compressed, 0, 3)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    if offset != len(compressed) {
+        t.Errorf("Incorrect Offset:\n\tExpected: %d\n\tGot: %d\n", len(compressed), offset)
+    }
+
+    if decoded[1].GetLabel() != "zed.io" || decoded[1].(*MXRecord).Target != "mail.zed.io" {
+        t.Errorf("Incorrect MX Record:\n\tExpected: %+v\n\tGot: %+v\n", mx, decoded[1])
+    }
+
+    // SRV targets are never compressed (RFC 2782), but the owner is
+    if decoded[2].GetLabel() != srv.Name || decoded[2].(*SRVRecord).Target != srv.Target {
+        t.Errorf("Incorrect SRV Record:\n\tExpected: %+v\n\tGot: %+v\n", srv, decoded[2])
+    }
+}
+
+func TestCompression_PointerLoop(t *testing.T) {
+    var message = [][]byte{
+        { 0xC0, 0x00 },                             // points at itself
+        { 3, 0x7A, 0x65, 0x64, 0xC0, 0x00 },        // points back at its own start
+        { 0x00, 0xC0, 0x03, 0xC0, 0x01 },           // forward pointer
+        { 0xC0 },                                   // truncated pointer
+        { 0x40, 0x00 },                             // reserved label type
+    }
+
+    for i, source := range message {
+        var start = 0
+        if i == 2 { start = 1 }
+
+        if _, _, err := ReadMessageLabel(source, start); err == nil {
+            t.Errorf("Didn't catch invalid pointer:\n\tMessage: %+v\n\tGot: %+v\n", source, err)
+        }
+    }
+}
+
+//----------------------------------------------
+// Rename Tests
+//----------------------------------------------
+
+func TestRename(t *testing.T) {
+    original, err := MX("*.zed.io", "mail.zed.io", 10, 10 * time.Second)
+    if err != nil { t.Fatal(err) }
+
+    var renamed = Rename(original, "branch.zed.io")
+    var mx, ok = renamed.(*MXRecord)
+    if !ok { t.Fatalf("Incorrect Type:\n\tExpected: %s\n\tGot: %T\n", "*MXRecord", renamed) }
+
+    if mx.Name != "branch.zed.io" || mx.Target != original.Target || mx.Priority != original.Priority {
+        t.Errorf("Incorrect Copy:\n\tExpected: %s\n\tGot: %+v\n", "branch.zed.io -> mail.zed.io", mx)
+    }
+    if original.Name != "*.zed.io" {
+        t.Errorf("Original Was Modified:\n\tGot: %s\n", original.Name)
+    }
+}
+
+//----------------------------------------------
+// Name Tests
+//----------------------------------------------
+
+func TestName_Canonical(t *testing.T) {
+    var cases = map[string]string{
+        "App.Production.ZED.io.":   "app.production.zed.io",
+        "zed.io":                   "zed.io",
+        ".":                        "",
+        "a\\.b.zed.io":             "a\\.b.zed.io",
+        "\\065pp.zed.io":           "app.zed.io",
+        "sp\\ ace.zed.io":          "sp\\032ace.zed.io",
+    }
+
+    for source, expected := range cases {
+        name, err := ParseName(source)
+        if err != nil {
+            t.Errorf("Could not parse %s: %s\n", source, err)
+            continue
+        }
+
+        if string(name) != expected {
+            t.Errorf("Incorrect Canonical Name:\n\tExpected: %s\n\tGot: %s\n", expected, name)
+        }
+    }
+
+    if !NamesEqual("WWW.zed.io.", "www.ZED.io") {
+        t.Errorf("Names differing in case and trailing dot should be equal\n")
+    }
+}
+
+func TestName_Invalid(t *testing.T) {
+    var long = strings.Repeat("a", 64)
+    var cases = map[string]error{
+        "a..b":                                     ErrEmptyLabel,
+        ".zed.io":                                  ErrEmptyLabel,
+        long + ".zed.io":                           ErrLabelTooLong,
+        strings.Repeat("abcdefg.", 32) + "io":      ErrNameTooLong,
+        "bad\\":                                    ErrInvalidEscape,
+        "bad\\12":                                  ErrInvalidEscape,
+        "bad\\999":                                 ErrInvalidEscape,
+    }
+
+    for source, expected := range cases {
+        if _, err := ParseName(source); err != expected {
+            t.Errorf("Incorrect Error For %s:\n\tExpected: %v\n\tGot: %v\n", source, expected, err)
+        }
+    }
+
+    // constructors reject names that cannot be sent
+    if _, err := A(long + ".zed.io", 10 * time.Second, net.ParseIP("10.0.0.1")); err != ErrLabelTooLong {
+        t.Errorf("Incorrect Error:\n\tExpected: %v\n\tGot: %v\n", ErrLabelTooLong, err)
+    }
+}
+
+func TestName_Hierarchy(t *testing.T) {
+    var name = Name("a\\.b.zed.io")
+
+    if len(name.Labels()) != 3 || string(name.Labels()[0]) != "a.b" {
+        t.Errorf("Incorrect Labels:\n\tGot: %q\n", name.Labels())
+    }
+    if name.Parent() != "zed.io" || Name("io").Parent() != "" {
+        t.Errorf("Incorrect Parent:\n\tGot: %s\n", name.Parent())
+    }
+    if !name.IsSubdomain("zed.io") || !name.IsSubdomain("") || name.IsSubdomain("b.zed.io") {
+        t.Errorf("Incorrect Subdomain Check For %s\n", name)
+    }
+    if !Name("*.zed.io").IsWildcard() || name.IsWildcard() {
+        t.Errorf("Incorrect Wildcard Check\n")
+    }
+}
+
+func TestName_WireRoundTrip(t *testing.T) {
+    // a dot inside a label survives the trip through the wire format
+    packed, err := CreateMessageLabel("A\\.b.Zed.io")
+    if err != nil { t.Fatal(err) }
+
+    var expected = []byte{ 3, 'A', '.', 'b', 3, 'Z', 'e', 'd', 2, 'i', 'o', 0 }
+    if !bytes.Equal(packed, expected) {
+        t.Errorf("Incorrect Packing:\n\tExpected: %v\n\tGot: %v\n", expected, packed)
+    }
+
+    name, _, err := ReadMessageLabel(packed, 0)
+    if err != nil { t.Fatal(err) }
+    if name != "A\\.b.Zed.io" {
+        t.Errorf("Incorrect Name:\n\tExpected: %s\n\tGot: %s\n", "A\\.b.Zed.io", name)
+    }
+}
+
+func TestReadMessageLabel_TooLong(t *testing.T) {
+    var label = append([]byte{ 63 }, bytes.Repeat([]byte{ 'x' }, 63)...)
+
+    // 193 bytes on its own, then a label pointing back at it makes 257
+    var message = bytes.Repeat(label, 3)
+    message = append(message, 0)
+    message = append(message, label...)
+    message = append(message, 0xC0, 0x00)
+
+    if _, _, err := ReadMessageLabel(message, 0); err != nil {
+        t.Errorf("Rejected a valid name:\n\tGot: %+v\n", err)
+    }
+    if _, _, err := ReadMessageLabel(message, 193); err != ErrNameTooLong {
+        t.Errorf("Didn't catch long name:\n\tExpected: %s\n\tGot: %+v\n", ErrNameTooLong, err)
+    }
+}
+
+func TestUnpack_ImpossibleCount(t *testing.T) {
+    var a, _ = A("zed.io", 10 * time.Second, net.ParseIP("127.0.0.1"))
+    var serialized, _ = a.Serialize()
+
+    if _, _, err := UnpackRecords(serialized, 0, 0xFFFF); err != ErrShortRecord {
+        t.Errorf("Didn't catch impossible count:\n\tExpected: %s\n\tGot: %+v\n", ErrShortRecord, err)
+    }
+}
+
+func FuzzUnpackRecord(f *testing.F) {
+    var a, _ = A("zed.io", 10 * time.Second, net.ParseIP("127.0.0.1"))
+    var aaaa, _ = AAAA("zed.io", 10 * time.Second, net.ParseIP("::1"))
+    var srv, _ = SRV("_phonebook._tcp.zed.io", "zed.io", 10 * time.Second, 10, 5, 8053)
+    var mx, _ = MX("zed.io", "mail.zed.io", 5, 10 * time.Second)
+    var txt, _ = TXT("zed.io", 10 * time.Second, "phonebook")
+    var soa, _ = SOA("zed.io", "ns1.zed.io", "admin.zed.io", time.Hour, 1, time.Hour, time.Minute, 24 * time.Hour, time.Minute)
+    var opt, _ = OPT(1232)
+
+    for _, rec := range []Record{ a, aaaa, srv, mx, txt, soa, opt } {
+        serialized, err := rec.Serialize()
+        if err != nil { f.Fatal(err) }
+        f.Add(serialized)
+    }
+    f.Add([]byte{ 0xC0, 0x00 })
+
+    f.Fuzz(func(t *testing.T, source []byte) {
+        rec, offset, err := UnpackRecord(source, 0)
+        if err != nil { return }
+
+        if rec == nil || offset > len(source) {
+            t.Errorf("Incorrect Unpack:\n\tLength: %d\n\tGot: %+v at %d\n", len(source), rec, offset)
+        }
+    })
+}
@@ -0,0 +1,214 @@
+package record
+
+import (
+    "fmt"
+    "time"
+    "bytes"
+    "errors"
+    "encoding/binary"
+)
+
+//----------------------------------------------
+//  SOA Record
+//      Zone -> Start of Authority
+//----------------------------------------------
+
+type SOARecord struct {
+    RecordHeader
+    MName                   string              // primary name server of the zone
+    RName                   string              // mailbox of the zone admin, '@' written as '.'
+    Serial                  uint32
+    Refresh                 time.Duration
+    Retry                   time.Duration
+    Expire                  time.Duration
+    Minimum                 time.Duration       // TTL of negative answers (RFC 2308)
+}
+
+//
+// Print the record to stdout (convenience function)
+//
+func (self *SOARecord) Print(indent int) {
+    var indentString string
+    for i := 0 ; i < indent; i++ { indentString += "\t" }
+
+    fmt.Printf("%sSOA:\n", indentString)
+    fmt.Printf("%s\t   Label: %s\n", indentString, self.Name)
+    fmt.Printf("%s\t     TTL: %+v\n", indentString, self.TTL)
+    fmt.Printf("%s\t   MName: %s\n", indentString, self.MName)
+    fmt.Printf("%s\t   RName: %s\n", indentString, self.RName)
+    fmt.Printf("%s\t  Serial: %d\n", indentString, self.Serial)
+    fmt.Printf("%s\t Refresh: %+v\n", indentString, self.Refresh)
+    fmt.Printf("%s\t   Retry: %+v\n", indentString, self.Retry)
+    fmt.Printf("%s\t  Expire: %+v\n", indentString, self.Expire)
+    fmt.Printf("%s\t Minimum: %+v\n", indentString, self.Minimum)
+}
+
+//
+// Return the record type
+//
+func (self *SOARecord) GetType() uint16 {
+    return self.Type
+}
+
+//
+// Return the record label
+//
+func (self *SOARecord) GetLabel() string {
+    return self.Name
+}
+
+//
+// Return (serialized) the timers following the two names
+//
+func (self *SOARecord) timers() []byte {
+    var result = make([]byte, 0)
+    var buffer = bytes.NewBuffer(result)
+
+    buffer.Write(Uint32ToBytes(self.Serial))
+    buffer.Write(Uint32ToBytes(uint32(self.Refresh.Seconds())))
+    buffer.Write(Uint32ToBytes(uint32(self.Retry.Seconds())))
+    buffer.Write(Uint32ToBytes(uint32(self.Expire.Seconds())))
+    buffer.Write(Uint32ToBytes(uint32(self.Minimum.Seconds())))
+
+    return buffer.Bytes()
+}
+
+//
+// Return (serialized) any data that affect the record's "Data Length" property
+//
+func (self *SOARecord) Data() ([]byte, error) {
+    var result = make([]byte, 0)
+    var buffer = bytes.NewBuffer(result)
+
+    mname, err := CreateMessageLabel(self.MName)
+    if err != nil { return nil, err }
+    buffer.Write(mname)
+
+    rname, err := CreateMessageLabel(self.RName)
+    if err != nil { return nil, err }
+    buffer.Write(rname)
+
+    buffer.Write(self.timers())
+
+    return buffer.Bytes(), nil
+}
+
+//
+// Return the data with both names compressed against the message's table
+//
+func (self *SOARecord) CompressedData(table Compression, offset int) ([]byte, error) {
+    var result = make([]byte, 0)
+    var buffer = bytes.NewBuffer(result)
+
+    mname, err := table.Label(self.MName, offset)
+    if err != nil { return nil, err }
+    buffer.Write(mname)
+
+    rname, err := table.Label(self.RName, offset + buffer.Len())
+    if err != nil { return nil, err }
+    buffer.Write(rname)
+
+    buffer.Write(self.timers())
+
+    return buffer.Bytes(), nil
+}
+
+//
+// Translate the record into a byte array to be placed in a DNS packet
+//
+func (self *SOARecord) Serialize() ([]byte, error) {
+    var result = make([]byte, 0)
+    var buffer = bytes.NewBuffer(result)
+
+    label, err := CreateMessageLabel(self.Name)
+    if err != nil { return nil, err }
+    buffer.Write(label)
+
+    buffer.Write(Uint16ToBytes(self.Type))
+    buffer.Write(Uint16ToBytes(self.Class))
+    buffer.Write(Uint32ToBytes(uint32(self.TTL.Seconds())))
+
+    data, err := self.Data()
+    if err != nil { return nil, err }
+
+    self.RDataLength = uint16(len(data))
+    buffer.Write(Uint16ToBytes(self.RDataLength))
+
+    buffer.Write(data)
+
+    return buffer.Bytes(), nil
+}
+
+//
+// Return the TTL to use when the SOA is sent along with a negative answer (RFC 2308 3)
+//
+func (self *SOARecord) NegativeTTL() time.Duration {
+    if self.Minimum < self.TTL {
+        return self.Minimum
+    }
+    return self.TTL
+}
+
+//
+// Create an SOA record given the zone, primary name server, admin mailbox, TTL, serial, and timers
+//
+func SOA(zone, mname, rname string, ttl time.Duration, serial uint32, refresh, retry, expire, minimum time.Duration) (*SOARecord, error) {
+    if len(zone) <= 0 {
+        return nil, errors.New("A zone is required.")
+    } else if len(mname) <= 0 {
+        return nil, errors.New("The record must contain a primary name server.")
+    } else if len(rname) <= 0 {
+        return nil, errors.New("The record must contain an admin mailbox.")
+    } else if ttl.Seconds() < 5 { // TODO: get actual max class int
+        return nil, errors.New(fmt.Sprintf("TTL of <5s is not supported. Received: %v", ttl))
+    }
+
+    var result = &SOARecord{
+        RecordHeader{
+            Name:        zone,
+            Type:        SOA_RECORD,
+            Class:       uint16( 1 ),                 // 'IN' class
+            TTL:         ttl,
+        },
+        mname,
+        rname,
+        serial,
+        refresh,
+        retry,
+        expire,
+        minimum,
+    }
+
+    // serialize to catch errors
+    _, err := result.Serialize()
+    return result, err
+}
+
+//
+// Decode the RDATA of a wire-format SOA record
+//
+func unpackSOA(header RecordHeader, message []byte, offset int) (Record, error) {
+    mname, offset, err := ReadMessageLabel(message, offset)
+    if err != nil { return nil, err }
+
+    rname, offset, err := ReadMessageLabel(message, offset)
+    if err != nil { return nil, err }
+
+    // five 32 bit timers must fill the rest of the data
+    if offset + 20 != len(message) { return nil, ErrShortRecord }
+
+    var seconds = func(i int) time.Duration {
+        return time.Duration(binary.BigEndian.Uint32(message[offset + i * 4:])) * time.Second
+    }
+
+    return &SOARecord{
+        header,
+        mname,
+        rname,
+        binary.BigEndian.Uint32(message[offset:]),
+        seconds(1),
+        seconds(2),
+        seconds(3),
+        seconds(4),
+    }, nil
+}
@@ -104,7 +104,7 @@ func SRV(name, target string, ttl time.Duration, priority, weight, port uint16)
     } else if len(target) <= 0 {
         return nil, errors.New("The record must contain a target hostname.")
     } else if ttl.Seconds() < 5 { // TODO: get actual max class int
-        return nil, errors.New(fmt.Sprintf("TTL of <5s is not supported. Received: %d", ttl.Seconds))
+        return nil, errors.New(fmt.Sprintf("TTL of <5s is not supported. Received: %v", ttl))
     }
 
     var result = &SRVRecord{
@@ -124,3 +124,20 @@ func SRV(name, target string, ttl time.Duration, priority, weight, port uint16)
     _, err := result.Serialize()
     return result, err
 }
+
+//
+// Decode the RDATA of a wire-format SRV record
+//
+func unpackSRV(header RecordHeader, message []byte, offset int) (Record, error) {
+    // priority, weight, and port precede the target
+    if header.RDataLength < 7 { return nil, ErrShortRecord }
+
+    var priority = binary.BigEndian.Uint16(message[offset:])
+    var weight = binary.BigEndian.Uint16(message[offset + 2:])
+    var port = binary.BigEndian.Uint16(message[offset + 4:])
+
+    target, err := unpackTargetLabel(message, offset + 6)
+    if err != nil { return nil, err }
+
+    return &SRVRecord{ header, priority, weight, port, target }, nil
+}
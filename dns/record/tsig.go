@@ -0,0 +1,197 @@
+package record
+
+import (
+    "fmt"
+    "time"
+    "bytes"
+    "errors"
+    "encoding/binary"
+)
+
+//----------------------------------------------
+//  TSIG Meta-Record
+//      Transaction signature over a whole message (RFC 8945)
+//----------------------------------------------
+
+//
+// The TSIG record is always the last record of the additional section
+// Its owner is the name of the key used, the class is ANY and the TTL zero
+// Names are written uncompressed and (the algorithm) in canonical form
+//
+type TSIGRecord struct {
+    RecordHeader
+    Algorithm               string
+    TimeSigned              time.Time           // whole seconds, 48 bits on the wire
+    Fudge                   time.Duration       // permitted clock skew, whole seconds
+    MAC                     []byte
+    OriginalID              uint16              // the message ID before any forwarder rewrote it
+    Error                   uint16              // extended error (BADSIG, BADKEY, ...) for responses
+    OtherData               []byte              // the server's time for BADTIME
+}
+
+//
+// Print the record to stdout (convenience function)
+//
+func (self *TSIGRecord) Print(indent int) {
+    var indentString string
+    for i := 0 ; i < indent; i++ { indentString += "\t" }
+
+    fmt.Printf("%sTSIG:\n", indentString)
+    fmt.Printf("%s\t      Key: %s\n", indentString, self.Name)
+    fmt.Printf("%s\tAlgorithm: %s\n", indentString, self.Algorithm)
+    fmt.Printf("%s\t   Signed: %v\n", indentString, self.TimeSigned)
+    fmt.Printf("%s\t    Fudge: %v\n", indentString, self.Fudge)
+    fmt.Printf("%s\t      MAC: %x\n", indentString, self.MAC)
+    fmt.Printf("%s\t    Error: %d\n", indentString, self.Error)
+}
+
+//
+// Return the record type
+//
+func (self *TSIGRecord) GetType() uint16 {
+    return self.Type
+}
+
+//
+// Return the record label
+//
+func (self *TSIGRecord) GetLabel() string {
+    return self.Name
+}
+
+//
+// Return (serialized) any data that affect the record's "Data Length" property
+//
+func (self *TSIGRecord) Data() ([]byte, error) {
+    var result = make([]byte, 0)
+    var buffer = bytes.NewBuffer(result)
+
+    algorithm, err := CreateMessageLabel(Canonical(self.Algorithm))
+    if err != nil { return nil, err }
+    buffer.Write(algorithm)
+
+    buffer.Write(self.Timers())
+    buffer.Write(Uint16ToBytes(uint16(len(self.MAC))))
+    buffer.Write(self.MAC)
+    buffer.Write(Uint16ToBytes(self.OriginalID))
+    buffer.Write(Uint16ToBytes(self.Error))
+    buffer.Write(Uint16ToBytes(uint16(len(self.OtherData))))
+    buffer.Write(self.OtherData)
+
+    return buffer.Bytes(), nil
+}
+
+//
+// Return the time signed (48 bits) and fudge (16 bits) as written on the wire
+//
+func (self *TSIGRecord) Timers() []byte {
+    return append(TSIGTime(self.TimeSigned), Uint16ToBytes(uint16(self.Fudge / time.Second))...)
+}
+
+//
+// Return a time as the 48 bit count of seconds TSIG uses (also the other data of a BADTIME error)
+//
+func TSIGTime(source time.Time) []byte {
+    var seconds = uint64(source.Unix())
+    return append(Uint16ToBytes(uint16(seconds >> 32)), Uint32ToBytes(uint32(seconds))...)
+}
+
+//
+// Translate the record into a byte array to be placed in a DNS packet
+//
+func (self *TSIGRecord) Serialize() ([]byte, error) {
+    var result = make([]byte, 0)
+    var buffer = bytes.NewBuffer(result)
+
+    label, err := CreateMessageLabel(self.Name)
+    if err != nil { return nil, err }
+    buffer.Write(label)
+
+    buffer.Write(Uint16ToBytes(self.Type))
+    buffer.Write(Uint16ToBytes(CLASS_ANY))
+    buffer.Write(Uint32ToBytes(0))
+
+    data, err := self.Data()
+    if err != nil { return nil, err }
+
+    self.RDataLength = uint16(len(data))
+    buffer.Write(Uint16ToBytes(self.RDataLength))
+    buffer.Write(data)
+
+    return buffer.Bytes(), nil
+}
+
+//
+// Create an (unsigned) TSIG record for the given key and algorithm names
+// The MAC is filled in when a message is signed with it
+//
+func TSIG(key, algorithm string, signed time.Time, fudge time.Duration) (*TSIGRecord, error) {
+    if len(key) <= 0 {
+        return nil, errors.New("A key name is required.")
+    } else if len(algorithm) <= 0 {
+        return nil, errors.New("An algorithm is required.")
+    } else if fudge < 0 || fudge / time.Second > 0xFFFF {
+        return nil, errors.New(fmt.Sprintf("Fudge must fit in 16 bits of seconds. Received: %v", fudge))
+    }
+
+    var result = &TSIGRecord{
+        RecordHeader: RecordHeader{
+            Name:        key,
+            Type:        TSIG_RECORD,
+            Class:       CLASS_ANY,
+        },
+        Algorithm:      algorithm,
+        TimeSigned:     signed.Truncate(time.Second),
+        Fudge:          fudge.Truncate(time.Second),
+    }
+
+    // serialize to catch errors
+    _, err := result.Serialize()
+    return result, err
+}
+
+//
+// Decode the RDATA of a wire-format TSIG record
+//
+func unpackTSIG(header RecordHeader, message []byte, offset int) (Record, error) {
+    // the algorithm name is never compressed, but reading it as if it could be is harmless
+    algorithm, offset, err := ReadMessageLabel(message, offset)
+    if err != nil { return nil, err }
+
+    // timers and the MAC size
+    if offset + 10 > len(message) { return nil, ErrShortRecord }
+
+    var signed = uint64(binary.BigEndian.Uint16(message[offset:])) << 32 | uint64(binary.BigEndian.Uint32(message[offset + 2:]))
+    var fudge = time.Duration(binary.BigEndian.Uint16(message[offset + 6:])) * time.Second
+    var macSize = int(binary.BigEndian.Uint16(message[offset + 8:]))
+    offset += 10
+
+    // MAC, original ID, error, and other data size
+    if offset + macSize + 6 > len(message) { return nil, ErrShortRecord }
+
+    var mac = make([]byte, macSize)
+    copy(mac, message[offset:])
+    offset += macSize
+
+    var originalID = binary.BigEndian.Uint16(message[offset:])
+    var tsigError = binary.BigEndian.Uint16(message[offset + 2:])
+    var otherSize = int(binary.BigEndian.Uint16(message[offset + 4:]))
+    offset += 6
+
+    // the other data must fill the rest of the record
+    if offset + otherSize != len(message) { return nil, ErrShortRecord }
+
+    var other = make([]byte, otherSize)
+    copy(other, message[offset:])
+
+    return &TSIGRecord{
+        RecordHeader:   header,
+        Algorithm:      algorithm,
+        TimeSigned:     time.Unix(int64(signed), 0),
+        Fudge:          fudge,
+        MAC:            mac,
+        OriginalID:     originalID,
+        Error:          tsigError,
+        OtherData:      other,
+    }, nil
+}
@@ -12,9 +12,19 @@ import (
 //      Hostname -> Text Data
 //----------------------------------------------
 
+const (
+    // a character-string holds at most 255 bytes, so longer text is sent as several in a row
+    TXT_STRING_MAX  int     = 255
+
+    // the most text that fits the 16 bit data length, with a length byte for each string
+    TXT_TEXT_MAX    int     = 65279
+)
+
+var ErrTextTooLong error = errors.New("ERROR: TXT records may not hold more than 65279 bytes of text")
+
 type TXTRecord struct {
     RecordHeader
-    Text            string
+    Text            string          // longer than TXT_STRING_MAX goes out as several strings
 }
 
 //
@@ -51,9 +61,17 @@ func (self *TXTRecord) Data() ([]byte, error) {
     var result = make([]byte, 0)
     var buffer = bytes.NewBuffer(result)
 
-    buffer.Write([]byte{ byte(len(self.Text)) })
-    buffer.Write([]byte(self.Text))
+    var text = self.Text
+    for {
+        var part = text[:min(len(text), TXT_STRING_MAX)]
+        buffer.Write([]byte{ byte(len(part)) })
+        buffer.Write([]byte(part))
 
+        text = text[len(part):]
+        if len(text) == 0 { break }
+    }
+
+    if buffer.Len() > 0xFFFF { return nil, ErrTextTooLong }
     return buffer.Bytes(), nil
 }
 
@@ -89,14 +107,18 @@ func TXT(hostname string, ttl time.Duration, text string) (*TXTRecord, error) {
     if len(hostname) <= 0 {
         return nil, errors.New(fmt.Sprintf("The record must contain a hostname. Received: '%s'.", hostname))
     } else if ttl.Seconds() < 5 { // TODO: get actual max class int
-        return nil, errors.New(fmt.Sprintf("TTL of <5s is not supported. Received: %d", ttl.Seconds))
+        return nil, errors.New(fmt.Sprintf("TTL of <5s is not supported. Received: %v", ttl))
     } else if len(text) == 0 {
         return nil, errors.New("Cannot store emtpy TXT record")
     }
 
+    // the hostname must be a name we can send
+    if _, err := ParseName(hostname) ; err != nil { return nil, err }
+
     // TODO: add checks on target -- are we remapping the current IP and some other security stuff
 
-    // TODO: verify text data length fits into 16bits
+    if len(text) > TXT_TEXT_MAX { return nil, ErrTextTooLong }
+
     return &TXTRecord{
         RecordHeader{
             hostname,
@@ -108,3 +130,22 @@ func TXT(hostname string, ttl time.Duration, text string) (*TXTRecord, error) {
         text,
     }, nil
 }
+
+//
+// Decode the RDATA of a wire-format TXT record
+// Multiple character-strings are joined into the single Text field
+//
+func unpackTXT(header RecordHeader, message []byte, offset int) (Record, error) {
+    var text string
+
+    for offset < len(message) {
+        var start = offset + 1
+        var finish = start + int(message[offset])
+        if finish > len(message) { return nil, ErrShortRecord }
+
+        text += string(message[start:finish])
+        offset = finish
+    }
+
+    return &TXTRecord{ header, text }, nil
+}
@@ -0,0 +1,76 @@
+package record
+
+import (
+    "fmt"
+    "bytes"
+)
+
+//----------------------------------------------
+//  Unknown Record
+//      Opaque RDATA for types we cannot decode (RFC 3597)
+//----------------------------------------------
+
+type UnknownRecord struct {
+    RecordHeader
+    RData           []byte
+}
+
+//
+// Print the record to stdout (convenience function)
+//
+func (self *UnknownRecord) Print(indent int) {
+    var indentString string
+    for i := 0 ; i < indent; i++ { indentString += "\t" }
+
+    fmt.Printf("%sTYPE%d:\n", indentString, self.Type)
+    fmt.Printf("%s\tLabel: %s\n", indentString, self.Name)
+    fmt.Printf("%s\tClass: %d\n", indentString, self.Class)
+    fmt.Printf("%s\t  TTL: %+v\n", indentString, self.TTL)
+    fmt.Printf("%s\t Data: %+v\n", indentString, self.RData)
+}
+
+//
+// Return the record type
+//
+func (self *UnknownRecord) GetType() uint16 {
+    return self.Type
+}
+
+//
+// Return the record label
+//
+func (self *UnknownRecord) GetLabel() string {
+    return self.Name
+}
+
+//
+// Return (serialized) any data that affect the record's "Data Length" property
+//
+func (self *UnknownRecord) Data() ([]byte, error) {
+    return self.RData, nil
+}
+
+//
+// Translate the record into a byte array to be placed in a DNS packet
+//
+func (self *UnknownRecord) Serialize() ([]byte, error) {
+    var result = make([]byte, 0)
+    var buffer = bytes.NewBuffer(result)
+
+    label, err := CreateMessageLabel(self.Name)
+    if err != nil { return nil, err }
+    buffer.Write(label)
+
+    buffer.Write(Uint16ToBytes(self.Type))
+    buffer.Write(Uint16ToBytes(self.Class))
+    buffer.Write(Uint32ToBytes(uint32(self.TTL.Seconds())))
+
+    data, err := self.Data()
+    if err != nil { return nil, err }
+
+    self.RDataLength = uint16(len(data))
+    buffer.Write(Uint16ToBytes(self.RDataLength))
+    buffer.Write(data)
+
+    return buffer.Bytes(), nil
+}
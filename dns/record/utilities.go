@@ -2,6 +2,7 @@ package record
 
 import (
     "bytes"
+    "reflect"
     "strings"
     "encoding/binary"
 )
@@ -12,25 +13,165 @@ import (
 func CreateMessageLabel(source string) ([]byte, error) {
     var result = make([]byte, 0)
     var buffer = bytes.NewBuffer(result)
-    var parts = strings.Split(source, ".")
 
-    for _, part := range parts {
-        if part == "" {
-            buffer.Write([]byte{0})
+    labels, err := SplitName(source)
+    if err != nil { return nil, err }
+
+    for _, label := range labels {
+        binary.Write( buffer, binary.BigEndian, uint8(len(label)) )
+
+        _, err := buffer.Write(label)
+        if err != nil { return nil, err }
+    }
+
+    buffer.Write([]byte{0})
+    return buffer.Bytes(), nil
+}
+
+//
+// Read a packed DNS label out of a message starting at offset, following
+// compression pointers (RFC 1035 4.1.4)
+// Returns the dotted label (presentation format, case preserved) and the offset of the first byte following it
+//
+func ReadMessageLabel(message []byte, offset int) (string, int, error) {
+    var parts = make([]string, 0)
+
+    // where the label ends in the message -- set at the first pointer followed
+    var next = -1
+
+    // pointers may only jump backwards, which rules out loops
+    var limit = offset
+
+    // the name's length on the wire, counting its length bytes and the root (RFC 1035 2.3.4)
+    var size = 1
+
+    for {
+        if offset >= len(message) { return "", 0, ErrShortLabel }
+
+        var length = int(message[offset])
+        if length == 0 {
+            offset += 1
+            break
+        }
+
+        if length & 0xC0 == 0xC0 {
+            if offset + 2 > len(message) { return "", 0, ErrShortLabel }
+
+            var target = int(message[offset] & 0x3F) << 8 | int(message[offset + 1])
+            if target >= limit { return "", 0, ErrLabelPointer }
+
+            if next < 0 { next = offset + 2 }
+            offset = target
+            limit = target
             continue
+        } else if length & 0xC0 != 0 {
+            // 0x40 and 0x80 are reserved label types
+            return "", 0, ErrLabelPointer
         }
 
-        binary.Write( buffer, binary.BigEndian, uint8(len(part)) )
+        var start = offset + 1
+        var finish = start + length
+        if finish > len(message) { return "", 0, ErrShortLabel }
 
-        _, err := buffer.Write([]byte(part))
-        if err != nil { return nil, err }
+        size += length + 1
+        if size > MAX_NAME_LENGTH { return "", 0, ErrNameTooLong }
+
+        // labels may hold any byte -- escape them so the name can be parsed back
+        parts = append(parts, escapeLabel(message[start:finish]))
+        offset = finish
+    }
+
+    if next < 0 { next = offset }
+    return strings.Join(parts, "."), next, nil
+}
+
+//
+// Return a (shallow) copy of the record
+// Every record embeds a RecordHeader and Serialize updates it, so records shared between
+// goroutines are copied before use -- the copy is made generically, the original is untouched
+//
+func Copy(rec Record) Record {
+    var original = reflect.ValueOf(rec)
+    if original.Kind() != reflect.Ptr || original.IsNil() { return rec }
+
+    var copied = reflect.New(original.Elem().Type())
+    copied.Elem().Set(original.Elem())
+
+    return copied.Interface().(Record)
+}
+
+//
+// Return the record's data in canonical form (RFC 4034 6.2) -- the names within it lowercased,
+// so records differing only in the case of those names have the same data
+//
+func CanonicalData(rec Record) ([]byte, error) {
+    switch typed := Copy(rec).(type) {
+        case *CNAMERecord:
+            typed.Target = Canonical(typed.Target)
+            return typed.Data()
+        case *PTRRecord:
+            typed.Target = Canonical(typed.Target)
+            return typed.Data()
+        case *NSRecord:
+            typed.Target = Canonical(typed.Target)
+            return typed.Data()
+        case *MXRecord:
+            typed.Target = Canonical(typed.Target)
+            return typed.Data()
+        case *SRVRecord:
+            typed.Target = Canonical(typed.Target)
+            return typed.Data()
+        case *SOARecord:
+            typed.MName, typed.RName = Canonical(typed.MName), Canonical(typed.RName)
+            return typed.Data()
     }
 
-    if !bytes.HasSuffix(buffer.Bytes(), []byte{0}) {
-        buffer.Write([]byte{0})
+    return rec.Data()
+}
+
+//
+// Return the index of the first record with the same type and data as rec (names compared canonically), or -1
+//
+func IndexOfData(records []Record, rec Record) int {
+    var data, err = CanonicalData(rec)
+    if err != nil { return -1 }
+
+    for i, curr := range records {
+        if curr.GetType() != rec.GetType() { continue }
+
+        var currData, err = CanonicalData(curr)
+        if err == nil && bytes.Equal(currData, data) { return i }
     }
 
-    return bytes.TrimSpace(buffer.Bytes()), nil
+    return -1
+}
+
+//
+// Return the header of any record -- every record type embeds a RecordHeader
+//
+func HeaderOf(rec Record) RecordHeader {
+    var value = reflect.ValueOf(rec)
+    if value.Kind() != reflect.Ptr || value.IsNil() { return RecordHeader{} }
+
+    var field = value.Elem().FieldByName("RecordHeader")
+    if !field.IsValid() { return RecordHeader{} }
+
+    header, _ := field.Interface().(RecordHeader)
+    return header
+}
+
+//
+// Return a copy of the record owned by a different name (used to synthesize wildcard answers)
+//
+func Rename(rec Record, name string) Record {
+    var copied = reflect.ValueOf(Copy(rec))
+    if copied.Kind() != reflect.Ptr || copied.IsNil() { return rec }
+
+    var field = copied.Elem().FieldByName("Name")
+    if !field.IsValid() || field.Kind() != reflect.String { return rec }
+    field.SetString(name)
+
+    return copied.Interface().(Record)
 }
 
 //
@@ -0,0 +1,352 @@
+package dns
+
+import (
+    "hash"
+    "time"
+    "bytes"
+    "errors"
+    "strconv"
+    "crypto/hmac"
+    "crypto/sha256"
+    "crypto/sha512"
+    "encoding/base64"
+    "encoding/binary"
+
+    "github.com/zmarcantel/phonebook/dns/record"
+)
+
+// algorithms a key may use (RFC 8945 6)
+const (
+    HMAC_SHA256     string          = "hmac-sha256"
+    HMAC_SHA512     string          = "hmac-sha512"
+)
+
+// clock skew allowed between signer and verifier unless the signer says otherwise (RFC 8945 10)
+const TSIG_FUDGE time.Duration = 300 * time.Second
+
+var ErrUnknownAlgorithm error       = errors.New("ERROR: Unsupported TSIG algorithm")
+var ErrEmptySecret      error       = errors.New("ERROR: A TSIG key must have a secret")
+var ErrTSIGFormat       error       = errors.New("ERROR: Malformed or misplaced TSIG record")
+
+var algorithms = map[string]func() hash.Hash{
+    HMAC_SHA256:    sha256.New,
+    HMAC_SHA512:    sha512.New,
+}
+
+//----------------------------------------------
+// TSIG Errors
+//----------------------------------------------
+
+//
+// A failed verification, carried in the error field of the TSIG record answering it
+// Responses to any of these have the NOTAUTH rcode
+//
+type TSIGError uint16
+
+const (
+    TSIG_BADSIG     TSIGError       = 16        // the MAC does not match
+    TSIG_BADKEY     TSIGError       = 17        // the key (or its algorithm) is not known
+    TSIG_BADTIME    TSIGError       = 18        // signed too long ago (or too far ahead)
+    TSIG_BADTRUNC   TSIGError       = 22        // the MAC is truncated further than we accept
+)
+
+func (self TSIGError) Error() string {
+    switch self {
+        case TSIG_BADSIG:   return "ERROR: TSIG signature does not match (BADSIG)"
+        case TSIG_BADKEY:   return "ERROR: TSIG key is not known (BADKEY)"
+        case TSIG_BADTIME:  return "ERROR: TSIG signature is outside the time window (BADTIME)"
+        case TSIG_BADTRUNC: return "ERROR: TSIG signature is truncated (BADTRUNC)"
+    }
+
+    return "ERROR: TSIG verification failed with error " + strconv.Itoa(int(self))
+}
+
+//----------------------------------------------
+// Keys
+//----------------------------------------------
+
+//
+// A shared secret known to both ends of a transaction
+//
+type TSIGKey struct {
+    Name            string
+    Algorithm       string
+    Secret          []byte
+}
+
+//
+// Create a key from its name, algorithm, and base64 secret (as written by tsig-keygen)
+//
+func NewTSIGKey(name, algorithm, secret string) (TSIGKey, error) {
+    decoded, err := base64.StdEncoding.DecodeString(secret)
+    if err != nil { return TSIGKey{}, err }
+
+    var key = TSIGKey{ name, record.Canonical(algorithm), decoded }
+    return key, key.validate()
+}
+
+//
+// Compute the MAC of data with the key
+//
+func (self *TSIGKey) mac(data []byte) ([]byte, error) {
+    var algorithm, known = algorithms[record.Canonical(self.Algorithm)]
+    if !known { return nil, ErrUnknownAlgorithm }
+
+    var digest = hmac.New(algorithm, self.Secret)
+    digest.Write(data)
+    return digest.Sum(nil), nil
+}
+
+//
+// The size of the key's untruncated MAC
+//
+func (self *TSIGKey) macSize() int {
+    var algorithm, known = algorithms[record.Canonical(self.Algorithm)]
+    if !known { return 0 }
+
+    return algorithm().Size()
+}
+
+//
+// The number of bytes a signature made with the key adds to a message
+//
+func (self *TSIGKey) SignatureSize() int {
+    var signature, err = record.TSIG(self.Name, self.Algorithm, time.Time{}, TSIG_FUDGE)
+    if err != nil { return 0 }
+
+    signature.MAC = make([]byte, self.macSize())
+    serialized, _ := signature.Serialize()
+    return len(serialized)
+}
+
+func (self *TSIGKey) validate() error {
+    if _, known := algorithms[record.Canonical(self.Algorithm)] ; !known { return ErrUnknownAlgorithm }
+    if len(self.Secret) == 0 { return ErrEmptySecret }
+
+    _, err := record.ParseName(self.Name)
+    return err
+}
+
+//
+// The keys a server (or client) will accept signatures from, by name
+//
+type KeyRing map[string]*TSIGKey
+
+//
+// Add (or replace) a key, checking its name and algorithm
+//
+func (self KeyRing) Add(key TSIGKey) error {
+    if err := key.validate() ; err != nil { return err }
+
+    self[record.Canonical(key.Name)] = &key
+    return nil
+}
+
+//
+// Look up a key by name (case-insensitive)
+//
+func (self KeyRing) Find(name string) *TSIGKey {
+    return self[record.Canonical(name)]
+}
+
+//----------------------------------------------
+// Signing and Verification (RFC 8945)
+//----------------------------------------------
+
+//
+// Return the TSIG record ending the message's additional section, or nil if it is not signed
+//
+func (self *Message) TSIG() *record.TSIGRecord {
+    if len(self.Extra) == 0 { return nil }
+
+    var tsig, _ = self.Extra[len(self.Extra) - 1].(*record.TSIGRecord)
+    return tsig
+}
+
+//
+// Append the TSIG record to a serialized message, signing it with key
+//    requestMAC: the MAC of the request being answered (nil when signing a request)
+//
+// The record's MAC and original ID are filled in. A nil key leaves the MAC empty,
+// as is done when answering a request whose signature could not be checked
+//
+func SignTSIG(packet []byte, tsig *record.TSIGRecord, key *TSIGKey, requestMAC []byte) ([]byte, error) {
+    return signTSIG(packet, tsig, key, requestMAC, false)
+}
+
+//
+// Sign a message following the first of a multi-message response (a zone transfer)
+//    priorMAC: the MAC of the message before it
+//
+// Only the timers of the TSIG record are covered, chaining each message to the last (RFC 8945 5.3.1)
+//
+func SignTSIGContinued(packet []byte, tsig *record.TSIGRecord, key *TSIGKey, priorMAC []byte) ([]byte, error) {
+    return signTSIG(packet, tsig, key, priorMAC, true)
+}
+
+func signTSIG(packet []byte, tsig *record.TSIGRecord, key *TSIGKey, priorMAC []byte, timersOnly bool) ([]byte, error) {
+    if len(packet) < HEADER_LENGTH { return nil, ErrShortHeader }
+
+    tsig.OriginalID = binary.BigEndian.Uint16(packet)
+    tsig.MAC = nil
+
+    if key != nil {
+        var digest, err = tsigDigest(packet, tsig, priorMAC, timersOnly)
+        if err != nil { return nil, err }
+
+        tsig.MAC, err = key.mac(digest)
+        if err != nil { return nil, err }
+    }
+
+    serialized, err := tsig.Serialize()
+    if err != nil { return nil, err }
+
+    var result = make([]byte, 0, len(packet) + len(serialized))
+    result = append(result, packet...)
+    result = append(result, serialized...)
+
+    // the TSIG record joins the additional section
+    binary.BigEndian.PutUint16(result[10:], binary.BigEndian.Uint16(packet[10:]) + 1)
+    return result, nil
+}
+
+//
+// Check the TSIG record of a serialized message against the key ring
+//    requestMAC: the MAC of the request when checking a response (nil when checking a request)
+//
+// Returns the TSIG record (nil if the message is unsigned) and the key named by it
+// Failures are TSIGErrors -- with the record and, if it is known, the key so the answer can say why --
+// or ErrTSIGFormat when a TSIG record is present but malformed or not the last record
+//
+func VerifyTSIG(packet []byte, keys KeyRing, requestMAC []byte, now time.Time) (*record.TSIGRecord, *TSIGKey, error) {
+    return verifyTSIG(packet, keys, requestMAC, now, false)
+}
+
+//
+// Check the TSIG record of a message following the first of a multi-message response (see SignTSIGContinued)
+//    priorMAC: the MAC of the message before it
+//
+func VerifyTSIGContinued(packet []byte, keys KeyRing, priorMAC []byte, now time.Time) (*record.TSIGRecord, *TSIGKey, error) {
+    return verifyTSIG(packet, keys, priorMAC, now, true)
+}
+
+func verifyTSIG(packet []byte, keys KeyRing, priorMAC []byte, now time.Time, timersOnly bool) (*record.TSIGRecord, *TSIGKey, error) {
+    tsig, offset, err := locateTSIG(packet)
+    if err != nil || tsig == nil { return nil, nil, err }
+
+    // key, then MAC, then time (RFC 8945 5.2)
+    var key = keys.Find(tsig.Name)
+    if key == nil || record.Canonical(key.Algorithm) != record.Canonical(tsig.Algorithm) {
+        return tsig, nil, TSIG_BADKEY
+    }
+
+    // truncated MACs may not be shorter than half the full MAC, nor 10 bytes (RFC 8945 5.2.2.1)
+    var full = key.macSize()
+    var minimum = full / 2
+    if minimum < 10 { minimum = 10 }
+    if len(tsig.MAC) > full || len(tsig.MAC) < minimum {
+        return tsig, key, ErrTSIGFormat
+    }
+
+    // the signed data is the message as it was before the TSIG record was added
+    var unsigned = make([]byte, offset)
+    copy(unsigned, packet)
+    binary.BigEndian.PutUint16(unsigned[10:], binary.BigEndian.Uint16(unsigned[10:]) - 1)
+
+    digest, err := tsigDigest(unsigned, tsig, priorMAC, timersOnly)
+    if err != nil { return tsig, key, err }
+
+    expected, err := key.mac(digest)
+    if err != nil { return tsig, key, err }
+
+    if !hmac.Equal(expected[:len(tsig.MAC)], tsig.MAC) {
+        return tsig, key, TSIG_BADSIG
+    }
+
+    var skew = now.Sub(tsig.TimeSigned)
+    if skew < 0 { skew = -skew }
+    if skew > tsig.Fudge {
+        return tsig, key, TSIG_BADTIME
+    }
+
+    // a good, but truncated, MAC -- we only accept whole ones
+    if len(tsig.MAC) < full {
+        return tsig, key, TSIG_BADTRUNC
+    }
+
+    return tsig, key, nil
+}
+
+//
+// Find the TSIG record of a serialized message
+// Returns the record (nil if there is none) and the offset it begins at
+//
+func locateTSIG(packet []byte) (*record.TSIGRecord, int, error) {
+    header, offset, err := UnpackHeader(packet)
+    if err != nil { return nil, 0, err }
+
+    _, offset, err = UnpackQuestions(packet, offset, int(header.QDCount))
+    if err != nil { return nil, 0, err }
+
+    var count = int(header.ANCount) + int(header.NSCount) + int(header.ARCount)
+    for i := 0 ; i < count ; i++ {
+        rec, next, err := record.UnpackRecord(packet, offset)
+        if err != nil { return nil, 0, err }
+
+        if tsig, signed := rec.(*record.TSIGRecord) ; signed {
+            // only the very last record of the message may be a TSIG
+            if i != count - 1 || header.ARCount == 0 || next != len(packet) {
+                return nil, 0, ErrTSIGFormat
+            }
+            return tsig, offset, nil
+        }
+
+        offset = next
+    }
+
+    return nil, 0, nil
+}
+
+//
+// Build the data a TSIG MAC is computed over (RFC 8945 4.3)
+//    unsigned:   the message without its TSIG record
+//    priorMAC:   the request's MAC for a response, the previous message's for a continuation
+//    timersOnly: a continuation, covering only the timers of the TSIG variables
+//
+func tsigDigest(unsigned []byte, tsig *record.TSIGRecord, priorMAC []byte, timersOnly bool) ([]byte, error) {
+    var result = make([]byte, 0)
+    var buffer = bytes.NewBuffer(result)
+
+    // responses chain to the request's MAC
+    if priorMAC != nil {
+        buffer.Write(Uint16ToBytes(uint16(len(priorMAC))))
+        buffer.Write(priorMAC)
+    }
+
+    // the message as its sender first built it
+    buffer.Write(Uint16ToBytes(tsig.OriginalID))
+    buffer.Write(unsigned[2:])
+
+    if timersOnly {
+        buffer.Write(tsig.Timers())
+        return buffer.Bytes(), nil
+    }
+
+    // and the TSIG variables, names in canonical form
+    name, err := record.CreateMessageLabel(record.Canonical(tsig.Name))
+    if err != nil { return nil, err }
+    buffer.Write(name)
+    buffer.Write(Uint16ToBytes(record.CLASS_ANY))
+    buffer.Write(record.Uint32ToBytes(0))
+
+    algorithm, err := record.CreateMessageLabel(record.Canonical(tsig.Algorithm))
+    if err != nil { return nil, err }
+    buffer.Write(algorithm)
+
+    buffer.Write(tsig.Timers())
+    buffer.Write(Uint16ToBytes(tsig.Error))
+    buffer.Write(Uint16ToBytes(uint16(len(tsig.OtherData))))
+    buffer.Write(tsig.OtherData)
+
+    return buffer.Bytes(), nil
+}
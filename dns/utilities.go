@@ -1,30 +1,16 @@
 package dns
 
 import (
+    "github.com/zmarcantel/phonebook/dns/record"
 )
 
 //
 // Transform a series of bytes into a qualified DNS label
+// Compression pointers are followed, so message must be the entire DNS message
+// Returns the label and the offset of the first byte following it
 //
-func GetMessageLabel(source []byte) (string, int) {
-    var name string
-    var offset int
-
-    for offset = 0 ; ; {
-        var length = int(source[offset])
-
-        if length == 0 {
-            break
-        }
-        var start = offset + 1
-        var finish = start + length
-        if len(name) > 0 { name += "." }
-        name += string(source[start:finish])
-        offset += length + 1
-    }
-    offset += 1
-
-    return name, offset
+func GetMessageLabel(message []byte, offset int) (string, int, error) {
+    return record.ReadMessageLabel(message, offset)
 }
 
 //
@@ -1,119 +1,283 @@
 package main
 
 import (
+    "io"
     "os"
     "fmt"
-    "net"
+    "flag"
     "time"
+    "errors"
+    "context"
+    "syscall"
+    "log/slog"
+    "strconv"
     "os/signal"
 
+    "github.com/zmarcantel/phonebook/config"
     "github.com/zmarcantel/phonebook/server"
+    "github.com/zmarcantel/phonebook/server/logging"
     "github.com/zmarcantel/phonebook/server/store"
+    "github.com/zmarcantel/phonebook/server/store/redis"
     "github.com/zmarcantel/phonebook/dns/record"
 
 )
 
+const DEFAULT_REDIS string = "127.0.0.1:6379"
+
+var ErrUnknownBackend error = errors.New("ERROR: Unknown storage backend -- expected memory, disk, directory, or redis")
+var ErrNoPath error = errors.New("ERROR: The disk and directory backends require a path option")
+
 func main() {
 
     //
-    // Setup the signal handlers and start the server
-    // The channel serves as an unhandled exception
+    // Read the configuration: defaults, then the -config file, then flags
     //
+    conf, err := config.FromArgs(os.Args[0], os.Args[1:], os.Stderr)
+    if err == flag.ErrHelp { os.Exit(0) }
+    if err != nil {
+        fmt.Printf("%s\n", err)
+        os.Exit(2)
+    }
 
-    var lock = make(chan error, 10)
-    watchSignals(lock)
-    var serve = server.Start("127.0.0.1", 53, nil, lock)
-    // shorthand for the above would be "server.Local(lock)"
+    // log to stderr at the configured level (already validated) -- a reload may change it
+    var level = new(slog.LevelVar)
+    setLevel(level, conf)
+    logging.SetDefault(logging.Slog(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{ Level: level }))))
+
+    backing, err := openStore(conf.Storage)
+    if err != nil {
+        fmt.Printf("%s\n", err)
+        os.Exit(1)
+    }
 
     //
-    // Add testing records, one of each type, until test suite built
+    // Setup the signal handlers and start the server
+    // The channel serves as an unhandled exception
     //
-    var a, err = record.A("zed.io", 10 * time.Second, net.ParseIP("127.0.0.1"))
-    if err != nil { panic(err) }
-    serve.Store.Add(a)
-
-    aaaa, err := record.AAAA("zed.io", 10 * time.Second, net.ParseIP("::1"))
-    if err != nil { panic(err) }
-    serve.Store.Add(aaaa)
-
-    srv, err := record.SRV("_test._tcp.zed.io", "zed.io", 10 * time.Second, 5, 5, 8053)
-    if err != nil { panic(err) }
-    serve.Store.Add(srv)
+    var lock = make(chan error, 10)
+    var serve = server.New(backing, lock)
 
-    cname, err := record.CNAME("app.production", "zed.io", 10 * time.Second)
-    if err != nil { panic(err) }
-    serve.Store.Add(cname)
+    if directory, ok := backing.(*store.DirectoryStore) ; ok {
+        if err = watchDirectory(serve, directory) ; err != nil { die(serve, err) }
+    }
 
-    ptr, err := record.PTR("127.0.0.1", "zed.io", 10 * time.Second)
-    if err != nil { panic(err) }
-    serve.Store.Add(ptr)
+    for _, file := range conf.Zones {
+        zone, records, err := readZone(file)
+        if err == nil { err = serve.ReplaceZone(zone, records) }
+        if err != nil { die(serve, err) }
+    }
 
-    ptr2, err := record.PTR("10.27/1.168.192.app.production", "zed.io", 10 * time.Second)
-    if err != nil { panic(err) }
-    serve.Store.Add(ptr2)
+    for _, listener := range conf.Listen {
+        for _, protocol := range listener.Protocols() {
+            if _, err = serve.ListenOn(protocol, listener.Address) ; err != nil { die(serve, err) }
+        }
+    }
 
-    mx, err := record.MX("mail.production", "mail.zed.io", 5, 10 * time.Second)
-    if err != nil { panic(err) }
-    serve.Store.Add(mx)
+    // serve Prometheus metrics and the management API alongside
+    if conf.Metrics != "" {
+        if _, err = serve.ListenMetrics(conf.Metrics) ; err != nil { die(serve, err) }
+    }
+    if conf.API != "" {
+        if _, err = serve.ListenAPI(conf.API, conf.APIToken) ; err != nil { die(serve, err) }
+    }
 
-    mxHigher, err := record.MX("mail.production", "internal.mail.zed.io", 20, 10 * time.Second)
-    if err != nil { panic(err) }
-    serve.Store.Add(mxHigher)
+    // SIGHUP re-reads the config and zone files
+    watchSignals(lock, func() {
+        var logger = logging.Default()
+        var ctx = context.Background()
 
-    txt, err := record.TXT("mail.production", 10 * time.Second, "admin email -- zach@zed.io")
-    if err != nil { panic(err) }
-    serve.Store.Add(txt)
+        reloaded, err := reload(serve, conf, level)
+        if err != nil {
+            logging.Error(logger, ctx, "reload failed -- still serving the previous zones", logging.F(logging.FIELD_ERROR, err))
+            return
+        }
 
-    serve.Store.(*store.MapStore).Print()
+        if changed := conf.Changed(reloaded) ; len(changed) > 0 {
+            logging.Warn(logger, ctx, "reloaded, but some settings only take effect on restart", logging.F("settings", changed))
+        } else {
+            logging.Info(logger, ctx, "reloaded", logging.F("zones", len(reloaded.Zones)))
+        }
+        conf = reloaded
+    })
 
     // wait for either unhandled exception or nil (signal)
     err = <-lock
-    die(err)
+    die(serve, err)
+}
+
+
+//
+// Open the configured storage backend
+//
+func openStore(storage config.Storage) (store.DNSStore, error) {
+    switch storage.Backend {
+        case "memory":
+            return store.Map(), nil
+
+        case "disk":
+            var path = storage.Options["path"]
+            if path == "" { return nil, ErrNoPath }
+
+            return store.OpenDisk(path)
+
+        case "directory":
+            var path = storage.Options["path"]
+            if path == "" { return nil, ErrNoPath }
+
+            var directory = store.Directory(path)
+            if debounce, given := storage.Options["debounce"] ; given {
+                var err error
+                if directory.Debounce, err = time.ParseDuration(debounce) ; err != nil { return nil, err }
+            }
+            return directory, nil
+
+        case "redis":
+            var address = storage.Options["address"]
+            if address == "" { address = DEFAULT_REDIS }
+
+            var client = redis.NewClient(address)
+            client.Password = storage.Options["password"]
+            if database, given := storage.Options["database"] ; given {
+                var err error
+                if client.Database, err = strconv.Atoi(database) ; err != nil { return nil, err }
+            }
+            return store.OpenRedis(client, storage.Options["prefix"])
+    }
+
+    return nil, ErrUnknownBackend
 }
 
+//
+// Parse the zone in a master file
+//
+func readZone(file config.ZoneFile) (*store.Zone, []record.Record, error) {
+    source, err := os.Open(file.Path)
+    if err != nil { return nil, nil, err }
+    defer source.Close()
+
+    zone, records, err := server.ReadZone(source, file.Origin)
+    if err != nil {
+        return nil, nil, errors.New(fmt.Sprintf("could not load zone %s from %s: %s", file.Origin, file.Path, err))
+    }
+
+    return zone, records, nil
+}
+
+//
+// Re-read the config and zone files, swapping in every zone at once only after every file has parsed
+// Anything that fails to parse or apply leaves the server as it was. Only the zones and log level are reloaded
+//
+func reload(serve *server.Server, previous *config.Config, level *slog.LevelVar) (*config.Config, error) {
+    conf, err := config.FromArgs(os.Args[0], os.Args[1:], io.Discard)
+    if err != nil { return nil, err }
+
+    var zones = make([]*store.Zone, 0, len(conf.Zones))
+    var records = make([][]record.Record, 0, len(conf.Zones))
+    for _, file := range conf.Zones {
+        zone, zoneRecords, err := readZone(file)
+        if err != nil { return nil, err }
+
+        zones = append(zones, zone)
+        records = append(records, zoneRecords)
+    }
+
+    // zones no longer configured are no longer served
+    var kept = make(map[string]bool, 0)
+    for _, zone := range zones {
+        kept[zone.Origin] = true
+    }
+
+    var removed = make([]string, 0)
+    for _, file := range previous.Zones {
+        if origin := record.Canonical(file.Origin) ; !kept[origin] { removed = append(removed, origin) }
+    }
+
+    // every zone is swapped in one batch -- queries in flight see all of them old or all new
+    if err = serve.ReplaceZones(zones, records, removed) ; err != nil { return nil, err }
+
+    setLevel(level, conf)
+    return conf, nil
+}
+
+//
+// Serve the zones in a directory store, following them as its files come and go
+//
+func watchDirectory(serve *server.Server, directory *store.DirectoryStore) error {
+    var served = make(map[string]bool, 0)
+
+    // the store calls this from one goroutine at a time
+    directory.Reloaded = func() {
+        var current = make(map[string]bool, 0)
+        for _, zone := range directory.Zones() {
+            serve.Zones.Put(zone)
+            serve.Journal.Clear(zone.Origin)
+            current[zone.Origin] = true
+        }
+
+        for origin := range served {
+            if current[origin] { continue }
+            serve.Zones.Remove(origin)
+            serve.Journal.Clear(origin)
+        }
+        served = current
+    }
+
+    return directory.Watch()
+}
+
+func setLevel(level *slog.LevelVar, conf *config.Config) {
+    var parsed, _ = logging.ParseLevel(conf.LogLevel)
+    level.Set(parsed.Level())
+}
 
 
 //
 // Responds to an error or signal being put on the server-lock
+// Queries in flight are given a few seconds to be answered before exiting
 //
-func die(err error) {
+func die(serve *server.Server, err error) {
     // signals put nil on the channel, so ignore those
     if err != nil {
         fmt.Printf("ERROR: %s\n", err)
     }
 
+    var ctx, cancel = context.WithTimeout(context.Background(), 5 * time.Second)
+    defer cancel()
+
+    if shutdownErr := serve.Shutdown(ctx) ; shutdownErr != nil {
+        fmt.Printf("ERROR: Could not shut down cleanly: %s\n", shutdownErr)
+    }
+
     // exit
+    if err != nil { os.Exit(1) }
     os.Exit(0)
 }
 
 
 //
 // Defines handlers for OS signals
+// Interrupts and terminations put nil on the server-lock, hangups call reload
 //
-func watchSignals(done chan error) {
-    var sigint = make(chan os.Signal, 1)
-    signal.Notify(sigint, os.Interrupt)
+func watchSignals(done chan error, reload func()) {
+    var stop = make(chan os.Signal, 1)
+    signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
 
-    var sigkill = make(chan os.Signal, 1)
-    signal.Notify(sigkill, os.Interrupt)
+    var hangup = make(chan os.Signal, 1)
+    signal.Notify(hangup, syscall.SIGHUP)
 
     go func(){
         var handled = false
         for {
             select {
-                case sig := <-sigint:
+                case sig := <-stop:
                     if handled { break }
-                    fmt.Printf("\nReceived interrupt signal: %s\n", sig)
+                    fmt.Printf("\nReceived signal: %s\n", sig)
                     handled = true
                     done <- nil
-                    break
 
-                case sig := <-sigkill:
-                    if handled { break }
-                    fmt.Printf("\nReceived termination signal: %s\n", sig)
-                    handled = true
-                    done <- nil
-                    break
+                case <-hangup:
+                    // reloads run one at a time, here
+                    if !handled { reload() }
             }
         }
     }()
@@ -0,0 +1,280 @@
+package api
+
+import (
+    "io"
+    "errors"
+    "strings"
+    "net/http"
+    "crypto/subtle"
+    "encoding/json"
+
+    "github.com/zmarcantel/phonebook/dns/record"
+
+    "github.com/zmarcantel/phonebook/server/store"
+)
+
+// the largest request body accepted -- a single record
+const MAX_BODY_SIZE int64 = 64 * 1024
+
+const CONTENT_TYPE string = "application/json"
+
+var ErrMismatch         error   = errors.New("ERROR: The record does not match the name and type in the path")
+var ErrUnauthorized     error   = errors.New("ERROR: Missing or incorrect bearer token")
+var ErrNoRoute          error   = errors.New("ERROR: No such path")
+var ErrMethod           error   = errors.New("ERROR: Method not allowed on this path")
+
+//----------------------------------------------
+// Management API
+//----------------------------------------------
+
+//
+// Manage the records of a store over HTTP with JSON bodies (see Record)
+//
+//    GET     /records                  every record in the store
+//    POST    /records                  add a record
+//    GET     /records/{name}           the records at a name
+//    GET     /records/{name}/{type}    the records of a type at a name
+//    PUT     /records/{name}/{type}    replace the (first) record of a type at a name
+//    DELETE  /records/{name}/{type}    delete the (first) record of a type at a name
+//
+// Lookups answer as the DNS server would, so names covered by a wildcard list its records
+//
+type API struct {
+    Store           store.DNSStore
+    Token           string              // bearer token required of every request (empty allows anyone)
+}
+
+func New(backing store.DNSStore) *API {
+    return &API{ Store: backing }
+}
+
+func (self *API) ServeHTTP(response http.ResponseWriter, request *http.Request) {
+    if self.Token != "" {
+        var given = request.Header.Get("Authorization")
+        if subtle.ConstantTimeCompare([]byte(given), []byte("Bearer " + self.Token)) != 1 {
+            writeError(response, http.StatusUnauthorized, ErrUnauthorized)
+            return
+        }
+    }
+
+    self.route(response, request)
+}
+
+//
+// Pick the handler by the method and the path below /records -- nothing, a name, or a name and type
+// (routed by hand rather than with method patterns, which builds without a module do not enable)
+//
+func (self *API) route(response http.ResponseWriter, request *http.Request) {
+    var rest, found = strings.CutPrefix(request.URL.Path, "/records")
+    if !found || (rest != "" && rest[0] != '/') {
+        writeError(response, http.StatusNotFound, ErrNoRoute)
+        return
+    }
+
+    var parts = make([]string, 0, 2)
+    if rest = strings.Trim(rest, "/") ; rest != "" {
+        parts = strings.Split(rest, "/")
+    }
+    for _, part := range parts {
+        if part == "" {
+            writeError(response, http.StatusNotFound, ErrNoRoute)
+            return
+        }
+    }
+
+    var method = request.Method
+    switch {
+        case len(parts) == 0 && method == http.MethodGet:
+            self.list(response, request)
+        case len(parts) == 0 && method == http.MethodPost:
+            self.create(response, request)
+        case len(parts) == 0:
+            notAllowed(response, "GET, POST")
+
+        case len(parts) == 1 && method == http.MethodGet:
+            self.listLabel(response, parts[0])
+        case len(parts) == 1:
+            notAllowed(response, "GET")
+
+        case len(parts) == 2 && method == http.MethodGet:
+            self.listType(response, parts[0], parts[1])
+        case len(parts) == 2 && method == http.MethodPut:
+            self.replace(response, request, parts[0], parts[1])
+        case len(parts) == 2 && method == http.MethodDelete:
+            self.remove(response, parts[0], parts[1])
+        case len(parts) == 2:
+            notAllowed(response, "GET, PUT, DELETE")
+
+        default:
+            writeError(response, http.StatusNotFound, ErrNoRoute)
+    }
+}
+
+func notAllowed(response http.ResponseWriter, methods string) {
+    response.Header().Set("Allow", methods)
+    writeError(response, http.StatusMethodNotAllowed, ErrMethod)
+}
+
+//----------------------------------------------
+// Handlers
+//----------------------------------------------
+
+func (self *API) list(response http.ResponseWriter, request *http.Request) {
+    var records = make([]record.Record, 0)
+    var err = self.Store.Walk(func(rec record.Record) error {
+        records = append(records, rec)
+        return nil
+    })
+
+    // a partial listing would pass for the whole store
+    if err != nil {
+        writeStoreError(response, err)
+        return
+    }
+
+    writeRecords(response, records)
+}
+
+func (self *API) listLabel(response http.ResponseWriter, name string) {
+    records, err := self.Store.FindLabel(name)
+    if err != nil && err != store.ErrNoData {
+        writeStoreError(response, err)
+        return
+    }
+
+    writeRecords(response, records)
+}
+
+func (self *API) listType(response http.ResponseWriter, name, typeName string) {
+    rType, known := record.TypeFromString(typeName)
+    if !known {
+        writeError(response, http.StatusBadRequest, ErrUnsupportedType)
+        return
+    }
+
+    records, err := self.Store.FindLabel(name)
+    if err != nil && err != store.ErrNoData {
+        writeStoreError(response, err)
+        return
+    }
+
+    var matching = make([]record.Record, 0, len(records))
+    for _, rec := range records {
+        if rec.GetType() == rType { matching = append(matching, rec) }
+    }
+
+    writeRecords(response, matching)
+}
+
+func (self *API) create(response http.ResponseWriter, request *http.Request) {
+    rec, err := readRecord(request)
+    if err != nil {
+        writeError(response, http.StatusBadRequest, err)
+        return
+    }
+
+    if err = self.Store.Add(rec) ; err != nil {
+        writeStoreError(response, err)
+        return
+    }
+
+    response.Header().Set("Location", "/records/" + rec.GetLabel() + "/" + record.TypeIntToString[rec.GetType()])
+    writeRecord(response, http.StatusCreated, rec)
+}
+
+func (self *API) replace(response http.ResponseWriter, request *http.Request, name, typeName string) {
+    rec, err := readRecord(request)
+    if err != nil {
+        writeError(response, http.StatusBadRequest, err)
+        return
+    }
+
+    // the body may not move the record somewhere else
+    var rType, known = record.TypeFromString(typeName)
+    if !known || rType != rec.GetType() || !record.NamesEqual(name, rec.GetLabel()) {
+        writeError(response, http.StatusBadRequest, ErrMismatch)
+        return
+    }
+
+    if err = self.Store.FindAndReplace(name, rType, rec) ; err != nil {
+        writeStoreError(response, err)
+        return
+    }
+
+    writeRecord(response, http.StatusOK, rec)
+}
+
+func (self *API) remove(response http.ResponseWriter, name, typeName string) {
+    rType, known := record.TypeFromString(typeName)
+    if !known {
+        writeError(response, http.StatusBadRequest, ErrUnsupportedType)
+        return
+    }
+
+    if err := self.Store.FindAndDelete(name, rType) ; err != nil {
+        writeStoreError(response, err)
+        return
+    }
+
+    response.WriteHeader(http.StatusNoContent)
+}
+
+//----------------------------------------------
+// Bodies
+//----------------------------------------------
+
+func readRecord(request *http.Request) (record.Record, error) {
+    var decoder = json.NewDecoder(io.LimitReader(request.Body, MAX_BODY_SIZE))
+    decoder.DisallowUnknownFields()
+
+    var source Record
+    if err := decoder.Decode(&source) ; err != nil { return nil, err }
+
+    return Decode(source)
+}
+
+func writeRecords(response http.ResponseWriter, records []record.Record) {
+    var result = make([]Record, 0, len(records))
+    for _, rec := range records {
+        // anything the API cannot represent (OPT, unknown types) is left out
+        if encoded, err := Encode(rec) ; err == nil {
+            result = append(result, encoded)
+        }
+    }
+
+    writeJSON(response, http.StatusOK, result)
+}
+
+func writeRecord(response http.ResponseWriter, status int, rec record.Record) {
+    encoded, err := Encode(rec)
+    if err != nil {
+        writeError(response, http.StatusInternalServerError, err)
+        return
+    }
+
+    writeJSON(response, status, encoded)
+}
+
+//
+// Map the store's errors onto HTTP statuses
+//
+func writeStoreError(response http.ResponseWriter, err error) {
+    switch err {
+        case store.ErrNotFound, store.ErrNoData:
+            writeError(response, http.StatusNotFound, err)
+        case store.ErrNilRecord, store.ErrInvalidType:
+            writeError(response, http.StatusBadRequest, err)
+        default:
+            writeError(response, http.StatusInternalServerError, err)
+    }
+}
+
+func writeError(response http.ResponseWriter, status int, err error) {
+    writeJSON(response, status, map[string]string{ "error": err.Error() })
+}
+
+func writeJSON(response http.ResponseWriter, status int, body interface{}) {
+    response.Header().Set("Content-Type", CONTENT_TYPE)
+    response.WriteHeader(status)
+    json.NewEncoder(response).Encode(body)
+}
@@ -0,0 +1,280 @@
+package api
+
+import (
+    "net"
+    "time"
+    "errors"
+    "strings"
+    "testing"
+    "encoding/json"
+    "net/http"
+    "net/http/httptest"
+
+    "github.com/zmarcantel/phonebook/dns/record"
+    "github.com/zmarcantel/phonebook/server/store"
+)
+
+//----------------------------------------------
+// Helpers
+//----------------------------------------------
+
+//
+// An API over a store holding a few known records
+//
+func testAPI(t *testing.T) *API {
+    var backing = store.Map()
+
+    var a, _ = record.A("zed.io", 10 * time.Second, net.ParseIP("127.0.0.1"))
+    var mx, _ = record.MX("zed.io", "mail.zed.io", 5, 10 * time.Second)
+    var srv, _ = record.SRV("_phonebook._tcp.zed.io", "zed.io", 10 * time.Second, 10, 5, 8053)
+
+    for _, rec := range []record.Record{ a, mx, srv } {
+        if err := backing.Add(rec) ; err != nil { t.Fatal(err) }
+    }
+
+    return New(backing)
+}
+
+//
+// Send a request to the API, returning the status and decoding the body into result (if not nil)
+//
+func testRequest(t *testing.T, handler http.Handler, method, path, body string, result interface{}) int {
+    var request = httptest.NewRequest(method, path, strings.NewReader(body))
+    var recorder = httptest.NewRecorder()
+    handler.ServeHTTP(recorder, request)
+
+    if result != nil {
+        if err := json.Unmarshal(recorder.Body.Bytes(), result) ; err != nil {
+            t.Fatalf("Invalid JSON:\n\tBody: %s\n\tGot: %s\n", recorder.Body.String(), err)
+        }
+    }
+
+    return recorder.Code
+}
+
+//----------------------------------------------
+// JSON Tests
+//----------------------------------------------
+
+func TestEncode_RoundTrip(t *testing.T) {
+    var a, _ = record.A("zed.io", 10 * time.Second, net.ParseIP("127.0.0.1"))
+    var aaaa, _ = record.AAAA("zed.io", 10 * time.Second, net.ParseIP("::1"))
+    var cname, _ = record.CNAME("app.zed.io", "zed.io", 10 * time.Second)
+    var ptr, _ = record.PTR("1.0.0.127.in-addr.arpa", "zed.io", 10 * time.Second)
+    var ns, _ = record.NS("zed.io", "ns1.zed.io", 10 * time.Second)
+    var mx, _ = record.MX("zed.io", "mail.zed.io", 5, 10 * time.Second)
+    var srv, _ = record.SRV("_phonebook._tcp.zed.io", "zed.io", 10 * time.Second, 10, 5, 8053)
+    var txt, _ = record.TXT("zed.io", 10 * time.Second, "phonebook")
+    var long, _ = record.TXT("mail._domainkey.zed.io", 10 * time.Second, strings.Repeat("k", 400))
+    var soa, _ = record.SOA("zed.io", "ns1.zed.io", "admin.zed.io", time.Hour, 7, time.Hour, time.Minute, 24 * time.Hour, time.Minute)
+
+    for _, original := range []record.Record{ a, aaaa, cname, ptr, ns, mx, srv, txt, long, soa } {
+        encoded, err := Encode(original)
+        if err != nil { t.Fatal(err) }
+
+        // through JSON and back
+        serialized, err := json.Marshal(encoded)
+        if err != nil { t.Fatal(err) }
+
+        var parsed Record
+        if err = json.Unmarshal(serialized, &parsed) ; err != nil { t.Fatal(err) }
+
+        decoded, err := Decode(parsed)
+        if err != nil {
+            t.Errorf("Could not decode:\n\tJSON: %s\n\tGot: %s\n", serialized, err)
+            continue
+        }
+
+        var expected, _ = original.Serialize()
+        var got, _ = decoded.Serialize()
+        if string(expected) != string(got) {
+            t.Errorf("Incorrect Round Trip:\n\tJSON: %s\n\tExpected: %+v\n\tGot: %+v\n", serialized, expected, got)
+        }
+    }
+}
+
+func TestEncode_Format(t *testing.T) {
+    var mx, _ = record.MX("zed.io", "mail.zed.io", 5, 10 * time.Second)
+    encoded, _ := Encode(mx)
+    serialized, _ := json.Marshal(encoded)
+
+    var expected = `{"name":"zed.io","type":"MX","ttl":10,"data":{"priority":5,"target":"mail.zed.io"}}`
+    if string(serialized) != expected {
+        t.Errorf("Incorrect JSON:\n\tExpected: %s\n\tGot: %s\n", expected, serialized)
+    }
+}
+
+func TestDecode_Invalid(t *testing.T) {
+    var invalid = []Record{
+        { Name: "zed.io", Type: "A", TTL: 10, Data: json.RawMessage(`{"ip":"nope"}`) },
+        { Name: "zed.io", Type: "A", TTL: 10, Data: json.RawMessage(`{"ip":"::1"}`) },
+        { Name: "zed.io", Type: "OPT", TTL: 10, Data: json.RawMessage(`{}`) },
+        { Name: "zed.io", Type: "BOGUS", TTL: 10, Data: json.RawMessage(`{}`) },
+        { Name: "zed.io", Type: "MX", TTL: 10 },
+        { Name: "zed..io", Type: "CNAME", TTL: 10, Data: json.RawMessage(`{"target":"zed.io"}`) },
+        { Name: "zed.io", Type: "SRV", TTL: 10, Data: json.RawMessage(`{"port":"80"}`) },
+        { Name: "zed.io", Type: "TXT", TTL: 10, Data: json.RawMessage(`{"text":"` + strings.Repeat("k", record.TXT_TEXT_MAX + 1) + `"}`) },
+    }
+
+    for _, source := range invalid {
+        if rec, err := Decode(source) ; err == nil {
+            t.Errorf("Didn't catch invalid record:\n\tSource: %+v\n\tGot: %+v\n", source, rec)
+        }
+    }
+}
+
+//----------------------------------------------
+// Endpoint Tests
+//----------------------------------------------
+
+func TestAPI_List(t *testing.T) {
+    var handler = testAPI(t)
+
+    var records []Record
+    if status := testRequest(t, handler, "GET", "/records", "", &records) ; status != http.StatusOK || len(records) != 3 {
+        t.Errorf("Incorrect Listing:\n\tExpected: %d, %d records\n\tGot: %d, %+v\n", http.StatusOK, 3, status, records)
+    }
+
+    records = nil
+    if status := testRequest(t, handler, "GET", "/records/ZED.io", "", &records) ; status != http.StatusOK || len(records) != 2 {
+        t.Errorf("Incorrect Label Listing:\n\tExpected: %d, %d records\n\tGot: %d, %+v\n", http.StatusOK, 2, status, records)
+    }
+
+    records = nil
+    if status := testRequest(t, handler, "GET", "/records/zed.io/mx", "", &records) ; status != http.StatusOK || len(records) != 1 || records[0].Type != "MX" {
+        t.Errorf("Incorrect Type Listing:\n\tExpected: %d, %s\n\tGot: %d, %+v\n", http.StatusOK, "one MX", status, records)
+    }
+
+    if status := testRequest(t, handler, "GET", "/records/nothing.zed.io", "", nil) ; status != http.StatusNotFound {
+        t.Errorf("Incorrect Status:\n\tExpected: %d\n\tGot: %d\n", http.StatusNotFound, status)
+    }
+}
+
+// a store that fails part way through a walk
+type testFailingWalk struct {
+    store.DNSStore
+}
+
+func (self testFailingWalk) Walk(fn func(record.Record) error) error {
+    self.DNSStore.Walk(func(rec record.Record) error {
+        fn(rec)
+        return errTestWalk
+    })
+    return errTestWalk
+}
+
+var errTestWalk = errors.New("ERROR: walk failed")
+
+func TestAPI_ListFailure(t *testing.T) {
+    var handler = New(testFailingWalk{ testAPI(t).Store })
+
+    // rather than pass what was read for every record
+    var body map[string]string
+    if status := testRequest(t, handler, "GET", "/records", "", &body) ; status != http.StatusInternalServerError || body["error"] != errTestWalk.Error() {
+        t.Errorf("Incorrect Listing:\n\tExpected: %d, %s\n\tGot: %d, %+v\n", http.StatusInternalServerError, errTestWalk, status, body)
+    }
+}
+
+func TestAPI_Create(t *testing.T) {
+    var handler = testAPI(t)
+
+    var created Record
+    var body = `{"name":"www.zed.io","type":"CNAME","ttl":30,"data":{"target":"zed.io"}}`
+    if status := testRequest(t, handler, "POST", "/records", body, &created) ; status != http.StatusCreated || created.Name != "www.zed.io" {
+        t.Errorf("Incorrect Create:\n\tExpected: %d\n\tGot: %d, %+v\n", http.StatusCreated, status, created)
+    }
+
+    var found, err = handler.Store.Find("www.zed.io", record.CNAME_RECORD)
+    if err != nil || found.(*record.CNAMERecord).Target != "zed.io" {
+        t.Errorf("Record not stored:\n\tGot: %+v, %v\n", found, err)
+    }
+
+    // malformed and unknown fields are refused
+    for _, invalid := range []string{ `{`, `{"name":"zed.io","type":"A","ttl":1,"data":{"ip":"10.0.0.1","bogus":1}}`, `{"name":"zed.io","type":"A"}` } {
+        var failure map[string]string
+        if status := testRequest(t, handler, "POST", "/records", invalid, &failure) ; status != http.StatusBadRequest || failure["error"] == "" {
+            t.Errorf("Incorrect Status:\n\tBody: %s\n\tExpected: %d\n\tGot: %d, %+v\n", invalid, http.StatusBadRequest, status, failure)
+        }
+    }
+}
+
+func TestAPI_Replace(t *testing.T) {
+    var handler = testAPI(t)
+
+    var body = `{"name":"zed.io","type":"MX","ttl":10,"data":{"priority":1,"target":"mx.zed.io"}}`
+    if status := testRequest(t, handler, "PUT", "/records/zed.io/MX", body, nil) ; status != http.StatusOK {
+        t.Errorf("Incorrect Status:\n\tExpected: %d\n\tGot: %d\n", http.StatusOK, status)
+    }
+
+    var found, _ = handler.Store.Find("zed.io", record.MX_RECORD)
+    if mx := found.(*record.MXRecord) ; mx.Priority != 1 || mx.Target != "mx.zed.io" {
+        t.Errorf("Record not replaced:\n\tGot: %+v\n", mx)
+    }
+
+    // the body must agree with the path
+    if status := testRequest(t, handler, "PUT", "/records/other.zed.io/MX", body, nil) ; status != http.StatusBadRequest {
+        t.Errorf("Incorrect Status:\n\tExpected: %d\n\tGot: %d\n", http.StatusBadRequest, status)
+    }
+
+    // and there must be something to replace
+    body = `{"name":"zed.io","type":"TXT","ttl":10,"data":{"text":"hi"}}`
+    if status := testRequest(t, handler, "PUT", "/records/zed.io/TXT", body, nil) ; status != http.StatusNotFound {
+        t.Errorf("Incorrect Status:\n\tExpected: %d\n\tGot: %d\n", http.StatusNotFound, status)
+    }
+}
+
+func TestAPI_Delete(t *testing.T) {
+    var handler = testAPI(t)
+
+    if status := testRequest(t, handler, "DELETE", "/records/zed.io/A", "", nil) ; status != http.StatusNoContent {
+        t.Errorf("Incorrect Status:\n\tExpected: %d\n\tGot: %d\n", http.StatusNoContent, status)
+    }
+    if _, err := handler.Store.Find("zed.io", record.A_RECORD) ; err == nil {
+        t.Errorf("Record not deleted\n")
+    }
+
+    if status := testRequest(t, handler, "DELETE", "/records/zed.io/A", "", nil) ; status != http.StatusNotFound {
+        t.Errorf("Incorrect Status:\n\tExpected: %d\n\tGot: %d\n", http.StatusNotFound, status)
+    }
+    if status := testRequest(t, handler, "DELETE", "/records/zed.io/BOGUS", "", nil) ; status != http.StatusBadRequest {
+        t.Errorf("Incorrect Status:\n\tExpected: %d\n\tGot: %d\n", http.StatusBadRequest, status)
+    }
+}
+
+func TestAPI_Token(t *testing.T) {
+    var handler = testAPI(t)
+    handler.Token = "secret"
+
+    if status := testRequest(t, handler, "GET", "/records", "", nil) ; status != http.StatusUnauthorized {
+        t.Errorf("Incorrect Status:\n\tExpected: %d\n\tGot: %d\n", http.StatusUnauthorized, status)
+    }
+
+    var request = httptest.NewRequest("GET", "/records", nil)
+    request.Header.Set("Authorization", "Bearer secret")
+    var recorder = httptest.NewRecorder()
+    handler.ServeHTTP(recorder, request)
+
+    if recorder.Code != http.StatusOK {
+        t.Errorf("Incorrect Status:\n\tExpected: %d\n\tGot: %d\n", http.StatusOK, recorder.Code)
+    }
+}
+
+func TestAPI_Routes(t *testing.T) {
+    var handler = testAPI(t)
+
+    var routes = []struct{ method, path string ; status int }{
+        { "GET", "/records/", http.StatusOK },
+        { "GET", "/recordsx", http.StatusNotFound },
+        { "GET", "/records/zed.io/MX/extra", http.StatusNotFound },
+        { "GET", "/records//MX", http.StatusNotFound },
+        { "GET", "/other", http.StatusNotFound },
+        { "PUT", "/records", http.StatusMethodNotAllowed },
+        { "POST", "/records/zed.io", http.StatusMethodNotAllowed },
+        { "POST", "/records/zed.io/MX", http.StatusMethodNotAllowed },
+    }
+    for _, route := range routes {
+        if status := testRequest(t, handler, route.method, route.path, "", nil) ; status != route.status {
+            t.Errorf("Incorrect Status (%s %s):\n\tExpected: %d\n\tGot: %d\n", route.method, route.path, route.status, status)
+        }
+    }
+}
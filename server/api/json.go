@@ -0,0 +1,191 @@
+package api
+
+import (
+    "net"
+    "time"
+    "errors"
+    "encoding/json"
+
+    "github.com/zmarcantel/phonebook/dns/record"
+)
+
+var ErrUnsupportedType  error   = errors.New("ERROR: Records of that type cannot be managed through the API")
+var ErrMissingData      error   = errors.New("ERROR: The record has no data")
+var ErrInvalidAddress   error   = errors.New("ERROR: Invalid IP address")
+
+//----------------------------------------------
+// JSON Representation
+//----------------------------------------------
+
+//
+// A record as sent and received by the API
+//    {"name": "zed.io", "type": "MX", "ttl": 10, "data": {"priority": 5, "target": "mail.zed.io"}}
+//
+// TTLs are in seconds and data holds the fields of the record type (see below)
+//
+type Record struct {
+    Name            string              `json:"name"`
+    Type            string              `json:"type"`
+    TTL             uint32              `json:"ttl"`
+    Data            json.RawMessage     `json:"data"`
+}
+
+// A, AAAA
+type AddressData struct {
+    IP              string              `json:"ip"`
+}
+
+// CNAME, PTR, NS
+type TargetData struct {
+    Target          string              `json:"target"`
+}
+
+type MXData struct {
+    Priority        uint16              `json:"priority"`
+    Target          string              `json:"target"`
+}
+
+type SRVData struct {
+    Priority        uint16              `json:"priority"`
+    Weight          uint16              `json:"weight"`
+    Port            uint16              `json:"port"`
+    Target          string              `json:"target"`
+}
+
+type TXTData struct {
+    Text            string              `json:"text"`
+}
+
+// timers are in seconds
+type SOAData struct {
+    MName           string              `json:"mname"`
+    RName           string              `json:"rname"`
+    Serial          uint32              `json:"serial"`
+    Refresh         uint32              `json:"refresh"`
+    Retry           uint32              `json:"retry"`
+    Expire          uint32              `json:"expire"`
+    Minimum         uint32              `json:"minimum"`
+}
+
+//
+// Convert a stored record to its API representation
+//
+func Encode(rec record.Record) (Record, error) {
+    var data interface{}
+    var ttl time.Duration
+
+    switch typed := rec.(type) {
+        case *record.ARecord:
+            data, ttl = AddressData{ typed.IP.String() }, typed.TTL
+        case *record.AAAARecord:
+            data, ttl = AddressData{ typed.IP.String() }, typed.TTL
+        case *record.CNAMERecord:
+            data, ttl = TargetData{ typed.Target }, typed.TTL
+        case *record.PTRRecord:
+            data, ttl = TargetData{ typed.Target }, typed.TTL
+        case *record.NSRecord:
+            data, ttl = TargetData{ typed.Target }, typed.TTL
+        case *record.MXRecord:
+            data, ttl = MXData{ typed.Priority, typed.Target }, typed.TTL
+        case *record.SRVRecord:
+            data, ttl = SRVData{ typed.Priority, typed.Weight, typed.Port, typed.Target }, typed.TTL
+        case *record.TXTRecord:
+            data, ttl = TXTData{ typed.Text }, typed.TTL
+        case *record.SOARecord:
+            data, ttl = SOAData{
+                typed.MName,
+                typed.RName,
+                typed.Serial,
+                seconds(typed.Refresh),
+                seconds(typed.Retry),
+                seconds(typed.Expire),
+                seconds(typed.Minimum),
+            }, typed.TTL
+        default:
+            return Record{}, ErrUnsupportedType
+    }
+
+    encoded, err := json.Marshal(data)
+    if err != nil { return Record{}, err }
+
+    return Record{
+        Name:   rec.GetLabel(),
+        Type:   record.TypeIntToString[rec.GetType()],
+        TTL:    seconds(ttl),
+        Data:   encoded,
+    }, nil
+}
+
+//
+// Build the record an API representation describes, validated by the record's constructor
+//
+func Decode(source Record) (record.Record, error) {
+    var rType, known = record.TypeFromString(source.Type)
+    if !known { return nil, ErrUnsupportedType }
+    if len(source.Data) == 0 { return nil, ErrMissingData }
+
+    var ttl = time.Duration(source.TTL) * time.Second
+
+    switch rType {
+        case record.A_RECORD, record.AAAA_RECORD:
+            var data AddressData
+            if err := json.Unmarshal(source.Data, &data) ; err != nil { return nil, err }
+
+            var ip = net.ParseIP(data.IP)
+            if ip == nil { return nil, ErrInvalidAddress }
+
+            if rType == record.A_RECORD { return built(record.A(source.Name, ttl, ip)) }
+            return built(record.AAAA(source.Name, ttl, ip))
+
+        case record.CNAME_RECORD, record.PTR_RECORD, record.NS_RECORD:
+            var data TargetData
+            if err := json.Unmarshal(source.Data, &data) ; err != nil { return nil, err }
+
+            switch rType {
+                case record.CNAME_RECORD:   return built(record.CNAME(source.Name, data.Target, ttl))
+                case record.PTR_RECORD:     return built(record.PTR(source.Name, data.Target, ttl))
+            }
+            return built(record.NS(source.Name, data.Target, ttl))
+
+        case record.MX_RECORD:
+            var data MXData
+            if err := json.Unmarshal(source.Data, &data) ; err != nil { return nil, err }
+            return built(record.MX(source.Name, data.Target, data.Priority, ttl))
+
+        case record.SRV_RECORD:
+            var data SRVData
+            if err := json.Unmarshal(source.Data, &data) ; err != nil { return nil, err }
+            return built(record.SRV(source.Name, data.Target, ttl, data.Priority, data.Weight, data.Port))
+
+        case record.TXT_RECORD:
+            var data TXTData
+            if err := json.Unmarshal(source.Data, &data) ; err != nil { return nil, err }
+
+            // text over record.TXT_STRING_MAX bytes is sent as several strings, so only the whole is limited
+            if len(data.Text) > record.TXT_TEXT_MAX { return nil, record.ErrTextTooLong }
+            return built(record.TXT(source.Name, ttl, data.Text))
+
+        case record.SOA_RECORD:
+            var data SOAData
+            if err := json.Unmarshal(source.Data, &data) ; err != nil { return nil, err }
+            return built(record.SOA(source.Name, data.MName, data.RName, ttl, data.Serial,
+                time.Duration(data.Refresh) * time.Second,
+                time.Duration(data.Retry) * time.Second,
+                time.Duration(data.Expire) * time.Second,
+                time.Duration(data.Minimum) * time.Second))
+    }
+
+    return nil, ErrUnsupportedType
+}
+
+//
+// Return a constructor's record, or nil (rather than a typed nil) on error
+//
+func built(rec record.Record, err error) (record.Record, error) {
+    if err != nil { return nil, err }
+    return rec, nil
+}
+
+func seconds(duration time.Duration) uint32 {
+    return uint32(duration / time.Second)
+}
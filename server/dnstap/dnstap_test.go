@@ -0,0 +1,288 @@
+package dnstap
+
+import (
+    "net"
+    "sync"
+    "time"
+    "bytes"
+    "testing"
+    "path/filepath"
+    "encoding/binary"
+)
+
+//----------------------------------------------
+// Helpers
+//----------------------------------------------
+
+//
+// Split a Frame Streams byte stream into control frames (type -> body) and data frames
+//
+func testFrames(t *testing.T, stream []byte) ([]uint32, [][]byte) {
+    var controls = make([]uint32, 0)
+    var data = make([][]byte, 0)
+
+    for len(stream) > 0 {
+        var length = binary.BigEndian.Uint32(stream)
+        stream = stream[4:]
+
+        if length == 0 {
+            // an escaped control frame
+            var size = binary.BigEndian.Uint32(stream)
+            controls = append(controls, binary.BigEndian.Uint32(stream[4:]))
+            stream = stream[4 + size:]
+            continue
+        }
+
+        if uint32(len(stream)) < length { t.Fatalf("Truncated Frame: %d of %d bytes\n", len(stream), length) }
+        data = append(data, stream[:length])
+        stream = stream[length:]
+    }
+
+    return controls, data
+}
+
+//
+// Decode a protobuf message into field number -> raw values (varints as uint64, bytes as []byte)
+//
+func testDecode(t *testing.T, message []byte) map[uint64][]interface{} {
+    var fields = make(map[uint64][]interface{}, 0)
+
+    for len(message) > 0 {
+        tag, read := binary.Uvarint(message)
+        message = message[read:]
+
+        switch tag & 0x07 {
+            case wireVarint:
+                value, read := binary.Uvarint(message)
+                message = message[read:]
+                fields[tag >> 3] = append(fields[tag >> 3], value)
+            case wireFixed32:
+                fields[tag >> 3] = append(fields[tag >> 3], binary.LittleEndian.Uint32(message))
+                message = message[4:]
+            case wireBytes:
+                length, read := binary.Uvarint(message)
+                message = message[read:]
+                fields[tag >> 3] = append(fields[tag >> 3], message[:length])
+                message = message[length:]
+            default:
+                t.Fatalf("Unexpected Wire Type: %d\n", tag & 0x07)
+        }
+    }
+
+    return fields
+}
+
+//
+// Accept a stream on the listener -- answering READY with ACCEPT, and STOP with FINISH -- and send
+// what was read once it ends. If told to hang up, it ends right after START
+//
+func testReceive(listener net.Listener, hangUp bool) chan []byte {
+    var received = make(chan []byte, 1)
+
+    go func() {
+        conn, err := listener.Accept()
+        if err != nil {
+            close(received)
+            return
+        }
+
+        var control = func(kind uint32, content bool) {
+            var body = binary.BigEndian.AppendUint32(nil, kind)
+            if content {
+                body = binary.BigEndian.AppendUint32(body, FIELD_CONTENT_TYPE)
+                body = binary.BigEndian.AppendUint32(body, uint32(len(CONTENT_TYPE)))
+                body = append(body, CONTENT_TYPE...)
+            }
+            conn.Write(append(binary.BigEndian.AppendUint32(make([]byte, 4), uint32(len(body))), body...))
+        }
+
+        // READY and START are the same size
+        var handshake = 2 * (8 + 12 + len(CONTENT_TYPE))
+
+        var stream = make([]byte, 0)
+        var chunk = make([]byte, 4096)
+        var accepted = false
+        for {
+            read, err := conn.Read(chunk)
+            stream = append(stream, chunk[:read]...)
+
+            if !accepted && len(stream) >= 12 && binary.BigEndian.Uint32(stream[8:]) == CONTROL_READY {
+                control(CONTROL_ACCEPT, true)
+                accepted = true
+            }
+            if hangUp && len(stream) >= handshake { break }
+            if bytes.HasSuffix(stream, []byte{ 0, 0, 0, 0, 0, 0, 0, 4, 0, 0, 0, byte(CONTROL_STOP) }) {
+                control(CONTROL_FINISH, false)
+                break
+            }
+            if err != nil { break }
+        }
+
+        conn.Close()
+        received <- stream
+    }()
+
+    return received
+}
+
+//----------------------------------------------
+// Writer Tests
+//----------------------------------------------
+
+func TestWriter_Stream(t *testing.T) {
+    var buffer bytes.Buffer
+
+    writer, err := NewWriter(&buffer)
+    if err != nil { t.Fatal(err) }
+    writer.Identity = []byte("ns1")
+
+    var received = time.Unix(1700000000, 500)
+    var message = &Message{
+        Type:               CLIENT_QUERY,
+        Protocol:           PROTOCOL_UDP,
+        QueryAddress:       &net.UDPAddr{ IP: net.ParseIP("10.0.0.7"), Port: 5353 },
+        ResponseAddress:    &net.UDPAddr{ IP: net.ParseIP("10.0.0.1"), Port: 53 },
+        QueryTime:          received,
+        QueryMessage:       []byte{ 0x12, 0x34, 0x01, 0x00 },
+    }
+
+    if err := writer.WriteMessage(message); err != nil { t.Fatal(err) }
+    if err := writer.Close(); err != nil { t.Fatal(err) }
+
+    if err := writer.WriteMessage(message); err != ErrWriterClosed {
+        t.Errorf("Incorrect Error:\n\tExpected: %v\n\tGot: %v\n", ErrWriterClosed, err)
+    }
+
+    controls, data := testFrames(t, buffer.Bytes())
+    if len(controls) != 2 || controls[0] != CONTROL_START || controls[1] != CONTROL_STOP {
+        t.Errorf("Incorrect Control Frames:\n\tExpected: %v\n\tGot: %v\n", []uint32{ CONTROL_START, CONTROL_STOP }, controls)
+    }
+    if len(data) != 1 { t.Fatalf("Incorrect Data Frames:\n\tExpected: %d\n\tGot: %d\n", 1, len(data)) }
+
+    var envelope = testDecode(t, data[0])
+    if string(envelope[1][0].([]byte)) != "ns1" || string(envelope[2][0].([]byte)) != "phonebook" || envelope[15][0].(uint64) != DNSTAP_MESSAGE {
+        t.Errorf("Incorrect Envelope:\n\tGot: %v\n", envelope)
+    }
+
+    var fields = testDecode(t, envelope[14][0].([]byte))
+    var expected = map[uint64]interface{}{
+        1:  uint64(CLIENT_QUERY),
+        2:  FAMILY_INET,
+        3:  PROTOCOL_UDP,
+        6:  uint64(5353),
+        7:  uint64(53),
+        8:  uint64(1700000000),
+        9:  uint32(500),
+    }
+    for field, value := range expected {
+        if len(fields[field]) != 1 || fields[field][0] != value {
+            t.Errorf("Incorrect Field %d:\n\tExpected: %v\n\tGot: %v\n", field, value, fields[field])
+        }
+    }
+
+    if !bytes.Equal(fields[4][0].([]byte), net.ParseIP("10.0.0.7").To4()) {
+        t.Errorf("Incorrect Query Address:\n\tGot: %v\n", fields[4])
+    }
+    if !bytes.Equal(fields[10][0].([]byte), message.QueryMessage) {
+        t.Errorf("Incorrect Query Message:\n\tGot: %v\n", fields[10])
+    }
+    if _, exists := fields[14] ; exists {
+        t.Errorf("Queries should not carry a response message\n")
+    }
+}
+
+func TestWriter_Socket(t *testing.T) {
+    var socket = filepath.Join(t.TempDir(), "dnstap.sock")
+    listener, err := net.Listen("unix", socket)
+    if err != nil { t.Fatal(err) }
+    defer listener.Close()
+
+    var received = testReceive(listener, false)
+
+    writer, err := Dial(socket)
+    if err != nil { t.Fatal(err) }
+
+    if err := writer.WriteMessage(&Message{ Type: AUTH_RESPONSE, ResponseMessage: []byte{ 1, 2, 3 } }); err != nil {
+        t.Fatal(err)
+    }
+    if err := writer.Close(); err != nil { t.Fatal(err) }
+
+    controls, data := testFrames(t, <-received)
+    var expected = []uint32{ CONTROL_READY, CONTROL_START, CONTROL_STOP }
+    if len(controls) != 3 || controls[0] != expected[0] || controls[1] != expected[1] || controls[2] != expected[2] {
+        t.Errorf("Incorrect Control Frames:\n\tExpected: %v\n\tGot: %v\n", expected, controls)
+    }
+    if len(data) != 1 {
+        t.Errorf("Incorrect Data Frames:\n\tExpected: %d\n\tGot: %d\n", 1, len(data))
+    }
+}
+
+func TestWriter_Reconnect(t *testing.T) {
+    var socket = filepath.Join(t.TempDir(), "dnstap.sock")
+    listener, err := net.Listen("unix", socket)
+    if err != nil { t.Fatal(err) }
+    defer listener.Close()
+
+    var first = testReceive(listener, true)
+    writer, err := Dial(socket)
+    if err != nil { t.Fatal(err) }
+    <-first
+
+    // the receiver is gone, so the message goes out on a new connection
+    var second = testReceive(listener, false)
+    if err := writer.WriteMessage(&Message{ Type: AUTH_RESPONSE, ResponseMessage: []byte{ 1, 2, 3 } }); err != nil {
+        t.Fatal(err)
+    }
+    if err := writer.Close(); err != nil { t.Fatal(err) }
+
+    controls, data := testFrames(t, <-second)
+    var expected = []uint32{ CONTROL_READY, CONTROL_START, CONTROL_STOP }
+    if len(controls) != 3 || controls[0] != expected[0] || controls[1] != expected[1] || controls[2] != expected[2] {
+        t.Errorf("Incorrect Control Frames:\n\tExpected: %v\n\tGot: %v\n", expected, controls)
+    }
+    if len(data) != 1 || writer.Dropped() != 0 {
+        t.Errorf("Incorrect Data Frames:\n\tExpected: %d, none dropped\n\tGot: %d, %d dropped\n", 1, len(data), writer.Dropped())
+    }
+}
+
+// a destination that takes nothing while stalled
+type testStalled struct {
+    stall           sync.Mutex
+    buffer          bytes.Buffer
+}
+
+func (self *testStalled) Write(content []byte) (int, error) {
+    self.stall.Lock()
+    defer self.stall.Unlock()
+    return self.buffer.Write(content)
+}
+
+func TestWriter_Dropped(t *testing.T) {
+    var destination = &testStalled{}
+    writer, err := NewWriter(destination)
+    if err != nil { t.Fatal(err) }
+
+    // a stalled destination holds up nobody writing messages -- what does not fit the queue is dropped
+    destination.stall.Lock()
+    var written = make(chan bool)
+    go func() {
+        for i := 0 ; i < QUEUE_LENGTH + 10 ; i++ {
+            writer.WriteMessage(&Message{ Type: CLIENT_QUERY, QueryMessage: []byte{ 1, 2, 3 } })
+        }
+        written <- true
+    }()
+
+    select {
+        case <-written:
+        case <-time.After(2 * time.Second):
+            t.Fatalf("Writing messages blocked on a stalled destination\n")
+    }
+
+    destination.stall.Unlock()
+    if err := writer.Close(); err != nil { t.Fatal(err) }
+
+    var _, data = testFrames(t, destination.buffer.Bytes())
+    if writer.Dropped() < 9 || len(data) + int(writer.Dropped()) != QUEUE_LENGTH + 10 {
+        t.Errorf("Incorrect Dropped:\n\tExpected: at least %d, with the rest written\n\tGot: %d dropped, %d written\n", 9, writer.Dropped(), len(data))
+    }
+}
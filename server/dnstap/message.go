@@ -0,0 +1,171 @@
+package dnstap
+
+import (
+    "net"
+    "time"
+    "encoding/binary"
+)
+
+// Dnstap.Type -- the only kind of payload defined
+const DNSTAP_MESSAGE uint64 = 1
+
+type MessageType uint64
+
+// Message.Type values (dnstap.proto)
+const (
+    AUTH_QUERY          MessageType     = 1
+    AUTH_RESPONSE       MessageType     = 2
+    RESOLVER_QUERY      MessageType     = 3
+    RESOLVER_RESPONSE   MessageType     = 4
+    CLIENT_QUERY        MessageType     = 5
+    CLIENT_RESPONSE     MessageType     = 6
+)
+
+// SocketFamily values
+const (
+    FAMILY_INET         uint64          = 1
+    FAMILY_INET6        uint64          = 2
+)
+
+// SocketProtocol values
+const (
+    PROTOCOL_UDP        uint64          = 1
+    PROTOCOL_TCP        uint64          = 2
+)
+
+// protobuf wire types
+const (
+    wireVarint          uint64          = 0
+    wireFixed32         uint64          = 5
+    wireBytes           uint64          = 2
+)
+
+//----------------------------------------------
+// dnstap Message
+//----------------------------------------------
+
+//
+// A single logged DNS message -- the parts of dnstap.Message we record
+//
+type Message struct {
+    Type                MessageType
+    Protocol            uint64              // PROTOCOL_UDP or PROTOCOL_TCP
+    QueryAddress        net.Addr            // the client
+    ResponseAddress     net.Addr            // the server
+    QueryTime           time.Time
+    ResponseTime        time.Time           // zero for queries
+    QueryMessage        []byte              // raw wire bytes
+    ResponseMessage     []byte              // raw wire bytes
+}
+
+//
+// Encode the message, wrapped in a Dnstap envelope, as a protobuf
+//
+func (self *Message) Marshal(identity, version []byte) []byte {
+    var message = make([]byte, 0, 64 + len(self.QueryMessage) + len(self.ResponseMessage))
+    message = appendVarintField(message, 1, uint64(self.Type))
+
+    var queryIP, queryPort = splitAddr(self.QueryAddress)
+    var responseIP, responsePort = splitAddr(self.ResponseAddress)
+
+    if family := socketFamily(queryIP, responseIP) ; family != 0 {
+        message = appendVarintField(message, 2, family)
+    }
+    if self.Protocol != 0 {
+        message = appendVarintField(message, 3, self.Protocol)
+    }
+    if queryIP != nil {
+        message = appendBytesField(message, 4, queryIP)
+    }
+    if responseIP != nil {
+        message = appendBytesField(message, 5, responseIP)
+    }
+    if queryIP != nil {
+        message = appendVarintField(message, 6, uint64(queryPort))
+    }
+    if responseIP != nil {
+        message = appendVarintField(message, 7, uint64(responsePort))
+    }
+    if !self.QueryTime.IsZero() {
+        message = appendVarintField(message, 8, uint64(self.QueryTime.Unix()))
+        message = appendFixed32Field(message, 9, uint32(self.QueryTime.Nanosecond()))
+    }
+    if self.QueryMessage != nil {
+        message = appendBytesField(message, 10, self.QueryMessage)
+    }
+    if !self.ResponseTime.IsZero() {
+        message = appendVarintField(message, 12, uint64(self.ResponseTime.Unix()))
+        message = appendFixed32Field(message, 13, uint32(self.ResponseTime.Nanosecond()))
+    }
+    if self.ResponseMessage != nil {
+        message = appendBytesField(message, 14, self.ResponseMessage)
+    }
+
+    // the Dnstap envelope
+    var result = make([]byte, 0, len(message) + len(identity) + len(version) + 16)
+    if len(identity) > 0 {
+        result = appendBytesField(result, 1, identity)
+    }
+    if len(version) > 0 {
+        result = appendBytesField(result, 2, version)
+    }
+    result = appendBytesField(result, 14, message)
+    result = appendVarintField(result, 15, DNSTAP_MESSAGE)
+
+    return result
+}
+
+//
+// Return the IP (4 bytes for IPv4) and port of a UDP or TCP address
+//
+func splitAddr(addr net.Addr) (net.IP, int) {
+    var ip net.IP
+    var port int
+
+    switch typed := addr.(type) {
+        case *net.UDPAddr:
+            ip, port = typed.IP, typed.Port
+        case *net.TCPAddr:
+            ip, port = typed.IP, typed.Port
+        default:
+            return nil, 0
+    }
+
+    if v4 := ip.To4() ; v4 != nil { ip = v4 }
+    return ip, port
+}
+
+func socketFamily(ips ...net.IP) uint64 {
+    for _, ip := range ips {
+        if ip == nil { continue }
+        if len(ip) == net.IPv4len { return FAMILY_INET }
+        return FAMILY_INET6
+    }
+
+    return 0
+}
+
+//----------------------------------------------
+// Protobuf Encoding
+//----------------------------------------------
+
+func appendVarint(buffer []byte, value uint64) []byte {
+    return binary.AppendUvarint(buffer, value)
+}
+
+func appendTag(buffer []byte, field, wire uint64) []byte {
+    return appendVarint(buffer, field << 3 | wire)
+}
+
+func appendVarintField(buffer []byte, field, value uint64) []byte {
+    return appendVarint(appendTag(buffer, field, wireVarint), value)
+}
+
+func appendFixed32Field(buffer []byte, field uint64, value uint32) []byte {
+    return binary.LittleEndian.AppendUint32(appendTag(buffer, field, wireFixed32), value)
+}
+
+func appendBytesField(buffer []byte, field uint64, value []byte) []byte {
+    buffer = appendVarint(appendTag(buffer, field, wireBytes), uint64(len(value)))
+    return append(buffer, value...)
+}
@@ -0,0 +1,340 @@
+package dnstap
+
+import (
+    "io"
+    "os"
+    "net"
+    "sync"
+    "time"
+    "bytes"
+    "errors"
+    "sync/atomic"
+    "encoding/binary"
+)
+
+// the Frame Streams content type of dnstap payloads
+const CONTENT_TYPE string = "protobuf:dnstap.Dnstap"
+
+// Frame Streams control frame types
+const (
+    CONTROL_ACCEPT      uint32      = 0x01
+    CONTROL_START       uint32      = 0x02
+    CONTROL_STOP        uint32      = 0x03
+    CONTROL_READY       uint32      = 0x04
+    CONTROL_FINISH      uint32      = 0x05
+
+    FIELD_CONTENT_TYPE  uint32      = 0x01
+)
+
+// the largest control frame we will read back from a socket
+const MAX_CONTROL_LENGTH uint32 = 512
+
+const (
+    // data frames waiting to be written -- more are dropped (and counted) rather than hold up queries
+    QUEUE_LENGTH        int             = 1024
+
+    // wait between attempts to reach a socket again after losing it
+    RECONNECT_INTERVAL  time.Duration   = time.Second
+
+    // longest a socket's receiver may take over the handshake, or to take the rest of the stream on Close
+    SOCKET_TIMEOUT      time.Duration   = 5 * time.Second
+)
+
+var ErrControlFrame     error   = errors.New("ERROR: Unexpected Frame Streams control frame")
+var ErrContentType      error   = errors.New("ERROR: The receiver does not accept dnstap")
+var ErrWriterClosed     error   = errors.New("ERROR: The dnstap writer is closed")
+
+//----------------------------------------------
+// Frame Streams Writer
+//----------------------------------------------
+
+//
+// Writes dnstap messages as a Frame Streams data stream (to a file or a unix socket)
+// Safe for concurrent use
+//
+// Messages are queued and written by a goroutine of the writer's own, so a slow destination never holds up
+// a caller: a message arriving to a full queue, or failing to be written, is dropped and counted (see Dropped).
+// A socket that fails is dialed again -- straight away, then every RECONNECT_INTERVAL -- dropping what comes meanwhile
+//
+type Writer struct {
+    Identity        []byte              // identifies this server in every message (optional)
+    Version         []byte              // the software writing the stream
+
+    lock            sync.RWMutex        // over the queue, closeBy, and conn -- the destination is the goroutine's
+    frames          chan []byte
+    done            chan struct{}
+    dropped         atomic.Uint64
+    closeBy         time.Time           // set once Close begins (taking no more messages), when it gives up on a socket
+
+    destination     io.Writer           // nil while a socket is being dialed again
+    closer          io.Closer
+    reader          io.Reader           // set for bidirectional (socket) streams
+    socket          string
+    conn            net.Conn
+    lastDial        time.Time
+}
+
+//
+// Begin a unidirectional stream on any writer (a file, a buffer)
+//
+func NewWriter(destination io.Writer) (*Writer, error) {
+    var result = &Writer{
+        Version:        []byte("phonebook"),
+        destination:    destination,
+    }
+
+    if closer, ok := destination.(io.Closer) ; ok {
+        result.closer = closer
+    }
+
+    var err = result.writeControl(CONTROL_START, true)
+    if err != nil { return nil, err }
+
+    result.start()
+    return result, nil
+}
+
+//
+// Begin a stream into a new (or truncated) file
+//
+func Create(path string) (*Writer, error) {
+    file, err := os.Create(path)
+    if err != nil { return nil, err }
+
+    writer, err := NewWriter(file)
+    if err != nil {
+        file.Close()
+        return nil, err
+    }
+
+    return writer, nil
+}
+
+//
+// Begin a bidirectional stream to a listening unix socket (dnstap -u, for example)
+// The receiver must accept the dnstap content type
+//
+func Dial(socket string) (*Writer, error) {
+    var result = &Writer{
+        Version:        []byte("phonebook"),
+        socket:         socket,
+    }
+
+    if err := result.dial() ; err != nil { return nil, err }
+
+    result.start()
+    return result, nil
+}
+
+//
+// Connect to the socket: READY -> ACCEPT, then START
+//
+func (self *Writer) dial() error {
+    self.lastDial = time.Now()
+
+    // the handshake gets SOCKET_TIMEOUT, or what Close has left
+    var deadline = self.lastDial.Add(SOCKET_TIMEOUT)
+    if closeBy := self.closing() ; !closeBy.IsZero() && closeBy.Before(deadline) { deadline = closeBy }
+
+    conn, err := net.DialTimeout("unix", self.socket, time.Until(deadline))
+    if err != nil { return err }
+
+    self.destination, self.reader = conn, conn
+    conn.SetDeadline(deadline)
+
+    err = self.writeControl(CONTROL_READY, true)
+    if err == nil { err = self.expectControl(CONTROL_ACCEPT) }
+    if err == nil { err = self.writeControl(CONTROL_START, true) }
+
+    if err != nil {
+        self.destination, self.reader = nil, nil
+        conn.Close()
+        return err
+    }
+    self.closer = conn
+
+    // Close may have begun meanwhile, and set its deadline
+    self.lock.Lock()
+    self.conn = conn
+    conn.SetDeadline(self.closeBy)
+    self.lock.Unlock()
+    return nil
+}
+
+//
+// When Close gives up on a socket, or zero if it has not begun
+//
+func (self *Writer) closing() time.Time {
+    self.lock.RLock()
+    defer self.lock.RUnlock()
+    return self.closeBy
+}
+
+//
+// Start writing queued frames
+//
+func (self *Writer) start() {
+    self.frames = make(chan []byte, QUEUE_LENGTH)
+    self.done = make(chan struct{})
+    go self.run()
+}
+
+func (self *Writer) run() {
+    defer close(self.done)
+
+    for frame := range self.frames {
+        if !self.write(frame) { self.dropped.Add(1) }
+    }
+}
+
+//
+// Write a frame, dialing a lost socket again -- false if the frame could not be written
+//
+func (self *Writer) write(frame []byte) bool {
+    if self.destination == nil && !self.redial() { return false }
+    if _, err := self.destination.Write(frame) ; err == nil { return true }
+    if self.socket == "" { return false }
+
+    // the receiver went away: try a new connection at once, for this frame
+    self.disconnect()
+    if !self.redial() { return false }
+
+    _, err := self.destination.Write(frame)
+    return err == nil
+}
+
+//
+// Dial the socket again, unless it was tried too recently or Close has given up on it
+//
+func (self *Writer) redial() bool {
+    if self.socket == "" || time.Since(self.lastDial) < RECONNECT_INTERVAL { return false }
+    if closeBy := self.closing() ; !closeBy.IsZero() && time.Now().After(closeBy) { return false }
+
+    return self.dial() == nil
+}
+
+func (self *Writer) disconnect() {
+    self.lock.Lock()
+    self.conn = nil
+    self.lock.Unlock()
+
+    self.closer.Close()
+    self.destination, self.closer, self.reader = nil, nil, nil
+    self.lastDial = time.Time{}
+}
+
+//
+// Queue a single message as a data frame -- dropped (and counted) if the queue is full
+//
+func (self *Writer) WriteMessage(message *Message) error {
+    var payload = message.Marshal(self.Identity, self.Version)
+
+    var frame = make([]byte, 4, 4 + len(payload))
+    binary.BigEndian.PutUint32(frame, uint32(len(payload)))
+    frame = append(frame, payload...)
+
+    self.lock.RLock()
+    defer self.lock.RUnlock()
+
+    if !self.closeBy.IsZero() { return ErrWriterClosed }
+
+    select {
+        case self.frames <- frame:
+        default:
+            self.dropped.Add(1)
+    }
+    return nil
+}
+
+//
+// Messages dropped so far, for a full queue or a failed write
+//
+func (self *Writer) Dropped() uint64 {
+    return self.dropped.Load()
+}
+
+//
+// Write what is queued, end the stream (STOP, and FINISH from a socket's receiver), and close the destination
+// A socket's receiver has SOCKET_TIMEOUT to take the rest
+//
+func (self *Writer) Close() error {
+    self.lock.Lock()
+    if !self.closeBy.IsZero() {
+        self.lock.Unlock()
+        return nil
+    }
+    self.closeBy = time.Now().Add(SOCKET_TIMEOUT)
+    close(self.frames)
+    if self.conn != nil { self.conn.SetDeadline(self.closeBy) }
+    self.lock.Unlock()
+
+    <-self.done
+    if self.destination == nil { return nil }
+
+    var err = self.writeControl(CONTROL_STOP, false)
+    if err == nil && self.reader != nil {
+        err = self.expectControl(CONTROL_FINISH)
+    }
+
+    if self.closer != nil {
+        if closeErr := self.closer.Close() ; err == nil {
+            err = closeErr
+        }
+    }
+
+    return err
+}
+
+//
+// Write a control frame, optionally naming our content type
+//
+func (self *Writer) writeControl(control uint32, contentType bool) error {
+    var body = binary.BigEndian.AppendUint32(nil, control)
+    if contentType {
+        body = binary.BigEndian.AppendUint32(body, FIELD_CONTENT_TYPE)
+        body = binary.BigEndian.AppendUint32(body, uint32(len(CONTENT_TYPE)))
+        body = append(body, CONTENT_TYPE...)
+    }
+
+    // an escape (a zero length data frame), the control frame length, then the frame
+    var frame = make([]byte, 8, 8 + len(body))
+    binary.BigEndian.PutUint32(frame[4:], uint32(len(body)))
+    frame = append(frame, body...)
+
+    _, err := self.destination.Write(frame)
+    return err
+}
+
+//
+// Read a control frame from the receiver, failing unless it is of the expected type
+// An ACCEPT must list the dnstap content type
+//
+func (self *Writer) expectControl(control uint32) error {
+    var header = make([]byte, 8)
+    if _, err := io.ReadFull(self.reader, header); err != nil { return err }
+
+    var length = binary.BigEndian.Uint32(header[4:])
+    if binary.BigEndian.Uint32(header) != 0 || length < 4 || length > MAX_CONTROL_LENGTH {
+        return ErrControlFrame
+    }
+
+    var body = make([]byte, length)
+    if _, err := io.ReadFull(self.reader, body); err != nil { return err }
+
+    if binary.BigEndian.Uint32(body) != control { return ErrControlFrame }
+    if control != CONTROL_ACCEPT { return nil }
+
+    // look for our content type among the accepted ones
+    for fields := body[4:] ; len(fields) >= 8 ; {
+        var kind = binary.BigEndian.Uint32(fields)
+        var size = binary.BigEndian.Uint32(fields[4:])
+        if uint32(len(fields) - 8) < size { break }
+
+        if kind == FIELD_CONTENT_TYPE && bytes.Equal(fields[8:8 + size], []byte(CONTENT_TYPE)) {
+            return nil
+        }
+        fields = fields[8 + size:]
+    }
+
+    return ErrContentType
+}
@@ -0,0 +1,46 @@
+package server
+
+import (
+    "net"
+    "time"
+    "net/http"
+
+    "github.com/zmarcantel/phonebook/server/api"
+)
+
+// how long an HTTP client may take to send its request headers
+const HTTPHeaderTimeout time.Duration = 5 * time.Second
+
+//
+// Serve the management API (see api.API) for the server's store
+// A non-empty token must be sent by every client as "Authorization: Bearer <token>"
+// Returns the address listened on -- Close and Shutdown stop the listener
+//
+func (self *Server) ListenAPI(address, token string) (net.Addr, error) {
+    var handler = api.New(self.Store)
+    handler.Token = token
+
+    return self.listenHTTP("api", address, handler)
+}
+
+//
+// Serve a handler on its own listener until the server is closed
+//
+func (self *Server) listenHTTP(purpose, address string, handler http.Handler) (net.Addr, error) {
+    listener, err := net.Listen("tcp", address)
+    if err != nil { return nil, err }
+
+    var httpServer = &http.Server{ Handler: handler, ReadHeaderTimeout: HTTPHeaderTimeout }
+    if err = self.trackHTTP(purpose, httpServer) ; err != nil {
+        listener.Close()
+        return nil, err
+    }
+
+    go func() {
+        if err := httpServer.Serve(listener) ; err != http.ErrServerClosed {
+            self.reportError(err)
+        }
+    }()
+
+    return listener.Addr(), nil
+}
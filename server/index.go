@@ -1,20 +1,27 @@
 package server
 
 import (
-    "fmt"
+    "io"
     "net"
     "time"
+    "sync"
+    "context"
     "errors"
     "strconv"
+    "encoding/binary"
 
     "github.com/zmarcantel/phonebook/dns"
     "github.com/zmarcantel/phonebook/dns/record"
 
     "github.com/zmarcantel/phonebook/server/store"
+    "github.com/zmarcantel/phonebook/server/dnstap"
+    "github.com/zmarcantel/phonebook/server/logging"
 )
 
 const (
     DNSTimeout      time.Duration   = 2 * 1e8
+    TCPIdleTimeout  time.Duration   = 10 * time.Second
+    MaxUDPSize      uint16          = 1232              // largest EDNS datagram we accept or send by default
 
     ERR_FORMAT      int             = 1
     ERR_INTERNAL    int             = 2
@@ -24,6 +31,8 @@ const (
 )
 
 var ErrShortRead    error           = errors.New("ERROR: short read")
+var ErrPanic        error           = errors.New("ERROR: Recovered from a panic while answering a query")
+var ErrProtocol     error           = errors.New("ERROR: Queries are only taken over udp or tcp")
 
 
 type Server struct {
@@ -32,6 +41,20 @@ type Server struct {
     Address         net.Addr
     Store           store.DNSStore
     Connection      *net.UDPConn
+    Listener        *net.TCPListener
+    IdleTimeout     time.Duration           // how long a TCP connection may sit between queries
+    MaxUDPSize      uint16                  // largest datagram we accept or send to EDNS clients
+    Zones           *store.Zones            // zones we are authoritative for -- none means every name
+    Logger          logging.Logger          // nil uses logging.Default() -- silent unless configured
+    Tap             *dnstap.Writer          // dnstap query log (optional) -- owned by the caller, not closed by Close
+    Metrics         *Metrics                // per-server counters (nil records nothing) -- see ListenMetrics
+    AllowUpdate     Policy                  // who may change zones with dynamic UPDATE (nil refuses everyone)
+    AllowTransfer   Policy                  // who may copy zones with AXFR and IXFR (nil refuses everyone)
+    Journal         *store.Journal          // recent changes made by UPDATE, for IXFR (nil always sends the whole zone)
+    Keys            dns.KeyRing             // TSIG keys -- once there are any, UPDATEs and zone transfers must be signed
+
+    state           lifecycle               // see Shutdown and Close
+    updating        sync.Mutex              // UPDATEs are applied one at a time
 }
 
 
@@ -42,21 +65,25 @@ type Server struct {
 //    Port: port to listen for queries on
 //
 func Start(bind string, port int, backing store.DNSStore, die chan error) *Server {
+    // the server has no logger of its own yet
+    var logger = logging.Default()
+    var ctx = context.Background()
+
     // get the DNS address for the DNS host
     if len(bind) == 0 || bind == "localhost" {
-        fmt.Printf("Using DEFULT_HOST:127.0.0.1 based on binding input: %s\n", bind)
+        logging.Info(logger, ctx, "using default host 127.0.0.1", logging.F("bind", bind))
         bind = "127.0.0.1"
     }
 
     if port <= 0 {
-        fmt.Printf("Using DEFULT_PORT:53 based on port input: %d\n", port)
+        logging.Info(logger, ctx, "using default port 53", logging.F("port", port))
         port = 53
     }
 
     // check if the store option is nil
     // if so, that means default storage agent -- MapStore
     if backing == nil {
-        fmt.Println("No backing given -- using default MapStore")
+        logging.Info(logger, ctx, "no backing given -- using default MapStore")
         backing = store.Map()
     }
 
@@ -77,21 +104,55 @@ func Start(bind string, port int, backing store.DNSStore, die chan error) *Serve
         return nil
     }
 
-    // make the server we will return
-    var result = &Server{
-        die,
-        make(chan error),
-        addr,
-        backing,
-        conn,
+    // and a TCP listener on the same address for queries too large for a datagram
+    tcpAddr, err := net.ResolveTCPAddr("tcp", addr.String())
+    if err != nil {
+        conn.Close()
+        die <- err
+        return nil
     }
 
-    // start watching for errors
-    go result.WatchErrors()
+    listener, err := net.ListenTCP("tcp", tcpAddr)
+    if err != nil {
+        conn.Close()
+        die <- err
+        return nil
+    }
+
+    // make the server we will return
+    var result = New(backing, die)
+    result.Address = addr
+    result.Connection = conn
+    result.Listener = listener
 
     // get the server listening before we return (convenience)
     // do the listening in a goroutine
     go result.Listen()
+    go result.ListenTCP()
+
+    return result
+}
+
+//
+// Create a server answering from the store without listening anywhere yet -- see ListenOn
+// Errors are watched from the start, and the given channel is the killswitch as with Start
+//
+func New(backing store.DNSStore, die chan error) *Server {
+    if backing == nil { backing = store.Map() }
+
+    var result = &Server{
+        Fatal:          die,
+        Error:          make(chan error),
+        Store:          backing,
+        IdleTimeout:    TCPIdleTimeout,
+        MaxUDPSize:     MaxUDPSize,
+        Zones:          store.NewZones(),
+        Journal:        store.NewJournal(store.JOURNAL_LIMIT),
+    }
+    result.Metrics = NewMetrics(result)
+
+    // start watching for errors
+    go result.WatchErrors()
 
     return result
 }
@@ -109,37 +170,195 @@ func Local(backing store.DNSStore, die chan error) *Server {
 // Responsible for intake only
 //
 func (self *Server) Listen() {
+    self.listenUDP(self.Connection)
+}
+
+//
+// Accept TCP connections and serve each in its own goroutine
+// Responsible for intake only
+//
+func (self *Server) ListenTCP() {
+    self.listenTCP(self.Listener)
+}
+
+//
+// Listen for queries on another address over "udp" or "tcp", alongside the server's own sockets
+// Returns the address listened on -- Close and Shutdown stop the listener
+//
+func (self *Server) ListenOn(protocol, address string) (net.Addr, error) {
+    switch protocol {
+        case PROTO_UDP:
+            addr, err := net.ResolveUDPAddr("udp", address)
+            if err != nil { return nil, err }
+
+            conn, err := net.ListenUDP("udp", addr)
+            if err != nil { return nil, err }
+
+            if err = self.trackSocket(conn, nil) ; err != nil {
+                conn.Close()
+                return nil, err
+            }
+
+            go self.listenUDP(conn)
+            return conn.LocalAddr(), nil
+
+        case PROTO_TCP:
+            addr, err := net.ResolveTCPAddr("tcp", address)
+            if err != nil { return nil, err }
+
+            listener, err := net.ListenTCP("tcp", addr)
+            if err != nil { return nil, err }
+
+            if err = self.trackSocket(nil, listener) ; err != nil {
+                listener.Close()
+                return nil, err
+            }
+
+            go self.listenTCP(listener)
+            return listener.Addr(), nil
+    }
+
+    return nil, ErrProtocol
+}
+
+//
+// Read datagrams off of a socket, answering each in its own goroutine
+//
+func (self *Server) listenUDP(socket *net.UDPConn) {
+    if !self.begin() { return }
+    defer self.end()
+
     // announce the listener
-    fmt.Printf("DNS Server listening on: %s\n", self.Connection.LocalAddr())
+    var ctx = context.Background()
+    var address = logging.F(logging.FIELD_ADDRESS, socket.LocalAddr().String())
+    logging.Info(self.logger(), ctx, "listening", address, logging.F(logging.FIELD_PROTOCOL, PROTO_UDP))
 
-    // defer closing the connection until the below for loop exits
-    // only happens on kill/int signal, error, etc
-    defer self.Connection.Close()
+    // answers to the server's own socket go out through Connection
+    var reply *net.UDPConn
+    if socket != self.Connection { reply = socket }
 
     // round and round it goes, when it stops, only the program knows!!
     for {
-        // make a 512 byte buffer (max DNS packet size as per RFC 1035)
-        var content = make([]byte, 512)
+        // make a buffer as large as the biggest datagram we will take (512 bytes without EDNS)
+        var content = make([]byte, self.udpSize())
 
         // read our packet into the buffer
-        var readLength, addr, err = self.Connection.ReadFromUDP(content)
+        var readLength, addr, err = socket.ReadFromUDP(content)
+        var received = time.Now()
         if err != nil {
+            // shutting down -- Shutdown closes the connection once responses in flight are sent
+            if self.closing() { break }
+
             // report the issue if it exists
-            self.Fatal <- err
+            self.reportFatal(err)
+            socket.Close()
+            break
         }
         if readLength == 0 {
             // got a short read... abort
             // TODO: send error response
-            self.Error <- err
+            self.reportError(ErrShortRead)
+            continue
         }
 
+        // the server may have begun shutting down while we read
+        if !self.begin() { break }
+
         // trim of any buffer fat and respond in an isolated goroutine
-        content = content[:readLength]
-        go self.Serve(addr, content)
+        var request = &Request{ Client: addr, Protocol: PROTO_UDP, Query: content[:readLength], Received: received, socket: reply }
+        if reply != nil { request.local = reply.LocalAddr() }
+        go func() {
+            defer self.end()
+            self.serve(request)
+        }()
     }
 
     // report the escaping for the foor loop without an error on the channel
-    fmt.Println("DNS passed on connection.")
+    logging.Info(self.logger(), ctx, "stopped listening", address, logging.F(logging.FIELD_PROTOCOL, PROTO_UDP))
+}
+
+//
+// Accept connections off of a TCP listener, serving each in its own goroutine
+//
+func (self *Server) listenTCP(listener *net.TCPListener) {
+    if !self.begin() { return }
+    defer self.end()
+
+    // announce the listener
+    var ctx = context.Background()
+    var address = logging.F(logging.FIELD_ADDRESS, listener.Addr().String())
+    logging.Info(self.logger(), ctx, "listening", address, logging.F(logging.FIELD_PROTOCOL, PROTO_TCP))
+
+    // defer closing the listener until the below for loop exits
+    defer listener.Close()
+
+    for {
+        conn, err := listener.AcceptTCP()
+        if err != nil {
+            // report the issue (unless shutting down) and stop accepting
+            if !self.closing() { self.reportFatal(err) }
+            break
+        }
+
+        go self.ServeTCP(conn)
+    }
+
+    logging.Info(self.logger(), ctx, "stopped listening", address, logging.F(logging.FIELD_PROTOCOL, PROTO_TCP))
+}
+
+//
+// Answer queries on a TCP connection until the client hangs up or goes idle
+// Each message is preceded by its length as a 2 byte integer (RFC 1035 4.2.2)
+// Runs in isolated/concurrent thread
+//
+func (self *Server) ServeTCP(conn *net.TCPConn) {
+    defer conn.Close()
+
+    // the connection counts as in flight until it ends
+    if !self.track(conn) { return }
+    defer self.untrack(conn)
+
+    var length = make([]byte, 2)
+    for {
+        // clients may send several queries over one connection
+        // but may not hold it open forever, nor past a shutdown
+        if !self.extendDeadline(conn) { return }
+
+        if _, err := io.ReadFull(conn, length); err != nil {
+            // EOF and idle timeouts are the normal ways for a connection to end
+            return
+        }
+
+        var query = make([]byte, binary.BigEndian.Uint16(length))
+        if _, err := io.ReadFull(conn, query); err != nil {
+            self.reportError(ErrShortRead)
+            return
+        }
+
+        var received = time.Now()
+        self.Metrics.begin()
+
+        // zone transfers answer with many messages, the rest with one
+        var answered bool
+        var request = &Request{ Client: conn.RemoteAddr(), Protocol: PROTO_TCP, Query: query, Received: received, local: conn.LocalAddr() }
+        var err = self.HandleStream(request, func(response []byte) error {
+            conn.SetWriteDeadline(time.Now().Add(self.IdleTimeout))
+
+            // prefix the length and send it as a single write
+            var framed = append(dns.Uint16ToBytes(uint16(len(response))), response...)
+            if _, err := conn.Write(framed) ; err != nil { return err }
+
+            answered = true
+            return nil
+        })
+        self.Metrics.end(received, answered && err == nil)
+
+        if err != nil {
+            logging.Warn(self.logger(), context.Background(), "could not respond to request",
+                logging.F(logging.FIELD_CLIENT, conn.RemoteAddr().String()), logging.F(logging.FIELD_ERROR, err))
+            return
+        }
+    }
 }
 
 //
@@ -147,18 +366,206 @@ func (self *Server) Listen() {
 // Runs in isolated/concurrent thread
 //
 func (self *Server) Serve(addr net.Addr, query []byte) {
-    // TODO: catch and respond to packet errors
-    var message = dns.UnpackMessage(query)
-
-    // TODO: logging verbosity
-    // print the request to logs
-    fmt.Printf("\n\nREQUEST: %d\n", message.Header.ID)
-    message.Questions.Print(1)
-
-    // verify it's a query...
-    if !message.Header.Response {
-        // get the answers to the questions posed
-        var answers, err = self.Answer(message.Questions)
+    self.serve(&Request{ Client: addr, Protocol: PROTO_UDP, Query: query, Received: time.Now() })
+}
+
+//
+// Answer a datagram, timed from when it was read
+//
+func (self *Server) serve(request *Request) {
+    self.Metrics.begin()
+
+    var response = self.Handle(request)
+    if response == nil {
+        self.Metrics.end(request.Received, false)
+        return
+    }
+
+    // write the serialized DNS packet to the address given in the request, from the socket it came in on
+    // this ends the cycle of the DNS request
+    var socket = request.socket
+    if socket == nil { socket = self.Connection }
+    _, err := socket.WriteTo(response, request.Client)
+    self.Metrics.end(request.Received, err == nil)
+
+    if err != nil {
+        logging.Warn(self.logger(), context.Background(), "could not respond to request",
+            logging.F(logging.FIELD_CLIENT, request.Client.String()), logging.F(logging.FIELD_ERROR, err))
+    }
+}
+
+//
+// The answer pipeline shared by every transport
+// Returns the serialized response, or nil if nothing should be sent back
+// Only the first message of a zone transfer is returned -- see HandleStream
+//
+func (self *Server) Handle(request *Request) []byte {
+    var result []byte
+    self.HandleStream(request, func(response []byte) error {
+        if result == nil { result = response }
+        return nil
+    })
+
+    return result
+}
+
+//
+// Answer a request, handing each serialized response message to send in order
+// Most requests are answered with a single message (or none), zone transfers with as many as the zone needs
+// Stops at, and returns, the first error from send
+//
+func (self *Server) HandleStream(request *Request, send func([]byte) error) (err error) {
+    if request.Received.IsZero() { request.Received = time.Now() }
+
+    // a bug tripped by one query drops that query rather than taking down the server
+    defer func() {
+        if panicked := recover() ; panicked != nil {
+            logging.Error(self.logger(), context.Background(), "recovered from panic",
+                logging.F(logging.FIELD_ERROR, panicked), logging.F(logging.FIELD_PROTOCOL, request.Protocol))
+            self.reportError(ErrPanic)
+            err = nil
+        }
+    }()
+    self.tap(request, dnstap.CLIENT_QUERY, nil)
+
+    // a query that cannot be read is answered FORMERR -- or dropped if even its header is unreadable
+    message, err := dns.UnpackMessage(request.Query)
+    if err != nil {
+        self.Metrics.parseError()
+        self.reportError(err)
+        return sendResponse(send, self.errorResponse(request, ERR_FORMAT))
+    }
+
+    var ctx = request.Context(message.Header.ID)
+    var logger = self.logger()
+    logging.Debug(logger, ctx, "request", questionFields(message.Questions)...)
+
+    // verify it's a query (or an update)...
+    if message.Header.Response {
+        return nil
+    }
+
+    // signatures are checked before anything else is looked at (RFC 8945 5.2)
+    signature, key, err := dns.VerifyTSIG(request.Query, self.Keys, nil, request.Received)
+    var failure, failed = err.(dns.TSIGError)
+    if err != nil && !failed {
+        self.reportError(err)
+        return sendResponse(send, self.errorResponse(request, ERR_FORMAT))
+    }
+    if !failed { request.Key = key }
+    request.signature = signature
+
+    // a client speaking a newer EDNS version than ours gets BADVERS and nothing else
+    var opt = message.OPT()
+    var badVersion = opt != nil && opt.Version > dns.EDNS_VERSION
+
+    var isUpdate = message.Header.Opcode == dns.OPCODE_UPDATE
+    if isUpdate {
+        self.Metrics.update()
+    } else {
+        self.Metrics.query(message.Questions)
+    }
+
+    var responses []dns.Message
+    if failed || self.unsigned(request, message) {
+        responses = []dns.Message{ self.unauthorized(request, message, failure) }
+    } else if isUpdate {
+        responses = []dns.Message{ self.update(request, message, badVersion) }
+    } else if isTransfer(message) {
+        responses = self.transfer(request, message, badVersion)
+    } else {
+        responses = []dns.Message{ self.query(message, badVersion) }
+    }
+
+    var answers int
+    var previous *record.TSIGRecord
+    for i := range responses {
+        var response = &responses[i]
+        answers += len(response.Answers)
+
+        // EDNS clients get an OPT record of our own back
+        if opt != nil {
+            response.Extra = append(response.Extra, self.responseOPT(opt))
+            response.Header.ARCount = uint16(len(response.Extra))
+
+            if badVersion {
+                response.SetExtendedRcode(dns.ERR_BADVERS)
+            }
+        }
+
+        // serialize the message for wire transfer
+        // datagrams are cut down to what the client can take, leaving it to retry over TCP
+        var serialized []byte
+        if request.Protocol == PROTO_UDP {
+            var limit = message.UDPSize()
+            if limit > self.udpSize() { limit = self.udpSize() }
+
+            // leaving room for our signature
+            if request.Key != nil { limit -= request.Key.SignatureSize() }
+
+            serialized, err = response.SerializeWithin(limit)
+        } else {
+            serialized, err = response.Serialize()
+        }
+        if err == nil && signature != nil {
+            serialized, previous, err = self.sign(request, serialized, failure, previous)
+        }
+        // a response that cannot be built or signed fails this query, answered SERVFAIL, and nothing else
+        if err != nil {
+            self.reportError(err)
+            return sendResponse(send, self.errorResponse(request, ERR_INTERNAL))
+        }
+
+        self.tap(request, dnstap.AUTH_RESPONSE, serialized)
+        if i == 0 { self.Metrics.response(response) }
+
+        if err = send(serialized) ; err != nil { return err }
+    }
+
+    // log the outcome
+    if logger.Enabled(ctx, logging.INFO) && len(responses) > 0 {
+        var fields = questionFields(message.Questions)
+        fields = append(fields,
+            logging.F(logging.FIELD_RCODE, responses[0].ExtendedRcode()),
+            logging.F("answers", answers),
+            logging.F(logging.FIELD_LATENCY, time.Since(request.Received)),
+        )
+        var outcome = "query answered"
+        if isUpdate { outcome = "update answered" }
+        if !isUpdate && isTransfer(message) { outcome = "transfer answered" }
+        if len(responses) > 1 { fields = append(fields, logging.F("messages", len(responses))) }
+        logging.Info(logger, ctx, outcome, fields...)
+    }
+
+    return nil
+}
+
+//
+// Send an error response, if there is one
+//
+func sendResponse(send func([]byte) error, response []byte) error {
+    if response == nil { return nil }
+    return send(response)
+}
+
+//
+// Answer the questions of a (well formed) query
+//
+func (self *Server) query(message *dns.Message, badVersion bool) dns.Message {
+    // names outside of our zones are refused rather than answered
+    var zone, refused = self.findZone(message.Questions)
+
+    // get the answers to the questions posed
+    var answers []record.Record
+    if countOPT(message) > 1 {
+        // only one OPT record is allowed per message (RFC 6891 6.1.1)
+        message.Header.Rcode = ERR_FORMAT
+    } else if refused {
+        message.Header.Rcode = ERR_REFUSED
+    } else if !badVersion {
+        var err error
+        answers, err = self.Answer(message.Questions)
+        // names that do not exist and types we do not serve are answers -- only a failing store is an error
         if err != nil {
             if err == store.ErrNotFound {
                 message.Header.Rcode = ERR_NOEXIST
@@ -166,47 +573,163 @@ func (self *Server) Serve(addr net.Addr, query []byte) {
                 message.Header.Rcode = ERR_NOIMPL
             } else {
                 message.Header.Rcode = ERR_INTERNAL
+                self.reportError(err)
             }
-            self.Error <- err
         }
+    }
 
-        // format the response(s) we found into a DNS packet to
-        // be served to the client
-        var response = generateAnswerMessage(message, answers)
+    // format the response(s) we found into a DNS packet to
+    // be served to the client
+    var response = generateAnswerMessage(message, answers)
+    response.Header.Authoritative = !refused
+    negativeAuthority(&response, zone)
 
-        // serialize the message for wire transfer
-        serialized, err := response.Serialize()
-        if err != nil {
-            self.Fatal <- err
-        }
+    return response
+}
 
-        // print the response to logs
-        fmt.Println("\n\nRESPONSE:")
-        response.Print(1)
+//
+// Build the response to a query that could not be unpacked (FORMERR) or answered (SERVFAIL)
+// Only the header is echoed -- nothing past it can be trusted. Returns nil to drop the packet
+// when the header itself is unreadable or the packet is not a query
+//
+func (self *Server) errorResponse(request *Request, rcode int) []byte {
+    var header, _, err = dns.UnpackHeader(request.Query)
+    if err != nil || header.Response { return nil }
+
+    var response = dns.Message{
+        Header: dns.MessageHeader{
+            ID:                 header.ID,
+            Response:           true,
+            Opcode:             header.Opcode,
+            RecursionDesired:   header.RecursionDesired,
+            Rcode:              rcode,
+        },
+    }
 
-        // write the serialized DNS packet to the address given in the request
-        // this ends the cycle of the DNS request
-        _, err = self.Connection.WriteTo(serialized, addr)
-        if err != nil {
-            fmt.Printf("ERROR: There was an error responding to request:\n%s\n\n", err)
-        }
+    serialized, err := response.Serialize()
+    if err != nil {
+        self.reportError(err)
+        return nil
     }
+
+    self.tap(request, dnstap.AUTH_RESPONSE, serialized)
+    self.Metrics.response(&response)
+    logging.Debug(self.logger(), request.Context(header.ID), "error response", logging.F(logging.FIELD_RCODE, rcode))
+
+    return serialized
 }
 
+//
+// Print errors reported by the listeners until the server is closed
+//
 func (self *Server) WatchErrors() {
+    self.state.init()
+
     for {
-        fmt.Println(<- self.Error)
+        select {
+            case err := <-self.Error:
+                logging.Error(self.logger(), context.Background(), "query failed", logging.F(logging.FIELD_ERROR, err))
+            case <-self.state.stopped:
+                return
+        }
+    }
+}
+
+
+//
+// Record a query (response nil) or response in the dnstap log, if there is one
+//
+func (self *Server) tap(request *Request, kind dnstap.MessageType, response []byte) {
+    if self.Tap == nil { return }
+
+    var message = &dnstap.Message{
+        Type:               kind,
+        Protocol:           dnstap.PROTOCOL_UDP,
+        QueryAddress:       request.Client,
+        ResponseAddress:    self.Address,
+        QueryTime:          request.Received,
+        QueryMessage:       request.Query,
+    }
+
+    if request.Protocol == PROTO_TCP {
+        message.Protocol = dnstap.PROTOCOL_TCP
+        if self.Listener != nil { message.ResponseAddress = self.Listener.Addr() }
+    }
+    if request.local != nil { message.ResponseAddress = request.local }
+
+    if response != nil {
+        message.ResponseTime = time.Now()
+        message.ResponseMessage = response
+    }
+
+    if err := self.Tap.WriteMessage(message) ; err != nil {
+        self.reportError(err)
+    }
+}
+
+//
+// The logger to write to -- the server's own, or the default
+//
+func (self *Server) logger() logging.Logger {
+    return logging.Or(self.Logger)
+}
+
+//
+// The name and type of the (first) question, for logging
+//
+func questionFields(questions []dns.Question) []logging.Field {
+    if len(questions) == 0 { return nil }
+
+    var qType = record.TypeIntToString[questions[0].Type]
+    if qType == "" { qType = strconv.Itoa(int(questions[0].Type)) }
+
+    return []logging.Field{
+        logging.F(logging.FIELD_NAME, questions[0].Name),
+        logging.F(logging.FIELD_TYPE, qType),
     }
 }
 
+//
+// The largest datagram the server accepts and sends, never less than 512 bytes
+//
+func (self *Server) udpSize() int {
+    if int(self.MaxUDPSize) < dns.UDP_PAYLOAD_SIZE {
+        return dns.UDP_PAYLOAD_SIZE
+    }
+
+    return int(self.MaxUDPSize)
+}
+
+//
+// Build the OPT record sent back to an EDNS client
+//
+func (self *Server) responseOPT(query *record.OPTRecord) *record.OPTRecord {
+    var opt, _ = record.OPT(uint16(self.udpSize()))
+
+    // the DO bit is copied from the query (RFC 3225 3)
+    opt.DNSSECOK = query.DNSSECOK
+    return opt
+}
+
+//
+// Count the OPT records in a message's additional section
+//
+func countOPT(message *dns.Message) int {
+    var count int
+    for _, rec := range message.Extra {
+        if rec.GetType() == record.OPT_RECORD { count += 1 }
+    }
+
+    return count
+}
 
 func generateAnswerMessage(message *dns.Message, answers []record.Record) dns.Message {
     var header = dns.MessageHeader {
         ID: message.Header.ID,
         Response: true,
         Opcode: message.Header.Opcode,
-        Authoritative: true,            // TODO: set this truthfully
-        Truncated: false,               // TODO: set this truthfully
+        Authoritative: true,            // cleared by Handle for names outside our zones
+        Truncated: false,               // set by SerializeWithin if the datagram overflows
         RecursionDesired: message.Header.RecursionDesired,
         RecursionAvailable: false,      // we will NEVER go to other DNS servers. RAFT baby....
         Rcode: message.Header.Rcode,    // no error
@@ -0,0 +1,261 @@
+package server
+
+import (
+    "net"
+    "sync"
+    "time"
+    "errors"
+    "context"
+    "net/http"
+)
+
+var ErrServerClosed     error   = errors.New("ERROR: The server is shutting down")
+var ErrHTTPListening    error   = errors.New("ERROR: The server is already serving that over HTTP")
+
+//----------------------------------------------
+// Server Lifecycle
+//----------------------------------------------
+
+//
+// Bookkeeping for stopping a server -- the zero value is ready to use,
+// so servers built by hand (rather than by Start) can still be shut down
+//
+type lifecycle struct {
+    setup           sync.Once
+    lock            sync.Mutex
+    closing         chan struct{}               // closed once the server stops taking queries
+    stopped         chan struct{}               // closed once the sockets are closed and WatchErrors returns
+    closed          sync.Once
+    closeErr        error
+    active          sync.WaitGroup              // listeners, in-flight queries, and open TCP connections
+    conns           map[*net.TCPConn]bool
+    udp             []*net.UDPConn              // sockets added with ListenOn
+    tcp             []*net.TCPListener
+    http            map[string]*http.Server     // HTTP listeners by purpose -- see listenHTTP
+}
+
+func (self *lifecycle) init() {
+    self.setup.Do(func() {
+        self.closing = make(chan struct{})
+        self.stopped = make(chan struct{})
+        self.conns = make(map[*net.TCPConn]bool, 0)
+        self.http = make(map[string]*http.Server, 0)
+    })
+}
+
+//
+// Stop accepting queries, wait for the ones in flight to be answered, then close the sockets
+// If the context ends first the server is closed anyway and the context's error is returned
+//
+func (self *Server) Shutdown(ctx context.Context) error {
+    self.stopIntake()
+
+    var drained = make(chan struct{})
+    go func() {
+        self.state.active.Wait()
+        close(drained)
+    }()
+
+    var err error
+    select {
+        case <-drained:
+        case <-ctx.Done():
+            err = ctx.Err()
+    }
+
+    if closeErr := self.Close() ; err == nil {
+        err = closeErr
+    }
+    return err
+}
+
+//
+// Stop immediately -- close the sockets and any open TCP connections without waiting
+// on queries in flight, and stop the WatchErrors goroutine. Safe to call more than once
+//
+func (self *Server) Close() error {
+    self.stopIntake()
+
+    self.state.closed.Do(func() {
+        self.state.lock.Lock()
+        defer self.state.lock.Unlock()
+
+        if self.Connection != nil {
+            self.state.closeErr = self.Connection.Close()
+        }
+        if self.Listener != nil {
+            self.Listener.Close()
+        }
+        for _, socket := range self.state.udp {
+            socket.Close()
+        }
+        for _, listener := range self.state.tcp {
+            listener.Close()
+        }
+        for conn := range self.state.conns {
+            conn.Close()
+        }
+        for _, httpServer := range self.state.http {
+            httpServer.Close()
+        }
+
+        close(self.state.stopped)
+    })
+
+    return self.state.closeErr
+}
+
+//
+// Stop taking new queries: no more packets are read and no more connections accepted,
+// and idle TCP connections are woken up so they can hang up
+//
+func (self *Server) stopIntake() {
+    self.state.init()
+
+    self.state.lock.Lock()
+    defer self.state.lock.Unlock()
+
+    if self.closing() { return }
+    close(self.state.closing)
+
+    // reads return immediately from here on -- the socket stays open for responses in flight
+    if self.Connection != nil {
+        self.Connection.SetReadDeadline(time.Now())
+    }
+    if self.Listener != nil {
+        self.Listener.Close()
+    }
+    for _, socket := range self.state.udp {
+        socket.SetReadDeadline(time.Now())
+    }
+    for _, listener := range self.state.tcp {
+        listener.Close()
+    }
+    for conn := range self.state.conns {
+        conn.SetReadDeadline(time.Now())
+    }
+}
+
+//
+// Check if the server has begun shutting down
+//
+func (self *Server) closing() bool {
+    self.state.init()
+
+    select {
+        case <-self.state.closing:
+            return true
+        default:
+            return false
+    }
+}
+
+//
+// Register work that Shutdown must wait on, false once the server is shutting down
+//
+func (self *Server) begin() bool {
+    self.state.init()
+
+    self.state.lock.Lock()
+    defer self.state.lock.Unlock()
+
+    if self.closing() { return false }
+
+    self.state.active.Add(1)
+    return true
+}
+
+func (self *Server) end() {
+    self.state.active.Done()
+}
+
+//
+// Register an open TCP connection, false once the server is shutting down
+//
+func (self *Server) track(conn *net.TCPConn) bool {
+    if !self.begin() { return false }
+
+    self.state.lock.Lock()
+    defer self.state.lock.Unlock()
+
+    self.state.conns[conn] = true
+    return true
+}
+
+func (self *Server) untrack(conn *net.TCPConn) {
+    self.state.lock.Lock()
+    delete(self.state.conns, conn)
+    self.state.lock.Unlock()
+
+    self.end()
+}
+
+//
+// Register a socket (either may be nil) so Close stops it -- none once shutting down
+//
+func (self *Server) trackSocket(socket *net.UDPConn, listener *net.TCPListener) error {
+    self.state.init()
+
+    self.state.lock.Lock()
+    defer self.state.lock.Unlock()
+
+    if self.closing() { return ErrServerClosed }
+
+    if socket != nil { self.state.udp = append(self.state.udp, socket) }
+    if listener != nil { self.state.tcp = append(self.state.tcp, listener) }
+    return nil
+}
+
+//
+// Register an HTTP listener so Close stops it -- one per purpose, and none once shutting down
+//
+func (self *Server) trackHTTP(purpose string, httpServer *http.Server) error {
+    self.state.init()
+
+    self.state.lock.Lock()
+    defer self.state.lock.Unlock()
+
+    if self.closing() { return ErrServerClosed }
+    if _, exists := self.state.http[purpose] ; exists { return ErrHTTPListening }
+
+    self.state.http[purpose] = httpServer
+    return nil
+}
+
+//
+// Push back a TCP connection's idle deadline, unless the server is shutting down
+// Done under the lock so it cannot undo the deadline stopIntake sets
+//
+func (self *Server) extendDeadline(conn *net.TCPConn) bool {
+    self.state.lock.Lock()
+    defer self.state.lock.Unlock()
+
+    if self.closing() { return false }
+
+    conn.SetReadDeadline(time.Now().Add(self.IdleTimeout))
+    return true
+}
+
+//
+// Report an error without blocking once the server has stopped
+//
+func (self *Server) reportError(err error) {
+    self.state.init()
+
+    select {
+        case self.Error <- err:
+        case <-self.state.stopped:
+    }
+}
+
+//
+// Report a fatal error without blocking once the server has stopped
+//
+func (self *Server) reportFatal(err error) {
+    self.state.init()
+
+    select {
+        case self.Fatal <- err:
+        case <-self.state.stopped:
+    }
+}
@@ -0,0 +1,194 @@
+package logging
+
+import (
+    "sync"
+    "errors"
+    "strings"
+    "context"
+)
+
+type Level int
+
+const (
+    DEBUG           Level       = iota
+    INFO
+    WARN
+    ERROR
+)
+
+// field keys shared by every part of the server, so logs can be filtered consistently
+const (
+    FIELD_ID        string      = "id"
+    FIELD_NAME      string      = "name"
+    FIELD_TYPE      string      = "type"
+    FIELD_RCODE     string      = "rcode"
+    FIELD_CLIENT    string      = "client"
+    FIELD_PROTOCOL  string      = "protocol"
+    FIELD_LATENCY   string      = "latency"
+    FIELD_ADDRESS   string      = "address"
+    FIELD_ERROR     string      = "error"
+)
+
+var ErrUnknownLevel error = errors.New("ERROR: Unknown log level -- expected debug, info, warn, or error")
+
+//----------------------------------------------
+// Logger Interface
+//----------------------------------------------
+
+//
+// A key/value pair attached to a log message
+//
+type Field struct {
+    Key             string
+    Value           interface{}
+}
+
+//
+// Shorthand for building a Field
+//
+func F(key string, value interface{}) Field {
+    return Field{ key, value }
+}
+
+//
+// The logger the server and stores write to
+// Implementations decide where messages go and which levels are kept,
+// and must be safe for concurrent use
+//
+type Logger interface {
+    // check if messages at the level would be kept -- lets callers skip building expensive fields
+    Enabled(ctx context.Context, level Level) bool
+
+    // write a message with any fields attached to the context followed by the given ones
+    Log(ctx context.Context, level Level, message string, fields ...Field)
+}
+
+//----------------------------------------------
+// Defaults
+//----------------------------------------------
+
+var defaultLock sync.RWMutex
+var defaultLogger Logger = Nop()
+
+//
+// Return the logger used by anything not given one of its own -- Nop unless SetDefault is called
+//
+func Default() Logger {
+    defaultLock.RLock()
+    defer defaultLock.RUnlock()
+
+    return defaultLogger
+}
+
+//
+// Replace the default logger (nil restores Nop)
+//
+func SetDefault(logger Logger) {
+    if logger == nil { logger = Nop() }
+
+    defaultLock.Lock()
+    defaultLogger = logger
+    defaultLock.Unlock()
+}
+
+//
+// Return the logger, or the default if it is nil
+//
+func Or(logger Logger) Logger {
+    if logger == nil { return Default() }
+    return logger
+}
+
+//----------------------------------------------
+// No-op Logger
+//----------------------------------------------
+
+type nopLogger struct {}
+
+//
+// A logger that discards everything -- the default for embedders
+//
+func Nop() Logger {
+    return nopLogger{}
+}
+
+func (nopLogger) Enabled(ctx context.Context, level Level) bool {
+    return false
+}
+
+func (nopLogger) Log(ctx context.Context, level Level, message string, fields ...Field) {}
+
+//----------------------------------------------
+// Context Fields
+//----------------------------------------------
+
+type contextKey struct {}
+
+//
+// Attach fields to a context so every message logged with it carries them
+// (the query ID and client of a request, for example)
+//
+func WithFields(ctx context.Context, fields ...Field) context.Context {
+    var existing = FieldsFrom(ctx)
+
+    var combined = make([]Field, 0, len(existing) + len(fields))
+    combined = append(combined, existing...)
+    combined = append(combined, fields...)
+
+    return context.WithValue(ctx, contextKey{}, combined)
+}
+
+//
+// Return the fields attached to a context
+//
+func FieldsFrom(ctx context.Context) []Field {
+    if ctx == nil { return nil }
+
+    var fields, _ = ctx.Value(contextKey{}).([]Field)
+    return fields
+}
+
+//----------------------------------------------
+// Convenience
+//----------------------------------------------
+
+func Debug(logger Logger, ctx context.Context, message string, fields ...Field) {
+    logger.Log(ctx, DEBUG, message, fields...)
+}
+
+func Info(logger Logger, ctx context.Context, message string, fields ...Field) {
+    logger.Log(ctx, INFO, message, fields...)
+}
+
+func Warn(logger Logger, ctx context.Context, message string, fields ...Field) {
+    logger.Log(ctx, WARN, message, fields...)
+}
+
+func Error(logger Logger, ctx context.Context, message string, fields ...Field) {
+    logger.Log(ctx, ERROR, message, fields...)
+}
+
+func (self Level) String() string {
+    switch self {
+        case DEBUG: return "DEBUG"
+        case INFO:  return "INFO"
+        case WARN:  return "WARN"
+        case ERROR: return "ERROR"
+    }
+
+    return "UNKNOWN"
+}
+
+//
+// Read a level from its name, in any case ("debug", "INFO", "Warn", "error")
+//
+func ParseLevel(name string) (Level, error) {
+    switch strings.ToUpper(strings.TrimSpace(name)) {
+        case "DEBUG":               return DEBUG, nil
+        case "INFO":                return INFO, nil
+        case "WARN", "WARNING":     return WARN, nil
+        case "ERROR":               return ERROR, nil
+    }
+
+    return INFO, ErrUnknownLevel
+}
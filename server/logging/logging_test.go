@@ -0,0 +1,85 @@
+package logging
+
+import (
+    "bytes"
+    "strings"
+    "context"
+    "testing"
+    "log/slog"
+)
+
+//----------------------------------------------
+// Default Tests
+//----------------------------------------------
+
+func TestDefault_Nop(t *testing.T) {
+    if Default().Enabled(context.Background(), ERROR) {
+        t.Errorf("The default logger should discard everything\n")
+    }
+
+    var buffer bytes.Buffer
+    var logger = Slog(slog.New(slog.NewTextHandler(&buffer, nil)))
+
+    SetDefault(logger)
+    defer SetDefault(nil)
+
+    if Or(nil) != logger {
+        t.Errorf("Or(nil) did not return the default\n")
+    }
+}
+
+//----------------------------------------------
+// slog Adapter Tests
+//----------------------------------------------
+
+func TestSlog_FieldsAndLevels(t *testing.T) {
+    var buffer bytes.Buffer
+    var logger = Slog(slog.New(slog.NewTextHandler(&buffer, &slog.HandlerOptions{ Level: slog.LevelInfo })))
+
+    var ctx = WithFields(context.Background(), F(FIELD_ID, 1234))
+    ctx = WithFields(ctx, F(FIELD_CLIENT, "127.0.0.1:5353"))
+
+    Debug(logger, ctx, "dropped")
+    Info(logger, ctx, "query answered", F(FIELD_NAME, "zed.io"), F(FIELD_RCODE, 0))
+
+    var output = buffer.String()
+    if strings.Contains(output, "dropped") {
+        t.Errorf("Debug message written at info level:\n%s\n", output)
+    }
+
+    for _, expected := range []string{ "level=INFO", "msg=\"query answered\"", "id=1234", "client=127.0.0.1:5353", "name=zed.io", "rcode=0" } {
+        if !strings.Contains(output, expected) {
+            t.Errorf("Missing From Output:\n\tExpected: %s\n\tGot: %s\n", expected, output)
+        }
+    }
+
+    if logger.Enabled(ctx, DEBUG) || !logger.Enabled(ctx, ERROR) {
+        t.Errorf("Incorrect Enabled Levels\n")
+    }
+}
+
+func TestParseLevel(t *testing.T) {
+    var cases = map[string]Level{
+        "debug":    DEBUG,
+        "INFO":     INFO,
+        "Warning":  WARN,
+        " error ":  ERROR,
+    }
+
+    for name, expected := range cases {
+        if level, err := ParseLevel(name) ; err != nil || level != expected {
+            t.Errorf("Incorrect Level:\n\tCase: %q\n\tExpected: %v\n\tGot: %v %v\n", name, expected, level, err)
+        }
+    }
+
+    if _, err := ParseLevel("loud") ; err != ErrUnknownLevel {
+        t.Errorf("Incorrect Error:\n\tExpected: %v\n\tGot: %v\n", ErrUnknownLevel, err)
+    }
+
+    // a level filters slog handlers directly
+    var buffer bytes.Buffer
+    var logger = Slog(slog.New(slog.NewTextHandler(&buffer, &slog.HandlerOptions{ Level: WARN })))
+    if logger.Enabled(context.Background(), INFO) || !logger.Enabled(context.Background(), WARN) {
+        t.Errorf("Incorrect Enabled Levels\n")
+    }
+}
@@ -0,0 +1,66 @@
+package logging
+
+import (
+    "context"
+    "log/slog"
+)
+
+//----------------------------------------------
+// log/slog Adapter
+//----------------------------------------------
+
+type slogLogger struct {
+    logger          *slog.Logger
+}
+
+//
+// Write to a log/slog logger -- levels map onto slog's and fields become attributes
+// A nil logger uses slog.Default()
+//
+func Slog(logger *slog.Logger) Logger {
+    if logger == nil { logger = slog.Default() }
+    return &slogLogger{ logger }
+}
+
+func (self *slogLogger) Enabled(ctx context.Context, level Level) bool {
+    return self.logger.Enabled(contextOrBackground(ctx), slogLevel(level))
+}
+
+func (self *slogLogger) Log(ctx context.Context, level Level, message string, fields ...Field) {
+    ctx = contextOrBackground(ctx)
+    if !self.logger.Enabled(ctx, slogLevel(level)) { return }
+
+    var contextual = FieldsFrom(ctx)
+    var attrs = make([]slog.Attr, 0, len(contextual) + len(fields))
+
+    for _, field := range contextual {
+        attrs = append(attrs, slog.Any(field.Key, field.Value))
+    }
+    for _, field := range fields {
+        attrs = append(attrs, slog.Any(field.Key, field.Value))
+    }
+
+    self.logger.LogAttrs(ctx, slogLevel(level), message, attrs...)
+}
+
+//
+// The matching slog level -- a Level can filter a slog handler (slog.HandlerOptions.Level)
+//
+func (self Level) Level() slog.Level {
+    return slogLevel(self)
+}
+
+func slogLevel(level Level) slog.Level {
+    switch level {
+        case DEBUG: return slog.LevelDebug
+        case INFO:  return slog.LevelInfo
+        case WARN:  return slog.LevelWarn
+    }
+
+    return slog.LevelError
+}
+
+func contextOrBackground(ctx context.Context) context.Context {
+    if ctx == nil { return context.Background() }
+    return ctx
+}
@@ -0,0 +1,151 @@
+package server
+
+import (
+    "net"
+    "time"
+    "errors"
+    "strconv"
+    "net/http"
+
+    "github.com/zmarcantel/phonebook/dns"
+    "github.com/zmarcantel/phonebook/dns/record"
+
+    "github.com/zmarcantel/phonebook/server/metrics"
+)
+
+// the path metrics are served from by ListenMetrics
+const METRICS_PATH string = "/metrics"
+
+var ErrNoMetrics        error   = errors.New("ERROR: The server is not collecting metrics")
+
+// names for the rcodes we send
+var rcodeNames = map[int]string{
+    0:                  "NOERROR",
+    ERR_FORMAT:         "FORMERR",
+    ERR_INTERNAL:       "SERVFAIL",
+    ERR_NOEXIST:        "NXDOMAIN",
+    ERR_NOIMPL:         "NOTIMP",
+    ERR_REFUSED:        "REFUSED",
+    ERR_YXDOMAIN:       "YXDOMAIN",
+    ERR_YXRRSET:        "YXRRSET",
+    ERR_NXRRSET:        "NXRRSET",
+    ERR_NOTAUTH:        "NOTAUTH",
+    ERR_NOTZONE:        "NOTZONE",
+    dns.ERR_BADVERS:    "BADVERS",
+}
+
+//----------------------------------------------
+// Server Metrics
+//----------------------------------------------
+
+//
+// The counters kept by a single server
+// A nil *Metrics records nothing, so servers built by hand need not collect any
+//
+type Metrics struct {
+    Registry        *metrics.Registry
+    Queries         *metrics.CounterVec         // by qtype
+    Updates         *metrics.Counter            // dynamic UPDATE requests
+    Responses       *metrics.CounterVec         // by rcode
+    Truncated       *metrics.Counter            // datagrams sent with TC set
+    ParseErrors     *metrics.Counter            // queries that could not be unpacked, answered FORMERR
+    Latency         *metrics.Histogram          // from reading the query to writing the response
+    InFlight        *metrics.Gauge              // queries currently being answered
+}
+
+//
+// Create the metrics for a server -- store and zone sizes are read from it at scrape time
+//
+func NewMetrics(server *Server) *Metrics {
+    var registry = metrics.NewRegistry()
+
+    var result = &Metrics{
+        Registry:       registry,
+        Queries:        registry.CounterVec("phonebook_queries_total", "Queries received, by question type.", "qtype"),
+        Updates:        registry.Counter("phonebook_updates_total", "Dynamic UPDATE requests received."),
+        Responses:      registry.CounterVec("phonebook_responses_total", "Responses sent, by response code.", "rcode"),
+        Truncated:      registry.Counter("phonebook_truncated_responses_total", "Responses cut down to fit a datagram."),
+        ParseErrors:    registry.Counter("phonebook_parse_errors_total", "Queries that could not be parsed, answered FORMERR (or dropped if even the header was unreadable)."),
+        Latency:        registry.Histogram("phonebook_query_duration_seconds", "Time from reading a query to writing its response.", nil),
+        InFlight:       registry.Gauge("phonebook_queries_in_flight", "Queries currently being answered."),
+    }
+
+    registry.GaugeFunc("phonebook_store_records", "Records in the backing store.", func() float64 {
+        if server.Store == nil { return 0 }
+        return float64(server.Store.Size())
+    })
+    registry.GaugeFunc("phonebook_zones", "Zones the server is authoritative for.", func() float64 {
+        if server.Zones == nil { return 0 }
+        return float64(server.Zones.Size())
+    })
+    registry.GaugeFunc("phonebook_dnstap_dropped", "Messages dropped from the dnstap log, for a full queue or a failed write.", func() float64 {
+        if server.Tap == nil { return 0 }
+        return float64(server.Tap.Dropped())
+    })
+
+    return result
+}
+
+func (self *Metrics) query(questions []dns.Question) {
+    if self == nil { return }
+
+    var qType = "none"
+    if len(questions) > 0 {
+        qType = record.TypeIntToString[questions[0].Type]
+        if qType == "" { qType = strconv.Itoa(int(questions[0].Type)) }
+    }
+
+    self.Queries.With(qType).Inc()
+}
+
+func (self *Metrics) update() {
+    if self == nil { return }
+    self.Updates.Inc()
+}
+
+func (self *Metrics) response(response *dns.Message) {
+    if self == nil { return }
+
+    var rcode = response.ExtendedRcode()
+    var name, known = rcodeNames[rcode]
+    if !known { name = strconv.Itoa(rcode) }
+
+    self.Responses.With(name).Inc()
+    if response.Header.Truncated { self.Truncated.Inc() }
+}
+
+func (self *Metrics) parseError() {
+    if self == nil { return }
+    self.ParseErrors.Inc()
+}
+
+//
+// Mark a query as in flight
+//
+func (self *Metrics) begin() {
+    if self == nil { return }
+    self.InFlight.Inc()
+}
+
+//
+// Mark a query as done, timing it if a response was written
+//
+func (self *Metrics) end(received time.Time, answered bool) {
+    if self == nil { return }
+
+    if answered { self.Latency.Observe(time.Since(received).Seconds()) }
+    self.InFlight.Dec()
+}
+
+//
+// Serve the server's metrics in the Prometheus text format at METRICS_PATH
+// Returns the address listened on -- Close and Shutdown stop the listener
+//
+func (self *Server) ListenMetrics(address string) (net.Addr, error) {
+    if self.Metrics == nil { return nil, ErrNoMetrics }
+
+    var mux = http.NewServeMux()
+    mux.Handle(METRICS_PATH, self.Metrics.Registry)
+
+    return self.listenHTTP("metrics", address, mux)
+}
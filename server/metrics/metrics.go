@@ -0,0 +1,314 @@
+package metrics
+
+import (
+    "io"
+    "fmt"
+    "math"
+    "sort"
+    "sync"
+    "bufio"
+    "errors"
+    "strconv"
+    "strings"
+    "net/http"
+    "sync/atomic"
+)
+
+// the Prometheus text exposition format served by Registry
+const CONTENT_TYPE string = "text/plain; version=0.0.4; charset=utf-8"
+
+// latency buckets (seconds) suited to answering from memory
+var DefaultBuckets = []float64{ 0.0001, 0.00025, 0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1 }
+
+var ErrDuplicate error = errors.New("ERROR: A metric with that name is already registered")
+
+//----------------------------------------------
+// Counter
+//----------------------------------------------
+
+//
+// A value that only goes up
+//
+type Counter struct {
+    value           uint64
+}
+
+func (self *Counter) Inc() {
+    atomic.AddUint64(&self.value, 1)
+}
+
+func (self *Counter) Add(delta uint64) {
+    atomic.AddUint64(&self.value, delta)
+}
+
+func (self *Counter) Value() uint64 {
+    return atomic.LoadUint64(&self.value)
+}
+
+func (self *Counter) write(w io.Writer, name string) {
+    fmt.Fprintf(w, "%s %d\n", name, self.Value())
+}
+
+//----------------------------------------------
+// Counter Vector
+//----------------------------------------------
+
+//
+// A family of counters told apart by the value of a single label
+//
+type CounterVec struct {
+    Label           string
+
+    lock            sync.RWMutex
+    counters        map[string]*Counter
+}
+
+//
+// Return the counter for a label value, creating it on first use
+//
+func (self *CounterVec) With(value string) *Counter {
+    self.lock.RLock()
+    var counter, exists = self.counters[value]
+    self.lock.RUnlock()
+    if exists { return counter }
+
+    self.lock.Lock()
+    defer self.lock.Unlock()
+
+    if counter, exists = self.counters[value] ; !exists {
+        counter = &Counter{}
+        self.counters[value] = counter
+    }
+    return counter
+}
+
+func (self *CounterVec) write(w io.Writer, name string) {
+    self.lock.RLock()
+    defer self.lock.RUnlock()
+
+    // sorted so scrapes are stable
+    var values = make([]string, 0, len(self.counters))
+    for value := range self.counters {
+        values = append(values, value)
+    }
+    sort.Strings(values)
+
+    for _, value := range values {
+        fmt.Fprintf(w, "%s{%s=\"%s\"} %d\n", name, self.Label, escapeLabel(value), self.counters[value].Value())
+    }
+}
+
+//----------------------------------------------
+// Gauge
+//----------------------------------------------
+
+//
+// A value that goes up and down
+//
+type Gauge struct {
+    bits            uint64
+}
+
+func (self *Gauge) Set(value float64) {
+    atomic.StoreUint64(&self.bits, math.Float64bits(value))
+}
+
+func (self *Gauge) Add(delta float64) {
+    for {
+        var old = atomic.LoadUint64(&self.bits)
+        var updated = math.Float64bits(math.Float64frombits(old) + delta)
+        if atomic.CompareAndSwapUint64(&self.bits, old, updated) { return }
+    }
+}
+
+func (self *Gauge) Inc() { self.Add(1) }
+func (self *Gauge) Dec() { self.Add(-1) }
+
+func (self *Gauge) Value() float64 {
+    return math.Float64frombits(atomic.LoadUint64(&self.bits))
+}
+
+func (self *Gauge) write(w io.Writer, name string) {
+    fmt.Fprintf(w, "%s %s\n", name, formatFloat(self.Value()))
+}
+
+//
+// A gauge read from a function at scrape time (the size of a store, for example)
+//
+type GaugeFunc func() float64
+
+func (self GaugeFunc) write(w io.Writer, name string) {
+    fmt.Fprintf(w, "%s %s\n", name, formatFloat(self()))
+}
+
+//----------------------------------------------
+// Histogram
+//----------------------------------------------
+
+//
+// Counts observations into cumulative buckets, tracking their sum and count
+//
+type Histogram struct {
+    lock            sync.Mutex
+    buckets         []float64           // upper bounds, ascending
+    counts          []uint64            // per bucket (not cumulative)
+    sum             float64
+    count           uint64
+}
+
+func (self *Histogram) Observe(value float64) {
+    self.lock.Lock()
+    defer self.lock.Unlock()
+
+    var bucket = sort.SearchFloat64s(self.buckets, value)
+    if bucket < len(self.counts) { self.counts[bucket] += 1 }
+
+    self.sum += value
+    self.count += 1
+}
+
+//
+// The number of observations so far
+//
+func (self *Histogram) Count() uint64 {
+    self.lock.Lock()
+    defer self.lock.Unlock()
+
+    return self.count
+}
+
+func (self *Histogram) write(w io.Writer, name string) {
+    self.lock.Lock()
+    defer self.lock.Unlock()
+
+    var cumulative uint64
+    for i, bound := range self.buckets {
+        cumulative += self.counts[i]
+        fmt.Fprintf(w, "%s_bucket{le=\"%s\"} %d\n", name, formatFloat(bound), cumulative)
+    }
+    fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", name, self.count)
+    fmt.Fprintf(w, "%s_sum %s\n", name, formatFloat(self.sum))
+    fmt.Fprintf(w, "%s_count %d\n", name, self.count)
+}
+
+//----------------------------------------------
+// Registry
+//----------------------------------------------
+
+type collector interface {
+    write(w io.Writer, name string)
+}
+
+type entry struct {
+    name            string
+    help            string
+    kind            string
+    metric          collector
+}
+
+//
+// A set of named metrics, written out in the Prometheus text format
+// Serves them over HTTP as an http.Handler
+//
+type Registry struct {
+    lock            sync.RWMutex
+    entries         []entry
+}
+
+func NewRegistry() *Registry {
+    return &Registry{}
+}
+
+func (self *Registry) Counter(name, help string) *Counter {
+    var counter = &Counter{}
+    self.register(name, help, "counter", counter)
+    return counter
+}
+
+func (self *Registry) CounterVec(name, help, label string) *CounterVec {
+    var vector = &CounterVec{ Label: label, counters: make(map[string]*Counter, 0) }
+    self.register(name, help, "counter", vector)
+    return vector
+}
+
+func (self *Registry) Gauge(name, help string) *Gauge {
+    var gauge = &Gauge{}
+    self.register(name, help, "gauge", gauge)
+    return gauge
+}
+
+func (self *Registry) GaugeFunc(name, help string, fn func() float64) {
+    self.register(name, help, "gauge", GaugeFunc(fn))
+}
+
+//
+// Register a histogram with the given bucket upper bounds (nil uses DefaultBuckets)
+//
+func (self *Registry) Histogram(name, help string, buckets []float64) *Histogram {
+    if buckets == nil { buckets = DefaultBuckets }
+
+    var sorted = append([]float64(nil), buckets...)
+    sort.Float64s(sorted)
+
+    var histogram = &Histogram{ buckets: sorted, counts: make([]uint64, len(sorted)) }
+    self.register(name, help, "histogram", histogram)
+    return histogram
+}
+
+//
+// Names are fixed by the code registering them, so a duplicate is a programming error
+//
+func (self *Registry) register(name, help, kind string, metric collector) {
+    self.lock.Lock()
+    defer self.lock.Unlock()
+
+    for _, existing := range self.entries {
+        if existing.name == name { panic(ErrDuplicate.Error() + ": " + name) }
+    }
+
+    self.entries = append(self.entries, entry{ name, help, kind, metric })
+}
+
+//
+// Write every metric in the Prometheus text exposition format
+//
+func (self *Registry) WriteText(w io.Writer) error {
+    var buffered = bufio.NewWriter(w)
+
+    self.lock.RLock()
+    for _, entry := range self.entries {
+        fmt.Fprintf(buffered, "# HELP %s %s\n", entry.name, escapeHelp(entry.help))
+        fmt.Fprintf(buffered, "# TYPE %s %s\n", entry.name, entry.kind)
+        entry.metric.write(buffered, entry.name)
+    }
+    self.lock.RUnlock()
+
+    return buffered.Flush()
+}
+
+func (self *Registry) ServeHTTP(response http.ResponseWriter, request *http.Request) {
+    response.Header().Set("Content-Type", CONTENT_TYPE)
+    self.WriteText(response)
+}
+
+//----------------------------------------------
+// Formatting
+//----------------------------------------------
+
+func formatFloat(value float64) string {
+    switch {
+        case math.IsInf(value, 1):  return "+Inf"
+        case math.IsInf(value, -1): return "-Inf"
+        case math.IsNaN(value):     return "NaN"
+    }
+
+    return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+func escapeLabel(value string) string {
+    return strings.NewReplacer("\\", "\\\\", "\"", "\\\"", "\n", "\\n").Replace(value)
+}
+
+func escapeHelp(value string) string {
+    return strings.NewReplacer("\\", "\\\\", "\n", "\\n").Replace(value)
+}
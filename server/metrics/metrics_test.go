@@ -0,0 +1,156 @@
+package metrics
+
+import (
+    "sync"
+    "bytes"
+    "strings"
+    "testing"
+    "net/http/httptest"
+)
+
+//----------------------------------------------
+// Helpers
+//----------------------------------------------
+
+func testText(t *testing.T, registry *Registry) string {
+    var buffer bytes.Buffer
+    if err := registry.WriteText(&buffer) ; err != nil { t.Fatal(err) }
+    return buffer.String()
+}
+
+func testContains(t *testing.T, text string, lines ...string) {
+    for _, line := range lines {
+        if !strings.Contains(text, line + "\n") {
+            t.Errorf("Missing Line:\n\tExpected: %s\n\tGot:\n%s\n", line, text)
+        }
+    }
+}
+
+//----------------------------------------------
+// Metrics
+//----------------------------------------------
+
+func TestRegistry_Counter(t *testing.T) {
+    var registry = NewRegistry()
+    var counter = registry.Counter("test_total", "A test counter.")
+
+    counter.Inc()
+    counter.Add(4)
+
+    testContains(t, testText(t, registry),
+        "# HELP test_total A test counter.",
+        "# TYPE test_total counter",
+        "test_total 5",
+    )
+}
+
+func TestRegistry_CounterVec(t *testing.T) {
+    var registry = NewRegistry()
+    var vector = registry.CounterVec("queries_total", "Queries.", "qtype")
+
+    vector.With("A").Inc()
+    vector.With("A").Inc()
+    vector.With("MX").Inc()
+    vector.With("say \"hi\"\\").Inc()
+
+    var text = testText(t, registry)
+    testContains(t, text,
+        `queries_total{qtype="A"} 2`,
+        `queries_total{qtype="MX"} 1`,
+        `queries_total{qtype="say \"hi\"\\"} 1`,
+    )
+
+    // sorted by label value
+    if strings.Index(text, `qtype="A"`) > strings.Index(text, `qtype="MX"`) {
+        t.Errorf("Label values are not sorted:\n%s\n", text)
+    }
+}
+
+func TestRegistry_Gauge(t *testing.T) {
+    var registry = NewRegistry()
+    var gauge = registry.Gauge("in_flight", "In flight.")
+    registry.GaugeFunc("size", "Size.", func() float64 { return 42 })
+
+    gauge.Inc()
+    gauge.Inc()
+    gauge.Dec()
+    gauge.Add(0.5)
+
+    testContains(t, testText(t, registry),
+        "# TYPE in_flight gauge",
+        "in_flight 1.5",
+        "# TYPE size gauge",
+        "size 42",
+    )
+}
+
+func TestRegistry_Histogram(t *testing.T) {
+    var registry = NewRegistry()
+    var histogram = registry.Histogram("latency_seconds", "Latency.", []float64{ 1, 0.1 })
+
+    histogram.Observe(0.05)
+    histogram.Observe(0.1)
+    histogram.Observe(0.5)
+    histogram.Observe(3)
+
+    // buckets are cumulative and sorted, with +Inf matching the count
+    testContains(t, testText(t, registry),
+        "# TYPE latency_seconds histogram",
+        `latency_seconds_bucket{le="0.1"} 2`,
+        `latency_seconds_bucket{le="1"} 3`,
+        `latency_seconds_bucket{le="+Inf"} 4`,
+        "latency_seconds_sum 3.65",
+        "latency_seconds_count 4",
+    )
+}
+
+func TestRegistry_Duplicate(t *testing.T) {
+    var registry = NewRegistry()
+    registry.Counter("test_total", "A test counter.")
+
+    defer func() {
+        if recover() == nil {
+            t.Errorf("Registering a name twice did not panic\n")
+        }
+    }()
+    registry.Gauge("test_total", "A test gauge.")
+}
+
+func TestRegistry_Concurrent(t *testing.T) {
+    var registry = NewRegistry()
+    var vector = registry.CounterVec("test_total", "A test counter.", "kind")
+    var gauge = registry.Gauge("test_gauge", "A test gauge.")
+    var histogram = registry.Histogram("test_seconds", "A test histogram.", nil)
+
+    var group sync.WaitGroup
+    for i := 0 ; i < 8 ; i++ {
+        group.Add(1)
+        go func() {
+            defer group.Done()
+            for j := 0 ; j < 1000 ; j++ {
+                vector.With("x").Inc()
+                gauge.Inc()
+                histogram.Observe(0.001)
+                if j % 100 == 0 { testText(t, registry) }
+            }
+        }()
+    }
+    group.Wait()
+
+    if vector.With("x").Value() != 8000 || gauge.Value() != 8000 || histogram.Count() != 8000 {
+        t.Errorf("Incorrect Totals:\n\tExpected: %d\n\tGot: %d, %v, %d\n", 8000, vector.With("x").Value(), gauge.Value(), histogram.Count())
+    }
+}
+
+func TestRegistry_ServeHTTP(t *testing.T) {
+    var registry = NewRegistry()
+    registry.Counter("test_total", "A test counter.").Inc()
+
+    var recorder = httptest.NewRecorder()
+    registry.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+
+    if recorder.Header().Get("Content-Type") != CONTENT_TYPE {
+        t.Errorf("Incorrect Content-Type:\n\tExpected: %s\n\tGot: %s\n", CONTENT_TYPE, recorder.Header().Get("Content-Type"))
+    }
+    testContains(t, recorder.Body.String(), "test_total 1")
+}
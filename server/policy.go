@@ -0,0 +1,50 @@
+package server
+
+import (
+    "net"
+
+    "github.com/zmarcantel/phonebook/dns/record"
+    "github.com/zmarcantel/phonebook/server/store"
+)
+
+//----------------------------------------------
+// Access Policies
+//----------------------------------------------
+
+//
+// Decides whether the client behind a request may update (or transfer) a zone
+//
+type Policy func(request *Request, zone *store.Zone) bool
+
+//
+// Allow every zone to clients within the given networks
+//
+func AllowFrom(networks ...*net.IPNet) Policy {
+    return func(request *Request, zone *store.Zone) bool {
+        var ip net.IP
+        switch addr := request.Client.(type) {
+            case *net.UDPAddr: ip = addr.IP
+            case *net.TCPAddr: ip = addr.IP
+        }
+        if ip == nil { return false }
+
+        for _, network := range networks {
+            if network.Contains(ip) { return true }
+        }
+        return false
+    }
+}
+
+//
+// Allow every zone to requests signed with one of the named TSIG keys
+//
+func AllowWithKey(names ...string) Policy {
+    return func(request *Request, zone *store.Zone) bool {
+        if request.Key == nil { return false }
+
+        for _, name := range names {
+            if record.NamesEqual(name, request.Key.Name) { return true }
+        }
+        return false
+    }
+}
@@ -3,16 +3,21 @@ package server
 import (
     "github.com/zmarcantel/phonebook/dns"
     "github.com/zmarcantel/phonebook/dns/record"
+    "github.com/zmarcantel/phonebook/server/store"
 )
 
 const (
     DNS_QUERY_ALL  int       = 255
     DNS_QUERY_A    int       = 1
     DNS_QUERY_AAAA int       = 28
+    DNS_QUERY_IXFR int       = 251
+    DNS_QUERY_AXFR int       = 252
 )
 
 //
 // Given a set of DNS queries, look into the local cache and get answers
+// A name that exists without the requested type adds nothing (NODATA) rather than failing
+// Answers owned by the queried name carry the name exactly as the client wrote it
 //
 func (self *Server) Answer(questions []dns.Question) ([]record.Record, error) {
     var result = make([]record.Record, 0)
@@ -23,23 +28,37 @@ func (self *Server) Answer(questions []dns.Question) ([]record.Record, error) {
             // if we are querying for ANY (255) then just lookup the label
             case DNS_QUERY_ALL:
                 var collection, err = self.Store.FindLabel(question.Name)
+                if err == store.ErrNoData { continue }
                 if err != nil { return nil, err }
-                result = append(result, collection...)
-                break
-
-            case DNS_QUERY_A, DNS_QUERY_AAAA:
-                var collection, err = self.Store.FindRecursively(question.Name, question.Type)
-                if err != nil { return nil, err }
-                result = append(result, collection...)
+                result = append(result, echoName(collection, question.Name)...)
                 break
 
+            // everything else returns the whole set of that type, or the CNAME in its place
             default:
-                var single, err = self.Store.Find(question.Name, question.Type)
+                var collection, err = self.Store.FindRecursively(question.Name, question.Type)
+                if err == store.ErrNoData { continue }
                 if err != nil { return nil, err }
-                result = append(result, single)
+                result = append(result, echoName(collection, question.Name)...)
                 break
         }
     }
 
     return result, nil
 }
+
+//
+// Return the records with any owned by name (compared canonically) renamed to name itself,
+// so responses echo the case the question was asked in -- the stored records are not modified
+//
+func echoName(collection []record.Record, name string) []record.Record {
+    var result = make([]record.Record, len(collection))
+
+    for i, rec := range collection {
+        if rec.GetLabel() != name && record.NamesEqual(rec.GetLabel(), name) {
+            rec = record.Rename(rec, name)
+        }
+        result[i] = rec
+    }
+
+    return result
+}
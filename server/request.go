@@ -0,0 +1,48 @@
+package server
+
+import (
+    "net"
+    "time"
+    "context"
+
+    "github.com/zmarcantel/phonebook/dns"
+    "github.com/zmarcantel/phonebook/dns/record"
+
+    "github.com/zmarcantel/phonebook/server/logging"
+)
+
+// constants representing the transport a query arrived on
+const (
+    PROTO_UDP string     = "udp"
+    PROTO_TCP string     = "tcp"
+)
+
+//
+// A single query as it was received by one of the listeners
+//
+type Request struct {
+    Client          net.Addr
+    Protocol        string
+    Query           []byte
+    Received        time.Time
+    Key             *dns.TSIGKey            // the key the query was signed with (nil if unsigned)
+
+    signature       *record.TSIGRecord      // the query's TSIG record, answered in kind
+    socket          *net.UDPConn            // the socket a datagram arrived on, to answer from (nil for Connection)
+    local           net.Addr                // the address the query arrived at (nil for the server's Address)
+}
+
+//
+// Return a context carrying the request's fields, so every message logged while answering it carries them
+//
+func (self *Request) Context(id uint16) context.Context {
+    var fields = []logging.Field{
+        logging.F(logging.FIELD_ID, id),
+        logging.F(logging.FIELD_PROTOCOL, self.Protocol),
+    }
+    if self.Client != nil {
+        fields = append(fields, logging.F(logging.FIELD_CLIENT, self.Client.String()))
+    }
+
+    return logging.WithFields(context.Background(), fields...)
+}
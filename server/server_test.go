@@ -0,0 +1,1885 @@
+package server
+
+import (
+    "io"
+    "fmt"
+    "strconv"
+    "net"
+    "sync"
+    "time"
+    "bytes"
+    "errors"
+    "context"
+    "strings"
+    "testing"
+    "net/http"
+    "encoding/binary"
+
+    "github.com/zmarcantel/phonebook/dns"
+    "github.com/zmarcantel/phonebook/dns/record"
+    "github.com/zmarcantel/phonebook/server/store"
+    "github.com/zmarcantel/phonebook/server/dnstap"
+    "github.com/zmarcantel/phonebook/server/logging"
+)
+
+//----------------------------------------------
+// Helpers
+//----------------------------------------------
+
+//
+// Start a server on ephemeral localhost ports holding a few known records
+//
+func testServer(t *testing.T) *Server {
+    var server = newTestServer(t)
+    testStart(server)
+    return server
+}
+
+//
+// Build (but do not start) a server on ephemeral localhost ports
+//
+func newTestServer(t *testing.T) *Server {
+    conn, err := net.ListenUDP("udp", &net.UDPAddr{ IP: net.ParseIP("127.0.0.1") })
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    listener, err := net.ListenTCP("tcp", &net.TCPAddr{ IP: net.ParseIP("127.0.0.1") })
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    var server = &Server{
+        Fatal:          make(chan error, 10),
+        Error:          make(chan error),
+        Address:        conn.LocalAddr(),
+        Store:          store.Map(),
+        Connection:     conn,
+        Listener:       listener,
+        IdleTimeout:    TCPIdleTimeout,
+        MaxUDPSize:     MaxUDPSize,
+    }
+
+    var a, _ = record.A("zed.io", 10 * time.Second, net.ParseIP("127.0.0.1"))
+    server.Store.Add(a)
+
+    for port := uint16(8000) ; port < 8040 ; port++ {
+        var srv, _ = record.SRV("_phonebook._tcp.zed.io", "zed.io", 10 * time.Second, 10, 5, port)
+        server.Store.Add(srv)
+    }
+
+    return server
+}
+
+//
+// Start the listeners of a server built by newTestServer
+//
+func testStart(server *Server) {
+    go server.WatchErrors()
+    go server.Listen()
+    go server.ListenTCP()
+}
+
+//
+// Build a serialized query for a single name and type
+//
+func testQuery(t *testing.T, id uint16, name string, qType uint16) []byte {
+    var query = dns.Message{
+        Header:    dns.MessageHeader{ ID: id, RecursionDesired: true, QDCount: 1 },
+        Questions: dns.QuestionCollection{ { Name: name, Type: qType, Class: 1 } },
+    }
+
+    serialized, err := query.Serialize()
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    return serialized
+}
+
+//
+// Build a serialized query advertising EDNS support
+//
+func testQueryEDNS(t *testing.T, id uint16, name string, qType uint16, opt *record.OPTRecord) []byte {
+    var query = dns.Message{
+        Header:    dns.MessageHeader{ ID: id, RecursionDesired: true, QDCount: 1, ARCount: 1 },
+        Questions: dns.QuestionCollection{ { Name: name, Type: qType, Class: 1 } },
+        Extra:     record.RecordCollection{ opt },
+    }
+
+    serialized, err := query.Serialize()
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    return serialized
+}
+
+//
+// Send a query to the server over UDP and return the unpacked response
+//
+func testExchangeUDP(t *testing.T, server *Server, query []byte) *dns.Message {
+    response, err := dns.UnpackMessage(testExchangeRaw(t, server, query))
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    return response
+}
+
+//
+// Send a query to the server over UDP and return the response as it arrived
+//
+func testExchangeRaw(t *testing.T, server *Server, query []byte) []byte {
+    conn, err := net.Dial("udp", server.Connection.LocalAddr().String())
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer conn.Close()
+
+    conn.SetDeadline(time.Now().Add(2 * time.Second))
+    if _, err := conn.Write(query); err != nil {
+        t.Fatal(err)
+    }
+
+    var content = make([]byte, 65535)
+    length, err := conn.Read(content)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    return content[:length]
+}
+
+//
+// Read a single length-prefixed response off of a TCP connection
+//
+func testReadTCP(t *testing.T, conn net.Conn) *dns.Message {
+    response, err := dns.UnpackMessage(testReadRawTCP(t, conn))
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    return response
+}
+
+//
+// Read a single length-prefixed message off of a TCP connection, undecoded
+//
+func testReadRawTCP(t *testing.T, conn net.Conn) []byte {
+    var length = make([]byte, 2)
+    if _, err := io.ReadFull(conn, length); err != nil {
+        t.Fatal(err)
+    }
+
+    var content = make([]byte, binary.BigEndian.Uint16(length))
+    if _, err := io.ReadFull(conn, content); err != nil {
+        t.Fatal(err)
+    }
+
+    return content
+}
+
+
+//----------------------------------------------
+// Transport Tests
+//----------------------------------------------
+
+func TestServer_UDP(t *testing.T) {
+    var server = testServer(t)
+    var response = testExchangeUDP(t, server, testQuery(t, 1234, "zed.io", record.A_RECORD))
+
+    if response.Header.ID != 1234 || !response.Header.Response {
+        t.Errorf("Incorrect Header:\n\tExpected: %d\n\tGot: %+v\n", 1234, response.Header)
+    }
+
+    if len(response.Answers) != 1 || response.Answers[0].GetType() != record.A_RECORD {
+        t.Errorf("Incorrect Answers:\n\tExpected: %s\n\tGot: %+v\n", "[A]", response.Answers)
+    }
+}
+
+func TestServer_CaseInsensitive(t *testing.T) {
+    var server = testServer(t)
+    var response = testExchangeUDP(t, server, testQuery(t, 1234, "ZeD.Io", record.A_RECORD))
+
+    if len(response.Answers) != 1 || response.Answers[0].GetType() != record.A_RECORD {
+        t.Fatalf("Incorrect Answers:\n\tExpected: %s\n\tGot: %+v\n", "[A]", response.Answers)
+    }
+
+    // the answer echoes the case of the question
+    if response.Questions[0].Name != "ZeD.Io" || response.Answers[0].GetLabel() != "ZeD.Io" {
+        t.Errorf("Incorrect Case:\n\tExpected: %s\n\tGot: %s, %s\n", "ZeD.Io", response.Questions[0].Name, response.Answers[0].GetLabel())
+    }
+
+    // the stored record keeps its own
+    rec, err := server.Store.Find("zed.io", record.A_RECORD)
+    if err != nil || rec.GetLabel() != "zed.io" {
+        t.Errorf("Stored Record Was Modified:\n\tGot: %+v (%v)\n", rec, err)
+    }
+}
+
+func TestServer_UDPTruncated(t *testing.T) {
+    var server = testServer(t)
+    var response = testExchangeUDP(t, server, testQuery(t, 1234, "_phonebook._tcp.zed.io", uint16(DNS_QUERY_ALL)))
+
+    if !response.Header.Truncated {
+        t.Errorf("TC bit not set:\n\tExpected: %v\n\tGot: %v\n", true, response.Header.Truncated)
+    }
+
+    if int(response.Header.ANCount) != len(response.Answers) || len(response.Answers) >= 40 {
+        t.Errorf("Incorrect Answers:\n\tExpected: %s\n\tGot: %d (header says %d)\n", "fewer than 40", len(response.Answers), response.Header.ANCount)
+    }
+}
+
+func TestServer_EDNSPayloadSize(t *testing.T) {
+    var server = testServer(t)
+    var opt, _ = record.OPT(4096)
+    opt.DNSSECOK = true
+
+    var response = testExchangeUDP(t, server, testQueryEDNS(t, 1234, "_phonebook._tcp.zed.io", uint16(DNS_QUERY_ALL), opt))
+
+    // the whole SRV set fits within the server's maximum
+    if response.Header.Truncated || len(response.Answers) != 40 {
+        t.Errorf("Incorrect Answers:\n\tExpected: %s\n\tGot: TC=%v, %d answers\n", "TC=false, 40 answers", response.Header.Truncated, len(response.Answers))
+    }
+
+    var echoed = response.OPT()
+    if echoed == nil || echoed.UDPSize != MaxUDPSize || !echoed.DNSSECOK {
+        t.Errorf("Incorrect OPT Record:\n\tExpected: %d bytes with DO\n\tGot: %+v\n", MaxUDPSize, echoed)
+    }
+}
+
+func TestServer_EDNSBadVersion(t *testing.T) {
+    var server = testServer(t)
+    var opt, _ = record.OPT(4096)
+    opt.Version = 1
+
+    var response = testExchangeUDP(t, server, testQueryEDNS(t, 1234, "zed.io", record.A_RECORD, opt))
+
+    if response.ExtendedRcode() != dns.ERR_BADVERS || len(response.Answers) != 0 {
+        t.Errorf("Incorrect Response:\n\tExpected: RCODE %d and no answers\n\tGot: RCODE %d and %d answers\n", dns.ERR_BADVERS, response.ExtendedRcode(), len(response.Answers))
+    }
+}
+
+func TestServer_TCPMultipleQueries(t *testing.T) {
+    var server = testServer(t)
+
+    conn, err := net.Dial("tcp", server.Listener.Addr().String())
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer conn.Close()
+    conn.SetDeadline(time.Now().Add(2 * time.Second))
+
+    // send both queries before reading either response
+    var queries = [][]byte{
+        testQuery(t, 0, "zed.io", record.A_RECORD),
+        testQuery(t, 1, "_phonebook._tcp.zed.io", uint16(DNS_QUERY_ALL)),
+    }
+
+    for _, query := range queries {
+        var framed = append(dns.Uint16ToBytes(uint16(len(query))), query...)
+        if _, err := conn.Write(framed); err != nil {
+            t.Fatal(err)
+        }
+    }
+
+    var first = testReadTCP(t, conn)
+    if first.Header.ID != 0 || len(first.Answers) != 1 {
+        t.Errorf("Incorrect First Response:\n\tExpected: %s\n\tGot: %+v\n", "1 A answer", first.Answers)
+    }
+
+    // the SRV set is larger than a 512 byte datagram
+    var second = testReadTCP(t, conn)
+    if second.Header.ID != 1 || len(second.Answers) != 40 {
+        t.Errorf("Incorrect Second Response:\n\tExpected: %s\n\tGot: %d\n", "40 SRV answers", len(second.Answers))
+    }
+}
+
+func TestServer_ListenOn(t *testing.T) {
+    var server = testServer(t)
+
+    udp, err := server.ListenOn(PROTO_UDP, "127.0.0.1:0")
+    if err != nil { t.Fatal(err) }
+    tcp, err := server.ListenOn(PROTO_TCP, "127.0.0.1:0")
+    if err != nil { t.Fatal(err) }
+
+    if _, err = server.ListenOn("sctp", "127.0.0.1:0") ; err != ErrProtocol {
+        t.Errorf("Incorrect Error:\n\tExpected: %v\n\tGot: %v\n", ErrProtocol, err)
+    }
+
+    // datagrams are answered from the socket they arrived on
+    conn, err := net.Dial("udp", udp.String())
+    if err != nil { t.Fatal(err) }
+    defer conn.Close()
+    conn.SetDeadline(time.Now().Add(2 * time.Second))
+
+    conn.Write(testQuery(t, 1, "zed.io", record.A_RECORD))
+    var content = make([]byte, 512)
+    length, err := conn.Read(content)
+    if err != nil { t.Fatal(err) }
+    if response, err := dns.UnpackMessage(content[:length]) ; err != nil || len(response.Answers) != 1 {
+        t.Errorf("Incorrect UDP Response:\n\tExpected: %s\n\tGot: %+v %v\n", "[A]", response, err)
+    }
+
+    stream, err := net.Dial("tcp", tcp.String())
+    if err != nil { t.Fatal(err) }
+    defer stream.Close()
+    stream.SetDeadline(time.Now().Add(2 * time.Second))
+
+    var query = testQuery(t, 2, "zed.io", record.A_RECORD)
+    stream.Write(append(dns.Uint16ToBytes(uint16(len(query))), query...))
+    if response := testReadTCP(t, stream) ; len(response.Answers) != 1 {
+        t.Errorf("Incorrect TCP Response:\n\tExpected: %s\n\tGot: %+v\n", "[A]", response.Answers)
+    }
+
+    // closing the server closes the extra listeners too
+    server.Close()
+    if _, err = net.Dial("tcp", tcp.String()) ; err == nil {
+        t.Errorf("TCP connection accepted after close\n")
+    }
+    if _, err = server.ListenOn(PROTO_UDP, "127.0.0.1:0") ; err != ErrServerClosed {
+        t.Errorf("Incorrect Error:\n\tExpected: %v\n\tGot: %v\n", ErrServerClosed, err)
+    }
+}
+
+func TestServer_TCPIdleTimeout(t *testing.T) {
+    var server = newTestServer(t)
+    server.IdleTimeout = 50 * time.Millisecond
+    testStart(server)
+
+    conn, err := net.Dial("tcp", server.Listener.Addr().String())
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer conn.Close()
+
+    // the server hangs up on a silent client
+    conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+    if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+        t.Errorf("Idle connection was not closed:\n\tExpected: %+v\n\tGot: %+v\n", io.EOF, err)
+    }
+}
+
+
+//----------------------------------------------
+// Zone Tests
+//----------------------------------------------
+
+//
+// Make the test server authoritative for zed.io
+//
+func testZone(t *testing.T, server *Server) *store.Zone {
+    var soa, _ = record.SOA("zed.io", "ns1.zed.io", "admin.zed.io", 60 * time.Second, 1, time.Hour, 10 * time.Minute, 24 * time.Hour, 30 * time.Second)
+    var ns, _ = record.NS("zed.io", "ns1.zed.io", 60 * time.Second)
+
+    zone, err := store.NewZone(soa, ns)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    if err := server.AddZone(zone); err != nil {
+        t.Fatal(err)
+    }
+
+    return zone
+}
+
+func TestServer_LoadZone(t *testing.T) {
+    var server = newTestServer(t)
+    server.Zones = store.NewZones()
+    testStart(server)
+    defer server.Close()
+
+    var file = "$ORIGIN example.com.\n$TTL 300\n" +
+        "@    SOA  ns1 hostmaster ( 7 3600 600 86400 60 )\n" +
+        "     NS   ns1\n" +
+        "ns1  A    10.0.0.53\n" +
+        "www  A    10.0.0.80\n"
+
+    zone, err := server.LoadZone(strings.NewReader(file), "example.com")
+    if err != nil { t.Fatal(err) }
+
+    if zone.Origin != "example.com" || zone.SOA.Serial != 7 || len(zone.NS) != 1 {
+        t.Errorf("Incorrect Zone:\n\tExpected: %s\n\tGot: %s %d %d\n", "example.com, serial 7, 1 NS", zone.Origin, zone.SOA.Serial, len(zone.NS))
+    }
+
+    var response = testExchangeUDP(t, server, testQuery(t, 1, "www.example.com", record.A_RECORD))
+    if !response.Header.Authoritative || len(response.Answers) != 1 {
+        t.Errorf("Incorrect Response:\n\tExpected: %s\n\tGot: AA=%v %+v\n", "AA=true, 1 answer", response.Header.Authoritative, response.Answers)
+    }
+
+    // the zone is only loaded once, and a file without an SOA is no zone
+    if _, err = server.LoadZone(strings.NewReader(file), "example.com") ; err != store.ErrZoneExists {
+        t.Errorf("Incorrect Error:\n\tExpected: %v\n\tGot: %v\n", store.ErrZoneExists, err)
+    }
+    if _, err = server.LoadZone(strings.NewReader("www.example.org. 60 A 10.0.0.1\n"), "example.org") ; err != store.ErrNoSOA {
+        t.Errorf("Incorrect Error:\n\tExpected: %v\n\tGot: %v\n", store.ErrNoSOA, err)
+    }
+}
+
+func TestServer_ReplaceZone(t *testing.T) {
+    var server = testTransferServer(t)
+    testStart(server)
+    defer server.Close()
+
+    var version = func(serial uint32, address string) (*store.Zone, []record.Record) {
+        var file = "$ORIGIN zed.io.\n$TTL 60\n" +
+            "@      SOA  ns1 hostmaster ( " + strconv.Itoa(int(serial)) + " 3600 600 86400 60 )\n" +
+            "       NS   ns1\n" +
+            "www    A    " + address + "\n" +
+            "v" + strconv.Itoa(int(serial)) + "    A    " + address + "\n" +
+            "sub    NS   ns1.sub\n"
+
+        zone, records, err := ReadZone(strings.NewReader(file), "zed.io")
+        if err != nil { t.Fatal(err) }
+        return zone, records
+    }
+
+    // queries keep being answered while the zone is swapped underneath them
+    var stop = make(chan struct{})
+    var failures = make(chan string, 1)
+    var done sync.WaitGroup
+    done.Add(1)
+    go func() {
+        defer done.Done()
+        for i := uint16(0) ; ; i++ {
+            select {
+                case <-stop: return
+                default:
+            }
+
+            var response = testExchangeUDP(t, server, testQuery(t, i, "www.zed.io", record.A_RECORD))
+            if response.Header.Rcode != 0 || len(response.Answers) != 1 {
+                select {
+                    case failures <- fmt.Sprintf("rcode %d, %d answers", response.Header.Rcode, len(response.Answers)):
+                    default:
+                }
+            }
+        }
+    }()
+
+    for serial := uint32(2) ; serial < 20 ; serial++ {
+        var zone, records = version(serial, "10.0.0." + strconv.Itoa(int(serial)))
+        if err := server.ReplaceZone(zone, records) ; err != nil { t.Fatal(err) }
+    }
+    close(stop)
+    done.Wait()
+
+    select {
+        case failure := <-failures:
+            t.Errorf("Query failed during a swap:\n\tGot: %s\n", failure)
+        default:
+    }
+
+    // only the latest version remains -- the records of zed.io's own, and nothing of sub.zed.io's
+    if server.Zones.Find("zed.io").SOA.Serial != 19 {
+        t.Errorf("Incorrect Serial:\n\tExpected: %d\n\tGot: %d\n", 19, server.Zones.Find("zed.io").SOA.Serial)
+    }
+    if _, err := server.Store.Find("v18.zed.io", record.A_RECORD) ; err == nil {
+        t.Errorf("Old record kept after a swap\n")
+    }
+    if _, err := server.Store.Find("v19.zed.io", record.A_RECORD) ; err != nil {
+        t.Errorf("New record missing after a swap: %v\n", err)
+    }
+    if _, err := server.Store.Find("host.sub.zed.io", record.A_RECORD) ; err != nil {
+        t.Errorf("Swap removed a record of another zone: %v\n", err)
+    }
+    if records, _ := server.Store.FindLabel("sub.zed.io") ; len(records) != 2 {
+        t.Errorf("Incorrect Delegation:\n\tExpected: %s\n\tGot: %+v\n", "sub.zed.io's SOA and NS", records)
+    }
+
+    // removing the zone takes its records along, and leaves the other
+    if err := server.RemoveZone("zed.io") ; err != nil { t.Fatal(err) }
+    if _, err := server.Store.Find("www.zed.io", record.A_RECORD) ; err == nil {
+        t.Errorf("Record kept after its zone was removed\n")
+    }
+    if server.Zones.Find("host.sub.zed.io") == nil {
+        t.Errorf("Removed the wrong zone\n")
+    }
+    if err := server.RemoveZone("zed.io") ; err != store.ErrNotFound {
+        t.Errorf("Incorrect Error:\n\tExpected: %v\n\tGot: %v\n", store.ErrNotFound, err)
+    }
+}
+
+func TestServer_ZoneAuthoritative(t *testing.T) {
+    var server = newTestServer(t)
+    testZone(t, server)
+    testStart(server)
+
+    var response = testExchangeUDP(t, server, testQuery(t, 1234, "zed.io", record.A_RECORD))
+    if !response.Header.Authoritative || response.Header.Rcode != 0 || len(response.Answers) != 1 {
+        t.Errorf("Incorrect Response:\n\tExpected: %s\n\tGot: AA=%v, RCODE %d, %d answers\n", "AA=true, RCODE 0, 1 answer", response.Header.Authoritative, response.Header.Rcode, len(response.Answers))
+    }
+
+    // the apex holds the zone's SOA
+    response = testExchangeUDP(t, server, testQuery(t, 1235, "zed.io", record.SOA_RECORD))
+    if len(response.Answers) != 1 || response.Answers[0].GetType() != record.SOA_RECORD {
+        t.Errorf("Incorrect SOA Answer:\n\tExpected: %s\n\tGot: %+v\n", "[SOA]", response.Answers)
+    }
+}
+
+func TestServer_ZoneRefused(t *testing.T) {
+    var server = newTestServer(t)
+    testZone(t, server)
+    testStart(server)
+
+    var response = testExchangeUDP(t, server, testQuery(t, 1234, "app.production", record.A_RECORD))
+    if response.Header.Authoritative || response.Header.Rcode != ERR_REFUSED || len(response.Answers) != 0 {
+        t.Errorf("Incorrect Response:\n\tExpected: %s\n\tGot: AA=%v, RCODE %d, %d answers\n", "AA=false, REFUSED, no answers", response.Header.Authoritative, response.Header.Rcode, len(response.Answers))
+    }
+}
+
+func TestServer_ZoneNegativeSOA(t *testing.T) {
+    var server = newTestServer(t)
+    testZone(t, server)
+    testStart(server)
+
+    var response = testExchangeUDP(t, server, testQuery(t, 1234, "missing.zed.io", record.A_RECORD))
+    if response.Header.Rcode != ERR_NOEXIST || !response.Header.Authoritative {
+        t.Errorf("Incorrect Response:\n\tExpected: %s\n\tGot: AA=%v, RCODE %d\n", "AA=true, NXDOMAIN", response.Header.Authoritative, response.Header.Rcode)
+    }
+
+    if len(response.Ns) != 1 || response.Ns[0].GetType() != record.SOA_RECORD {
+        t.Fatalf("Incorrect Authority:\n\tExpected: %s\n\tGot: %+v\n", "[SOA]", response.Ns)
+    }
+
+    // the negative TTL is the lesser of the SOA TTL and its minimum
+    if response.Ns[0].(*record.SOARecord).TTL != 30 * time.Second {
+        t.Errorf("Incorrect Negative TTL:\n\tExpected: %v\n\tGot: %v\n", 30 * time.Second, response.Ns[0].(*record.SOARecord).TTL)
+    }
+}
+
+func TestServer_ZoneNoData(t *testing.T) {
+    var server = newTestServer(t)
+    testZone(t, server)
+    testStart(server)
+
+    // zed.io exists, but holds no MX records
+    var response = testExchangeUDP(t, server, testQuery(t, 1234, "zed.io", record.MX_RECORD))
+    if response.Header.Rcode != 0 || len(response.Answers) != 0 {
+        t.Errorf("Incorrect Response:\n\tExpected: %s\n\tGot: RCODE %d, %d answers\n", "NOERROR, no answers", response.Header.Rcode, len(response.Answers))
+    }
+
+    if len(response.Ns) != 1 || response.Ns[0].GetType() != record.SOA_RECORD {
+        t.Errorf("Incorrect Authority:\n\tExpected: %s\n\tGot: %+v\n", "[SOA]", response.Ns)
+    }
+
+    // _tcp.zed.io is an empty non-terminal above the SRV records
+    response = testExchangeUDP(t, server, testQuery(t, 1235, "_tcp.zed.io", record.A_RECORD))
+    if response.Header.Rcode != 0 || len(response.Answers) != 0 {
+        t.Errorf("Incorrect Empty Non-Terminal Response:\n\tExpected: %s\n\tGot: RCODE %d, %d answers\n", "NOERROR, no answers", response.Header.Rcode, len(response.Answers))
+    }
+}
+
+
+//----------------------------------------------
+// Concurrency Tests (run with -race)
+//----------------------------------------------
+
+func TestServer_ConcurrentQueriesAndUpdates(t *testing.T) {
+    var server = newTestServer(t)
+    testZone(t, server)
+    testStart(server)
+
+    var done = make(chan bool)
+
+    // the application changes records and zones while queries are answered
+    go func() {
+        for i := 0 ; i < 100 ; i++ {
+            var a, _ = record.A("churn.zed.io", 10 * time.Second, net.ParseIP("10.0.0.1"))
+            server.Store.Add(a)
+            server.Store.FindAndDelete("churn.zed.io", record.A_RECORD)
+
+            var soa, _ = record.SOA("other.io", "ns1.other.io", "admin.other.io", 60 * time.Second, 1, time.Hour, 10 * time.Minute, 24 * time.Hour, 30 * time.Second)
+            var zone, _ = store.NewZone(soa)
+            server.Zones.Add(zone)
+            server.Zones.Remove("other.io")
+        }
+        done <- true
+    }()
+
+    var queries = []struct{ name string ; qType uint16 }{
+        { "zed.io", record.A_RECORD },
+        { "churn.zed.io", record.A_RECORD },
+        { "_phonebook._tcp.zed.io", uint16(DNS_QUERY_ALL) },
+        { "missing.zed.io", record.A_RECORD },
+    }
+
+    for c := 0 ; c < 4 ; c++ {
+        go func(c int) {
+            defer func() { done <- true }()
+
+            conn, err := net.Dial("udp", server.Connection.LocalAddr().String())
+            if err != nil {
+                t.Error(err)
+                return
+            }
+            defer conn.Close()
+
+            var content = make([]byte, 65535)
+            for i := 0 ; i < 25 ; i++ {
+                var query = queries[(c + i) % len(queries)]
+                var message = dns.Message{
+                    Header:    dns.MessageHeader{ ID: uint16(i), QDCount: 1 },
+                    Questions: dns.QuestionCollection{ { Name: query.name, Type: query.qType, Class: 1 } },
+                }
+
+                serialized, err := message.Serialize()
+                if err != nil {
+                    t.Error(err)
+                    return
+                }
+
+                conn.SetDeadline(time.Now().Add(2 * time.Second))
+                if _, err := conn.Write(serialized); err != nil {
+                    t.Error(err)
+                    return
+                }
+
+                length, err := conn.Read(content)
+                if err != nil {
+                    t.Error(err)
+                    return
+                }
+
+                if _, err := dns.UnpackMessage(content[:length]); err != nil {
+                    t.Error(err)
+                }
+            }
+        }(c)
+    }
+
+    for i := 0 ; i < 5 ; i++ {
+        <-done
+    }
+}
+
+
+//----------------------------------------------
+// Lifecycle Tests
+//----------------------------------------------
+
+func TestServer_ShutdownStopsListening(t *testing.T) {
+    var server = testServer(t)
+
+    // the server answers, then stops
+    testExchangeUDP(t, server, testQuery(t, 1234, "zed.io", record.A_RECORD))
+
+    var ctx, cancel = context.WithTimeout(context.Background(), 2 * time.Second)
+    defer cancel()
+
+    if err := server.Shutdown(ctx); err != nil {
+        t.Fatal(err)
+    }
+
+    if _, err := net.DialTimeout("tcp", server.Listener.Addr().String(), time.Second); err == nil {
+        t.Errorf("TCP connection accepted after shutdown\n")
+    }
+
+    // shutting down (or closing) again is harmless
+    if err := server.Shutdown(ctx); err != nil {
+        t.Errorf("Second Shutdown Failed: %s\n", err)
+    }
+    server.Close()
+
+    // errors reported after shutdown must not block
+    var reported = make(chan bool)
+    go func() {
+        server.reportError(ErrShortRead)
+        reported <- true
+    }()
+
+    select {
+        case <-reported:
+        case <-time.After(time.Second):
+            t.Errorf("Reporting an error blocked after shutdown\n")
+    }
+}
+
+func TestServer_ShutdownClosesIdleTCP(t *testing.T) {
+    var server = newTestServer(t)
+    server.IdleTimeout = time.Minute
+    testStart(server)
+
+    conn, err := net.Dial("tcp", server.Listener.Addr().String())
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer conn.Close()
+
+    var query = testQuery(t, 1234, "zed.io", record.A_RECORD)
+    conn.Write(append(dns.Uint16ToBytes(uint16(len(query))), query...))
+    testReadTCP(t, conn)
+
+    // the connection is idle -- shutdown hangs it up rather than waiting out the idle timeout
+    var ctx, cancel = context.WithTimeout(context.Background(), 2 * time.Second)
+    defer cancel()
+
+    var started = time.Now()
+    if err := server.Shutdown(ctx); err != nil {
+        t.Fatal(err)
+    }
+
+    conn.SetReadDeadline(time.Now().Add(time.Second))
+    if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+        t.Errorf("Incorrect Read After Shutdown:\n\tExpected: %v\n\tGot: %v\n", io.EOF, err)
+    }
+
+    if elapsed := time.Since(started) ; elapsed > time.Second {
+        t.Errorf("Shutdown waited on an idle connection: %v\n", elapsed)
+    }
+}
+
+func TestServer_ShutdownWaitsForInFlight(t *testing.T) {
+    var server = testServer(t)
+
+    // stand in for a query that is still being answered
+    if !server.begin() {
+        t.Fatal("could not begin work on a running server")
+    }
+
+    var ctx, cancel = context.WithTimeout(context.Background(), 100 * time.Millisecond)
+    defer cancel()
+
+    if err := server.Shutdown(ctx); err != context.DeadlineExceeded {
+        t.Errorf("Incorrect Error:\n\tExpected: %v\n\tGot: %v\n", context.DeadlineExceeded, err)
+    }
+    server.end()
+
+    // no new work is taken once shut down
+    if server.begin() {
+        t.Errorf("Work began after shutdown\n")
+    }
+}
+
+
+//----------------------------------------------
+// Logging Tests
+//----------------------------------------------
+
+//
+// A logger that keeps every message it is given
+//
+type testLogger struct {
+    lock            sync.Mutex
+    messages        []string
+    fields          []map[string]interface{}
+}
+
+func (self *testLogger) Enabled(ctx context.Context, level logging.Level) bool {
+    return true
+}
+
+func (self *testLogger) Log(ctx context.Context, level logging.Level, message string, fields ...logging.Field) {
+    var collected = make(map[string]interface{}, 0)
+    for _, field := range append(logging.FieldsFrom(ctx), fields...) {
+        collected[field.Key] = field.Value
+    }
+
+    self.lock.Lock()
+    defer self.lock.Unlock()
+
+    self.messages = append(self.messages, message)
+    self.fields = append(self.fields, collected)
+}
+
+func (self *testLogger) find(message string) map[string]interface{} {
+    self.lock.Lock()
+    defer self.lock.Unlock()
+
+    for i, logged := range self.messages {
+        if logged == message { return self.fields[i] }
+    }
+    return nil
+}
+
+func TestServer_LogsQueries(t *testing.T) {
+    var server = newTestServer(t)
+    var logger = &testLogger{}
+    server.Logger = logger
+    testStart(server)
+
+    testExchangeUDP(t, server, testQuery(t, 1234, "missing.zed.io", record.A_RECORD))
+
+    var fields = logger.find("query answered")
+    if fields == nil {
+        t.Fatalf("No query logged:\n\tGot: %v\n", logger.messages)
+    }
+
+    var expected = map[string]interface{}{
+        logging.FIELD_ID:       uint16(1234),
+        logging.FIELD_NAME:     "missing.zed.io",
+        logging.FIELD_TYPE:     "A",
+        logging.FIELD_RCODE:    ERR_NOEXIST,
+        logging.FIELD_PROTOCOL: PROTO_UDP,
+    }
+    for key, value := range expected {
+        if fields[key] != value {
+            t.Errorf("Incorrect Field %s:\n\tExpected: %v\n\tGot: %v\n", key, value, fields[key])
+        }
+    }
+
+    if _, ok := fields[logging.FIELD_CLIENT]; !ok {
+        t.Errorf("Missing Field: %s\n", logging.FIELD_CLIENT)
+    }
+    if _, ok := fields[logging.FIELD_LATENCY].(time.Duration); !ok {
+        t.Errorf("Missing Field: %s\n", logging.FIELD_LATENCY)
+    }
+}
+
+
+//----------------------------------------------
+// dnstap Tests
+//----------------------------------------------
+
+//
+// A buffer the server may write to while the test reads it
+//
+type testBuffer struct {
+    lock            sync.Mutex
+    buffer          bytes.Buffer
+}
+
+func (self *testBuffer) Write(content []byte) (int, error) {
+    self.lock.Lock()
+    defer self.lock.Unlock()
+    return self.buffer.Write(content)
+}
+
+func (self *testBuffer) Bytes() []byte {
+    self.lock.Lock()
+    defer self.lock.Unlock()
+    return append([]byte(nil), self.buffer.Bytes()...)
+}
+
+func TestServer_Dnstap(t *testing.T) {
+    var server = newTestServer(t)
+    var stream = &testBuffer{}
+
+    tap, err := dnstap.NewWriter(stream)
+    if err != nil { t.Fatal(err) }
+    server.Tap = tap
+    testStart(server)
+
+    var query = testQuery(t, 1234, "zed.io", record.A_RECORD)
+    testExchangeUDP(t, server, query)
+
+    // the response is sent before it is logged
+    var ctx, cancel = context.WithTimeout(context.Background(), 2 * time.Second)
+    defer cancel()
+    server.Shutdown(ctx)
+    tap.Close()
+
+    // START, the query, the response, STOP -- each data frame holds the raw query
+    var content = stream.Bytes()
+    var frames = 0
+    for len(content) >= 4 {
+        var length = int(binary.BigEndian.Uint32(content))
+        content = content[4:]
+
+        if length == 0 {
+            content = content[4 + binary.BigEndian.Uint32(content):]
+            continue
+        }
+
+        if !bytes.Contains(content[:length], query) {
+            t.Errorf("Data frame %d is missing the query\n", frames)
+        }
+        content = content[length:]
+        frames += 1
+    }
+
+    if frames != 2 {
+        t.Errorf("Incorrect Data Frames:\n\tExpected: %d (query and response)\n\tGot: %d\n", 2, frames)
+    }
+}
+
+func TestServer_MalformedQueries(t *testing.T) {
+    var server = testServer(t)
+    var query = testQuery(t, 4321, "zed.io", record.A_RECORD)
+
+    // every cut short query gets FORMERR back, once there is a header to answer
+    for i := dns.HEADER_LENGTH ; i < len(query) ; i++ {
+        var response = testExchangeUDP(t, server, query[:i])
+        if response.Header.ID != 4321 || response.Header.Rcode != ERR_FORMAT || len(response.Questions) != 0 {
+            t.Errorf("Incorrect Response:\n\tLength: %d\n\tExpected: ID %d, rcode %d\n\tGot: %+v\n", i, 4321, ERR_FORMAT, response.Header)
+        }
+    }
+
+    // a partial header is dropped
+    conn, err := net.Dial("udp", server.Connection.LocalAddr().String())
+    if err != nil { t.Fatal(err) }
+    defer conn.Close()
+
+    conn.SetDeadline(time.Now().Add(200 * time.Millisecond))
+    conn.Write(query[:dns.HEADER_LENGTH - 1])
+    if _, err := conn.Read(make([]byte, 512)); err == nil {
+        t.Errorf("Answered a partial header\n")
+    }
+
+    // and the server carries on
+    var response = testExchangeUDP(t, server, query)
+    if response.Header.Rcode != 0 || len(response.Answers) != 1 {
+        t.Errorf("Incorrect Response:\n\tExpected: %s\n\tGot: %+v\n", "1 answer", response)
+    }
+}
+
+func TestServer_NXDOMAINNotReported(t *testing.T) {
+    var server = newTestServer(t)
+
+    // nothing watches for errors, so reporting one would hold the query up
+    go server.Listen()
+
+    var response = testExchangeUDP(t, server, testQuery(t, 4321, "missing.zed.io", record.A_RECORD))
+    if response.Header.ID != 4321 || response.Header.Rcode != ERR_NOEXIST {
+        t.Errorf("Incorrect Response:\n\tExpected: ID %d, rcode %d\n\tGot: %+v\n", 4321, ERR_NOEXIST, response.Header)
+    }
+}
+
+func TestServer_UnserializableAnswer(t *testing.T) {
+    var server = testServer(t)
+
+    // a record that cannot be put on the wire, as no constructor would build it
+    var broken = &record.TXTRecord{
+        RecordHeader:   record.RecordHeader{ Name: "zed.io", Type: record.TXT_RECORD, Class: 1, TTL: 10 * time.Second },
+        Text:           strings.Repeat("k", 0xFFFF),
+    }
+    if err := server.Store.Add(broken) ; err != nil { t.Fatal(err) }
+
+    // fails only that query, with SERVFAIL
+    var response = testExchangeUDP(t, server, testQuery(t, 4321, "zed.io", record.TXT_RECORD))
+    if response.Header.ID != 4321 || response.Header.Rcode != ERR_INTERNAL {
+        t.Errorf("Incorrect Response:\n\tExpected: ID %d, rcode %d\n\tGot: %+v\n", 4321, ERR_INTERNAL, response.Header)
+    }
+
+    select {
+        case err := <-server.Fatal:
+            t.Errorf("Incorrect Fatal:\n\tExpected: nothing\n\tGot: %v\n", err)
+        default:
+    }
+
+    // and the server carries on
+    response = testExchangeUDP(t, server, testQuery(t, 4322, "zed.io", record.A_RECORD))
+    if response.Header.Rcode != 0 || len(response.Answers) != 1 {
+        t.Errorf("Incorrect Response:\n\tExpected: %s\n\tGot: %+v\n", "1 answer", response)
+    }
+}
+
+func TestServer_Metrics(t *testing.T) {
+    var server = newTestServer(t)
+    server.Zones = store.NewZones()
+    server.Metrics = NewMetrics(server)
+    testStart(server)
+
+    addr, err := server.ListenMetrics("127.0.0.1:0")
+    if err != nil { t.Fatal(err) }
+
+    if _, err = server.ListenMetrics("127.0.0.1:0") ; err != ErrHTTPListening {
+        t.Errorf("Incorrect Error:\n\tExpected: %s\n\tGot: %v\n", ErrHTTPListening, err)
+    }
+
+    testExchangeUDP(t, server, testQuery(t, 1, "zed.io", record.A_RECORD))
+    testExchangeUDP(t, server, testQuery(t, 2, "nothing.zed.io", record.A_RECORD))
+    testExchangeUDP(t, server, testQuery(t, 3, "_phonebook._tcp.zed.io", uint16(DNS_QUERY_ALL)))
+
+    // a query claiming a record it does not carry is answered FORMERR
+    var broken = testQuery(t, 4, "zed.io", record.A_RECORD)
+    binary.BigEndian.PutUint16(broken[10:], 1)
+
+    var conn, _ = net.DialUDP("udp", nil, server.Connection.LocalAddr().(*net.UDPAddr))
+    conn.Write(append(broken, 0x00, 0x01))
+    conn.Close()
+
+    // the response is written (and timed) just before it is received
+    var text string
+    for deadline := time.Now().Add(2 * time.Second) ; time.Now().Before(deadline) ; {
+        response, err := http.Get("http://" + addr.String() + METRICS_PATH)
+        if err != nil { t.Fatal(err) }
+
+        body, _ := io.ReadAll(response.Body)
+        response.Body.Close()
+
+        text = string(body)
+        if strings.Contains(text, "phonebook_query_duration_seconds_count 4\n") &&
+           strings.Contains(text, "phonebook_parse_errors_total 1\n") { break }
+        time.Sleep(10 * time.Millisecond)
+    }
+
+    for _, line := range []string{
+        `phonebook_queries_total{qtype="A"} 2`,
+        `phonebook_queries_total{qtype="255"} 1`,
+        `phonebook_responses_total{rcode="NOERROR"} 2`,
+        `phonebook_responses_total{rcode="NXDOMAIN"} 1`,
+        `phonebook_responses_total{rcode="FORMERR"} 1`,
+        "phonebook_truncated_responses_total 1",
+        "phonebook_parse_errors_total 1",
+        "phonebook_query_duration_seconds_count 4",
+        "phonebook_queries_in_flight 0",
+        "phonebook_store_records 41",
+        "phonebook_zones 0",
+    } {
+        if !strings.Contains(text, line + "\n") {
+            t.Errorf("Missing Metric:\n\tExpected: %s\n\tGot:\n%s\n", line, text)
+        }
+    }
+
+    // closing the server stops the metrics listener too
+    server.Close()
+    if _, err = http.Get("http://" + addr.String() + METRICS_PATH) ; err == nil {
+        t.Errorf("Metrics still served after Close\n")
+    }
+}
+
+func TestServer_API(t *testing.T) {
+    var server = testServer(t)
+    defer server.Close()
+
+    addr, err := server.ListenAPI("127.0.0.1:0", "secret")
+    if err != nil { t.Fatal(err) }
+
+    var body = `{"name":"www.zed.io","type":"A","ttl":30,"data":{"ip":"10.0.0.7"}}`
+    request, _ := http.NewRequest("POST", "http://" + addr.String() + "/records", strings.NewReader(body))
+    request.Header.Set("Authorization", "Bearer secret")
+
+    response, err := http.DefaultClient.Do(request)
+    if err != nil { t.Fatal(err) }
+    response.Body.Close()
+
+    if response.StatusCode != http.StatusCreated {
+        t.Fatalf("Incorrect Status:\n\tExpected: %d\n\tGot: %d\n", http.StatusCreated, response.StatusCode)
+    }
+
+    // the record is answered straight away
+    var answer = testExchangeUDP(t, server, testQuery(t, 1, "www.zed.io", record.A_RECORD))
+    if len(answer.Answers) != 1 || !answer.Answers[0].(*record.ARecord).IP.Equal(net.ParseIP("10.0.0.7")) {
+        t.Errorf("Incorrect Answers:\n\tExpected: %s\n\tGot: %+v\n", "10.0.0.7", answer.Answers)
+    }
+}
+
+//----------------------------------------------
+// Dynamic UPDATE
+//----------------------------------------------
+
+//
+// Build a serialized UPDATE for a zone with the given prerequisites and updates
+//
+func testUpdate(t *testing.T, id uint16, zone string, prerequisites, updates []record.Record) []byte {
+    var message = dns.Message{
+        Header:    dns.MessageHeader{ ID: id, Opcode: dns.OPCODE_UPDATE, QDCount: 1, ANCount: uint16(len(prerequisites)), NSCount: uint16(len(updates)) },
+        Questions: dns.QuestionCollection{ { Name: zone, Type: record.SOA_RECORD, Class: record.CLASS_IN } },
+        Answers:   prerequisites,
+        Ns:        updates,
+    }
+
+    serialized, err := message.Serialize()
+    if err != nil { t.Fatal(err) }
+
+    return serialized
+}
+
+//
+// A record with no data, of the given class -- how UPDATE asks "exists?" and "delete everything"
+//
+func testEmpty(name string, rType, class uint16) record.Record {
+    return &record.UnknownRecord{ RecordHeader: record.RecordHeader{ Name: name, Type: rType, Class: class } }
+}
+
+//
+// A copy of an A record in another class with no TTL -- how UPDATE names a single record
+//
+func testInClass(rec *record.ARecord, class uint16) record.Record {
+    var copied = *rec
+    copied.Class = class
+    copied.TTL = 0
+    return &copied
+}
+
+//
+// A server authoritative for zed.io that accepts updates from anyone
+//
+func testUpdateServer(t *testing.T) *Server {
+    var server = newTestServer(t)
+    testZone(t, server)
+    server.AllowUpdate = func(*Request, *store.Zone) bool { return true }
+    testStart(server)
+    return server
+}
+
+func TestServer_UpdateAddAndDelete(t *testing.T) {
+    var server = testUpdateServer(t)
+    defer server.Close()
+
+    var first, _ = record.A("app.zed.io", 30 * time.Second, net.ParseIP("10.0.0.1"))
+    var second, _ = record.A("app.zed.io", 30 * time.Second, net.ParseIP("10.0.0.2"))
+
+    var response = testExchangeUDP(t, server, testUpdate(t, 1, "zed.io", nil, []record.Record{ first, second }))
+    if response.Header.Rcode != 0 || response.Header.Opcode != dns.OPCODE_UPDATE || !response.Header.Response {
+        t.Fatalf("Incorrect Response:\n\tExpected: %s\n\tGot: %+v\n", "NOERROR UPDATE response", response.Header)
+    }
+
+    var answer = testExchangeUDP(t, server, testQuery(t, 2, "app.zed.io", record.A_RECORD))
+    if len(answer.Answers) != 2 {
+        t.Errorf("Incorrect Answers:\n\tExpected: %d\n\tGot: %+v\n", 2, answer.Answers)
+    }
+
+    // the serial moves on so secondaries notice
+    if serial := server.Zones.Find("zed.io").SOA.Serial ; serial != 2 {
+        t.Errorf("Incorrect Serial:\n\tExpected: %d\n\tGot: %d\n", 2, serial)
+    }
+
+    // a single record, then the rest of the name
+    testExchangeUDP(t, server, testUpdate(t, 3, "zed.io", nil, []record.Record{ testInClass(first, record.CLASS_NONE) }))
+    answer = testExchangeUDP(t, server, testQuery(t, 4, "app.zed.io", record.A_RECORD))
+    if len(answer.Answers) != 1 || !answer.Answers[0].(*record.ARecord).IP.Equal(second.IP) {
+        t.Errorf("Incorrect Answers:\n\tExpected: %s\n\tGot: %+v\n", second.IP, answer.Answers)
+    }
+
+    testExchangeUDP(t, server, testUpdate(t, 5, "zed.io", nil, []record.Record{ testEmpty("app.zed.io", uint16(DNS_QUERY_ALL), record.CLASS_ANY) }))
+    answer = testExchangeUDP(t, server, testQuery(t, 6, "app.zed.io", record.A_RECORD))
+    if answer.Header.Rcode != ERR_NOEXIST {
+        t.Errorf("Incorrect Rcode:\n\tExpected: %d\n\tGot: %d\n", ERR_NOEXIST, answer.Header.Rcode)
+    }
+
+    // the apex keeps its SOA and NS
+    testExchangeUDP(t, server, testUpdate(t, 7, "zed.io", nil, []record.Record{ testEmpty("zed.io", uint16(DNS_QUERY_ALL), record.CLASS_ANY) }))
+    for _, rType := range []uint16{ record.SOA_RECORD, record.NS_RECORD } {
+        if _, err := server.Store.Find("zed.io", rType) ; err != nil {
+            t.Errorf("Apex record deleted:\n\tType: %d\n\tGot: %s\n", rType, err)
+        }
+    }
+    if _, err := server.Store.Find("zed.io", record.A_RECORD) ; err == nil {
+        t.Errorf("Apex A record not deleted\n")
+    }
+}
+
+func TestServer_UpdateDeleteAnyCase(t *testing.T) {
+    var server = testUpdateServer(t)
+    defer server.Close()
+
+    var mx, _ = record.MX("app.zed.io", "mail.zed.io", 10, 30 * time.Second)
+    testExchangeUDP(t, server, testUpdate(t, 1, "zed.io", nil, []record.Record{ mx }))
+
+    // names in the data are matched in any case, as they are everywhere else
+    var deleted, _ = record.MX("app.zed.io", "MAIL.Zed.IO.", 10, 30 * time.Second)
+    deleted.Class, deleted.TTL = record.CLASS_NONE, 0
+
+    var response = testExchangeUDP(t, server, testUpdate(t, 2, "zed.io", nil, []record.Record{ deleted }))
+    if response.Header.Rcode != 0 {
+        t.Fatalf("Incorrect Rcode:\n\tExpected: %d\n\tGot: %d\n", 0, response.Header.Rcode)
+    }
+
+    if _, err := server.Store.Find("app.zed.io", record.MX_RECORD) ; err == nil {
+        t.Errorf("MX record not deleted\n")
+    }
+}
+
+func TestServer_UpdatePrerequisites(t *testing.T) {
+    var server = testUpdateServer(t)
+    defer server.Close()
+
+    var existing, _ = record.A("zed.io", 10 * time.Second, net.ParseIP("127.0.0.1"))
+    var other, _ = record.A("zed.io", 10 * time.Second, net.ParseIP("127.0.0.2"))
+    var added, _ = record.A("new.zed.io", 10 * time.Second, net.ParseIP("10.0.0.1"))
+
+    var cases = []struct {
+        prerequisites   []record.Record
+        rcode           int
+    }{
+        { []record.Record{ testEmpty("zed.io", uint16(DNS_QUERY_ALL), record.CLASS_ANY) }, 0 },
+        { []record.Record{ testEmpty("missing.zed.io", uint16(DNS_QUERY_ALL), record.CLASS_ANY) }, ERR_NOEXIST },
+        { []record.Record{ testEmpty("zed.io", record.MX_RECORD, record.CLASS_ANY) }, ERR_NXRRSET },
+        { []record.Record{ testEmpty("zed.io", uint16(DNS_QUERY_ALL), record.CLASS_NONE) }, ERR_YXDOMAIN },
+        { []record.Record{ testEmpty("zed.io", record.A_RECORD, record.CLASS_NONE) }, ERR_YXRRSET },
+        { []record.Record{ testInClass(existing, record.CLASS_IN) }, 0 },
+        { []record.Record{ testInClass(other, record.CLASS_IN) }, ERR_NXRRSET },
+        { []record.Record{ testEmpty("zed.org", uint16(DNS_QUERY_ALL), record.CLASS_ANY) }, ERR_NOTZONE },
+    }
+
+    for i, test := range cases {
+        server.Store.FindAndDelete("new.zed.io", record.A_RECORD)
+
+        var response = testExchangeUDP(t, server, testUpdate(t, uint16(i), "zed.io", test.prerequisites, []record.Record{ added }))
+        if response.Header.Rcode != test.rcode {
+            t.Errorf("Incorrect Rcode:\n\tCase: %d\n\tExpected: %d\n\tGot: %d\n", i, test.rcode, response.Header.Rcode)
+        }
+
+        // the update is applied only when the prerequisites hold
+        var _, err = server.Store.Find("new.zed.io", record.A_RECORD)
+        if (err == nil) != (test.rcode == 0) {
+            t.Errorf("Incorrect Outcome:\n\tCase: %d\n\tExpected applied: %v\n\tGot: %v\n", i, test.rcode == 0, err)
+        }
+    }
+}
+
+func TestServer_UpdateAtomic(t *testing.T) {
+    var server = testUpdateServer(t)
+    defer server.Close()
+
+    var added, _ = record.A("new.zed.io", 10 * time.Second, net.ParseIP("10.0.0.1"))
+    var outside, _ = record.A("zed.org", 10 * time.Second, net.ParseIP("10.0.0.2"))
+
+    // one bad update spoils the lot
+    var response = testExchangeUDP(t, server, testUpdate(t, 1, "zed.io", nil, []record.Record{ added, outside }))
+    if response.Header.Rcode != ERR_NOTZONE {
+        t.Errorf("Incorrect Rcode:\n\tExpected: %d\n\tGot: %d\n", ERR_NOTZONE, response.Header.Rcode)
+    }
+    if _, err := server.Store.Find("new.zed.io", record.A_RECORD) ; err == nil {
+        t.Errorf("Partial update applied\n")
+    }
+    if serial := server.Zones.Find("zed.io").SOA.Serial ; serial != 1 {
+        t.Errorf("Incorrect Serial:\n\tExpected: %d\n\tGot: %d\n", 1, serial)
+    }
+}
+
+func TestServer_UpdateRefused(t *testing.T) {
+    var added, _ = record.A("new.zed.io", 10 * time.Second, net.ParseIP("10.0.0.1"))
+
+    _, loopback, _ := net.ParseCIDR("127.0.0.0/8")
+    _, elsewhere, _ := net.ParseCIDR("10.0.0.0/8")
+
+    // nobody may update without a policy, and then only the clients it names
+    var cases = []struct {
+        policy          Policy
+        rcode           int
+    }{
+        { nil, ERR_REFUSED },
+        { AllowFrom(elsewhere), ERR_REFUSED },
+        { AllowFrom(elsewhere, loopback), 0 },
+    }
+
+    for i, test := range cases {
+        var server = newTestServer(t)
+        testZone(t, server)
+        server.AllowUpdate = test.policy
+        testStart(server)
+
+        var response = testExchangeUDP(t, server, testUpdate(t, uint16(i), "zed.io", nil, []record.Record{ added }))
+        if response.Header.Rcode != test.rcode {
+            t.Errorf("Incorrect Rcode:\n\tCase: %d\n\tExpected: %d\n\tGot: %d\n", i, test.rcode, response.Header.Rcode)
+        }
+
+        server.Close()
+    }
+
+    // zones we do not hold (or names within them) are not ours to update
+    var server = testUpdateServer(t)
+    defer server.Close()
+
+    for _, zone := range []string{ "zed.org", "app.zed.io" } {
+        var response = testExchangeUDP(t, server, testUpdate(t, 4, zone, nil, []record.Record{ added }))
+        if response.Header.Rcode != ERR_NOTAUTH {
+            t.Errorf("Incorrect Rcode:\n\tZone: %s\n\tExpected: %d\n\tGot: %d\n", zone, ERR_NOTAUTH, response.Header.Rcode)
+        }
+    }
+}
+
+func TestServer_UpdateCNAMEConflict(t *testing.T) {
+    var server = testUpdateServer(t)
+    defer server.Close()
+
+    // zed.io holds an A record, so a CNAME there is ignored (but not an error)
+    var cname, _ = record.CNAME("zed.io", "elsewhere.zed.io", 10 * time.Second)
+    var response = testExchangeUDP(t, server, testUpdate(t, 1, "zed.io", nil, []record.Record{ cname }))
+    if response.Header.Rcode != 0 {
+        t.Errorf("Incorrect Rcode:\n\tExpected: %d\n\tGot: %d\n", 0, response.Header.Rcode)
+    }
+    if _, err := server.Store.Find("zed.io", record.CNAME_RECORD) ; err == nil {
+        t.Errorf("CNAME added beside other data\n")
+    }
+
+    // nothing changed, so the serial stays put
+    if serial := server.Zones.Find("zed.io").SOA.Serial ; serial != 1 {
+        t.Errorf("Incorrect Serial:\n\tExpected: %d\n\tGot: %d\n", 1, serial)
+    }
+}
+
+//----------------------------------------------
+// TSIG
+//----------------------------------------------
+
+const testSecret string = "c2VjcmV0LWtleS1mb3ItdGVzdGluZy1wdXJwb3Nlcw=="
+
+//
+// A server for zed.io accepting updates signed with the "update.zed.io" key
+//
+func testTSIGServer(t *testing.T) (*Server, *dns.TSIGKey) {
+    key, err := dns.NewTSIGKey("update.zed.io", dns.HMAC_SHA256, testSecret)
+    if err != nil { t.Fatal(err) }
+
+    var server = newTestServer(t)
+    testZone(t, server)
+    server.Keys = make(dns.KeyRing)
+    server.Keys.Add(key)
+    server.AllowUpdate = AllowWithKey("update.zed.io")
+    testStart(server)
+
+    return server, server.Keys.Find("update.zed.io")
+}
+
+//
+// Sign a serialized message with key at the given time, returning it and its TSIG record
+//
+func testSign(t *testing.T, packet []byte, key *dns.TSIGKey, signed time.Time) ([]byte, *record.TSIGRecord) {
+    tsig, err := record.TSIG(key.Name, key.Algorithm, signed, dns.TSIG_FUDGE)
+    if err != nil { t.Fatal(err) }
+
+    packet, err = dns.SignTSIG(packet, tsig, key, nil)
+    if err != nil { t.Fatal(err) }
+
+    return packet, tsig
+}
+
+func TestServer_TSIGUpdate(t *testing.T) {
+    var server, key = testTSIGServer(t)
+    defer server.Close()
+
+    var added, _ = record.A("new.zed.io", 10 * time.Second, net.ParseIP("10.0.0.1"))
+    var request, signature = testSign(t, testUpdate(t, 1, "zed.io", nil, []record.Record{ added }), key, time.Now())
+
+    var raw = testExchangeRaw(t, server, request)
+    response, err := dns.UnpackMessage(raw)
+    if err != nil { t.Fatal(err) }
+    if response.Header.Rcode != 0 {
+        t.Fatalf("Incorrect Rcode:\n\tExpected: %d\n\tGot: %d\n", 0, response.Header.Rcode)
+    }
+    if _, err = server.Store.Find("new.zed.io", record.A_RECORD) ; err != nil {
+        t.Errorf("Signed update not applied:\n\tGot: %v\n", err)
+    }
+
+    // the answer is signed in turn, chained to our request
+    if tsig, _, err := dns.VerifyTSIG(raw, server.Keys, signature.MAC, time.Now()) ; tsig == nil || err != nil {
+        t.Errorf("Response not signed:\n\tGot: %+v %v\n", tsig, err)
+    }
+
+    // without a signature, the same update is refused
+    var unsigned = testExchangeUDP(t, server, testUpdate(t, 2, "zed.io", nil, []record.Record{ added }))
+    if unsigned.Header.Rcode != ERR_REFUSED || unsigned.TSIG() != nil {
+        t.Errorf("Incorrect Unsigned Response:\n\tExpected: %d\n\tGot: %d %+v\n", ERR_REFUSED, unsigned.Header.Rcode, unsigned.Extra)
+    }
+
+    // plain queries need no signature
+    var answer = testExchangeUDP(t, server, testQuery(t, 3, "new.zed.io", record.A_RECORD))
+    if answer.Header.Rcode != 0 || len(answer.Answers) != 1 || answer.TSIG() != nil {
+        t.Errorf("Incorrect Query Response:\n\tGot: %+v %+v\n", answer.Header, answer.Answers)
+    }
+}
+
+func TestServer_TSIGFailures(t *testing.T) {
+    var server, key = testTSIGServer(t)
+    defer server.Close()
+
+    var added, _ = record.A("new.zed.io", 10 * time.Second, net.ParseIP("10.0.0.1"))
+    var update = testUpdate(t, 1, "zed.io", nil, []record.Record{ added })
+
+    var unknown = &dns.TSIGKey{ Name: "other.zed.io", Algorithm: dns.HMAC_SHA256, Secret: key.Secret }
+    var wrong = &dns.TSIGKey{ Name: key.Name, Algorithm: key.Algorithm, Secret: []byte("not the secret") }
+
+    var cases = []struct {
+        name        string
+        key         *dns.TSIGKey
+        signed      time.Time
+        expected    dns.TSIGError
+        signedReply bool
+    }{
+        { "unknown key", unknown, time.Now(), dns.TSIG_BADKEY, false },
+        { "wrong secret", wrong, time.Now(), dns.TSIG_BADSIG, false },
+        { "stale", key, time.Now().Add(-time.Hour), dns.TSIG_BADTIME, true },
+    }
+
+    for _, test := range cases {
+        var request, signature = testSign(t, update, test.key, test.signed)
+
+        var raw = testExchangeRaw(t, server, request)
+        response, err := dns.UnpackMessage(raw)
+        if err != nil { t.Fatal(err) }
+
+        var tsig = response.TSIG()
+        if response.Header.Rcode != ERR_NOTAUTH || tsig == nil || tsig.Error != uint16(test.expected) {
+            t.Errorf("Incorrect Response:\n\tCase: %s\n\tExpected: %d, %v\n\tGot: %d, %+v\n", test.name, ERR_NOTAUTH, test.expected, response.Header.Rcode, tsig)
+            continue
+        }
+
+        // only a request whose MAC checked out gets a signed answer
+        if test.signedReply {
+            if _, _, err = dns.VerifyTSIG(raw, server.Keys, signature.MAC, test.signed) ; err != nil {
+                t.Errorf("Response not signed:\n\tCase: %s\n\tGot: %v\n", test.name, err)
+            }
+            if len(tsig.OtherData) != 6 {
+                t.Errorf("Missing server time:\n\tCase: %s\n\tGot: %+v\n", test.name, tsig.OtherData)
+            }
+        } else if len(tsig.MAC) != 0 {
+            t.Errorf("Response signed:\n\tCase: %s\n\tGot: %x\n", test.name, tsig.MAC)
+        }
+    }
+
+    if _, err := server.Store.Find("new.zed.io", record.A_RECORD) ; err == nil {
+        t.Errorf("Unauthenticated update applied\n")
+    }
+}
+
+func TestServer_TSIGQuery(t *testing.T) {
+    var server, key = testTSIGServer(t)
+    defer server.Close()
+
+    // signed queries are answered signed, over TCP as well
+    var request, signature = testSign(t, testQuery(t, 1, "zed.io", record.SOA_RECORD), key, time.Now())
+
+    conn, err := net.Dial("tcp", server.Listener.Addr().String())
+    if err != nil { t.Fatal(err) }
+    defer conn.Close()
+    conn.SetDeadline(time.Now().Add(2 * time.Second))
+
+    conn.Write(append(dns.Uint16ToBytes(uint16(len(request))), request...))
+    var raw = testReadRawTCP(t, conn)
+
+    if tsig, _, err := dns.VerifyTSIG(raw, server.Keys, signature.MAC, time.Now()) ; tsig == nil || err != nil {
+        t.Errorf("Response not signed:\n\tGot: %+v %v\n", tsig, err)
+    }
+
+    // zone transfers need a signature once keys are configured
+    var transfer = testExchangeUDP(t, server, testQuery(t, 2, "zed.io", uint16(DNS_QUERY_AXFR)))
+    if transfer.Header.Rcode != ERR_REFUSED {
+        t.Errorf("Incorrect Rcode:\n\tExpected: %d\n\tGot: %d\n", ERR_REFUSED, transfer.Header.Rcode)
+    }
+}
+
+
+//----------------------------------------------
+// Zone Transfer Tests
+//----------------------------------------------
+
+//
+// A server holding zed.io, with a zone of its own delegated beneath it, that transfers to localhost
+//
+func testTransferServer(t *testing.T) *Server {
+    var server = newTestServer(t)
+    testZone(t, server)
+
+    var soa, _ = record.SOA("sub.zed.io", "ns1.sub.zed.io", "admin.zed.io", 60 * time.Second, 1, time.Hour, 10 * time.Minute, 24 * time.Hour, 30 * time.Second)
+    var ns, _ = record.NS("sub.zed.io", "ns1.sub.zed.io", 60 * time.Second)
+    sub, err := store.NewZone(soa, ns)
+    if err != nil { t.Fatal(err) }
+    if err = server.AddZone(sub) ; err != nil { t.Fatal(err) }
+
+    var hidden, _ = record.A("host.sub.zed.io", 60 * time.Second, net.ParseIP("10.1.0.1"))
+    server.Store.Add(hidden)
+
+    _, localhost, _ := net.ParseCIDR("127.0.0.0/8")
+    server.AllowTransfer = AllowFrom(localhost)
+    server.AllowUpdate = func(*Request, *store.Zone) bool { return true }
+    server.Journal = store.NewJournal(store.JOURNAL_LIMIT)
+    return server
+}
+
+//
+// Build a serialized IXFR query from a client holding the given serial
+//
+func testIXFR(t *testing.T, id uint16, zone string, serial uint32) []byte {
+    var soa, _ = record.SOA(zone, "ns1." + zone, "admin." + zone, 60 * time.Second, serial, time.Hour, 10 * time.Minute, 24 * time.Hour, 30 * time.Second)
+    var query = dns.Message{
+        Header:    dns.MessageHeader{ ID: id, QDCount: 1, NSCount: 1 },
+        Questions: dns.QuestionCollection{ { Name: zone, Type: uint16(DNS_QUERY_IXFR), Class: record.CLASS_IN } },
+        Ns:        []record.Record{ soa },
+    }
+
+    serialized, err := query.Serialize()
+    if err != nil { t.Fatal(err) }
+
+    return serialized
+}
+
+//
+// Send a transfer request over TCP and read messages until the closing SOA, returning them undecoded
+//
+func testTransfer(t *testing.T, server *Server, query []byte) [][]byte {
+    conn, err := net.Dial("tcp", server.Listener.Addr().String())
+    if err != nil { t.Fatal(err) }
+    defer conn.Close()
+    conn.SetDeadline(time.Now().Add(2 * time.Second))
+
+    conn.Write(append(dns.Uint16ToBytes(uint16(len(query))), query...))
+
+    var result = make([][]byte, 0)
+    var first *record.SOARecord
+    var count int
+    for {
+        var raw = testReadRawTCP(t, conn)
+        result = append(result, raw)
+
+        response, err := dns.UnpackMessage(raw)
+        if err != nil { t.Fatal(err) }
+        if response.Header.Rcode != 0 || len(response.Answers) == 0 { return result }
+
+        if first == nil { first, _ = response.Answers[0].(*record.SOARecord) }
+        count += len(response.Answers)
+
+        // a lone SOA, or the first SOA repeated at the end
+        var last, isSOA = response.Answers[len(response.Answers) - 1].(*record.SOARecord)
+        if first == nil || count == 1 || (isSOA && count > 1 && last.Serial == first.Serial) { return result }
+    }
+}
+
+//
+// Decode the messages of a transfer and join their answers
+//
+func testTransferRecords(t *testing.T, messages [][]byte) []record.Record {
+    var result = make([]record.Record, 0)
+    for _, raw := range messages {
+        response, err := dns.UnpackMessage(raw)
+        if err != nil { t.Fatal(err) }
+        result = append(result, response.Answers...)
+    }
+
+    return result
+}
+
+//
+// Describe records by type and serial (for SOAs), for comparing the shape of a transfer
+//
+func testTransferShape(records []record.Record) string {
+    var parts = make([]string, 0, len(records))
+    for _, rec := range records {
+        if soa, isSOA := rec.(*record.SOARecord) ; isSOA {
+            parts = append(parts, fmt.Sprintf("SOA%d", soa.Serial))
+        } else {
+            parts = append(parts, record.TypeIntToString[rec.GetType()] + ":" + rec.GetLabel())
+        }
+    }
+
+    return strings.Join(parts, " ")
+}
+
+func TestServer_AXFR(t *testing.T) {
+    var server = testTransferServer(t)
+    for i := 0 ; i < 500 ; i++ {
+        var rec, _ = record.A(fmt.Sprintf("host-%03d.a-rather-long-label-to-fill-messages.zed.io", i), 60 * time.Second, net.ParseIP("10.0.0.1"))
+        server.Store.Add(rec)
+    }
+    testStart(server)
+    defer server.Close()
+
+    var messages = testTransfer(t, server, testQuery(t, 1, "zed.io", uint16(DNS_QUERY_AXFR)))
+    if len(messages) < 2 {
+        t.Errorf("Incorrect Messages:\n\tExpected: %s\n\tGot: %d\n", "more than one", len(messages))
+    }
+
+    // only the first message carries the question
+    for i, raw := range messages {
+        response, err := dns.UnpackMessage(raw)
+        if err != nil { t.Fatal(err) }
+
+        if response.Header.ID != 1 || !response.Header.Authoritative || len(response.Questions) != map[bool]int{ true: 1, false: 0 }[i == 0] {
+            t.Errorf("Incorrect Message %d:\n\tExpected: %s\n\tGot: %+v %+v\n", i, "ID 1, AA, question only first", response.Header, response.Questions)
+        }
+    }
+
+    // SOA, the apex A and NS, the test server's SRVs, every host, the delegation to sub.zed.io, then the SOA again
+    var records = testTransferRecords(t, messages)
+    if len(records) != 545 {
+        t.Errorf("Incorrect Record Count:\n\tExpected: %d\n\tGot: %d\n", 545, len(records))
+    }
+
+    var soas, delegated int
+    for _, rec := range records {
+        switch {
+            case rec.GetType() == record.SOA_RECORD:
+                soas += 1
+                if !record.NamesEqual(rec.GetLabel(), "zed.io") { t.Errorf("Transferred foreign SOA: %s\n", rec.GetLabel()) }
+            case rec.GetLabel() == "host.sub.zed.io":
+                t.Errorf("Transferred a record of another zone\n")
+            case rec.GetType() == record.NS_RECORD && rec.GetLabel() == "sub.zed.io":
+                delegated += 1
+        }
+    }
+
+    if soas != 2 || records[0].GetType() != record.SOA_RECORD || records[len(records) - 1].GetType() != record.SOA_RECORD {
+        t.Errorf("Incorrect Framing:\n\tExpected: %s\n\tGot: %d SOAs\n", "SOA first and last", soas)
+    }
+    if delegated != 1 {
+        t.Errorf("Incorrect Delegation:\n\tExpected: %d\n\tGot: %d\n", 1, delegated)
+    }
+}
+
+func TestServer_AXFRRefused(t *testing.T) {
+    var server = testTransferServer(t)
+    testStart(server)
+    defer server.Close()
+
+    // never over UDP
+    var response = testExchangeUDP(t, server, testQuery(t, 1, "zed.io", uint16(DNS_QUERY_AXFR)))
+    if response.Header.Rcode != ERR_NOIMPL {
+        t.Errorf("Incorrect UDP Rcode:\n\tExpected: %d\n\tGot: %d\n", ERR_NOIMPL, response.Header.Rcode)
+    }
+
+    // only for the apex of a zone we hold
+    var cases = []struct {
+        name        string
+        expected    int
+    }{
+        { "app.zed.io", ERR_NOTAUTH },
+        { "example.com", ERR_NOTAUTH },
+    }
+
+    for _, test := range cases {
+        var messages = testTransfer(t, server, testQuery(t, 2, test.name, uint16(DNS_QUERY_AXFR)))
+        response, err := dns.UnpackMessage(messages[0])
+        if err != nil { t.Fatal(err) }
+
+        if len(messages) != 1 || response.Header.Rcode != test.expected || len(response.Answers) != 0 {
+            t.Errorf("Incorrect Response:\n\tCase: %s\n\tExpected: %d\n\tGot: %d (%d messages)\n", test.name, test.expected, response.Header.Rcode, len(messages))
+        }
+    }
+}
+
+func TestServer_AXFRPolicy(t *testing.T) {
+    var server = testTransferServer(t)
+    _, elsewhere, _ := net.ParseCIDR("192.0.2.0/24")
+    server.AllowTransfer = AllowFrom(elsewhere)
+    testStart(server)
+    defer server.Close()
+
+    var messages = testTransfer(t, server, testQuery(t, 1, "zed.io", uint16(DNS_QUERY_AXFR)))
+    response, err := dns.UnpackMessage(messages[0])
+    if err != nil { t.Fatal(err) }
+
+    if response.Header.Rcode != ERR_REFUSED || len(response.Answers) != 0 {
+        t.Errorf("Incorrect Response:\n\tExpected: %d\n\tGot: %d, %d answers\n", ERR_REFUSED, response.Header.Rcode, len(response.Answers))
+    }
+
+    // no policy refuses everyone
+    var closed = testTransferServer(t)
+    closed.AllowTransfer = nil
+    testStart(closed)
+    defer closed.Close()
+
+    response, err = dns.UnpackMessage(testTransfer(t, closed, testQuery(t, 1, "zed.io", uint16(DNS_QUERY_AXFR)))[0])
+    if err != nil { t.Fatal(err) }
+    if response.Header.Rcode != ERR_REFUSED {
+        t.Errorf("Incorrect Rcode:\n\tExpected: %d\n\tGot: %d\n", ERR_REFUSED, response.Header.Rcode)
+    }
+}
+
+func TestServer_IXFR(t *testing.T) {
+    var server = testTransferServer(t)
+    testStart(server)
+    defer server.Close()
+
+    // serial 1 -> 2 adds two hosts, 2 -> 3 removes one and changes the other's TTL
+    var first, _ = record.A("one.zed.io", 30 * time.Second, net.ParseIP("10.0.0.1"))
+    var second, _ = record.A("two.zed.io", 30 * time.Second, net.ParseIP("10.0.0.2"))
+    var longer, _ = record.A("two.zed.io", 90 * time.Second, net.ParseIP("10.0.0.2"))
+
+    var updates = [][]record.Record{
+        { first, second },
+        { testInClass(first, record.CLASS_NONE), longer },
+    }
+    for i, update := range updates {
+        var response = testExchangeUDP(t, server, testUpdate(t, uint16(i + 1), "zed.io", nil, update))
+        if response.Header.Rcode != 0 { t.Fatalf("Update %d failed with rcode %d\n", i, response.Header.Rcode) }
+    }
+
+    var cases = []struct {
+        name        string
+        serial      uint32
+        expected    string
+    }{
+        { "from the start", 1, "SOA3 SOA1 SOA2 A:one.zed.io A:two.zed.io SOA2 A:one.zed.io A:two.zed.io SOA3 A:two.zed.io SOA3" },
+        { "from the middle", 2, "SOA3 SOA2 A:one.zed.io A:two.zed.io SOA3 A:two.zed.io SOA3" },
+        { "up to date", 3, "SOA3" },
+        { "ahead of us", 7, "SOA3" },
+    }
+
+    for _, test := range cases {
+        var records = testTransferRecords(t, testTransfer(t, server, testIXFR(t, 10, "zed.io", test.serial)))
+        if shape := testTransferShape(records) ; shape != test.expected {
+            t.Errorf("Incorrect Transfer:\n\tCase: %s\n\tExpected: %s\n\tGot: %s\n", test.name, test.expected, shape)
+        }
+    }
+
+    // the changed TTL travels with the record
+    var records = testTransferRecords(t, testTransfer(t, server, testIXFR(t, 11, "zed.io", 2)))
+    if ttl := record.HeaderOf(records[len(records) - 2]).TTL ; ttl != 90 * time.Second {
+        t.Errorf("Incorrect TTL:\n\tExpected: %v\n\tGot: %v\n", 90 * time.Second, ttl)
+    }
+
+    // over UDP the client is only told the current version
+    var response = testExchangeUDP(t, server, testIXFR(t, 12, "zed.io", 1))
+    if shape := testTransferShape(response.Answers) ; shape != "SOA3" {
+        t.Errorf("Incorrect UDP Transfer:\n\tExpected: %s\n\tGot: %s\n", "SOA3", shape)
+    }
+}
+
+func TestServer_IXFRFallback(t *testing.T) {
+    var server = testTransferServer(t)
+    testStart(server)
+    defer server.Close()
+
+    var host, _ = record.A("one.zed.io", 30 * time.Second, net.ParseIP("10.0.0.1"))
+    if response := testExchangeUDP(t, server, testUpdate(t, 1, "zed.io", nil, []record.Record{ host })) ; response.Header.Rcode != 0 {
+        t.Fatalf("Update failed with rcode %d\n", response.Header.Rcode)
+    }
+
+    // a serial the journal never saw gets the whole zone, just as AXFR sends it
+    var expected = testTransferShape(testTransferRecords(t, testTransfer(t, server, testQuery(t, 4, "zed.io", uint16(DNS_QUERY_AXFR)))))
+    if !strings.Contains(expected, "A:one.zed.io") || !strings.HasPrefix(expected, "SOA2 ") {
+        t.Fatalf("Incorrect AXFR:\n\tExpected: %s\n\tGot: %s\n", "SOA2 ... A:one.zed.io ... SOA2", expected)
+    }
+
+    var records = testTransferRecords(t, testTransfer(t, server, testIXFR(t, 1, "zed.io", 0)))
+    if shape := testTransferShape(records) ; !testSameRecords(shape, expected) {
+        t.Errorf("Incorrect Transfer:\n\tExpected: %s\n\tGot: %s\n", expected, shape)
+    }
+
+    // as does every client once the journal is forgotten
+    server.Journal.Clear("zed.io")
+    records = testTransferRecords(t, testTransfer(t, server, testIXFR(t, 2, "zed.io", 1)))
+    if shape := testTransferShape(records) ; !testSameRecords(shape, expected) {
+        t.Errorf("Incorrect Transfer:\n\tExpected: %s\n\tGot: %s\n", expected, shape)
+    }
+
+    // without the client's SOA there is nothing to go on
+    var query = testQuery(t, 3, "zed.io", uint16(DNS_QUERY_IXFR))
+    response, err := dns.UnpackMessage(testTransfer(t, server, query)[0])
+    if err != nil { t.Fatal(err) }
+    if response.Header.Rcode != ERR_FORMAT {
+        t.Errorf("Incorrect Rcode:\n\tExpected: %d\n\tGot: %d\n", ERR_FORMAT, response.Header.Rcode)
+    }
+}
+
+//
+// Compare transfer shapes with SOAs fixed at either end, ignoring the order of the records between
+//
+func testSameRecords(got, expected string) bool {
+    var left, right = strings.Fields(got), strings.Fields(expected)
+    if len(left) != len(right) || len(left) < 2 { return false }
+    if left[0] != right[0] || left[len(left) - 1] != right[len(right) - 1] { return false }
+
+    var counts = make(map[string]int, 0)
+    for _, part := range left { counts[part] += 1 }
+    for _, part := range right { counts[part] -= 1 }
+    for _, count := range counts {
+        if count != 0 { return false }
+    }
+
+    return true
+}
+
+func TestServer_TransferTSIG(t *testing.T) {
+    var server, key = testTSIGServer(t)
+    defer server.Close()
+    server.AllowTransfer = AllowWithKey("update.zed.io")
+
+    for i := 0 ; i < 500 ; i++ {
+        var rec, _ = record.A(fmt.Sprintf("host-%03d.a-rather-long-label-to-fill-messages.zed.io", i), 60 * time.Second, net.ParseIP("10.0.0.1"))
+        server.Store.Add(rec)
+    }
+
+    var request, signature = testSign(t, testQuery(t, 1, "zed.io", uint16(DNS_QUERY_AXFR)), key, time.Now())
+    var messages = testTransfer(t, server, request)
+    if len(messages) < 2 {
+        t.Fatalf("Incorrect Messages:\n\tExpected: %s\n\tGot: %d\n", "more than one", len(messages))
+    }
+
+    // the first answer is signed over the request's MAC, each after over the MAC before it
+    tsig, _, err := dns.VerifyTSIG(messages[0], server.Keys, signature.MAC, time.Now())
+    if tsig == nil || err != nil {
+        t.Fatalf("First message not signed:\n\tGot: %+v %v\n", tsig, err)
+    }
+
+    for i, raw := range messages[1:] {
+        var next *record.TSIGRecord
+        next, _, err = dns.VerifyTSIGContinued(raw, server.Keys, tsig.MAC, time.Now())
+        if next == nil || err != nil {
+            t.Fatalf("Message %d not signed:\n\tGot: %+v %v\n", i + 1, next, err)
+        }
+        tsig = next
+    }
+
+    // an unsigned request is not enough when keys are configured, even from an allowed address
+    var unsigned = testTransfer(t, server, testQuery(t, 2, "zed.io", uint16(DNS_QUERY_AXFR)))
+    response, err := dns.UnpackMessage(unsigned[0])
+    if err != nil { t.Fatal(err) }
+    if response.Header.Rcode != ERR_REFUSED {
+        t.Errorf("Incorrect Rcode:\n\tExpected: %d\n\tGot: %d\n", ERR_REFUSED, response.Header.Rcode)
+    }
+}
+
+//
+// A map store whose batches refuse records at one name, to fail a change part way through
+//
+type testFailingStore struct {
+    *store.MapStore
+    label           string
+}
+
+type testFailingBatch struct {
+    store.DNSStore
+    label           string
+}
+
+var errTestRefused = errors.New("ERROR: refused by the test store")
+
+func (self *testFailingStore) Batch(fn func(store.DNSStore) error) error {
+    return self.MapStore.Batch(func(batch store.DNSStore) error {
+        return fn(&testFailingBatch{ batch, self.label })
+    })
+}
+
+func (self *testFailingBatch) Add(rec record.Record) error {
+    if record.NamesEqual(rec.GetLabel(), self.label) { return errTestRefused }
+    return self.DNSStore.Add(rec)
+}
+
+func TestServer_ReplaceZones(t *testing.T) {
+    var server = newTestServer(t)
+    server.Store = &testFailingStore{ store.Map(), "broken.zed.io" }
+
+    var version = func(origin string, serial int, extra string) (*store.Zone, []record.Record) {
+        var file = "$ORIGIN " + origin + ".\n$TTL 60\n" +
+            "@      SOA  ns1 hostmaster ( " + strconv.Itoa(serial) + " 3600 600 86400 60 )\n" +
+            "       NS   ns1\n" +
+            "v" + strconv.Itoa(serial) + "    A    10.0.0.1\n" + extra
+
+        zone, records, err := ReadZone(strings.NewReader(file), origin)
+        if err != nil { t.Fatal(err) }
+        return zone, records
+    }
+
+    var zed, zedRecords = version("zed.io", 1, "")
+    var other, otherRecords = version("example.org", 1, "")
+    if err := server.ReplaceZones([]*store.Zone{ zed, other }, [][]record.Record{ zedRecords, otherRecords }, nil) ; err != nil {
+        t.Fatal(err)
+    }
+
+    // the second zone fails, so neither the first nor the removal is applied
+    var org, orgRecords = version("example.org", 2, "")
+    var broken, brokenRecords = version("zed.io", 2, "broken A 10.0.0.2\n")
+    var err = server.ReplaceZones([]*store.Zone{ org, broken }, [][]record.Record{ orgRecords, brokenRecords }, []string{ "zed.io" })
+    if err != errTestRefused {
+        t.Errorf("Incorrect Error:\n\tExpected: %v\n\tGot: %v\n", errTestRefused, err)
+    }
+
+    for _, origin := range []string{ "zed.io", "example.org" } {
+        if zone := server.Zones.Find(origin) ; zone == nil || zone.SOA.Serial != 1 {
+            t.Errorf("Incorrect Zone After a Failed Swap (%s):\n\tExpected: serial %d\n\tGot: %+v\n", origin, 1, zone)
+        }
+        if _, err := server.Store.Find("v1." + origin, record.A_RECORD) ; err != nil {
+            t.Errorf("Record lost in a failed swap (%s): %v\n", origin, err)
+        }
+        if _, err := server.Store.Find("v2." + origin, record.A_RECORD) ; err == nil {
+            t.Errorf("Record kept from a failed swap (%s)\n", origin)
+        }
+    }
+
+    // and once it succeeds, all of it is
+    err = server.ReplaceZones([]*store.Zone{ org }, [][]record.Record{ orgRecords }, []string{ "zed.io", "missing.zed.io" })
+    if err != nil { t.Fatal(err) }
+
+    if server.Zones.Find("v1.zed.io") != nil || server.Zones.Find("example.org").SOA.Serial != 2 {
+        t.Errorf("Incorrect Zones:\n\tExpected: %s\n\tGot: %+v\n", "only example.org, at serial 2", server.Zones.Backing)
+    }
+    if _, err := server.Store.Find("v1.zed.io", record.A_RECORD) ; err == nil {
+        t.Errorf("Record kept after its zone was removed\n")
+    }
+}
@@ -0,0 +1,288 @@
+package store
+
+import (
+    "io"
+    "os"
+    "fmt"
+    "sort"
+    "sync"
+    "time"
+    "errors"
+    "context"
+    "strings"
+    "path/filepath"
+
+    "github.com/zmarcantel/phonebook/dns/record"
+    "github.com/zmarcantel/phonebook/server/logging"
+)
+
+const (
+    ZONE_FILE_SUFFIX    string          = ".zone"
+
+    // quiet time after a change before the directory is re-read, so a burst of writes is read once
+    DIRECTORY_DEBOUNCE  time.Duration   = 500 * time.Millisecond
+)
+
+var ErrWatching     error   = errors.New("ERROR: The directory is already being watched")
+
+//----------------------------------------------
+// Directory Store
+//----------------------------------------------
+
+//
+// A store serving the zone files in a directory, re-read whenever they change
+//
+// Every "<origin>.zone" file (hidden files aside) is parsed with its name as the starting $ORIGIN,
+// and its records added to the store. When a file is written, moved into place, or removed, the files
+// that changed are parsed again and their old records swapped for the new as one change,
+// so lookups see each reload entirely or not at all
+//
+// A file that fails to parse is logged and its previous records kept until it is fixed.
+// Records added by other means (the API, UPDATEs) stay, as do those of unchanged files. A record from a file that is
+// changed or removed goes with it, matched by name, type, and data -- so it goes even if deleted and added again,
+// or given a new TTL, since. One whose data was changed since belongs to whoever changed it, and stays
+//
+// Changes are noticed with inotify on Linux, and by checking every DIRECTORY_POLL elsewhere
+//
+type DirectoryStore struct {
+    *MapStore
+
+    Path            string
+    Debounce        time.Duration           // zero uses DIRECTORY_DEBOUNCE
+
+    // called after a reload changes the store, from the goroutine watching the directory
+    Reloaded        func()
+
+    files           map[string]*watchedFile
+    loading         sync.Mutex
+
+    watcher         *directoryWatcher
+    watching        sync.Mutex
+}
+
+// what was last read from a file -- its records are those of the last time it parsed
+type watchedFile struct {
+    modified        time.Time
+    size            int64
+    records         []record.Record
+}
+
+func Directory(path string) *DirectoryStore {
+    return &DirectoryStore{
+        MapStore:   Map(),
+        Path:       path,
+        files:      make(map[string]*watchedFile, 0),
+    }
+}
+
+//
+// Read the directory, parsing the zone files that are new or changed since it was last read
+// Returns the first error met -- files that parse are loaded regardless
+//
+func (self *DirectoryStore) Load() error {
+    var changed, err = self.load()
+    if changed && self.Reloaded != nil { self.Reloaded() }
+
+    return err
+}
+
+func (self *DirectoryStore) load() (bool, error) {
+    self.loading.Lock()
+    defer self.loading.Unlock()
+
+    var logger = logging.Or(self.Logger)
+    var ctx = context.Background()
+
+    entries, err := os.ReadDir(self.Path)
+    if err != nil {
+        logging.Error(logger, ctx, "could not read the zone directory", logging.F("path", self.Path), logging.F(logging.FIELD_ERROR, err))
+        return false, err
+    }
+
+    var removed = make([]record.Record, 0)
+    var added = make([]record.Record, 0)
+    var seen = make(map[string]bool, 0)
+    var reread = 0
+    var failed error
+
+    for _, entry := range entries {
+        var name = entry.Name()
+        if !IsZoneFile(name) { continue }
+
+        // follow links, and skip anything gone since the listing
+        var info, err = os.Stat(filepath.Join(self.Path, name))
+        if err != nil || !info.Mode().IsRegular() { continue }
+        seen[name] = true
+
+        var previous = self.files[name]
+        if previous != nil && previous.modified.Equal(info.ModTime()) && previous.size == info.Size() { continue }
+
+        var current = &watchedFile{ modified: info.ModTime(), size: info.Size() }
+        self.files[name] = current
+
+        records, err := self.parse(name)
+        if err != nil {
+            logging.Error(logger, ctx, "could not load zone file -- keeping its previous records",
+                logging.F("file", name), logging.F(logging.FIELD_ERROR, err))
+            if failed == nil { failed = err }
+
+            if previous != nil { current.records = previous.records }
+            continue
+        }
+
+        if previous != nil { removed = append(removed, previous.records...) }
+        added = append(added, records...)
+        current.records = records
+        reread += 1
+    }
+
+    for name, file := range self.files {
+        if seen[name] { continue }
+
+        removed = append(removed, file.records...)
+        delete(self.files, name)
+        reread += 1
+    }
+
+    if reread == 0 { return false, failed }
+
+    self.exchange(removed, added)
+    logging.Info(logger, ctx, "zone files loaded", logging.F("files", reread), logging.F("records", len(added)))
+    return true, failed
+}
+
+//
+// Parse a zone file, its name (less the suffix) being the starting $ORIGIN
+//
+func (self *DirectoryStore) parse(name string) ([]record.Record, error) {
+    source, err := os.Open(filepath.Join(self.Path, name))
+    if err != nil { return nil, err }
+    defer source.Close()
+
+    records, err := ParseZone(source, strings.TrimSuffix(name, ZONE_FILE_SUFFIX))
+    if err != nil { return nil, errors.New(fmt.Sprintf("could not load %s: %s", name, err)) }
+
+    return records, nil
+}
+
+//
+// The zones the files describe: each SOA, with the NS records at its name in the same file
+//
+func (self *DirectoryStore) Zones() []*Zone {
+    self.loading.Lock()
+    defer self.loading.Unlock()
+
+    var result = make([]*Zone, 0, len(self.files))
+    for _, file := range self.files {
+        for _, rec := range file.records {
+            var soa, ok = rec.(*record.SOARecord)
+            if !ok { continue }
+
+            var ns = make([]*record.NSRecord, 0)
+            for _, other := range file.records {
+                if typed, ok := other.(*record.NSRecord) ; ok && record.NamesEqual(typed.GetLabel(), soa.GetLabel()) {
+                    ns = append(ns, typed)
+                }
+            }
+
+            zone, _ := NewZone(soa, ns...)
+            result = append(result, zone)
+        }
+    }
+
+    sort.Slice(result, func(i, j int) bool {
+        return labelLess(result[i].Origin, result[j].Origin)
+    })
+
+    return result
+}
+
+//
+// Check if a file name is one the store serves: "<origin>.zone", and not hidden
+// (editors and config-management tools write their temporary files as dot files)
+//
+func IsZoneFile(name string) bool {
+    return strings.HasSuffix(name, ZONE_FILE_SUFFIX) && len(name) > len(ZONE_FILE_SUFFIX) && !strings.HasPrefix(name, ".")
+}
+
+//----------------------------------------------
+// Watching
+//----------------------------------------------
+
+//
+// Load the directory, then keep reloading it as it changes until Close
+//
+func (self *DirectoryStore) Watch() error {
+    self.watching.Lock()
+    defer self.watching.Unlock()
+
+    if self.watcher != nil { return ErrWatching }
+
+    // watch before the first load, so nothing changed in between is missed
+    var events, closer, err = watchDirectory(self.Path)
+    if err != nil { return err }
+
+    if err = self.Load() ; err != nil {
+        closer.Close()
+        return err
+    }
+
+    self.watcher = &directoryWatcher{ closer: closer, done: make(chan struct{}) }
+    go self.watch(events, self.watcher.done)
+    return nil
+}
+
+//
+// Stop watching the directory -- the store keeps its records, and may be watched again
+//
+func (self *DirectoryStore) Close() error {
+    self.watching.Lock()
+    defer self.watching.Unlock()
+
+    if self.watcher == nil { return nil }
+
+    var err = self.watcher.closer.Close()
+    <-self.watcher.done
+
+    self.watcher = nil
+    return err
+}
+
+type directoryWatcher struct {
+    closer          io.Closer
+    done            chan struct{}
+}
+
+//
+// Reload once events stop arriving for the debounce period -- until the events channel closes
+//
+func (self *DirectoryStore) watch(events <-chan struct{}, done chan struct{}) {
+    defer close(done)
+
+    var debounce = self.Debounce
+    if debounce <= 0 { debounce = DIRECTORY_DEBOUNCE }
+
+    var timer *time.Timer
+    var fire <-chan time.Time
+    defer func() {
+        if timer != nil { timer.Stop() }
+    }()
+
+    for {
+        select {
+            case _, ok := <-events:
+                if !ok { return }
+
+                // every event restarts the wait
+                if timer != nil { timer.Stop() }
+                timer = time.NewTimer(debounce)
+                fire = timer.C
+
+            case <-fire:
+                fire = nil
+
+                // errors are logged as they are met, and what was loaded before is kept
+                self.Load()
+        }
+    }
+}
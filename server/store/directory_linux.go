@@ -0,0 +1,50 @@
+//go:build linux
+
+package store
+
+import (
+    "io"
+    "os"
+    "syscall"
+)
+
+// anything that may change which files are in the directory, or what they hold
+const INOTIFY_EVENTS uint32 = syscall.IN_CLOSE_WRITE | syscall.IN_MOVED_TO | syscall.IN_MOVED_FROM |
+    syscall.IN_CREATE | syscall.IN_DELETE | syscall.IN_ATTRIB | syscall.IN_DELETE_SELF | syscall.IN_MOVE_SELF
+
+//
+// Watch a directory with inotify, sending on the channel (without blocking) whenever something in it changes
+// The channel is closed once the returned closer is
+//
+// Events are not told apart -- the store re-reads the directory and compares the files itself,
+// which also catches files replaced beneath a symlink
+//
+func watchDirectory(path string) (<-chan struct{}, io.Closer, error) {
+    fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+    if err != nil { return nil, nil, os.NewSyscallError("inotify_init1", err) }
+
+    if _, err = syscall.InotifyAddWatch(fd, path, INOTIFY_EVENTS) ; err != nil {
+        syscall.Close(fd)
+        return nil, nil, &os.PathError{ Op: "inotify_add_watch", Path: path, Err: err }
+    }
+
+    // a non-blocking descriptor is read through the runtime's poller, so Close interrupts a read
+    var events = os.NewFile(uintptr(fd), "inotify")
+    var changes = make(chan struct{}, 1)
+
+    go func() {
+        defer close(changes)
+
+        var buffer = make([]byte, 64 * (syscall.SizeofInotifyEvent + syscall.NAME_MAX + 1))
+        for {
+            if _, err := events.Read(buffer) ; err != nil { return }
+
+            select {
+                case changes <- struct{}{}:
+                default:
+            }
+        }
+    }()
+
+    return changes, events, nil
+}
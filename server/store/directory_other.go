@@ -0,0 +1,53 @@
+//go:build !linux
+
+package store
+
+import (
+    "io"
+    "sync"
+    "time"
+)
+
+// how often a directory is checked for changes where inotify is not available
+const DIRECTORY_POLL time.Duration = 5 * time.Second
+
+//
+// Without inotify, signal every DIRECTORY_POLL -- the store compares the files itself,
+// so a check that finds nothing changed costs a directory listing
+// The channel is closed once the returned closer is
+//
+func watchDirectory(path string) (<-chan struct{}, io.Closer, error) {
+    var changes = make(chan struct{}, 1)
+    var poller = &directoryPoller{ stop: make(chan struct{}) }
+
+    go func() {
+        defer close(changes)
+
+        var ticker = time.NewTicker(DIRECTORY_POLL)
+        defer ticker.Stop()
+
+        for {
+            select {
+                case <-poller.stop:
+                    return
+                case <-ticker.C:
+                    select {
+                        case changes <- struct{}{}:
+                        default:
+                    }
+            }
+        }
+    }()
+
+    return changes, poller, nil
+}
+
+type directoryPoller struct {
+    stop            chan struct{}
+    once            sync.Once
+}
+
+func (self *directoryPoller) Close() error {
+    self.once.Do(func() { close(self.stop) })
+    return nil
+}
@@ -0,0 +1,589 @@
+package store
+
+import (
+    "os"
+    "io"
+    "fmt"
+    "sync"
+    "bytes"
+    "errors"
+    "hash/crc32"
+    "context"
+    "path/filepath"
+    "encoding/binary"
+
+    "github.com/zmarcantel/phonebook/dns/record"
+    "github.com/zmarcantel/phonebook/server/logging"
+)
+
+const (
+    DISK_MAGIC          string      = "PBDB"
+    DISK_VERSION        uint16      = 1
+
+    // the log is compacted once it holds this many changes, and more than twice as many as there are records
+    DISK_COMPACT_AFTER  int         = 4096
+
+    // records written per entry when compacting
+    DISK_COMPACT_BATCH  int         = 1024
+
+    DISK_HEADER_SIZE    int         = 8     // magic, version, and two reserved bytes
+    DISK_ENTRY_HEADER   int         = 8     // payload length and checksum
+)
+
+// operations in an entry of the file
+const (
+    DISK_ADD            byte        = 1     // record
+    DISK_DELETE         byte        = 2     // name and type -- the first record of the type at the name
+    DISK_REPLACE        byte        = 3     // name, type, and the record that replaces the first of the type there
+)
+
+var ErrStoreClosed      error       = errors.New("ERROR: The store is closed")
+var ErrDiskFormat       error       = errors.New("ERROR: Not a phonebook store file")
+var ErrDiskVersion      error       = errors.New("ERROR: The store file was written by a newer version")
+var ErrDiskEntry        error       = errors.New("ERROR: Malformed entry in the store file")
+var ErrDiskCorrupt      error       = errors.New("ERROR: The store file is damaged before its last entry -- restore it from a backup")
+
+var diskTable = crc32.MakeTable(crc32.Castagnoli)
+
+//----------------------------------------------
+// Disk Store
+//----------------------------------------------
+
+//
+// A store kept in a file, so records added at runtime survive a restart
+//
+// Records are served from memory (a MapStore), and every change is first appended to the file as
+// a checksummed entry and synced. A batch (see Batcher) is one entry, so it is kept whole or not at all.
+// Opening the file replays it. A last entry cut short by a crash (running to the end of the file) is dropped,
+// but one failing its checksum with whole entries after it means the file was damaged: opening fails
+// rather than drop the changes that follow
+//
+// The file is rewritten with only the records it holds once it grows well past them -- to a temporary
+// file, synced, and renamed over the original, so a crash leaves either the old file or the new
+//
+// Records are stored in their wire format (uncompressed), behind a format version in the file's header
+//
+type DiskStore struct {
+    Path            string
+
+    Logger          logging.Logger          // nil uses logging.Default()
+
+    index           *MapStore
+    file            *os.File
+    size            int64                   // where the next entry goes
+    changes         int                     // changes in the file since it was last compacted
+
+    // writers take this lock (lookups only the index's), so changes reach the file in the order they are made
+    lock            sync.Mutex
+}
+
+//
+// Open (or create) the store file at the given path
+//
+func OpenDisk(path string) (*DiskStore, error) {
+    file, err := os.OpenFile(path, os.O_RDWR | os.O_CREATE, 0600)
+    if err != nil { return nil, err }
+
+    var result = &DiskStore{ Path: path, index: Map(), file: file }
+    if err = result.replay() ; err != nil {
+        file.Close()
+        return nil, err
+    }
+
+    result.maybeCompact()
+    return result, nil
+}
+
+//
+// Read the file into the index, dropping a torn last entry -- a damaged one before others fails with ErrDiskCorrupt
+//
+func (self *DiskStore) replay() error {
+    contents, err := io.ReadAll(self.file)
+    if err != nil { return err }
+
+    // a new file gets its header
+    if len(contents) == 0 {
+        if _, err = self.file.Write(diskHeader()) ; err != nil { return err }
+
+        self.size = int64(DISK_HEADER_SIZE)
+        return self.file.Sync()
+    }
+
+    if len(contents) < DISK_HEADER_SIZE || string(contents[:4]) != DISK_MAGIC { return ErrDiskFormat }
+    if binary.BigEndian.Uint16(contents[4:]) > DISK_VERSION { return ErrDiskVersion }
+
+    var offset = DISK_HEADER_SIZE
+    for offset < len(contents) {
+        // one failing with more after it, or claiming more than is left when whole entries follow, was damaged
+        payload, size, ok := diskEntry(contents[offset:])
+        if !ok && (offset + size < len(contents) || diskEntryWithin(contents[offset:])) {
+            logging.Error(logging.Or(self.Logger), context.Background(), "store file entry is damaged, with entries after it",
+                logging.F("path", self.Path), logging.F("offset", offset))
+            return ErrDiskCorrupt
+        }
+        if !ok { break }
+
+        if err = self.apply(payload) ; err != nil { return err }
+        offset += size
+    }
+
+    // whatever follows the last whole entry never finished being written
+    if offset < len(contents) {
+        logging.Warn(logging.Or(self.Logger), context.Background(), "dropping an incomplete entry from the store file",
+            logging.F("path", self.Path), logging.F("bytes", len(contents) - offset))
+
+        if err = self.file.Truncate(int64(offset)) ; err != nil { return err }
+        if err = self.file.Sync() ; err != nil { return err }
+    }
+
+    self.size = int64(offset)
+    _, err = self.file.Seek(self.size, io.SeekStart)
+    return err
+}
+
+//
+// Split the entry at the start of the data from its header, checking it, and give its size with the header
+// False if it fails its checksum, or is cut short -- in which case its size is all the data left
+//
+func diskEntry(data []byte) ([]byte, int, bool) {
+    if len(data) < DISK_ENTRY_HEADER { return nil, len(data), false }
+
+    var length = int(binary.BigEndian.Uint32(data))
+    var checksum = binary.BigEndian.Uint32(data[4:])
+    if length > len(data) - DISK_ENTRY_HEADER { return nil, len(data), false }
+
+    var payload = data[DISK_ENTRY_HEADER:DISK_ENTRY_HEADER + length]
+    return payload, DISK_ENTRY_HEADER + length, crc32.Checksum(payload, diskTable) == checksum
+}
+
+//
+// Whether a whole entry starts anywhere past the start of the data -- never so in what a torn write left behind
+//
+func diskEntryWithin(data []byte) bool {
+    for start := 1 ; start + DISK_ENTRY_HEADER < len(data) ; start++ {
+        if payload, _, ok := diskEntry(data[start:]) ; ok && len(payload) > 0 { return true }
+    }
+    return false
+}
+
+//
+// Apply the operations of an entry to the index
+//
+func (self *DiskStore) apply(payload []byte) error {
+    var reader = &diskReader{ data: payload }
+
+    for !reader.done() {
+        var err error
+        switch reader.byte() {
+            case DISK_ADD:
+                var rec = reader.record()
+                if reader.err == nil { err = self.index.Add(rec) }
+
+            case DISK_DELETE:
+                var name, rType = reader.string(), reader.uint16()
+                if reader.err == nil { err = self.index.FindAndDelete(name, rType) }
+
+            case DISK_REPLACE:
+                var name, rType, rec = reader.string(), reader.uint16(), reader.record()
+                if reader.err == nil { err = self.index.FindAndReplace(name, rType, rec) }
+
+            default:
+                return ErrDiskEntry
+        }
+
+        if reader.err != nil { return reader.err }
+        if err != nil { return errors.New(fmt.Sprintf("ERROR: Could not replay the store file: %s", err)) }
+        self.changes += 1
+    }
+
+    return nil
+}
+
+//
+// Append an entry to the file and sync it -- the caller holds the lock
+//
+func (self *DiskStore) write(payload []byte, changes int) error {
+    if self.file == nil { return ErrStoreClosed }
+
+    var err = writeEntry(self.file, payload)
+    if err == nil { err = self.file.Sync() }
+    if err != nil {
+        // cut off what made it, so the entries after are not lost behind it -- or stop writing, if even that fails
+        if self.file.Truncate(self.size) != nil {
+            self.file.Close()
+            self.file = nil
+        } else {
+            self.file.Seek(self.size, io.SeekStart)
+        }
+        return err
+    }
+
+    self.size += int64(DISK_ENTRY_HEADER + len(payload))
+    self.changes += changes
+    return nil
+}
+
+func writeEntry(file io.Writer, payload []byte) error {
+    var entry = make([]byte, DISK_ENTRY_HEADER, DISK_ENTRY_HEADER + len(payload))
+    binary.BigEndian.PutUint32(entry, uint32(len(payload)))
+    binary.BigEndian.PutUint32(entry[4:], crc32.Checksum(payload, diskTable))
+    entry = append(entry, payload...)
+
+    var _, err = file.Write(entry)
+    return err
+}
+
+//
+// Rewrite the file if it holds far more changes than records -- the caller holds the lock
+// The change that grew it is already safe, so a failure is only logged (and tried again after the next)
+//
+func (self *DiskStore) maybeCompact() {
+    if self.changes < DISK_COMPACT_AFTER || int64(self.changes) <= 2 * self.index.Size() { return }
+
+    if err := self.compact() ; err != nil {
+        logging.Error(logging.Or(self.Logger), context.Background(), "could not compact the store file",
+            logging.F("path", self.Path), logging.F(logging.FIELD_ERROR, err))
+    }
+}
+
+//
+// Rewrite the file to hold only the records in the store
+//
+func (self *DiskStore) Compact() error {
+    self.lock.Lock()
+    defer self.lock.Unlock()
+
+    if self.file == nil { return ErrStoreClosed }
+    return self.compact()
+}
+
+func (self *DiskStore) compact() error {
+    var temporary = self.Path + ".compact"
+    file, err := os.OpenFile(temporary, os.O_RDWR | os.O_CREATE | os.O_TRUNC, 0600)
+    if err != nil { return err }
+
+    var failed = func(err error) error {
+        file.Close()
+        os.Remove(temporary)
+        return err
+    }
+
+    if _, err = file.Write(diskHeader()) ; err != nil { return failed(err) }
+
+    var payload bytes.Buffer
+    var count = 0
+    err = self.index.Walk(func(rec record.Record) error {
+        data, err := serializeRecord(rec)
+        if err != nil { return err }
+
+        encodeAdd(&payload, data)
+        count += 1
+
+        if count % DISK_COMPACT_BATCH != 0 { return nil }
+        err = writeEntry(file, payload.Bytes())
+        payload.Reset()
+        return err
+    })
+    if err == nil && payload.Len() > 0 { err = writeEntry(file, payload.Bytes()) }
+    if err == nil { err = file.Sync() }
+    if err != nil { return failed(err) }
+
+    // the new file takes the old one's place whole
+    if err = os.Rename(temporary, self.Path) ; err != nil { return failed(err) }
+    syncDirectory(filepath.Dir(self.Path))
+
+    self.file.Close()
+    self.file = file
+    self.changes = count
+
+    // the file position is already at its end
+    size, err := file.Seek(0, io.SeekCurrent)
+    self.size = size
+    return err
+}
+
+//
+// Sync a directory, so a rename in it survives a crash (not every platform can)
+//
+func syncDirectory(path string) {
+    if directory, err := os.Open(path) ; err == nil {
+        directory.Sync()
+        directory.Close()
+    }
+}
+
+//
+// Stop writing to the file -- lookups keep working, changes return ErrStoreClosed
+//
+func (self *DiskStore) Close() error {
+    self.lock.Lock()
+    defer self.lock.Unlock()
+
+    if self.file == nil { return nil }
+
+    var err = self.file.Close()
+    self.file = nil
+    return err
+}
+
+//----------------------------------------------
+// DNSStore Implementation
+//----------------------------------------------
+
+func (self *DiskStore) Add(rec record.Record) error {
+    if rec == nil { return ErrNilRecord }
+
+    data, err := serializeRecord(rec)
+    if err != nil { return err }
+
+    var payload bytes.Buffer
+    encodeAdd(&payload, data)
+
+    self.lock.Lock()
+    defer self.lock.Unlock()
+
+    if err := self.write(payload.Bytes(), 1) ; err != nil { return err }
+    self.index.Add(rec)
+    self.maybeCompact()
+    return nil
+}
+
+func (self *DiskStore) Delete(rec record.Record) error {
+    if rec == nil { return ErrNilRecord }
+    return self.FindAndDelete(rec.GetLabel(), rec.GetType())
+}
+
+func (self *DiskStore) FindAndDelete(rLabel string, rType uint16) error {
+    if rLabel == "" { return ErrNilRecord }
+    if rType == 0 { return ErrInvalidType }
+
+    var payload bytes.Buffer
+    encodeDelete(&payload, rLabel, rType)
+
+    self.lock.Lock()
+    defer self.lock.Unlock()
+
+    // only changes that will happen are written -- nothing else changes the index while the lock is held
+    if !self.index.holds(rLabel, rType) { return ErrNotFound }
+
+    if err := self.write(payload.Bytes(), 1) ; err != nil { return err }
+    self.index.FindAndDelete(rLabel, rType)
+    self.maybeCompact()
+    return nil
+}
+
+func (self *DiskStore) FindAndReplace(rLabel string, rType uint16, newer record.Record) error {
+    if rLabel == "" || newer == nil { return ErrNilRecord }
+    if rType == 0 { return ErrInvalidType }
+
+    data, err := serializeRecord(newer)
+    if err != nil { return err }
+
+    var payload bytes.Buffer
+    encodeReplace(&payload, rLabel, rType, data)
+
+    self.lock.Lock()
+    defer self.lock.Unlock()
+
+    if !self.index.holds(rLabel, rType) { return ErrNotFound }
+
+    if err := self.write(payload.Bytes(), 1) ; err != nil { return err }
+    self.index.FindAndReplace(rLabel, rType, newer)
+    self.maybeCompact()
+    return nil
+}
+
+//
+// Apply the changes fn makes as one entry in the file, and one swap in memory (see MapStore.Batch)
+//
+func (self *DiskStore) Batch(fn func(DNSStore) error) error {
+    self.lock.Lock()
+    defer self.lock.Unlock()
+
+    if self.file == nil { return ErrStoreClosed }
+
+    var err = self.index.Batch(func(working DNSStore) error {
+        var batch = &diskBatch{ DNSStore: working }
+        if err := fn(batch) ; err != nil { return err }
+        if batch.changes == 0 { return nil }
+
+        // the index only takes the changes once they are in the file
+        return self.write(batch.payload.Bytes(), batch.changes)
+    })
+    if err != nil { return err }
+
+    self.maybeCompact()
+    return nil
+}
+
+func (self *DiskStore) Find(rLabel string, rType uint16) (record.Record, error) {
+    return self.index.Find(rLabel, rType)
+}
+
+func (self *DiskStore) FindLabel(rLabel string) ([]record.Record, error) {
+    return self.index.FindLabel(rLabel)
+}
+
+func (self *DiskStore) FindRecursively(rLabel string, rType uint16) ([]record.Record, error) {
+    return self.index.FindRecursively(rLabel, rType)
+}
+
+func (self *DiskStore) Walk(fn func(record.Record) error) error {
+    return self.index.Walk(fn)
+}
+
+func (self *DiskStore) Size() int64 {
+    return self.index.Size()
+}
+
+func (self *DiskStore) LabelSize(label string) int {
+    return self.index.LabelSize(label)
+}
+
+//
+// The store seen inside a batch, noting each change made to it for the file
+//
+type diskBatch struct {
+    DNSStore
+
+    payload         bytes.Buffer
+    changes         int
+}
+
+func (self *diskBatch) Add(rec record.Record) error {
+    if rec == nil { return ErrNilRecord }
+
+    data, err := serializeRecord(rec)
+    if err != nil { return err }
+    if err = self.DNSStore.Add(rec) ; err != nil { return err }
+
+    encodeAdd(&self.payload, data)
+    self.changes += 1
+    return nil
+}
+
+func (self *diskBatch) Delete(rec record.Record) error {
+    if rec == nil { return ErrNilRecord }
+    return self.FindAndDelete(rec.GetLabel(), rec.GetType())
+}
+
+func (self *diskBatch) FindAndDelete(rLabel string, rType uint16) error {
+    if err := self.DNSStore.FindAndDelete(rLabel, rType) ; err != nil { return err }
+
+    encodeDelete(&self.payload, rLabel, rType)
+    self.changes += 1
+    return nil
+}
+
+func (self *diskBatch) FindAndReplace(rLabel string, rType uint16, newer record.Record) error {
+    if newer == nil { return ErrNilRecord }
+
+    data, err := serializeRecord(newer)
+    if err != nil { return err }
+    if err = self.DNSStore.FindAndReplace(rLabel, rType, newer) ; err != nil { return err }
+
+    encodeReplace(&self.payload, rLabel, rType, data)
+    self.changes += 1
+    return nil
+}
+
+//----------------------------------------------
+// Encoding
+//----------------------------------------------
+
+func diskHeader() []byte {
+    var header = make([]byte, DISK_HEADER_SIZE)
+    copy(header, DISK_MAGIC)
+    binary.BigEndian.PutUint16(header[4:], DISK_VERSION)
+    return header
+}
+
+func encodeAdd(buffer *bytes.Buffer, data []byte) {
+    buffer.WriteByte(DISK_ADD)
+    encodeString(buffer, string(data))
+}
+
+func encodeDelete(buffer *bytes.Buffer, rLabel string, rType uint16) {
+    buffer.WriteByte(DISK_DELETE)
+    encodeString(buffer, record.Canonical(rLabel))
+    buffer.Write(record.Uint16ToBytes(rType))
+}
+
+func encodeReplace(buffer *bytes.Buffer, rLabel string, rType uint16, data []byte) {
+    buffer.WriteByte(DISK_REPLACE)
+    encodeString(buffer, record.Canonical(rLabel))
+    buffer.Write(record.Uint16ToBytes(rType))
+    encodeString(buffer, string(data))
+}
+
+//
+// Serialize a copy, as serializing fills in fields of the record (which lookups may be reading)
+//
+func serializeRecord(rec record.Record) ([]byte, error) {
+    var data, err = record.Copy(rec).Serialize()
+    if err != nil { return nil, err }
+    if len(data) > 0xFFFF { return nil, ErrDiskEntry }
+
+    return data, nil
+}
+
+func encodeString(buffer *bytes.Buffer, value string) {
+    buffer.Write(record.Uint16ToBytes(uint16(len(value))))
+    buffer.WriteString(value)
+}
+
+//
+// Reads the fields of an entry, holding on to the first error
+//
+type diskReader struct {
+    data            []byte
+    offset          int
+    err             error
+}
+
+func (self *diskReader) done() bool {
+    return self.err != nil || self.offset >= len(self.data)
+}
+
+func (self *diskReader) take(count int) []byte {
+    if self.err != nil { return nil }
+    if self.offset + count > len(self.data) {
+        self.err = ErrDiskEntry
+        return nil
+    }
+
+    var result = self.data[self.offset:self.offset + count]
+    self.offset += count
+    return result
+}
+
+func (self *diskReader) byte() byte {
+    var data = self.take(1)
+    if data == nil { return 0 }
+    return data[0]
+}
+
+func (self *diskReader) uint16() uint16 {
+    var data = self.take(2)
+    if data == nil { return 0 }
+    return binary.BigEndian.Uint16(data)
+}
+
+func (self *diskReader) string() string {
+    var length = self.uint16()
+    return string(self.take(int(length)))
+}
+
+func (self *diskReader) record() record.Record {
+    var data = []byte(self.string())
+    if self.err != nil { return nil }
+
+    rec, finish, err := record.UnpackRecord(data, 0)
+    if err == nil && finish != len(data) { err = ErrDiskEntry }
+    if err != nil {
+        self.err = err
+        return nil
+    }
+
+    return rec
+}
@@ -7,9 +7,22 @@ import (
 )
 
 var ErrNotFound     error   = errors.New("ERROR: That record does not exist")
+var ErrNoData       error   = errors.New("ERROR: No records of that type exist at that label")
 var ErrNilRecord    error   = errors.New("ERROR: Cannot operate on nil record.")
 var ErrInvalidType  error   = errors.New("ERROR: Invalid record type")
 
+//
+// Lookups (Find, FindLabel, FindRecursively) distinguish between:
+//    ErrNotFound: the label does not exist at all (NXDOMAIN)
+//    ErrNoData:   the label exists, if only as an empty non-terminal, but holds no matching records (NODATA)
+//
+// Implementations must be safe for concurrent use: the server answers every query in its own
+// goroutine while the application adds and deletes records. Records returned by lookups are
+// owned by the caller, which may modify (serialize) them without affecting the store
+//
+// Lookups of names that do not exist are answered by a wildcard ("*.parent") at the closest
+// existing ancestor, if there is one, with the records returned as owned by the queried name (RFC 4592)
+//
 type DNSStore interface {
     // record interaction operations
     Add(record.Record) error
@@ -20,7 +33,31 @@ type DNSStore interface {
     FindAndReplace(rLabel string, rType uint16, newer record.Record) error
     FindRecursively(rLabel string, rType uint16) ([]record.Record, error)
 
+    // enumeration -- stops at, and returns, the first error from the callback
+    Walk(fn func(record.Record) error) error
+
     // statistics
     Size() int64
     LabelSize(label string) int
+}
+
+//
+// Stores that can apply several changes as one implement Batcher
+// Inside fn the store is seen with the batch's changes so far; nothing else sees them
+// until fn returns nil, and nothing is kept at all if fn returns an error
+//
+type Batcher interface {
+    Batch(fn func(DNSStore) error) error
+}
+
+//
+// Run fn as a single batch if the store supports it (see Batcher), or directly against the store if not,
+// in which case changes are seen as they are made and those made before an error are kept
+//
+func Batch(backing DNSStore, fn func(DNSStore) error) error {
+    if batcher, ok := backing.(Batcher) ; ok {
+        return batcher.Batch(fn)
+    }
+
+    return fn(backing)
 }
\ No newline at end of file
@@ -0,0 +1,99 @@
+package store
+
+import (
+    "sync"
+
+    "github.com/zmarcantel/phonebook/dns/record"
+)
+
+// the number of changes kept for each zone unless told otherwise
+const JOURNAL_LIMIT int = 100
+
+//----------------------------------------------
+// Change Journal
+//----------------------------------------------
+
+//
+// One step in a zone's history: the records removed and added when the serial moved from From to To
+// The SOA records themselves are not listed among the changes
+//
+type Change struct {
+    From            *record.SOARecord
+    To              *record.SOARecord
+    Deleted         []record.Record
+    Added           []record.Record
+}
+
+//
+// The most recent changes of each zone, so secondaries can catch up incrementally (IXFR)
+// Safe for concurrent use -- a nil journal keeps nothing
+//
+type Journal struct {
+    Limit           int                     // changes kept per zone -- the oldest are forgotten first
+
+    changes         map[string][]Change
+    lock            sync.RWMutex
+}
+
+func NewJournal(limit int) *Journal {
+    return &Journal{
+        Limit:      limit,
+        changes:    make(map[string][]Change, 0),
+    }
+}
+
+//
+// Note a change to the zone with the given origin
+// A change that does not follow on from the last one breaks the history, which then starts over from it
+//
+func (self *Journal) Append(origin string, change Change) {
+    if self == nil || change.From == nil || change.To == nil { return }
+    origin = zoneKey(origin)
+
+    self.lock.Lock()
+    defer self.lock.Unlock()
+
+    var history = self.changes[origin]
+    if len(history) > 0 && history[len(history) - 1].To.Serial != change.From.Serial {
+        history = nil
+    }
+
+    history = append(history, change)
+    if self.Limit > 0 && len(history) > self.Limit {
+        history = append([]Change(nil), history[len(history) - self.Limit:]...)
+    }
+
+    self.changes[origin] = history
+}
+
+//
+// The changes taking the zone from the given serial to its latest, in order
+// False if the journal does not reach back that far (or never saw the serial)
+//
+func (self *Journal) Since(origin string, serial uint32) ([]Change, bool) {
+    if self == nil { return nil, false }
+
+    self.lock.RLock()
+    defer self.lock.RUnlock()
+
+    var history = self.changes[zoneKey(origin)]
+    for i, change := range history {
+        if change.From.Serial == serial {
+            return append([]Change(nil), history[i:]...), true
+        }
+    }
+
+    return nil, false
+}
+
+//
+// Forget the history of a zone (after it is changed by other means, or removed)
+//
+func (self *Journal) Clear(origin string) {
+    if self == nil { return }
+
+    self.lock.Lock()
+    defer self.lock.Unlock()
+
+    delete(self.changes, zoneKey(origin))
+}
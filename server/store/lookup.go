@@ -0,0 +1,183 @@
+package store
+
+import (
+    "github.com/zmarcantel/phonebook/dns/record"
+)
+
+//----------------------------------------------
+// Lookups
+//----------------------------------------------
+
+//
+// What lookups need to know of a store's names (given in canonical form):
+//    owned:   the records at exactly the name, in added order (nil if none) -- lookups copy them before returning any
+//    exists:  whether the name exists, holding records or as an empty non-terminal above names that do
+//
+// MapStore and RedisStore answer lookups the same way, each over its own names
+//
+type nameIndex interface {
+    owned(cleanLabel string) ([]record.Record, error)
+    exists(cleanLabel string) bool
+}
+
+//
+// Find the first record of a type at a name (see DNSStore.Find)
+//
+func findIn(index nameIndex, rLabel string, rType uint16) (record.Record, error) {
+    // check if the record exists (or a wildcard covers it) and if so, return it
+    var cleanLabel = record.Canonical(rLabel)
+    collection, exists, err := collectionIn(index, cleanLabel, rLabel)
+    if err != nil { return nil, err }
+
+    if exists {
+        for _, curr := range collection {
+            // if the labels and types match
+            if record.Canonical(curr.GetLabel()) == cleanLabel && curr.GetType() == rType {
+                return record.Copy(curr), nil
+            }
+        }
+
+        return nil, ErrNoData
+    }
+
+    // the label exists but not with that type.... no data
+    if index.exists(cleanLabel) {
+        return nil, ErrNoData
+    }
+
+    // there is nothing at or beneath the label.... 404
+    return nil, ErrNotFound
+}
+
+//
+// Find every record at a name (see DNSStore.FindLabel)
+//
+func findLabelIn(index nameIndex, rLabel string) ([]record.Record, error) {
+    // check if the label exists (or a wildcard covers it) and if so, return it
+    var cleanLabel = record.Canonical(rLabel)
+    collection, exists, err := collectionIn(index, cleanLabel, rLabel)
+    if err != nil { return nil, err }
+
+    if exists {
+        var result = make([]record.Record, len(collection))
+        for i, curr := range collection {
+            result[i] = record.Copy(curr)
+        }
+        return result, nil
+    }
+
+    // an empty non-terminal exists, but has nothing to give
+    if index.exists(cleanLabel) {
+        return nil, ErrNoData
+    }
+
+    // there is nothing at or beneath the label.... 404
+    return nil, ErrNotFound
+}
+
+//
+// Find records of a type at a name, following a CNAME there to the A and AAAA records of its target
+// (see DNSStore.FindRecursively)
+//
+func findRecursivelyIn(index nameIndex, rLabel string, rType uint16) ([]record.Record, error) {
+    // check if the record exists (or a wildcard covers it) and if so, return it
+    var cleanLabel = record.Canonical(rLabel)
+    collection, exists, err := collectionIn(index, cleanLabel, rLabel)
+    if err != nil { return nil, err }
+
+    if exists {
+        var result = make([]record.Record, 0)
+
+        for _, curr := range collection {
+            // if the labels match
+            if record.Canonical(curr.GetLabel()) == cleanLabel {
+
+                // if the current record is a CNAME -- lookup any A/AAAA records at the target
+                if curr.GetType() == record.CNAME_RECORD {
+                    // the CNAME always comes first
+                    // also reflect it for convenience
+                    result = append(result, record.Copy(curr))
+                    var cname = curr.(*record.CNAMERecord)
+
+                    // but we were looking for and A/AAAA record... recurse
+                    if rType == record.A_RECORD || rType == record.AAAA_RECORD {
+                        // lookup A records and append them
+                        aRecord, err := findIn(index, cname.Target, record.A_RECORD)
+                        if err != nil && err != ErrNotFound && err != ErrNoData { return nil, err }
+                        if err == nil { result = append(result, aRecord) }
+
+                        // lookup AAAA records and append them
+                        aaaaRecord, err := findIn(index, cname.Target, record.AAAA_RECORD)
+                        if err != nil && err != ErrNotFound && err != ErrNoData { return nil, err }
+                        if err == nil { result = append(result, aaaaRecord) }
+                    }
+                } else if curr.GetType() == rType {
+                    result = append(result, record.Copy(curr))
+                }
+            }
+        }
+
+        if len(result) > 0 {
+            return result, nil
+        }
+        return nil, ErrNoData
+    }
+
+    // the label exists but not with that type.... no data
+    if index.exists(cleanLabel) {
+        return nil, ErrNoData
+    }
+
+    // there is nothing at or beneath the label.... 404
+    return nil, ErrNotFound
+}
+
+//
+// Return the records owned by the label, or synthesize them from a wildcard (RFC 4592)
+// when the label does not exist. Synthesized records are copies owned by the queried name
+//
+func collectionIn(index nameIndex, cleanLabel, rLabel string) ([]record.Record, bool, error) {
+    collection, err := index.owned(cleanLabel)
+    if err != nil || len(collection) > 0 { return collection, err == nil, err }
+
+    // an existing name, even an empty non-terminal, is never covered by a wildcard
+    if index.exists(cleanLabel) { return nil, false, nil }
+
+    source, err := wildcardIn(index, cleanLabel)
+    if err != nil || len(source) == 0 { return nil, false, err }
+
+    var result = make([]record.Record, 0, len(source))
+    for _, curr := range source {
+        result = append(result, record.Rename(curr, rLabel))
+    }
+
+    return result, true, nil
+}
+
+//
+// Find the records of the wildcard that may answer for a name that does not exist
+// Only the "*" child of the closest existing ancestor (the closest encloser) applies,
+// so closer names block wildcards further up the tree
+//
+func wildcardIn(index nameIndex, cleanLabel string) ([]record.Record, error) {
+    for parent := record.Name(cleanLabel).Parent() ; parent != "" ; parent = parent.Parent() {
+        if index.exists(string(parent)) {
+            return index.owned("*." + string(parent))
+        }
+    }
+
+    // the root is the closest encloser of everything else
+    return index.owned("*")
+}
+
+//
+// Adjust the descendant count of every ancestor of the label
+//
+func countAncestors(descendants map[string]int, cleanLabel string, delta int) {
+    for parent := record.Name(cleanLabel).Parent() ; parent != "" ; parent = parent.Parent() {
+        descendants[string(parent)] += delta
+        if descendants[string(parent)] <= 0 {
+            delete(descendants, string(parent))
+        }
+    }
+}
@@ -2,15 +2,33 @@ package store
 
 import (
     "fmt"
-    "strings"
+    "sort"
+    "sync"
+    "bytes"
+    "context"
 
     "github.com/zmarcantel/phonebook/dns/record"
+    "github.com/zmarcantel/phonebook/server/logging"
 )
 
+//
+// Records are keyed by the canonical form of their owner name, so lookups are
+// case-insensitive and ignore any trailing dot
+//
+// Safe for concurrent use -- lookups share a read lock, changes take the write lock.
+// Lookups return copies, so callers (the server serializing answers) never share a record
+//
 type MapStore struct {
     Backing          map[string][]record.Record
     Labels           int64
     Records          int64
+
+    // number of labels beneath each name -- names with no records of their own are empty non-terminals
+    descendants      map[string]int
+
+    lock             sync.RWMutex
+
+    Logger           logging.Logger         // nil uses logging.Default()
 }
 
 func Map() *MapStore {
@@ -18,9 +36,49 @@ func Map() *MapStore {
         make(map[string][]record.Record, 0),
         0,
         0,
+        make(map[string]int, 0),
+        sync.RWMutex{},
+        nil,
     }
 }
 
+//
+// Apply the changes fn makes as one -- fn works on a copy of the store that replaces it only if fn returns nil
+// Lookups wait until the batch is done, so they see the store either before or after all of it
+//
+func (self *MapStore) Batch(fn func(DNSStore) error) error {
+    self.lock.Lock()
+    defer self.lock.Unlock()
+
+    var working = self.clone()
+    if err := fn(working) ; err != nil { return err }
+
+    self.Backing = working.Backing
+    self.Labels = working.Labels
+    self.Records = working.Records
+    self.descendants = working.descendants
+    return nil
+}
+
+//
+// Copy the store's contents (the records themselves are shared) -- the caller holds the lock
+//
+func (self *MapStore) clone() *MapStore {
+    var result = Map()
+    result.Labels = self.Labels
+    result.Records = self.Records
+    result.Logger = self.Logger
+
+    for label, collection := range self.Backing {
+        result.Backing[label] = append([]record.Record(nil), collection...)
+    }
+    for label, count := range self.descendants {
+        result.descendants[label] = count
+    }
+
+    return result
+}
+
 //
 // Add a record to the naive map implementation so the record can be queried
 //
@@ -28,18 +86,62 @@ func (self *MapStore) Add(rec record.Record) error {
     // input validation
     if rec == nil { return ErrNilRecord }
 
+    self.lock.Lock()
+    defer self.lock.Unlock()
+
+    self.add(rec)
+    return nil
+}
+
+//
+// Add a record -- the caller holds the lock
+//
+func (self *MapStore) add(rec record.Record) {
     // check if there are any other records sharing the label
     // if so, there is a map entry all ready so just add it
-    var cleanLabel = strings.TrimSuffix(rec.GetLabel(), ".")
+    var cleanLabel = record.Canonical(rec.GetLabel())
     if collection, exists := self.Backing[cleanLabel] ; exists {
         self.Backing[cleanLabel] = append(collection, rec)
     } else {
         self.Labels += 1
         self.Backing[cleanLabel] = []record.Record{ rec }
+        self.countAncestors(cleanLabel, 1)
     }
 
     self.Records += 1
-    return nil
+}
+
+//
+// Remove the given records and add others, as one change
+// Each removed record is matched by name, type, and data (RDATA) -- the very value added, if still there,
+// otherwise one holding the same data, as when replaced with only a new TTL. Those matching nothing are skipped
+//
+func (self *MapStore) exchange(removed, added []record.Record) {
+    self.lock.Lock()
+    defer self.lock.Unlock()
+
+    for _, rec := range removed {
+        var cleanLabel = record.Canonical(rec.GetLabel())
+        if index := indexOfData(self.Backing[cleanLabel], rec) ; index >= 0 {
+            self.removeAt(cleanLabel, index)
+        }
+    }
+
+    for _, rec := range added {
+        if rec != nil { self.add(rec) }
+    }
+}
+
+//
+// Find the given record among those at its name: the same value if there, otherwise the first of the same type
+// holding the same data (RDATA, names in it compared canonically), or -1
+//
+func indexOfData(records []record.Record, rec record.Record) int {
+    for i, curr := range records {
+        if curr == rec { return i }
+    }
+
+    return record.IndexOfData(records, rec)
 }
 
 //
@@ -49,18 +151,16 @@ func (self *MapStore) Delete(rec record.Record) error {
     // input validation
     if rec == nil { return ErrNilRecord }
 
+    self.lock.Lock()
+    defer self.lock.Unlock()
+
     // check if the record exists and if so, delete it
-    var cleanLabel = strings.TrimSuffix(rec.GetLabel(), ".")
+    var cleanLabel = record.Canonical(rec.GetLabel())
     if collection, exists := self.Backing[cleanLabel] ; exists {
         for i, curr := range collection {
             // if the labels and types match
-            if curr.GetLabel() == rec.GetLabel() && curr.GetType() == rec.GetType() {
-                // do an "in-place remove" of the record -- still O(n-i), but preserves added order
-                // but first, null out the position in the slice the record was
-                // records are pointers and will not get garbage collected if the entry is not nil'd
-                self.Backing[cleanLabel][i] = nil
-                self.Backing[cleanLabel] = append(self.Backing[cleanLabel][:i], self.Backing[cleanLabel][i + 1:]...)
-                self.Records -= 1
+            if record.NamesEqual(curr.GetLabel(), rec.GetLabel()) && curr.GetType() == rec.GetType() {
+                self.removeAt(cleanLabel, i)
                 return nil
             }
         }
@@ -79,20 +179,10 @@ func (self *MapStore) Find(rLabel string, rType uint16) (record.Record, error) {
     // input validation
     if rLabel == "" { return nil, ErrNilRecord }
 
-    // check if the record exists and if so, return it
-    var cleanLabel = strings.TrimSuffix(rLabel, ".")
-    if collection, exists := self.Backing[cleanLabel] ; exists {
-        for _, curr := range collection {
-            // if the labels and types match
-            if curr.GetLabel() == rLabel && curr.GetType() == rType {
-                return curr, nil
-            }
-        }
-    }
+    self.lock.RLock()
+    defer self.lock.RUnlock()
 
-    // either there was no collection at the label,
-    // or the record did not exist in the collection.... either way 404
-    return nil, ErrNotFound
+    return findIn(self, rLabel, rType)
 }
 
 //
@@ -102,15 +192,10 @@ func (self *MapStore) FindLabel(rLabel string) ([]record.Record, error) {
     // input validation
     if rLabel == "" { return nil, ErrNilRecord }
 
-    // check if the label exists and if so, return it
-    var cleanLabel = strings.TrimSuffix(rLabel, ".")
-    if collection, exists := self.Backing[cleanLabel] ; exists {
-        return collection, nil
-    }
+    self.lock.RLock()
+    defer self.lock.RUnlock()
 
-    // either there was no collection at the label,
-    // or the record did not exist in the collection.... either way 404
-    return nil, ErrNotFound
+    return findLabelIn(self, rLabel)
 }
 
 
@@ -122,18 +207,16 @@ func (self *MapStore) FindAndDelete(rLabel string, rType uint16) error {
     if rLabel == "" { return ErrNilRecord }
     if rType == 0 { return ErrInvalidType }
 
+    self.lock.Lock()
+    defer self.lock.Unlock()
+
     // check if the record exists and if so, delete it
-    var cleanLabel = strings.TrimSuffix(rLabel, ".")
+    var cleanLabel = record.Canonical(rLabel)
     if collection, exists := self.Backing[cleanLabel] ; exists {
         for i, curr := range collection {
             // if the labels and types match
-            if curr.GetLabel() == rLabel && curr.GetType() == rType {
-                // do an "in-place remove" of the record -- still O(n-i), but preserves added order
-                // but first, null out the position in the slice the record was
-                // records are pointers and will not get garbage collected if the entry is not nil'd
-                self.Backing[cleanLabel][i] = nil
-                self.Backing[cleanLabel] = append(self.Backing[cleanLabel][:i], self.Backing[cleanLabel][i + 1:]...)
-                self.Records -= 1
+            if record.Canonical(curr.GetLabel()) == cleanLabel && curr.GetType() == rType {
+                self.removeAt(cleanLabel, i)
                 return nil
             }
         }
@@ -153,12 +236,15 @@ func (self *MapStore) FindAndReplace(rLabel string, rType uint16, newer record.R
     if rLabel == "" { return ErrNilRecord }
     if rType == 0 { return ErrInvalidType }
 
+    self.lock.Lock()
+    defer self.lock.Unlock()
+
     // check if the record exists and if so, delete it
-    var cleanLabel = strings.TrimSuffix(rLabel, ".")
+    var cleanLabel = record.Canonical(rLabel)
     if collection, exists := self.Backing[cleanLabel] ; exists {
         for i, curr := range collection {
             // if the labels and types match
-            if curr.GetLabel() == rLabel && curr.GetType() == rType {
+            if record.Canonical(curr.GetLabel()) == cleanLabel && curr.GetType() == rType {
                 // do an "in-place replace" of the record -- still O(1)
                 // first, null out the position in the slice the record was
                 // records are pointers and will not get garbage collected if the entry is not nil'd
@@ -174,59 +260,143 @@ func (self *MapStore) FindAndReplace(rLabel string, rType uint16, newer record.R
     return ErrNotFound
 }
 
+//
+// Check if a record of the type is stored at exactly the label (wildcards aside) --
+// whether FindAndDelete and FindAndReplace would find one
+//
+func (self *MapStore) holds(rLabel string, rType uint16) bool {
+    self.lock.RLock()
+    defer self.lock.RUnlock()
+
+    var cleanLabel = record.Canonical(rLabel)
+    for _, curr := range self.Backing[cleanLabel] {
+        if curr.GetType() == rType { return true }
+    }
+
+    return false
+}
+
 //
 // Find records recursively from the local collection
 // This primarily applies to CNAME records
 //
 func (self *MapStore) FindRecursively(rLabel string, rType uint16) ([]record.Record, error) {
-    fmt.Printf("Recursively looking for: %s (%d)\n", rLabel, rType)
+    logging.Debug(logging.Or(self.Logger), context.Background(), "recursive lookup",
+        logging.F(logging.FIELD_NAME, rLabel), logging.F(logging.FIELD_TYPE, rType))
+
     // input validation
     if rLabel == "" { return nil, ErrNilRecord }
     if rType == 0 { return nil, ErrInvalidType }
 
-    // check if the record exists and if so, return it
-    var cleanLabel = strings.TrimSuffix(rLabel, ".")
-    if collection, exists := self.Backing[cleanLabel] ; exists {
-        var result = make([]record.Record, 0)
-
-        for _, curr := range collection {
-            // if the labels match
-            if curr.GetLabel() == rLabel {
-
-                // if the current record is a CNAME -- lookup any A/AAAA records at the target
-                if curr.GetType() == record.CNAME_RECORD {
-                    // the CNAME always comes first
-                    // also reflect it for convenience
-                    result = append(result, curr)
-                    var cname = curr.(*record.CNAMERecord)
-
-                    // but we were looking for and A/AAAA record... recurse
-                    if rType == record.A_RECORD || rType == record.AAAA_RECORD {
-                        // lookup A records and append them
-                        aRecord, err := self.Find(cname.Target, record.A_RECORD)
-                        if err != nil && err != ErrNotFound { return nil, err }
-                        result = append(result, aRecord)
-
-                        // lookup AAAA records and append them
-                        aaaaRecord, err := self.Find(cname.Target, record.AAAA_RECORD)
-                        if err != nil && err != ErrNotFound { return nil, err }
-                        result = append(result, aaaaRecord)
-                    }
-                } else if curr.GetType() == rType {
-                    result = append(result, curr)
-                }
-            }
+    self.lock.RLock()
+    defer self.lock.RUnlock()
+
+    return findRecursivelyIn(self, rLabel, rType)
+}
+
+//
+// The records at exactly the label (see nameIndex) -- the caller holds the lock
+//
+func (self *MapStore) owned(cleanLabel string) ([]record.Record, error) {
+    return self.Backing[cleanLabel], nil
+}
+
+//
+// Check if a label exists -- either holding records or as an empty non-terminal
+//
+func (self *MapStore) exists(cleanLabel string) bool {
+    if collection, exists := self.Backing[cleanLabel] ; exists && len(collection) > 0 {
+        return true
+    }
+
+    return self.descendants[cleanLabel] > 0
+}
+
+//
+// Adjust the descendant count of every ancestor of the label
+//
+func (self *MapStore) countAncestors(cleanLabel string, delta int) {
+    if self.descendants == nil { self.descendants = make(map[string]int, 0) }
+    countAncestors(self.descendants, cleanLabel, delta)
+}
+
+//
+// Remove the record at position i of the label's collection, dropping the label once it is empty
+//
+func (self *MapStore) removeAt(cleanLabel string, i int) {
+    var collection = self.Backing[cleanLabel]
+
+    // do an "in-place remove" of the record -- still O(n-i), but preserves added order
+    // but first, null out the position in the slice the record was
+    // records are pointers and will not get garbage collected if the entry is not nil'd
+    collection[i] = nil
+    collection = append(collection[:i], collection[i + 1:]...)
+    self.Records -= 1
+
+    if len(collection) > 0 {
+        self.Backing[cleanLabel] = collection
+        return
+    }
+
+    // nothing left at the label -- it no longer exists (unless it has descendants)
+    delete(self.Backing, cleanLabel)
+    self.Labels -= 1
+    self.countAncestors(cleanLabel, -1)
+}
+
+//
+// Call fn for every record in the store
+// Labels are visited parents first (comparing labels from the right), records in added order
+//
+func (self *MapStore) Walk(fn func(record.Record) error) error {
+    // snapshot under the read lock so the callback may modify the store
+    self.lock.RLock()
+
+    var labels = make([]string, 0, len(self.Backing))
+    for label := range self.Backing {
+        labels = append(labels, label)
+    }
+
+    sort.Slice(labels, func(i, j int) bool {
+        return labelLess(labels[i], labels[j])
+    })
+
+    var snapshot = make([]record.Record, 0, self.Records)
+    for _, label := range labels {
+        for _, rec := range self.Backing[label] {
+            snapshot = append(snapshot, record.Copy(rec))
         }
+    }
 
-        return result, nil
+    self.lock.RUnlock()
+
+    for _, rec := range snapshot {
+        var err = fn(rec)
+        if err != nil { return err }
     }
 
-    // either there was no collection at the label,
-    // or the record did not exist in the collection.... either way 404
-    return nil, ErrNotFound
+    return nil
+}
+
+//
+// Order labels from the root down, so a zone's apex comes before its children
+//
+func labelLess(left, right string) bool {
+    var leftParts = record.Name(left).Labels()
+    var rightParts = record.Name(right).Labels()
+
+    for i := 1 ; i <= len(leftParts) && i <= len(rightParts) ; i++ {
+        var l, r = leftParts[len(leftParts) - i], rightParts[len(rightParts) - i]
+        if compared := bytes.Compare(l, r) ; compared != 0 { return compared < 0 }
+    }
+
+    return len(leftParts) < len(rightParts)
 }
 
 func (self *MapStore) Size() int64 {
+    self.lock.RLock()
+    defer self.lock.RUnlock()
+
     return self.Records
 }
 
@@ -234,8 +404,11 @@ func (self *MapStore) LabelSize(label string) int {
     // input validation
     if label == "" { return 0 }
 
+    self.lock.RLock()
+    defer self.lock.RUnlock()
+
     // check if the record exists and if so, return it
-    var cleanLabel = strings.TrimSuffix(label, ".")
+    var cleanLabel = record.Canonical(label)
     if collection, exists := self.Backing[cleanLabel] ; exists {
         return len(collection)
     } else {
@@ -248,6 +421,9 @@ func (self *MapStore) LabelSize(label string) int {
 // Print the contents of the map to stdout
 //
 func (self *MapStore) Print() {
+    self.lock.RLock()
+    defer self.lock.RUnlock()
+
     fmt.Printf("\n\nMapStore Data:\n%+v\n\n", self.Backing)
 }
 
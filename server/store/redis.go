@@ -0,0 +1,1012 @@
+package store
+
+import (
+    "fmt"
+    "sort"
+    "sync"
+    "time"
+    "errors"
+    "context"
+    "strconv"
+    "strings"
+
+    "github.com/zmarcantel/phonebook/dns/record"
+    "github.com/zmarcantel/phonebook/server/logging"
+    "github.com/zmarcantel/phonebook/server/store/redis"
+)
+
+const (
+    REDIS_PREFIX            string          = "phonebook:"
+    REDIS_RECORD_VERSION    byte            = 1
+
+    // attempts at a change before giving up on other writers getting in its way
+    REDIS_RETRIES           int             = 5
+
+    // wait between attempts to subscribe again after losing the connection
+    REDIS_RECONNECT         time.Duration   = time.Second
+
+    // keys asked for with each SCAN, and commands sent with each pipeline, when reading every name
+    REDIS_SCAN_COUNT        int             = 1000
+)
+
+var ErrRedisConflict    error   = errors.New("ERROR: Records kept changing in redis while being changed -- gave up")
+var ErrRedisRecord      error   = errors.New("ERROR: Malformed record in redis")
+var ErrRedisExpiry      error   = errors.New("ERROR: Records must expire at least a millisecond from now")
+var ErrRedisPermanent   error   = errors.New("ERROR: The name holds records that never expire -- expiring records need a name of their own")
+
+//----------------------------------------------
+// Redis Store
+//----------------------------------------------
+
+//
+// A store kept in Redis, so every server pointed at it answers with the same records
+//
+// Each name is a hash at "<prefix>name:<name>", with a field for each record: "<TYPE>:<sequence>"
+// (the sequence keeps the order records were added in) holding a version byte and the record in wire format
+//
+// Lookups are answered from a local cache. Every name that exists is kept (with when it expires), so a name
+// that does not exist costs no round trip, while the records of a name are read through on first use.
+// Each change is published on "<prefix>changes", and every store subscribed -- this one included --
+// reads the changed name again. Losing the subscription re-reads every name once it is back
+//
+// AddExpiring adds records for ephemeral registrations, which Redis forgets unless added again in time.
+// Keys expire whole, so the records of a name share one expiry: AddExpiring sets it, but is refused
+// (ErrRedisPermanent) at a name holding records that never expire. Add -- and a batch adding to a name --
+// makes the name's records permanent, expiring ones included, so nothing added to stay ever expires
+//
+type RedisStore struct {
+    Client          *redis.Client
+    Prefix          string
+    Logger          logging.Logger          // nil uses logging.Default()
+
+    names           map[string]time.Time    // every name holding records, and when it expires (zero never)
+    descendants     map[string]int
+    nextExpiry      time.Time
+    cache           map[string][]record.Record
+    generation      uint64                  // bumped as the cache is changed, so reads started before are not kept
+    lock            sync.RWMutex
+
+    subscription    *redis.Subscription
+    stop            chan struct{}
+    done            chan struct{}
+    subscribing     sync.Mutex
+}
+
+// commands are sent with either a pooled client or a connection of its own
+type redisDoer interface {
+    Do(args ...string) (interface{}, error)
+}
+
+//
+// Read every name from Redis (under REDIS_PREFIX, if prefix is empty) and follow their changes until Close
+//
+func OpenRedis(client *redis.Client, prefix string) (*RedisStore, error) {
+    if prefix == "" { prefix = REDIS_PREFIX }
+
+    var result = &RedisStore{
+        Client:         client,
+        Prefix:         prefix,
+        names:          make(map[string]time.Time, 0),
+        descendants:    make(map[string]int, 0),
+        cache:          make(map[string][]record.Record, 0),
+        stop:           make(chan struct{}),
+        done:           make(chan struct{}),
+    }
+
+    // subscribe first, so no change made while reading is missed
+    subscription, err := client.Subscribe(result.channel())
+    if err != nil { return nil, err }
+
+    if err = result.load() ; err != nil {
+        subscription.Close()
+        return nil, err
+    }
+
+    result.subscription = subscription
+    go result.listen(subscription)
+    return result, nil
+}
+
+func (self *RedisStore) key(name string) string {
+    return self.Prefix + "name:" + name
+}
+
+func (self *RedisStore) channel() string {
+    return self.Prefix + "changes"
+}
+
+//
+// Stop following changes -- the client is left open
+//
+func (self *RedisStore) Close() error {
+    self.subscribing.Lock()
+    select {
+        case <-self.stop:
+            self.subscribing.Unlock()
+            return nil
+        default:
+    }
+
+    close(self.stop)
+    var err = self.subscription.Close()
+    self.subscribing.Unlock()
+
+    <-self.done
+    return err
+}
+
+//----------------------------------------------
+// Cache
+//----------------------------------------------
+
+//
+// Read which names exist (and when they expire) from scratch, forgetting every cached record
+//
+func (self *RedisStore) load() error {
+    var keys = make([]string, 0)
+    var pattern = escapeGlob(self.key("")) + "*"
+
+    for cursor := "0" ; ; {
+        reply, err := self.Client.Do("SCAN", cursor, "MATCH", pattern, "COUNT", strconv.Itoa(REDIS_SCAN_COUNT))
+        if err != nil { return err }
+
+        var fields, _ = reply.([]interface{})
+        if len(fields) != 2 { return redis.ErrUnexpected }
+
+        found, err := redis.Strings(fields[1], nil)
+        if err != nil { return err }
+        keys = append(keys, found...)
+
+        if cursor, _ = fields[0].(string) ; cursor == "0" || cursor == "" { break }
+    }
+
+    var names = make(map[string]time.Time, len(keys))
+    for start := 0 ; start < len(keys) ; start += REDIS_SCAN_COUNT {
+        var chunk = keys[start:min(start + REDIS_SCAN_COUNT, len(keys))]
+
+        var commands = make([][]string, len(chunk))
+        for i, key := range chunk {
+            commands[i] = []string{ "PTTL", key }
+        }
+
+        replies, err := self.Client.Pipeline(commands)
+        if err != nil { return err }
+
+        var now = time.Now()
+        for i, key := range chunk {
+            if deadline, exists := expiryOf(replies[i], now) ; exists {
+                names[strings.TrimPrefix(key, self.key(""))] = deadline
+            }
+        }
+    }
+
+    self.lock.Lock()
+    defer self.lock.Unlock()
+
+    self.names = make(map[string]time.Time, len(names))
+    self.descendants = make(map[string]int, 0)
+    self.nextExpiry = time.Time{}
+    for name, deadline := range names {
+        self.setName(name, true, deadline)
+    }
+
+    self.cache = make(map[string][]record.Record, 0)
+    self.generation += 1
+    return nil
+}
+
+//
+// Read whether a name exists again, forgetting its cached records
+//
+func (self *RedisStore) refresh(name string) error {
+    reply, err := self.Client.Do("PTTL", self.key(name))
+    if err != nil { return err }
+
+    var deadline, exists = expiryOf(reply, time.Now())
+
+    self.lock.Lock()
+    defer self.lock.Unlock()
+
+    self.setName(name, exists, deadline)
+    delete(self.cache, name)
+    self.generation += 1
+    return nil
+}
+
+//
+// Turn a PTTL reply into when the key expires (zero never), and whether it exists at all
+//
+func expiryOf(reply interface{}, now time.Time) (time.Time, bool) {
+    var remaining, err = redis.Int(reply, nil)
+    if err != nil || remaining == -2 { return time.Time{}, false }
+    if remaining < 0 { return time.Time{}, true }
+
+    return now.Add(time.Duration(remaining) * time.Millisecond), true
+}
+
+//
+// Note a name existing (until the deadline, if not zero) or not -- the caller holds the write lock
+//
+func (self *RedisStore) setName(name string, exists bool, deadline time.Time) {
+    var _, known = self.names[name]
+
+    if !exists {
+        if known {
+            delete(self.names, name)
+            countAncestors(self.descendants, name, -1)
+        }
+        return
+    }
+
+    if !known { countAncestors(self.descendants, name, 1) }
+    self.names[name] = deadline
+
+    if !deadline.IsZero() && (self.nextExpiry.IsZero() || deadline.Before(self.nextExpiry)) {
+        self.nextExpiry = deadline
+    }
+}
+
+//
+// Forget names that have expired -- Redis has, or is about to
+//
+func (self *RedisStore) sweep() {
+    self.lock.RLock()
+    var due = !self.nextExpiry.IsZero() && !time.Now().Before(self.nextExpiry)
+    self.lock.RUnlock()
+    if !due { return }
+
+    self.lock.Lock()
+    defer self.lock.Unlock()
+
+    var now = time.Now()
+    self.nextExpiry = time.Time{}
+    for name, deadline := range self.names {
+        if deadline.IsZero() { continue }
+
+        if now.Before(deadline) {
+            self.setName(name, true, deadline)
+            continue
+        }
+
+        self.setName(name, false, deadline)
+        delete(self.cache, name)
+    }
+
+    self.generation += 1
+}
+
+//
+// Follow the changes published until Close, subscribing again (and re-reading every name) if the connection is lost
+//
+func (self *RedisStore) listen(subscription *redis.Subscription) {
+    defer close(self.done)
+
+    var logger = logging.Or(self.Logger)
+    var ctx = context.Background()
+
+    for {
+        for {
+            _, name, err := subscription.Receive()
+            if err != nil { break }
+
+            if err = self.refresh(name) ; err != nil {
+                logging.Warn(logger, ctx, "could not read a changed name from redis", logging.F(logging.FIELD_NAME, name), logging.F(logging.FIELD_ERROR, err))
+            }
+        }
+        subscription.Close()
+
+        for {
+            select {
+                case <-self.stop:
+                    return
+                case <-time.After(REDIS_RECONNECT):
+            }
+
+            var err error
+            if subscription, err = self.resubscribe() ; err == nil { break }
+            logging.Warn(logger, ctx, "lost the redis subscription -- serving cached records until it is back", logging.F(logging.FIELD_ERROR, err))
+        }
+    }
+}
+
+func (self *RedisStore) resubscribe() (*redis.Subscription, error) {
+    subscription, err := self.Client.Subscribe(self.channel())
+    if err != nil { return nil, err }
+
+    if err = self.load() ; err != nil {
+        subscription.Close()
+        return nil, err
+    }
+
+    // Close may have come meanwhile -- it closes whichever subscription is current
+    self.subscribing.Lock()
+    defer self.subscribing.Unlock()
+
+    self.subscription = subscription
+    select {
+        case <-self.stop:
+            subscription.Close()
+        default:
+    }
+
+    return subscription, nil
+}
+
+//
+// The records at exactly the name (see nameIndex), read through the cache
+//
+func (self *RedisStore) owned(cleanLabel string) ([]record.Record, error) {
+    self.lock.RLock()
+    var _, exists = self.names[cleanLabel]
+    var cached, hit = self.cache[cleanLabel]
+    var generation = self.generation
+    self.lock.RUnlock()
+
+    if !exists { return nil, nil }
+    if hit { return cached, nil }
+
+    records, err := self.read(self.Client, cleanLabel)
+    if err != nil { return nil, err }
+
+    self.lock.Lock()
+    if self.generation == generation { self.cache[cleanLabel] = records }
+    self.lock.Unlock()
+
+    return records, nil
+}
+
+func (self *RedisStore) exists(cleanLabel string) bool {
+    self.lock.RLock()
+    defer self.lock.RUnlock()
+
+    var deadline, named = self.names[cleanLabel]
+    if named && (deadline.IsZero() || time.Now().Before(deadline)) { return true }
+
+    return self.descendants[cleanLabel] > 0
+}
+
+//----------------------------------------------
+// Encoding
+//----------------------------------------------
+
+// a record read from a name's hash, with its field
+type redisField struct {
+    field           string
+    sequence        uint64
+    record          record.Record
+}
+
+//
+// Read the records of a name, in the order they were added
+//
+func (self *RedisStore) read(conn redisDoer, name string) ([]record.Record, error) {
+    fields, err := self.readFields(conn, name)
+    if err != nil { return nil, err }
+
+    var result = make([]record.Record, len(fields))
+    for i, field := range fields {
+        result[i] = field.record
+    }
+
+    return result, nil
+}
+
+func (self *RedisStore) readFields(conn redisDoer, name string) ([]redisField, error) {
+    pairs, err := redis.Strings(conn.Do("HGETALL", self.key(name)))
+    if err != nil { return nil, err }
+    if len(pairs) % 2 != 0 { return nil, redis.ErrUnexpected }
+
+    var result = make([]redisField, 0, len(pairs) / 2)
+    for i := 0 ; i < len(pairs) ; i += 2 {
+        var separator = strings.LastIndexByte(pairs[i], ':')
+        sequence, err := strconv.ParseUint(pairs[i][separator + 1:], 10, 64)
+        if separator < 0 || err != nil { return nil, ErrRedisRecord }
+
+        rec, err := decodeRedisRecord(pairs[i + 1])
+        if err != nil { return nil, err }
+
+        result = append(result, redisField{ pairs[i], sequence, rec })
+    }
+
+    sort.Slice(result, func(i, j int) bool {
+        return result[i].sequence < result[j].sequence
+    })
+
+    return result, nil
+}
+
+func redisFieldName(rType uint16, sequence int64) string {
+    var name, known = record.TypeIntToString[rType]
+    if !known { name = fmt.Sprintf("TYPE%d", rType) }
+
+    return fmt.Sprintf("%s:%d", name, sequence)
+}
+
+func encodeRedisRecord(rec record.Record) (string, error) {
+    data, err := serializeRecord(rec)
+    if err != nil { return "", err }
+
+    return string(REDIS_RECORD_VERSION) + string(data), nil
+}
+
+func decodeRedisRecord(value string) (record.Record, error) {
+    if len(value) == 0 || value[0] != REDIS_RECORD_VERSION { return nil, ErrRedisRecord }
+
+    rec, finish, err := record.UnpackRecord([]byte(value[1:]), 0)
+    if err != nil { return nil, err }
+    if finish != len(value) - 1 { return nil, ErrRedisRecord }
+
+    return rec, nil
+}
+
+//
+// Escape the characters SCAN's MATCH treats specially
+//
+func escapeGlob(value string) string {
+    var result strings.Builder
+    for _, c := range value {
+        if strings.ContainsRune(`*?[]\`, c) { result.WriteByte('\\') }
+        result.WriteRune(c)
+    }
+
+    return result.String()
+}
+
+//
+// Run commands between MULTI and EXEC, returning EXEC's replies -- nil if a watched key changed
+//
+func transact(conn *redis.Conn, commands [][]string) ([]interface{}, error) {
+    conn.Send("MULTI")
+    for _, command := range commands {
+        conn.Send(command...)
+    }
+    conn.Send("EXEC")
+    if err := conn.Flush() ; err != nil { return nil, err }
+
+    // MULTI and each command are acknowledged before EXEC replies
+    var failed error
+    for i := 0 ; i <= len(commands) ; i++ {
+        if _, err := conn.Receive() ; err != nil && failed == nil { failed = err }
+    }
+
+    reply, err := conn.Receive()
+    if err == nil { err = failed }
+    if err != nil { return nil, err }
+    if reply == nil { return nil, nil }
+
+    var replies, ok = reply.([]interface{})
+    if !ok { return nil, redis.ErrUnexpected }
+    for _, item := range replies {
+        if err, failed := item.(error) ; failed { return nil, err }
+    }
+
+    return replies, nil
+}
+
+//
+// Take a connection of its own for a change -- release it with the function returned, which drops any WATCH
+//
+func (self *RedisStore) conn() (*redis.Conn, func(), error) {
+    conn, err := self.Client.Conn()
+    if err != nil { return nil, nil, err }
+
+    return conn, func() {
+        if _, err := conn.Do("UNWATCH") ; err != nil { conn.Close() }
+        conn.Release()
+    }, nil
+}
+
+//----------------------------------------------
+// DNSStore Implementation
+//----------------------------------------------
+
+func (self *RedisStore) Add(rec record.Record) error {
+    return self.add(rec, 0)
+}
+
+//
+// Add a record that Redis forgets after the lifetime, unless the name's records are added again before then
+// The lifetime applies to every record at the name, so a name holding permanent records is refused
+//
+func (self *RedisStore) AddExpiring(rec record.Record, lifetime time.Duration) error {
+    if lifetime < time.Millisecond { return ErrRedisExpiry }
+    return self.add(rec, lifetime)
+}
+
+func (self *RedisStore) add(rec record.Record, lifetime time.Duration) error {
+    if rec == nil { return ErrNilRecord }
+
+    value, err := encodeRedisRecord(rec)
+    if err != nil { return err }
+
+    sequence, err := redis.Int(self.Client.Do("INCR", self.Prefix + "sequence"))
+    if err != nil { return err }
+
+    var name = record.Canonical(rec.GetLabel())
+    var key = self.key(name)
+    var commands = [][]string{ { "HSET", key, redisFieldName(rec.GetType(), sequence), value } }
+    if lifetime > 0 {
+        commands = append(commands, []string{ "PEXPIRE", key, strconv.FormatInt(lifetime.Milliseconds(), 10) })
+    } else {
+        // the key would otherwise keep any expiry, taking this record with it
+        commands = append(commands, []string{ "PERSIST", key })
+    }
+    commands = append(commands, []string{ "PUBLISH", self.channel(), name })
+
+    conn, release, err := self.conn()
+    if err != nil { return err }
+    defer release()
+
+    for attempt := 0 ; attempt < REDIS_RETRIES ; attempt++ {
+        // records that never expire are not made to
+        if lifetime > 0 {
+            if _, err = conn.Do("WATCH", key) ; err != nil { return err }
+
+            remaining, err := redis.Int(conn.Do("PTTL", key))
+            if err != nil { return err }
+            if remaining == -1 { return ErrRedisPermanent }
+        }
+
+        replies, err := transact(conn, commands)
+        if err != nil { return err }
+        if replies != nil { return self.refresh(name) }
+    }
+
+    return ErrRedisConflict
+}
+
+func (self *RedisStore) Delete(rec record.Record) error {
+    if rec == nil { return ErrNilRecord }
+    return self.FindAndDelete(rec.GetLabel(), rec.GetType())
+}
+
+func (self *RedisStore) FindAndDelete(rLabel string, rType uint16) error {
+    if rLabel == "" { return ErrNilRecord }
+    if rType == 0 { return ErrInvalidType }
+
+    return self.change(rLabel, rType, nil)
+}
+
+func (self *RedisStore) FindAndReplace(rLabel string, rType uint16, newer record.Record) error {
+    if rLabel == "" || newer == nil { return ErrNilRecord }
+    if rType == 0 { return ErrInvalidType }
+
+    return self.change(rLabel, rType, newer)
+}
+
+//
+// Replace (or, given nil, delete) the first record of a type at a name, retrying if another writer gets there first
+//
+func (self *RedisStore) change(rLabel string, rType uint16, newer record.Record) error {
+    var name = record.Canonical(rLabel)
+    var key = self.key(name)
+
+    var value string
+    if newer != nil {
+        var err error
+        if value, err = encodeRedisRecord(newer) ; err != nil { return err }
+    }
+
+    conn, release, err := self.conn()
+    if err != nil { return err }
+    defer release()
+
+    for attempt := 0 ; attempt < REDIS_RETRIES ; attempt++ {
+        if _, err = conn.Do("WATCH", key) ; err != nil { return err }
+
+        fields, err := self.readFields(conn, name)
+        if err != nil { return err }
+
+        var target = -1
+        for i, field := range fields {
+            if field.record.GetType() == rType {
+                target = i
+                break
+            }
+        }
+        if target < 0 { return ErrNotFound }
+
+        var command = []string{ "HDEL", key, fields[target].field }
+        if newer != nil { command = []string{ "HSET", key, fields[target].field, value } }
+
+        replies, err := transact(conn, [][]string{ command, { "PUBLISH", self.channel(), name } })
+        if err != nil { return err }
+        if replies != nil { return self.refresh(name) }
+    }
+
+    return ErrRedisConflict
+}
+
+//
+// Apply the changes fn makes as one transaction (see Batcher)
+// The names fn reads or changes are watched: if another writer changes one first, fn is run again
+// on what is there now -- up to REDIS_RETRIES times, before giving up with ErrRedisConflict
+//
+func (self *RedisStore) Batch(fn func(DNSStore) error) error {
+    conn, release, err := self.conn()
+    if err != nil { return err }
+    defer release()
+
+    for attempt := 0 ; attempt < REDIS_RETRIES ; attempt++ {
+        var batch = self.batch(conn)
+        if err = fn(batch) ; err != nil { return err }
+        if len(batch.changed) == 0 { return nil }
+
+        committed, err := batch.commit()
+        if err != nil { return err }
+        if !committed {
+            conn.Do("UNWATCH")
+            continue
+        }
+
+        for _, name := range batch.changed {
+            if err = self.refresh(name) ; err != nil { return err }
+        }
+        return nil
+    }
+
+    return ErrRedisConflict
+}
+
+func (self *RedisStore) Find(rLabel string, rType uint16) (record.Record, error) {
+    if rLabel == "" { return nil, ErrNilRecord }
+
+    self.sweep()
+    return findIn(self, rLabel, rType)
+}
+
+func (self *RedisStore) FindLabel(rLabel string) ([]record.Record, error) {
+    if rLabel == "" { return nil, ErrNilRecord }
+
+    self.sweep()
+    return findLabelIn(self, rLabel)
+}
+
+func (self *RedisStore) FindRecursively(rLabel string, rType uint16) ([]record.Record, error) {
+    logging.Debug(logging.Or(self.Logger), context.Background(), "recursive lookup",
+        logging.F(logging.FIELD_NAME, rLabel), logging.F(logging.FIELD_TYPE, rType))
+
+    if rLabel == "" { return nil, ErrNilRecord }
+    if rType == 0 { return nil, ErrInvalidType }
+
+    self.sweep()
+    return findRecursivelyIn(self, rLabel, rType)
+}
+
+//
+// Call fn for every record, names visited parents first (see MapStore.Walk)
+//
+func (self *RedisStore) Walk(fn func(record.Record) error) error {
+    self.sweep()
+    return walkNames(self.sortedNames(), self.owned, fn)
+}
+
+//
+// Every name holding records, parents first
+//
+func (self *RedisStore) sortedNames() []string {
+    self.lock.RLock()
+    var names = make([]string, 0, len(self.names))
+    for name := range self.names {
+        names = append(names, name)
+    }
+    self.lock.RUnlock()
+
+    sort.Slice(names, func(i, j int) bool {
+        return labelLess(names[i], names[j])
+    })
+
+    return names
+}
+
+//
+// Snapshot the records of each name, then call fn for each (so fn may change the store)
+//
+func walkNames(names []string, owned func(string) ([]record.Record, error), fn func(record.Record) error) error {
+    var snapshot = make([]record.Record, 0, len(names))
+    for _, name := range names {
+        records, err := owned(name)
+        if err != nil { return err }
+
+        for _, rec := range records {
+            snapshot = append(snapshot, record.Copy(rec))
+        }
+    }
+
+    for _, rec := range snapshot {
+        if err := fn(rec) ; err != nil { return err }
+    }
+
+    return nil
+}
+
+//
+// The number of records, counted in Redis -- zero if it cannot be reached
+//
+func (self *RedisStore) Size() int64 {
+    self.sweep()
+    var names = self.sortedNames()
+
+    var total int64 = 0
+    for start := 0 ; start < len(names) ; start += REDIS_SCAN_COUNT {
+        var chunk = names[start:min(start + REDIS_SCAN_COUNT, len(names))]
+
+        var commands = make([][]string, len(chunk))
+        for i, name := range chunk {
+            commands[i] = []string{ "HLEN", self.key(name) }
+        }
+
+        replies, err := self.Client.Pipeline(commands)
+        if err != nil { return 0 }
+
+        for _, reply := range replies {
+            count, _ := redis.Int(reply, nil)
+            total += count
+        }
+    }
+
+    return total
+}
+
+func (self *RedisStore) LabelSize(label string) int {
+    if label == "" { return 0 }
+
+    self.sweep()
+    var records, _ = self.owned(record.Canonical(label))
+    return len(records)
+}
+
+//----------------------------------------------
+// Batches
+//----------------------------------------------
+
+//
+// The store as seen inside a batch: the names read are watched, and the changes kept until commit
+//
+type redisBatch struct {
+    store           *RedisStore
+    conn            *redis.Conn
+
+    names           map[string]bool
+    descendants     map[string]int
+    contents        map[string][]record.Record      // names read or changed by the batch
+    changed         []string
+    added           map[string]bool                 // names records were added to, which stop expiring (see Add)
+    size            int64                           // records added less those deleted
+}
+
+func (self *RedisStore) batch(conn *redis.Conn) *redisBatch {
+    self.sweep()
+
+    self.lock.RLock()
+    defer self.lock.RUnlock()
+
+    var result = &redisBatch{
+        store:          self,
+        conn:           conn,
+        names:          make(map[string]bool, len(self.names)),
+        descendants:    make(map[string]int, len(self.descendants)),
+        contents:       make(map[string][]record.Record, 0),
+        changed:        make([]string, 0),
+        added:          make(map[string]bool, 0),
+    }
+
+    for name := range self.names {
+        result.names[name] = true
+    }
+    for name, count := range self.descendants {
+        result.descendants[name] = count
+    }
+
+    return result
+}
+
+//
+// The records of a name, watched and read from Redis the first time the batch asks
+//
+func (self *redisBatch) owned(cleanLabel string) ([]record.Record, error) {
+    if records, read := self.contents[cleanLabel] ; read { return records, nil }
+
+    if _, err := self.conn.Do("WATCH", self.store.key(cleanLabel)) ; err != nil { return nil, err }
+
+    records, err := self.store.read(self.conn, cleanLabel)
+    if err != nil { return nil, err }
+
+    self.contents[cleanLabel] = records
+    self.setName(cleanLabel, len(records) > 0)
+    return records, nil
+}
+
+func (self *redisBatch) exists(cleanLabel string) bool {
+    return self.names[cleanLabel] || self.descendants[cleanLabel] > 0
+}
+
+func (self *redisBatch) setName(name string, exists bool) {
+    if exists == self.names[name] { return }
+
+    if exists {
+        self.names[name] = true
+        countAncestors(self.descendants, name, 1)
+    } else {
+        delete(self.names, name)
+        countAncestors(self.descendants, name, -1)
+    }
+}
+
+//
+// Swap in a name's new records
+//
+func (self *redisBatch) set(name string, records []record.Record) {
+    var first = true
+    for _, changed := range self.changed {
+        if changed == name { first = false }
+    }
+    if first { self.changed = append(self.changed, name) }
+
+    self.contents[name] = records
+    self.setName(name, len(records) > 0)
+}
+
+func (self *redisBatch) Add(rec record.Record) error {
+    if rec == nil { return ErrNilRecord }
+    if _, err := encodeRedisRecord(rec) ; err != nil { return err }
+
+    var name = record.Canonical(rec.GetLabel())
+    current, err := self.owned(name)
+    if err != nil { return err }
+
+    self.set(name, append(append([]record.Record(nil), current...), rec))
+    self.added[name] = true
+    self.size += 1
+    return nil
+}
+
+func (self *redisBatch) Delete(rec record.Record) error {
+    if rec == nil { return ErrNilRecord }
+    return self.FindAndDelete(rec.GetLabel(), rec.GetType())
+}
+
+func (self *redisBatch) FindAndDelete(rLabel string, rType uint16) error {
+    if rLabel == "" { return ErrNilRecord }
+    if rType == 0 { return ErrInvalidType }
+
+    var name = record.Canonical(rLabel)
+    current, err := self.owned(name)
+    if err != nil { return err }
+
+    for i, curr := range current {
+        if curr.GetType() != rType { continue }
+
+        var remaining = append(append([]record.Record(nil), current[:i]...), current[i + 1:]...)
+        self.set(name, remaining)
+        self.size -= 1
+        return nil
+    }
+
+    return ErrNotFound
+}
+
+func (self *redisBatch) FindAndReplace(rLabel string, rType uint16, newer record.Record) error {
+    if rLabel == "" || newer == nil { return ErrNilRecord }
+    if rType == 0 { return ErrInvalidType }
+    if _, err := encodeRedisRecord(newer) ; err != nil { return err }
+
+    var name = record.Canonical(rLabel)
+    current, err := self.owned(name)
+    if err != nil { return err }
+
+    for i, curr := range current {
+        if curr.GetType() != rType { continue }
+
+        var replaced = append([]record.Record(nil), current...)
+        replaced[i] = newer
+        self.set(name, replaced)
+        return nil
+    }
+
+    return ErrNotFound
+}
+
+func (self *redisBatch) Find(rLabel string, rType uint16) (record.Record, error) {
+    if rLabel == "" { return nil, ErrNilRecord }
+    return findIn(self, rLabel, rType)
+}
+
+func (self *redisBatch) FindLabel(rLabel string) ([]record.Record, error) {
+    if rLabel == "" { return nil, ErrNilRecord }
+    return findLabelIn(self, rLabel)
+}
+
+func (self *redisBatch) FindRecursively(rLabel string, rType uint16) ([]record.Record, error) {
+    if rLabel == "" { return nil, ErrNilRecord }
+    if rType == 0 { return nil, ErrInvalidType }
+    return findRecursivelyIn(self, rLabel, rType)
+}
+
+//
+// Walk the names as the batch sees them -- those it has not read come from the cache, unwatched
+//
+func (self *redisBatch) Walk(fn func(record.Record) error) error {
+    var names = make([]string, 0, len(self.names))
+    for name := range self.names {
+        names = append(names, name)
+    }
+    sort.Slice(names, func(i, j int) bool {
+        return labelLess(names[i], names[j])
+    })
+
+    return walkNames(names, func(name string) ([]record.Record, error) {
+        if records, read := self.contents[name] ; read { return records, nil }
+        return self.store.owned(name)
+    }, fn)
+}
+
+func (self *redisBatch) Size() int64 {
+    return self.store.Size() + self.size
+}
+
+func (self *redisBatch) LabelSize(label string) int {
+    if label == "" { return 0 }
+
+    var records, _ = self.owned(record.Canonical(label))
+    return len(records)
+}
+
+//
+// Write every changed name whole -- false if a watched name changed first
+// Each keeps its expiry, unless records were added to it (see RedisStore.Add)
+//
+func (self *redisBatch) commit() (bool, error) {
+    var store = self.store
+
+    // the names changed were all read (and so watched) first
+    for _, name := range self.changed {
+        self.conn.Send("PTTL", store.key(name))
+    }
+    if err := self.conn.Flush() ; err != nil { return false, err }
+
+    var lifetimes = make([]int64, len(self.changed))
+    var count = 0
+    for i, name := range self.changed {
+        lifetime, err := redis.Int(self.conn.Receive())
+        if err != nil { return false, err }
+
+        lifetimes[i] = lifetime
+        count += len(self.contents[name])
+    }
+
+    // sequences are handed out whether or not the transaction goes through
+    last, err := redis.Int(store.Client.Do("INCRBY", store.Prefix + "sequence", strconv.Itoa(count)))
+    if err != nil { return false, err }
+    var sequence = last - int64(count)
+
+    var commands = make([][]string, 0, 3 * len(self.changed))
+    for i, name := range self.changed {
+        var key = store.key(name)
+        commands = append(commands, []string{ "DEL", key })
+
+        var records = self.contents[name]
+        if len(records) > 0 {
+            var command = []string{ "HSET", key }
+            for _, rec := range records {
+                sequence += 1
+
+                value, err := encodeRedisRecord(rec)
+                if err != nil { return false, err }
+                command = append(command, redisFieldName(rec.GetType(), sequence), value)
+            }
+            commands = append(commands, command)
+
+            if lifetimes[i] > 0 && !self.added[name] {
+                commands = append(commands, []string{ "PEXPIRE", key, strconv.FormatInt(lifetimes[i], 10) })
+            }
+        }
+
+        commands = append(commands, []string{ "PUBLISH", store.channel(), name })
+    }
+
+    replies, err := transact(self.conn, commands)
+    if err != nil { return false, err }
+
+    return replies != nil, nil
+}
@@ -0,0 +1,497 @@
+package redis
+
+import (
+    "io"
+    "net"
+    "sync"
+    "time"
+    "bufio"
+    "errors"
+    "strconv"
+    "strings"
+)
+
+const (
+    // connections kept open between commands
+    MAX_IDLE            int             = 8
+
+    DEFAULT_TIMEOUT     time.Duration   = 5 * time.Second
+
+    // the longest bulk string Redis itself allows -- and the most elements taken in an array
+    MAX_REPLY_LENGTH    int             = 512 * 1024 * 1024
+
+    // arrays nest no deeper than this in any reply we ask for
+    MAX_REPLY_DEPTH     int             = 8
+)
+
+var ErrClosed       error   = errors.New("ERROR: redis: the client is closed")
+var ErrProtocol     error   = errors.New("ERROR: redis: malformed reply")
+var ErrNil          error   = errors.New("ERROR: redis: nil reply")
+var ErrUnexpected   error   = errors.New("ERROR: redis: unexpected reply type")
+
+//
+// An error reply from the server ("ERR unknown command", "WRONGTYPE ...") -- the connection is still usable
+//
+type Error string
+
+func (self Error) Error() string {
+    return "ERROR: redis: " + string(self)
+}
+
+//----------------------------------------------
+// Client
+//----------------------------------------------
+
+//
+// A client for a Redis server, speaking RESP (version 2) over TCP
+// Safe for concurrent use -- commands are sent over a small pool of connections
+//
+// Replies are returned as:
+//    simple and bulk strings:  string
+//    integers:                 int64
+//    arrays:                   []interface{}
+//    nil bulk strings/arrays:  nil
+//    error replies:            an Error (as the error)
+//
+type Client struct {
+    Address         string
+    Password        string                  // sent with AUTH on connecting, if set
+    Database        int                     // chosen with SELECT on connecting, if not 0
+    Timeout         time.Duration           // for connecting and for each command -- zero uses DEFAULT_TIMEOUT
+
+    idle            []*Conn
+    closed          bool
+    lock            sync.Mutex
+}
+
+func NewClient(address string) *Client {
+    return &Client{ Address: address }
+}
+
+//
+// Run a command on a pooled connection
+//
+func (self *Client) Do(args ...string) (interface{}, error) {
+    conn, err := self.Conn()
+    if err != nil { return nil, err }
+    defer conn.Release()
+
+    return conn.Do(args...)
+}
+
+//
+// Send several commands at once, reading their replies in order (an error reply is kept in its place)
+// The error returned is only for the connection failing
+//
+func (self *Client) Pipeline(commands [][]string) ([]interface{}, error) {
+    conn, err := self.Conn()
+    if err != nil { return nil, err }
+    defer conn.Release()
+
+    for _, command := range commands {
+        if err = conn.Send(command...) ; err != nil { return nil, err }
+    }
+    if err = conn.Flush() ; err != nil { return nil, err }
+
+    var replies = make([]interface{}, len(commands))
+    for i := range commands {
+        reply, err := conn.Receive()
+        if _, isReply := err.(Error) ; err != nil && !isReply { return nil, err }
+
+        if err != nil {
+            replies[i] = err
+        } else {
+            replies[i] = reply
+        }
+    }
+
+    return replies, nil
+}
+
+//
+// Take a connection of its own (for WATCH and MULTI, which hold state on the connection)
+// Release it when done -- it returns to the pool unless it failed, or was left watching keys or in a transaction
+//
+func (self *Client) Conn() (*Conn, error) {
+    self.lock.Lock()
+    if self.closed {
+        self.lock.Unlock()
+        return nil, ErrClosed
+    }
+
+    if count := len(self.idle) ; count > 0 {
+        var conn = self.idle[count - 1]
+        self.idle = self.idle[:count - 1]
+        self.lock.Unlock()
+        return conn, nil
+    }
+    self.lock.Unlock()
+
+    return self.dial()
+}
+
+func (self *Client) dial() (*Conn, error) {
+    var timeout = self.timeout()
+    socket, err := net.DialTimeout("tcp", self.Address, timeout)
+    if err != nil { return nil, err }
+
+    var conn = &Conn{
+        socket:     socket,
+        reader:     bufio.NewReader(socket),
+        writer:     bufio.NewWriter(socket),
+        timeout:    timeout,
+        client:     self,
+    }
+
+    if self.Password != "" {
+        if _, err = conn.Do("AUTH", self.Password) ; err != nil {
+            conn.Close()
+            return nil, err
+        }
+    }
+    if self.Database != 0 {
+        if _, err = conn.Do("SELECT", strconv.Itoa(self.Database)) ; err != nil {
+            conn.Close()
+            return nil, err
+        }
+    }
+
+    return conn, nil
+}
+
+func (self *Client) timeout() time.Duration {
+    if self.Timeout <= 0 { return DEFAULT_TIMEOUT }
+    return self.Timeout
+}
+
+//
+// Return a connection to the pool, or close it if the pool is full (or closed)
+//
+func (self *Client) release(conn *Conn) {
+    self.lock.Lock()
+    defer self.lock.Unlock()
+
+    if self.closed || len(self.idle) >= MAX_IDLE {
+        conn.Close()
+        return
+    }
+
+    self.idle = append(self.idle, conn)
+}
+
+//
+// Close the pooled connections -- connections taken (and subscriptions) are closed as they are released
+//
+func (self *Client) Close() error {
+    self.lock.Lock()
+    defer self.lock.Unlock()
+
+    self.closed = true
+    for _, conn := range self.idle {
+        conn.Close()
+    }
+    self.idle = nil
+
+    return nil
+}
+
+//----------------------------------------------
+// Connection
+//----------------------------------------------
+
+//
+// A single connection -- not safe for concurrent use
+//
+type Conn struct {
+    socket          net.Conn
+    reader          *bufio.Reader
+    writer          *bufio.Writer
+    timeout         time.Duration           // zero blocks forever (subscriptions)
+
+    client          *Client
+    broken          bool
+    watching        bool                    // keys are WATCHed
+    queueing        bool                    // MULTI was sent without EXEC or DISCARD
+}
+
+//
+// Send a command and read its reply
+//
+func (self *Conn) Do(args ...string) (interface{}, error) {
+    if err := self.Send(args...) ; err != nil { return nil, err }
+    if err := self.Flush() ; err != nil { return nil, err }
+
+    return self.Receive()
+}
+
+//
+// Buffer a command, to be sent with Flush
+//
+func (self *Conn) Send(args ...string) error {
+    self.track(args)
+
+    self.writer.WriteByte('*')
+    self.writer.WriteString(strconv.Itoa(len(args)))
+    self.writer.WriteString("\r\n")
+
+    for _, arg := range args {
+        self.writer.WriteByte('$')
+        self.writer.WriteString(strconv.Itoa(len(arg)))
+        self.writer.WriteString("\r\n")
+        self.writer.WriteString(arg)
+        self.writer.WriteString("\r\n")
+    }
+
+    return nil
+}
+
+func (self *Conn) Flush() error {
+    self.deadline()
+    return self.fail(self.writer.Flush())
+}
+
+//
+// Read the next reply
+//
+func (self *Conn) Receive() (interface{}, error) {
+    self.deadline()
+
+    reply, err := readReply(self.reader, 0)
+    if _, isReply := err.(Error) ; err != nil && !isReply { return nil, self.fail(err) }
+
+    return reply, err
+}
+
+func (self *Conn) deadline() {
+    if self.timeout > 0 {
+        self.socket.SetDeadline(time.Now().Add(self.timeout))
+    } else {
+        self.socket.SetDeadline(time.Time{})
+    }
+}
+
+//
+// Follow the transaction state a command leaves on the connection -- assumed, from sending it, to take effect
+//
+func (self *Conn) track(args []string) {
+    if len(args) == 0 { return }
+
+    switch strings.ToUpper(args[0]) {
+        case "WATCH":
+            if !self.queueing { self.watching = true }
+        case "UNWATCH":
+            if !self.queueing { self.watching = false }
+        case "MULTI":
+            self.queueing = true
+        case "EXEC", "DISCARD":
+            self.watching, self.queueing = false, false
+    }
+}
+
+//
+// Note a failed connection, so it is not reused
+//
+func (self *Conn) fail(err error) error {
+    if err != nil { self.broken = true }
+    return err
+}
+
+//
+// Hand the connection back to its client, or close it if it failed or is left watching keys or in a transaction
+//
+func (self *Conn) Release() {
+    if self.broken || self.client == nil || self.watching || self.queueing {
+        self.Close()
+        return
+    }
+
+    self.client.release(self)
+}
+
+func (self *Conn) Close() error {
+    self.broken = true
+    return self.socket.Close()
+}
+
+//----------------------------------------------
+// Subscriptions
+//----------------------------------------------
+
+//
+// A connection subscribed to channels -- it receives messages until closed
+//
+type Subscription struct {
+    conn            *Conn
+}
+
+//
+// Subscribe to channels on a connection of their own, which waits for messages without a timeout
+//
+func (self *Client) Subscribe(channels ...string) (*Subscription, error) {
+    conn, err := self.dial()
+    if err != nil { return nil, err }
+
+    var args = append([]string{ "SUBSCRIBE" }, channels...)
+    if err = conn.Send(args...) ; err == nil { err = conn.Flush() }
+
+    // each channel is confirmed in turn
+    for i := 0 ; err == nil && i < len(channels) ; i++ {
+        var reply interface{}
+        reply, err = conn.Receive()
+
+        var fields, _ = reply.([]interface{})
+        if err == nil && (len(fields) != 3 || fields[0] != "subscribe") { err = ErrUnexpected }
+    }
+
+    if err != nil {
+        conn.Close()
+        return nil, err
+    }
+
+    conn.timeout = 0
+    return &Subscription{ conn }, nil
+}
+
+//
+// Wait for the next message, returning its channel and payload
+// Returns an error once the subscription is closed (or its connection fails)
+//
+func (self *Subscription) Receive() (string, string, error) {
+    for {
+        reply, err := self.conn.Receive()
+        if err != nil { return "", "", err }
+
+        var fields, _ = reply.([]interface{})
+        if len(fields) != 3 || fields[0] != "message" { continue }
+
+        var channel, _ = fields[1].(string)
+        var payload, _ = fields[2].(string)
+        return channel, payload, nil
+    }
+}
+
+func (self *Subscription) Close() error {
+    return self.conn.Close()
+}
+
+//----------------------------------------------
+// Reply Helpers
+//----------------------------------------------
+
+//
+// Convert a reply to a string, passing errors through -- a nil reply is ErrNil
+//
+func String(reply interface{}, err error) (string, error) {
+    if err != nil { return "", err }
+
+    switch typed := reply.(type) {
+        case string:    return typed, nil
+        case int64:     return strconv.FormatInt(typed, 10), nil
+        case nil:       return "", ErrNil
+    }
+    return "", ErrUnexpected
+}
+
+//
+// Convert a reply to an integer, passing errors through -- a nil reply is ErrNil
+//
+func Int(reply interface{}, err error) (int64, error) {
+    if err != nil { return 0, err }
+
+    switch typed := reply.(type) {
+        case int64:
+            return typed, nil
+        case string:
+            number, err := strconv.ParseInt(typed, 10, 64)
+            if err != nil { return 0, ErrUnexpected }
+            return number, nil
+        case nil:
+            return 0, ErrNil
+    }
+    return 0, ErrUnexpected
+}
+
+//
+// Convert an array reply to strings, passing errors through -- a nil array is empty
+//
+func Strings(reply interface{}, err error) ([]string, error) {
+    if err != nil { return nil, err }
+    if reply == nil { return []string{}, nil }
+
+    var fields, ok = reply.([]interface{})
+    if !ok { return nil, ErrUnexpected }
+
+    var result = make([]string, len(fields))
+    for i, field := range fields {
+        if result[i], err = String(field, nil) ; err != nil && err != ErrNil { return nil, err }
+    }
+
+    return result, nil
+}
+
+//----------------------------------------------
+// Protocol
+//----------------------------------------------
+
+//
+// Read one RESP value, nested depth arrays deep
+// Lengths past MAX_REPLY_LENGTH, and arrays past MAX_REPLY_DEPTH, are refused before anything is allocated for them
+//
+func readReply(reader *bufio.Reader, depth int) (interface{}, error) {
+    line, err := readLine(reader)
+    if err != nil { return nil, err }
+    if len(line) == 0 { return nil, ErrProtocol }
+
+    switch line[0] {
+        case '+':
+            return line[1:], nil
+
+        case '-':
+            return nil, Error(line[1:])
+
+        case ':':
+            number, err := strconv.ParseInt(line[1:], 10, 64)
+            if err != nil { return nil, ErrProtocol }
+            return number, nil
+
+        case '$':
+            length, err := strconv.Atoi(line[1:])
+            if err != nil || length < -1 || length > MAX_REPLY_LENGTH { return nil, ErrProtocol }
+            if length == -1 { return nil, nil }
+
+            var data = make([]byte, length + 2)
+            if _, err = io.ReadFull(reader, data) ; err != nil { return nil, err }
+            if data[length] != '\r' || data[length + 1] != '\n' { return nil, ErrProtocol }
+            return string(data[:length]), nil
+
+        case '*':
+            count, err := strconv.Atoi(line[1:])
+            if err != nil || count < -1 || count > MAX_REPLY_LENGTH || depth >= MAX_REPLY_DEPTH { return nil, ErrProtocol }
+            if count == -1 { return nil, nil }
+
+            // grown as elements arrive, rather than trusting the count
+            // an error inside an array (EXEC's replies) is kept in its place
+            var result = make([]interface{}, 0, min(count, 1024))
+            for i := 0 ; i < count ; i++ {
+                value, err := readReply(reader, depth + 1)
+                if _, isReply := err.(Error) ; err != nil && !isReply { return nil, err }
+
+                if err != nil {
+                    result = append(result, err)
+                } else {
+                    result = append(result, value)
+                }
+            }
+            return result, nil
+    }
+
+    return nil, ErrProtocol
+}
+
+func readLine(reader *bufio.Reader) (string, error) {
+    line, err := reader.ReadString('\n')
+    if err != nil { return "", err }
+    if len(line) < 2 || line[len(line) - 2] != '\r' { return "", ErrProtocol }
+
+    return line[:len(line) - 2], nil
+}
@@ -0,0 +1,233 @@
+package redis_test
+
+import (
+    "net"
+    "strings"
+    "testing"
+
+    "github.com/zmarcantel/phonebook/server/store/redis"
+    "github.com/zmarcantel/phonebook/server/store/redis/redistest"
+)
+
+//----------------------------------------------
+// Helpers
+//----------------------------------------------
+
+func testClient(t *testing.T) (*redis.Client, *redistest.Server) {
+    server, err := redistest.NewServer()
+    if err != nil { t.Fatal(err) }
+
+    var client = redis.NewClient(server.Addr())
+    t.Cleanup(func() {
+        client.Close()
+        server.Close()
+    })
+
+    return client, server
+}
+
+//
+// Answer whatever is sent first with a fixed reply, as a broken (or hostile) server might
+//
+func testRawServer(t *testing.T, reply string) string {
+    listener, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil { t.Fatal(err) }
+    t.Cleanup(func() { listener.Close() })
+
+    go func() {
+        conn, err := listener.Accept()
+        if err != nil { return }
+        defer conn.Close()
+
+        conn.Read(make([]byte, 4096))
+        conn.Write([]byte(reply))
+
+        // held open until the client gives up, so it fails on the reply rather than the connection closing
+        conn.Read(make([]byte, 1))
+    }()
+
+    return listener.Addr().String()
+}
+
+//----------------------------------------------
+// Command Tests
+//----------------------------------------------
+
+func TestClient_Do(t *testing.T) {
+    var client, _ = testClient(t)
+
+    count, err := redis.Int(client.Do("INCRBY", "counter", "5"))
+    if err != nil { t.Fatal(err) }
+    if count != 5 {
+        t.Errorf("Incorrect INCRBY:\n\tExpected: %d\n\tGot: %d\n", 5, count)
+    }
+
+    if _, err = client.Do("HSET", "hash", "b", "2", "a", "1") ; err != nil { t.Fatal(err) }
+    fields, err := redis.Strings(client.Do("HGETALL", "hash"))
+    if err != nil { t.Fatal(err) }
+    if len(fields) != 4 || fields[0] != "a" || fields[3] != "2" {
+        t.Errorf("Incorrect HGETALL:\n\tExpected: %v\n\tGot: %v\n", []string{ "a", "1", "b", "2" }, fields)
+    }
+
+    // an error reply leaves the connection usable
+    _, err = client.Do("INCR", "hash")
+    if _, isReply := err.(redis.Error) ; !isReply {
+        t.Errorf("Incorrect Error:\n\tExpected: a WRONGTYPE reply\n\tGot: %v\n", err)
+    }
+    if reply, err := redis.String(client.Do("PING")) ; err != nil || reply != "PONG" {
+        t.Errorf("Incorrect PING:\n\tExpected: PONG\n\tGot: %q (%v)\n", reply, err)
+    }
+}
+
+func TestClient_Pipeline(t *testing.T) {
+    var client, _ = testClient(t)
+
+    replies, err := client.Pipeline([][]string{
+        { "INCR", "counter" },
+        { "HSET", "counter", "field", "value" },
+        { "INCR", "counter" },
+    })
+    if err != nil { t.Fatal(err) }
+
+    if first, _ := redis.Int(replies[0], nil) ; first != 1 {
+        t.Errorf("Incorrect First Reply:\n\tExpected: %d\n\tGot: %v\n", 1, replies[0])
+    }
+    if _, isReply := replies[1].(redis.Error) ; !isReply {
+        t.Errorf("Incorrect Second Reply:\n\tExpected: a WRONGTYPE reply\n\tGot: %v\n", replies[1])
+    }
+    if third, _ := redis.Int(replies[2], nil) ; third != 2 {
+        t.Errorf("Incorrect Third Reply:\n\tExpected: %d\n\tGot: %v\n", 2, replies[2])
+    }
+}
+
+func TestClient_Transaction(t *testing.T) {
+    var client, _ = testClient(t)
+
+    conn, err := client.Conn()
+    if err != nil { t.Fatal(err) }
+    defer conn.Release()
+
+    // a watched key changed by another connection aborts EXEC
+    if _, err = conn.Do("WATCH", "counter") ; err != nil { t.Fatal(err) }
+    if _, err = client.Do("INCR", "counter") ; err != nil { t.Fatal(err) }
+
+    conn.Do("MULTI")
+    conn.Do("INCR", "counter")
+    reply, err := conn.Do("EXEC")
+    if err != nil || reply != nil {
+        t.Errorf("Incorrect EXEC:\n\tExpected: nil\n\tGot: %v (%v)\n", reply, err)
+    }
+
+    // and one left alone does not
+    if _, err = conn.Do("WATCH", "counter") ; err != nil { t.Fatal(err) }
+    conn.Do("MULTI")
+    conn.Do("INCR", "counter")
+    replies, err := conn.Do("EXEC")
+    if err != nil { t.Fatal(err) }
+
+    var results, _ = replies.([]interface{})
+    if len(results) != 1 || results[0] != int64(2) {
+        t.Errorf("Incorrect EXEC:\n\tExpected: %v\n\tGot: %v\n", []interface{}{ int64(2) }, replies)
+    }
+}
+
+func TestClient_ReleaseTransaction(t *testing.T) {
+    var client, _ = testClient(t)
+
+    // a connection done with goes back to the pool
+    conn, err := client.Conn()
+    if err != nil { t.Fatal(err) }
+    conn.Do("PING")
+    conn.Release()
+
+    again, err := client.Conn()
+    if err != nil { t.Fatal(err) }
+    if again != conn {
+        t.Errorf("Incorrect Release:\n\tExpected: the connection reused\n\tGot: a new one\n")
+    }
+
+    // while one left watching keys, or in a transaction, is closed rather than lend its state to the next user
+    for _, command := range []string{ "WATCH", "MULTI" } {
+        var args = []string{ command }
+        if command == "WATCH" { args = append(args, "counter") }
+
+        if _, err = again.Do(args...) ; err != nil { t.Fatal(err) }
+        again.Release()
+
+        next, err := client.Conn()
+        if err != nil { t.Fatal(err) }
+        if next == again {
+            t.Errorf("Incorrect Release:\n\tCase: %s\n\tExpected: a new connection\n\tGot: the one left in %s\n", command, command)
+        }
+        again = next
+    }
+
+    // EXEC ends both
+    again.Do("WATCH", "counter")
+    again.Do("MULTI")
+    again.Do("INCR", "counter")
+    if _, err = again.Do("EXEC") ; err != nil { t.Fatal(err) }
+    again.Release()
+
+    last, err := client.Conn()
+    if err != nil { t.Fatal(err) }
+    defer last.Release()
+    if last != again {
+        t.Errorf("Incorrect Release:\n\tExpected: the connection reused after EXEC\n\tGot: a new one\n")
+    }
+}
+
+func TestClient_ReplyLimits(t *testing.T) {
+    var cases = map[string]string{
+        "long string":      "$" + "536870913" + "\r\n",
+        "long array":       "*" + "536870913" + "\r\n",
+        "deep array":       strings.Repeat("*1\r\n", redis.MAX_REPLY_DEPTH + 1) + ":1\r\n",
+    }
+
+    // refused before anything is allocated for them
+    for name, reply := range cases {
+        var client = redis.NewClient(testRawServer(t, reply))
+        if _, err := client.Do("GET", "key") ; err != redis.ErrProtocol {
+            t.Errorf("Incorrect Error:\n\tCase: %s\n\tExpected: %v\n\tGot: %v\n", name, redis.ErrProtocol, err)
+        }
+        client.Close()
+    }
+
+    // while nesting as deep as allowed is read
+    var client = redis.NewClient(testRawServer(t, strings.Repeat("*1\r\n", redis.MAX_REPLY_DEPTH) + ":1\r\n"))
+    defer client.Close()
+    if _, err := client.Do("GET", "key") ; err != nil {
+        t.Errorf("Incorrect Error:\n\tExpected: nil\n\tGot: %v\n", err)
+    }
+}
+
+func TestClient_Subscribe(t *testing.T) {
+    var client, server = testClient(t)
+
+    subscription, err := client.Subscribe("first", "second")
+    if err != nil { t.Fatal(err) }
+    defer subscription.Close()
+
+    if count, err := redis.Int(client.Do("PUBLISH", "second", "hello")) ; err != nil || count != 1 {
+        t.Errorf("Incorrect PUBLISH:\n\tExpected: %d\n\tGot: %d (%v)\n", 1, count, err)
+    }
+
+    channel, payload, err := subscription.Receive()
+    if err != nil { t.Fatal(err) }
+    if channel != "second" || payload != "hello" {
+        t.Errorf("Incorrect Message:\n\tExpected: second: hello\n\tGot: %s: %s\n", channel, payload)
+    }
+
+    // losing the connection ends the subscription
+    server.Disconnect()
+    if _, _, err = subscription.Receive() ; err == nil {
+        t.Errorf("Incorrect Receive:\n\tExpected: an error once disconnected\n\tGot: nil\n")
+    }
+
+    // the pooled connection was dropped too: it fails once, and is replaced
+    if _, err = client.Do("PING") ; err == nil {
+        t.Errorf("Incorrect PING:\n\tExpected: an error on the dropped connection\n\tGot: nil\n")
+    } else {
+        if _, err = client.Do("PING") ; err != nil { t.Fatal(err) }
+    }
+}
@@ -0,0 +1,524 @@
+package redistest
+
+import (
+    "io"
+    "net"
+    "path"
+    "sort"
+    "sync"
+    "time"
+    "bufio"
+    "strconv"
+    "strings"
+
+    "github.com/zmarcantel/phonebook/server/store/redis"
+)
+
+//----------------------------------------------
+// In-Process Stand-In
+//----------------------------------------------
+
+//
+// A stand-in for redis-server, listening on the loopback interface, for tests without one
+// (a package of its own, so only tests build it in)
+//
+// It speaks enough of Redis for the redis client and the store built on it: strings (as counters), hashes,
+// key expiry, SCAN, WATCH/MULTI/EXEC, and PUBLISH/SUBSCRIBE. Data is kept in memory and lost on Close
+//
+type Server struct {
+    listener        net.Listener
+
+    values          map[string]*fakeValue
+    versions        map[string]uint64       // bumped on every change to a key, for WATCH
+    version         uint64
+    subscribers     map[string]map[*fakeClient]bool
+
+    clients         map[*fakeClient]bool
+    lock            sync.Mutex
+    done            sync.WaitGroup
+}
+
+type fakeValue struct {
+    counter         string
+    hash            map[string]string       // nil for a counter
+    expires         time.Time               // zero never expires
+}
+
+type fakeClient struct {
+    conn            net.Conn
+    writer          *bufio.Writer
+    writing         sync.Mutex
+
+    watched         map[string]uint64
+    queued          [][]string              // nil unless in MULTI
+    subscribed      int
+}
+
+//
+// Start a stand-in listening on a free port of 127.0.0.1
+//
+func NewServer() (*Server, error) {
+    listener, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil { return nil, err }
+
+    var result = &Server{
+        listener:       listener,
+        values:         make(map[string]*fakeValue, 0),
+        versions:       make(map[string]uint64, 0),
+        subscribers:    make(map[string]map[*fakeClient]bool, 0),
+        clients:        make(map[*fakeClient]bool, 0),
+    }
+
+    result.done.Add(1)
+    go result.accept()
+    return result, nil
+}
+
+func (self *Server) Addr() string {
+    return self.listener.Addr().String()
+}
+
+//
+// Stop listening and drop every client
+//
+func (self *Server) Close() error {
+    var err = self.listener.Close()
+
+    self.lock.Lock()
+    for client := range self.clients {
+        client.conn.Close()
+    }
+    self.lock.Unlock()
+
+    self.done.Wait()
+    return err
+}
+
+//
+// Drop every client without stopping, as a restarted server would
+//
+func (self *Server) Disconnect() {
+    self.lock.Lock()
+    defer self.lock.Unlock()
+
+    for client := range self.clients {
+        client.conn.Close()
+    }
+}
+
+func (self *Server) accept() {
+    defer self.done.Done()
+
+    for {
+        conn, err := self.listener.Accept()
+        if err != nil { return }
+
+        var client = &fakeClient{ conn: conn, writer: bufio.NewWriter(conn) }
+        self.lock.Lock()
+        self.clients[client] = true
+        self.lock.Unlock()
+
+        self.done.Add(1)
+        go self.serve(client)
+    }
+}
+
+func (self *Server) serve(client *fakeClient) {
+    defer self.done.Done()
+    defer func() {
+        self.lock.Lock()
+        delete(self.clients, client)
+        for _, subscribers := range self.subscribers {
+            delete(subscribers, client)
+        }
+        self.lock.Unlock()
+        client.conn.Close()
+    }()
+
+    var reader = bufio.NewReader(client.conn)
+    for {
+        command, err := readCommand(reader)
+        if err != nil { return }
+        if len(command) == 0 { continue }
+
+        var name = strings.ToUpper(command[0])
+        if name == "QUIT" {
+            client.reply(fakeStatus("OK"))
+            return
+        }
+
+        client.reply(self.run(client, name, command[1:]))
+    }
+}
+
+//
+// Read a command sent as an array of bulk strings
+//
+func readCommand(reader *bufio.Reader) ([]string, error) {
+    line, err := readLine(reader)
+    if err != nil { return nil, err }
+    if len(line) == 0 || line[0] != '*' { return nil, redis.ErrProtocol }
+
+    count, err := strconv.Atoi(line[1:])
+    if err != nil { return nil, redis.ErrProtocol }
+
+    var result = make([]string, 0, count)
+    for i := 0 ; i < count ; i++ {
+        line, err = readLine(reader)
+        if err != nil { return nil, err }
+        if len(line) == 0 || line[0] != '$' { return nil, redis.ErrProtocol }
+
+        length, err := strconv.Atoi(line[1:])
+        if err != nil || length < 0 { return nil, redis.ErrProtocol }
+
+        var data = make([]byte, length + 2)
+        if _, err = io.ReadFull(reader, data) ; err != nil { return nil, err }
+        result = append(result, string(data[:length]))
+    }
+
+    return result, nil
+}
+
+func readLine(reader *bufio.Reader) (string, error) {
+    line, err := reader.ReadString('\n')
+    if err != nil { return "", err }
+    if len(line) < 2 || line[len(line) - 2] != '\r' { return "", redis.ErrProtocol }
+
+    return line[:len(line) - 2], nil
+}
+
+//
+// Write a reply: string, int64, []interface{}, nil, or a redis.Error (an OK status is the string "OK")
+//
+func (self *fakeClient) reply(value interface{}) {
+    self.writing.Lock()
+    defer self.writing.Unlock()
+
+    writeReply(self.writer, value)
+    self.writer.Flush()
+}
+
+func writeReply(writer *bufio.Writer, value interface{}) {
+    switch typed := value.(type) {
+        case fakeStatus:
+            writer.WriteString("+" + string(typed) + "\r\n")
+        case redis.Error:
+            writer.WriteString("-" + string(typed) + "\r\n")
+        case int64:
+            writer.WriteString(":" + strconv.FormatInt(typed, 10) + "\r\n")
+        case int:
+            writer.WriteString(":" + strconv.Itoa(typed) + "\r\n")
+        case string:
+            writer.WriteString("$" + strconv.Itoa(len(typed)) + "\r\n" + typed + "\r\n")
+        case []interface{}:
+            if typed == nil {
+                writer.WriteString("*-1\r\n")
+                return
+            }
+            writer.WriteString("*" + strconv.Itoa(len(typed)) + "\r\n")
+            for _, item := range typed {
+                writeReply(writer, item)
+            }
+        default:
+            writer.WriteString("$-1\r\n")
+    }
+}
+
+// a simple string reply ("+OK")
+type fakeStatus string
+
+//----------------------------------------------
+// Commands
+//----------------------------------------------
+
+func (self *Server) run(client *fakeClient, name string, args []string) interface{} {
+    self.lock.Lock()
+    defer self.lock.Unlock()
+
+    // inside MULTI, everything but the transaction commands waits for EXEC
+    if client.queued != nil && name != "EXEC" && name != "DISCARD" && name != "MULTI" && name != "WATCH" {
+        client.queued = append(client.queued, append([]string{ name }, args...))
+        return fakeStatus("QUEUED")
+    }
+
+    switch name {
+        case "MULTI":
+            if client.queued != nil { return redis.Error("ERR MULTI calls can not be nested") }
+            client.queued = make([][]string, 0)
+            return fakeStatus("OK")
+
+        case "EXEC":
+            if client.queued == nil { return redis.Error("ERR EXEC without MULTI") }
+            var queued = client.queued
+            client.queued = nil
+
+            var changed = false
+            for key, version := range client.watched {
+                if self.versions[key] != version { changed = true }
+            }
+            client.watched = nil
+            if changed { return []interface{}(nil) }
+
+            var result = make([]interface{}, 0, len(queued))
+            for _, command := range queued {
+                result = append(result, self.command(client, command[0], command[1:]))
+            }
+            return result
+
+        case "DISCARD":
+            if client.queued == nil { return redis.Error("ERR DISCARD without MULTI") }
+            client.queued = nil
+            client.watched = nil
+            return fakeStatus("OK")
+
+        case "WATCH":
+            if client.queued != nil { return redis.Error("ERR WATCH inside MULTI is not allowed") }
+            if client.watched == nil { client.watched = make(map[string]uint64, 0) }
+            for _, key := range args {
+                self.expire(key)
+                client.watched[key] = self.versions[key]
+            }
+            return fakeStatus("OK")
+
+        case "UNWATCH":
+            client.watched = nil
+            return fakeStatus("OK")
+    }
+
+    return self.command(client, name, args)
+}
+
+func (self *Server) command(client *fakeClient, name string, args []string) interface{} {
+    var arity = map[string]int{
+        "PING": 0, "AUTH": 1, "SELECT": 1, "INCR": 1, "INCRBY": 2, "HSET": 3, "HGETALL": 1, "HDEL": 2,
+        "HLEN": 1, "DEL": 1, "EXISTS": 1, "PEXPIRE": 2, "PERSIST": 1, "PTTL": 1, "SCAN": 1, "PUBLISH": 2, "SUBSCRIBE": 1,
+        "FLUSHALL": 0,
+    }
+    if minimum, known := arity[name] ; !known {
+        return redis.Error("ERR unknown command '" + name + "'")
+    } else if len(args) < minimum {
+        return redis.Error("ERR wrong number of arguments for '" + name + "' command")
+    }
+
+    switch name {
+        case "PING":
+            return fakeStatus("PONG")
+
+        case "AUTH", "SELECT":
+            return fakeStatus("OK")
+
+        case "FLUSHALL":
+            for key := range self.values {
+                self.remove(key)
+            }
+            return fakeStatus("OK")
+
+        case "INCR", "INCRBY":
+            var delta = int64(1)
+            if name == "INCRBY" {
+                var err error
+                if delta, err = strconv.ParseInt(args[1], 10, 64) ; err != nil { return redis.Error("ERR value is not an integer or out of range") }
+            }
+
+            var value = self.lookup(args[0])
+            if value != nil && value.hash != nil { return fakeWrongType }
+
+            var current = int64(0)
+            if value != nil { current, _ = strconv.ParseInt(value.counter, 10, 64) }
+            current += delta
+
+            if value == nil {
+                value = &fakeValue{}
+                self.values[args[0]] = value
+            }
+            value.counter = strconv.FormatInt(current, 10)
+            self.touch(args[0])
+            return current
+
+        case "HSET":
+            if len(args) % 2 != 1 { return redis.Error("ERR wrong number of arguments for 'HSET' command") }
+
+            var value = self.lookup(args[0])
+            if value != nil && value.hash == nil { return fakeWrongType }
+            if value == nil {
+                value = &fakeValue{ hash: make(map[string]string, 0) }
+                self.values[args[0]] = value
+            }
+
+            var added = 0
+            for i := 1 ; i < len(args) ; i += 2 {
+                if _, exists := value.hash[args[i]] ; !exists { added += 1 }
+                value.hash[args[i]] = args[i + 1]
+            }
+            self.touch(args[0])
+            return added
+
+        case "HGETALL":
+            var value = self.lookup(args[0])
+            if value == nil { return []interface{}{} }
+            if value.hash == nil { return fakeWrongType }
+
+            var fields = make([]string, 0, len(value.hash))
+            for field := range value.hash {
+                fields = append(fields, field)
+            }
+            sort.Strings(fields)
+
+            var result = make([]interface{}, 0, 2 * len(fields))
+            for _, field := range fields {
+                result = append(result, field, value.hash[field])
+            }
+            return result
+
+        case "HDEL":
+            var value = self.lookup(args[0])
+            if value == nil { return 0 }
+            if value.hash == nil { return fakeWrongType }
+
+            var removed = 0
+            for _, field := range args[1:] {
+                if _, exists := value.hash[field] ; exists {
+                    delete(value.hash, field)
+                    removed += 1
+                }
+            }
+
+            if len(value.hash) == 0 {
+                self.remove(args[0])
+            } else if removed > 0 {
+                self.touch(args[0])
+            }
+            return removed
+
+        case "HLEN":
+            var value = self.lookup(args[0])
+            if value == nil { return 0 }
+            if value.hash == nil { return fakeWrongType }
+            return len(value.hash)
+
+        case "DEL", "EXISTS":
+            var count = 0
+            for _, key := range args {
+                if self.lookup(key) == nil { continue }
+                count += 1
+                if name == "DEL" { self.remove(key) }
+            }
+            return count
+
+        case "PEXPIRE":
+            var value = self.lookup(args[0])
+            if value == nil { return 0 }
+
+            lifetime, err := strconv.ParseInt(args[1], 10, 64)
+            if err != nil { return redis.Error("ERR value is not an integer or out of range") }
+
+            value.expires = time.Now().Add(time.Duration(lifetime) * time.Millisecond)
+            self.touch(args[0])
+            return 1
+
+        case "PERSIST":
+            var value = self.lookup(args[0])
+            if value == nil || value.expires.IsZero() { return 0 }
+
+            value.expires = time.Time{}
+            self.touch(args[0])
+            return 1
+
+        case "PTTL":
+            var value = self.lookup(args[0])
+            if value == nil { return -2 }
+            if value.expires.IsZero() { return -1 }
+            return int64(time.Until(value.expires) / time.Millisecond)
+
+        case "SCAN":
+            return self.scan(args)
+
+        case "PUBLISH":
+            var count = 0
+            for subscriber := range self.subscribers[args[0]] {
+                subscriber.reply([]interface{}{ "message", args[0], args[1] })
+                count += 1
+            }
+            return count
+
+        case "SUBSCRIBE":
+            // every channel after the first is confirmed here, ahead of the reply to the command
+            for i, channel := range args {
+                if self.subscribers[channel] == nil { self.subscribers[channel] = make(map[*fakeClient]bool, 0) }
+                if !self.subscribers[channel][client] {
+                    self.subscribers[channel][client] = true
+                    client.subscribed += 1
+                }
+
+                if i < len(args) - 1 {
+                    client.writing.Lock()
+                    writeReply(client.writer, []interface{}{ "subscribe", channel, client.subscribed })
+                    client.writing.Unlock()
+                }
+            }
+            return []interface{}{ "subscribe", args[len(args) - 1], client.subscribed }
+    }
+
+    return redis.Error("ERR unknown command '" + name + "'")
+}
+
+var fakeWrongType = redis.Error("WRONGTYPE Operation against a key holding the wrong kind of value")
+
+//
+// SCAN cursor [MATCH pattern] [COUNT count] -- the cursor is a position in the sorted keys
+//
+func (self *Server) scan(args []string) interface{} {
+    cursor, err := strconv.Atoi(args[0])
+    if err != nil || cursor < 0 { return redis.Error("ERR invalid cursor") }
+
+    var pattern = "*"
+    var count = 10
+    for i := 1 ; i + 1 < len(args) ; i += 2 {
+        switch strings.ToUpper(args[i]) {
+            case "MATCH":
+                pattern = args[i + 1]
+            case "COUNT":
+                if count, err = strconv.Atoi(args[i + 1]) ; err != nil || count < 1 { return redis.Error("ERR syntax error") }
+        }
+    }
+
+    var keys = make([]string, 0, len(self.values))
+    for key := range self.values {
+        if self.lookup(key) != nil { keys = append(keys, key) }
+    }
+    sort.Strings(keys)
+
+    var matched = make([]interface{}, 0)
+    var next = cursor
+    for ; next < len(keys) && next < cursor + count ; next++ {
+        if ok, _ := path.Match(pattern, keys[next]) ; ok { matched = append(matched, keys[next]) }
+    }
+    if next >= len(keys) { next = 0 }
+
+    return []interface{}{ strconv.Itoa(next), matched }
+}
+
+//
+// The live value at a key, dropping it if it has expired -- the caller holds the lock
+//
+func (self *Server) lookup(key string) *fakeValue {
+    self.expire(key)
+    return self.values[key]
+}
+
+func (self *Server) expire(key string) {
+    var value, exists = self.values[key]
+    if exists && !value.expires.IsZero() && !time.Now().Before(value.expires) {
+        self.remove(key)
+    }
+}
+
+func (self *Server) remove(key string) {
+    delete(self.values, key)
+    self.touch(key)
+}
+
+func (self *Server) touch(key string) {
+    self.version += 1
+    self.versions[key] = self.version
+}
@@ -0,0 +1,51 @@
+package redistest
+
+import (
+    "time"
+    "testing"
+
+    "github.com/zmarcantel/phonebook/server/store/redis"
+)
+
+func TestServer_Expiry(t *testing.T) {
+    server, err := NewServer()
+    if err != nil { t.Fatal(err) }
+    defer server.Close()
+
+    var client = redis.NewClient(server.Addr())
+    defer client.Close()
+
+    replies, err := client.Pipeline([][]string{
+        { "HSET", "kept", "field", "value" },
+        { "PEXPIRE", "kept", "50" },
+        { "PERSIST", "kept" },
+        { "HSET", "gone", "field", "value" },
+        { "PEXPIRE", "gone", "50" },
+        { "PTTL", "gone" },
+        { "PTTL", "kept" },
+    })
+    if err != nil { t.Fatal(err) }
+
+    if persisted, _ := redis.Int(replies[2], nil) ; persisted != 1 {
+        t.Errorf("Incorrect PERSIST:\n\tExpected: %d\n\tGot: %v\n", 1, replies[2])
+    }
+    if remaining, _ := redis.Int(replies[5], nil) ; remaining <= 0 || remaining > 50 {
+        t.Errorf("Incorrect PTTL:\n\tExpected: at most %d\n\tGot: %v\n", 50, replies[5])
+    }
+    if remaining, _ := redis.Int(replies[6], nil) ; remaining != -1 {
+        t.Errorf("Incorrect PTTL:\n\tExpected: %d\n\tGot: %v\n", -1, replies[6])
+    }
+
+    // keys are gone once they expire, and SCAN no longer finds them
+    time.Sleep(100 * time.Millisecond)
+    if remaining, err := redis.Int(client.Do("PTTL", "gone")) ; err != nil || remaining != -2 {
+        t.Errorf("Incorrect PTTL:\n\tExpected: %d\n\tGot: %d (%v)\n", -2, remaining, err)
+    }
+
+    reply, err := client.Do("SCAN", "0", "MATCH", "*", "COUNT", "10")
+    if err != nil { t.Fatal(err) }
+    var fields, _ = reply.([]interface{})
+    if keys, _ := redis.Strings(fields[1], nil) ; len(keys) != 1 || keys[0] != "kept" {
+        t.Errorf("Incorrect SCAN:\n\tExpected: %v\n\tGot: %v\n", []string{ "kept" }, keys)
+    }
+}
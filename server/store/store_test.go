@@ -0,0 +1,1300 @@
+package store
+
+import (
+    "os"
+    "fmt"
+    "net"
+    "time"
+    "bytes"
+    "strings"
+    "testing"
+    "path/filepath"
+
+    "github.com/zmarcantel/phonebook/dns/record"
+    "github.com/zmarcantel/phonebook/server/store/redis"
+    "github.com/zmarcantel/phonebook/server/store/redis/redistest"
+)
+
+//----------------------------------------------
+// Helpers
+//----------------------------------------------
+
+//
+// A map store holding an A record two labels beneath an empty non-terminal
+//
+func testMapStore(t *testing.T) *MapStore {
+    var store = Map()
+
+    var a, _ = record.A("app.production.zed.io", 10 * time.Second, net.ParseIP("127.0.0.1"))
+    if err := store.Add(a); err != nil {
+        t.Fatal(err)
+    }
+
+    var mx, _ = record.MX("zed.io", "mail.zed.io", 5, 10 * time.Second)
+    if err := store.Add(mx); err != nil {
+        t.Fatal(err)
+    }
+
+    return store
+}
+
+func testLookupError(t *testing.T, operation string, got, expected error) {
+    if got != expected {
+        t.Errorf("Incorrect %s Error:\n\tExpected: %+v\n\tGot: %+v\n", operation, expected, got)
+    }
+}
+
+
+//----------------------------------------------
+// NXDOMAIN vs NODATA Tests
+//----------------------------------------------
+
+func TestMapStore_FindNoData(t *testing.T) {
+    var store = testMapStore(t)
+
+    _, err := store.Find("app.production.zed.io", record.AAAA_RECORD)
+    testLookupError(t, "Find", err, ErrNoData)
+
+    _, err = store.FindRecursively("app.production.zed.io", record.AAAA_RECORD)
+    testLookupError(t, "FindRecursively", err, ErrNoData)
+}
+
+func TestMapStore_FindNotFound(t *testing.T) {
+    var store = testMapStore(t)
+
+    _, err := store.Find("missing.zed.io", record.A_RECORD)
+    testLookupError(t, "Find", err, ErrNotFound)
+
+    _, err = store.FindLabel("missing.zed.io")
+    testLookupError(t, "FindLabel", err, ErrNotFound)
+
+    _, err = store.FindRecursively("missing.zed.io", record.A_RECORD)
+    testLookupError(t, "FindRecursively", err, ErrNotFound)
+}
+
+func TestMapStore_EmptyNonTerminal(t *testing.T) {
+    var store = testMapStore(t)
+
+    // production.zed.io holds nothing, but app.production.zed.io lives beneath it
+    _, err := store.Find("production.zed.io", record.A_RECORD)
+    testLookupError(t, "Find", err, ErrNoData)
+
+    _, err = store.FindLabel("production.zed.io")
+    testLookupError(t, "FindLabel", err, ErrNoData)
+
+    _, err = store.FindRecursively("production.zed.io", record.A_RECORD)
+    testLookupError(t, "FindRecursively", err, ErrNoData)
+}
+
+func TestMapStore_DeleteRemovesName(t *testing.T) {
+    var store = testMapStore(t)
+
+    if err := store.FindAndDelete("app.production.zed.io", record.A_RECORD); err != nil {
+        t.Fatal(err)
+    }
+
+    // with its only descendant gone, the empty non-terminal disappears too
+    _, err := store.Find("app.production.zed.io", record.A_RECORD)
+    testLookupError(t, "Find", err, ErrNotFound)
+
+    _, err = store.Find("production.zed.io", record.A_RECORD)
+    testLookupError(t, "Find", err, ErrNotFound)
+
+    if store.Labels != 1 || store.Size() != 1 {
+        t.Errorf("Incorrect Counts:\n\tExpected: %d labels, %d records\n\tGot: %d labels, %d records\n", 1, 1, store.Labels, store.Size())
+    }
+}
+
+
+//----------------------------------------------
+// Name Normalization Tests
+//----------------------------------------------
+
+func TestMapStore_CaseInsensitive(t *testing.T) {
+    var store = testMapStore(t)
+
+    for _, name := range []string{ "App.Production.ZED.io", "app.production.zed.io.", "APP.PRODUCTION.ZED.IO." } {
+        rec, err := store.Find(name, record.A_RECORD)
+        if err != nil {
+            t.Errorf("Could not find %s: %s\n", name, err)
+            continue
+        }
+
+        if rec.GetLabel() != "app.production.zed.io" {
+            t.Errorf("Incorrect Record:\n\tExpected: %s\n\tGot: %s\n", "app.production.zed.io", rec.GetLabel())
+        }
+    }
+
+    // both spellings land on the same label
+    var upper, _ = record.TXT("APP.production.zed.io.", 10 * time.Second, "upper")
+    if err := store.Add(upper); err != nil { t.Fatal(err) }
+
+    if store.Labels != 2 || store.LabelSize("app.production.zed.io") != 2 {
+        t.Errorf("Incorrect Counts:\n\tExpected: %d labels, %d at the name\n\tGot: %d labels, %d at the name\n", 2, 2, store.Labels, store.LabelSize("app.production.zed.io"))
+    }
+
+    if err := store.FindAndDelete("App.Production.Zed.Io", record.TXT_RECORD); err != nil {
+        t.Errorf("Could not delete by another spelling: %s\n", err)
+    }
+}
+
+//----------------------------------------------
+// Wildcard Tests
+//----------------------------------------------
+
+//
+// A map store with a wildcard beneath svc.internal, and a real name that blocks it
+//
+func testWildcardStore(t *testing.T) *MapStore {
+    var store = Map()
+
+    var records = make([]record.Record, 0)
+    var add = func(rec record.Record, err error) {
+        if err != nil { t.Fatal(err) }
+        records = append(records, rec)
+    }
+
+    add(record.A("*.svc.internal", 10 * time.Second, net.ParseIP("10.0.0.1")))
+    add(record.TXT("*.svc.internal", 10 * time.Second, "preview"))
+    add(record.A("db.svc.internal", 10 * time.Second, net.ParseIP("10.0.0.2")))
+    add(record.CNAME("*.cdn.internal", "edge.internal", 10 * time.Second))
+    add(record.A("edge.internal", 10 * time.Second, net.ParseIP("10.0.0.3")))
+
+    for _, rec := range records {
+        if err := store.Add(rec); err != nil { t.Fatal(err) }
+    }
+
+    return store
+}
+
+func TestMapStore_WildcardSynthesis(t *testing.T) {
+    var store = testWildcardStore(t)
+
+    rec, err := store.Find("feature-123.svc.internal", record.A_RECORD)
+    if err != nil { t.Fatal(err) }
+
+    var a = rec.(*record.ARecord)
+    if a.Name != "feature-123.svc.internal" || !a.IP.Equal(net.ParseIP("10.0.0.1")) {
+        t.Errorf("Incorrect Synthesized Record:\n\tExpected: %s -> %s\n\tGot: %s -> %s\n", "feature-123.svc.internal", "10.0.0.1", a.Name, a.IP)
+    }
+
+    // the stored wildcard keeps its own owner
+    wildcard, err := store.Find("*.svc.internal", record.A_RECORD)
+    if err != nil { t.Fatal(err) }
+    if wildcard.GetLabel() != "*.svc.internal" {
+        t.Errorf("Wildcard Was Modified:\n\tGot: %s\n", wildcard.GetLabel())
+    }
+
+    collection, err := store.FindLabel("feature-123.svc.internal")
+    if err != nil { t.Fatal(err) }
+    if len(collection) != 2 {
+        t.Errorf("Incorrect Synthesized Set:\n\tExpected: %d records\n\tGot: %d\n", 2, len(collection))
+    }
+
+    // the wildcard name exists, but holds no MX records
+    _, err = store.Find("feature-123.svc.internal", record.MX_RECORD)
+    testLookupError(t, "Find", err, ErrNoData)
+}
+
+func TestMapStore_WildcardCNAME(t *testing.T) {
+    var store = testWildcardStore(t)
+
+    collection, err := store.FindRecursively("images.cdn.internal", record.A_RECORD)
+    if err != nil { t.Fatal(err) }
+
+    if len(collection) != 2 || collection[0].GetLabel() != "images.cdn.internal" || collection[1].GetLabel() != "edge.internal" {
+        t.Errorf("Incorrect CNAME Chain:\n\tGot: %+v\n", collection)
+    }
+}
+
+func TestMapStore_WildcardBlocked(t *testing.T) {
+    var store = testWildcardStore(t)
+
+    // an existing name is answered on its own, never by the wildcard
+    _, err := store.Find("db.svc.internal", record.TXT_RECORD)
+    testLookupError(t, "Find", err, ErrNoData)
+
+    // beneath db.svc.internal, the closest encloser has no wildcard of its own
+    _, err = store.Find("replica.db.svc.internal", record.A_RECORD)
+    testLookupError(t, "Find", err, ErrNotFound)
+
+    // wildcards only match beneath their parent
+    _, err = store.Find("svc.internal", record.A_RECORD)
+    testLookupError(t, "Find", err, ErrNoData)
+
+    _, err = store.Find("other.internal", record.A_RECORD)
+    testLookupError(t, "Find", err, ErrNotFound)
+}
+
+//----------------------------------------------
+// Zone File Tests
+//----------------------------------------------
+
+var testZoneFile = `$ORIGIN zed.io.
+$TTL 1h
+@       IN  SOA ns1 hostmaster (
+                2024010101  ; serial
+                1h 15m 1w   ; refresh, retry, expire
+                5m )        ; minimum
+        IN  NS  ns1
+        IN  MX  10 mail
+ns1     300 IN  A   10.0.0.53
+mail        A   10.0.0.25
+            AAAA ::1
+www     IN  CNAME   @
+_sip._tcp   SRV 10 20 5060 sip.example.com.
+txt     TXT "hello \"world\"" " again"
+$ORIGIN in-addr.arpa.
+53.0.0.10   PTR ns1.zed.io.
+`
+
+func TestParseZone(t *testing.T) {
+    records, err := ParseZone(strings.NewReader(testZoneFile), "")
+    if err != nil { t.Fatal(err) }
+
+    if len(records) != 10 {
+        t.Fatalf("Incorrect Record Count:\n\tExpected: %d\n\tGot: %d\n", 10, len(records))
+    }
+
+    var soa = records[0].(*record.SOARecord)
+    if soa.Name != "zed.io" || soa.MName != "ns1.zed.io" || soa.RName != "hostmaster.zed.io" {
+        t.Errorf("Incorrect SOA Names:\n\tGot: %s %s %s\n", soa.Name, soa.MName, soa.RName)
+    }
+    if soa.Serial != 2024010101 || soa.Retry != 15 * time.Minute || soa.Expire != 7 * 24 * time.Hour || soa.Minimum != 5 * time.Minute {
+        t.Errorf("Incorrect SOA Timers:\n\tGot: %+v\n", soa)
+    }
+
+    // the owner is inherited from the previous line
+    var ns = records[1].(*record.NSRecord)
+    if ns.Name != "zed.io" || ns.Target != "ns1.zed.io" || ns.TTL != time.Hour {
+        t.Errorf("Incorrect NS:\n\tGot: %+v\n", ns)
+    }
+
+    if records[3].(*record.ARecord).TTL != 300 * time.Second {
+        t.Errorf("Explicit TTL was not applied:\n\tGot: %v\n", records[3].(*record.ARecord).TTL)
+    }
+
+    var aaaa = records[5].(*record.AAAARecord)
+    if aaaa.Name != "mail.zed.io" || !aaaa.IP.Equal(net.ParseIP("::1")) {
+        t.Errorf("Incorrect AAAA:\n\tGot: %+v\n", aaaa)
+    }
+
+    if records[6].(*record.CNAMERecord).Target != "zed.io" {
+        t.Errorf("'@' was not expanded:\n\tGot: %s\n", records[6].(*record.CNAMERecord).Target)
+    }
+
+    var srv = records[7].(*record.SRVRecord)
+    if srv.Name != "_sip._tcp.zed.io" || srv.Target != "sip.example.com" || srv.Port != 5060 {
+        t.Errorf("Incorrect SRV:\n\tGot: %+v\n", srv)
+    }
+
+    if text := records[8].(*record.TXTRecord).Text ; text != `hello "world" again` {
+        t.Errorf("Incorrect TXT:\n\tExpected: %s\n\tGot: %s\n", `hello "world" again`, text)
+    }
+
+    if records[9].GetLabel() != "53.0.0.10.in-addr.arpa" {
+        t.Errorf("$ORIGIN was not applied:\n\tGot: %s\n", records[9].GetLabel())
+    }
+}
+
+func TestParseZone_Errors(t *testing.T) {
+    var broken = map[string]string{
+        "no ttl":       "zed.io. IN A 10.0.0.1\n",
+        "bad address":  "$TTL 60\nzed.io. A 10.0.0\n",
+        "bad type":     "$TTL 60\nzed.io. BOGUS 1\n",
+        "no owner":     "$TTL 60\n  A 10.0.0.1\n",
+        "unbalanced":   "$TTL 60\nzed.io. SOA ns1 host ( 1 2 3 4 5\n",
+        "field count":  "$TTL 60\nzed.io. MX mail.zed.io.\n",
+        "bad class":    "$TTL 60\nzed.io. CH A 10.0.0.1\n",
+        "long string":  "$TTL 60\nzed.io. TXT \"" + strings.Repeat("k", 256) + "\"\n",
+    }
+
+    for name, source := range broken {
+        if _, err := ParseZone(strings.NewReader(source), "zed.io"); err == nil {
+            t.Errorf("Expected an error parsing zone (%s)\n", name)
+        }
+    }
+}
+
+func TestParseZone_Generic(t *testing.T) {
+    var source = "$ORIGIN zed.io.\n$TTL 60\n" +
+        "opaque  TYPE65280 \\# 5 0102 030405\n" +
+        "empty   TYPE65281 \\# 0\n" +
+        "host    A \\# 4 0A000001\n"
+
+    records, err := ParseZone(strings.NewReader(source), "")
+    if err != nil { t.Fatal(err) }
+    if len(records) != 3 { t.Fatalf("Incorrect Record Count:\n\tExpected: %d\n\tGot: %d\n", 3, len(records)) }
+
+    var opaque, _ = records[0].(*record.UnknownRecord)
+    if opaque == nil || opaque.Name != "opaque.zed.io" || opaque.Type != 65280 || !bytes.Equal(opaque.RData, []byte{ 1, 2, 3, 4, 5 }) {
+        t.Errorf("Incorrect Generic Record:\n\tGot: %+v\n", records[0])
+    }
+    if empty, _ := records[1].(*record.UnknownRecord) ; empty == nil || len(empty.RData) != 0 {
+        t.Errorf("Incorrect Empty Record:\n\tGot: %+v\n", records[1])
+    }
+
+    // known types come back as themselves
+    if a, _ := records[2].(*record.ARecord) ; a == nil || !a.IP.Equal(net.ParseIP("10.0.0.1")) || a.TTL != time.Minute {
+        t.Errorf("Incorrect A:\n\tGot: %+v\n", records[2])
+    }
+
+    var broken = map[string]string{
+        "short data":   "$TTL 60\nzed.io. TYPE65280 \\# 4 0102\n",
+        "bad hex":      "$TTL 60\nzed.io. TYPE65280 \\# 1 zz\n",
+        "no length":    "$TTL 60\nzed.io. TYPE65280 \\#\n",
+        "bad type":     "$TTL 60\nzed.io. TYPE70000 \\# 0\n",
+        "bad A":        "$TTL 60\nzed.io. A \\# 3 0A0000\n",
+    }
+    for name, source := range broken {
+        if _, err := ParseZone(strings.NewReader(source), "zed.io"); err == nil {
+            t.Errorf("Expected an error parsing zone (%s)\n", name)
+        }
+    }
+
+    // what the export writes for a type it has no format for is read back the same
+    var store = Map()
+    if err := store.Add(opaque) ; err != nil { t.Fatal(err) }
+
+    var exported bytes.Buffer
+    if err := ExportZone(&exported, store, "zed.io"); err != nil { t.Fatal(err) }
+
+    reloaded, err := ParseZone(&exported, "")
+    if err != nil { t.Fatal(err) }
+    if len(reloaded) != 1 || !bytes.Equal(reloaded[0].(*record.UnknownRecord).RData, opaque.RData) {
+        t.Errorf("Export Did Not Round Trip:\n\tExpected: %+v\n\tGot: %+v\n", opaque, reloaded)
+    }
+}
+
+func TestExportZone_RoundTrip(t *testing.T) {
+    var store = Map()
+    if err := LoadZone(strings.NewReader(testZoneFile), "", store); err != nil {
+        t.Fatal(err)
+    }
+
+    var exported bytes.Buffer
+    if err := ExportZone(&exported, store, "zed.io"); err != nil {
+        t.Fatal(err)
+    }
+
+    // only the zed.io records, with the SOA leading
+    var lines = strings.Split(strings.TrimSpace(exported.String()), "\n")
+    if len(lines) != 10 || lines[0] != "$ORIGIN zed.io." || !strings.Contains(lines[1], "\tSOA\t") {
+        t.Fatalf("Unexpected Export:\n%s\n", exported.String())
+    }
+
+    // reading the export back gives the same records
+    var reloaded = Map()
+    if err := LoadZone(&exported, "", reloaded); err != nil {
+        t.Fatal(err)
+    }
+
+    var first bytes.Buffer
+    var second bytes.Buffer
+    ExportZone(&first, store, "zed.io")
+    ExportZone(&second, reloaded, "zed.io")
+
+    if first.String() != second.String() {
+        t.Errorf("Export Did Not Round Trip:\n\tFirst:\n%s\n\tSecond:\n%s\n", first.String(), second.String())
+    }
+}
+
+func TestExportZone_LongText(t *testing.T) {
+    var store = Map()
+    var text = strings.Repeat("k", 400)
+    var long, _ = record.TXT("mail._domainkey.zed.io", 10 * time.Second, text)
+    if err := store.Add(long) ; err != nil { t.Fatal(err) }
+
+    // too long for a single string, so it is written as several
+    var exported bytes.Buffer
+    if err := ExportZone(&exported, store, "zed.io"); err != nil {
+        t.Fatal(err)
+    }
+
+    records, err := ParseZone(&exported, "")
+    if err != nil { t.Fatal(err) }
+
+    if len(records) != 1 || records[0].(*record.TXTRecord).Text != text {
+        t.Errorf("Export Did Not Round Trip:\n\tExpected: %d bytes of text\n\tGot: %+v\n", len(text), records)
+    }
+}
+
+//----------------------------------------------
+// Concurrency Tests (run with -race)
+//----------------------------------------------
+
+func TestMapStore_Concurrent(t *testing.T) {
+    var store = testMapStore(t)
+    var done = make(chan bool)
+
+    // writers churn a set of labels while readers look up the stable ones
+    for w := 0 ; w < 4 ; w++ {
+        go func(w int) {
+            for i := 0 ; i < 200 ; i++ {
+                var name = fmt.Sprintf("host-%d-%d.churn.zed.io", w, i % 10)
+                var a, _ = record.A(name, 10 * time.Second, net.ParseIP("10.0.0.1"))
+
+                store.Add(a)
+                store.FindAndReplace(name, record.A_RECORD, a)
+                store.Delete(a)
+            }
+            done <- true
+        }(w)
+    }
+
+    for r := 0 ; r < 4 ; r++ {
+        go func() {
+            for i := 0 ; i < 200 ; i++ {
+                rec, err := store.Find("app.production.zed.io", record.A_RECORD)
+                if err != nil { t.Error(err) }
+
+                // returned records belong to the caller
+                if _, err = rec.Serialize(); err != nil { t.Error(err) }
+
+                store.FindRecursively("zed.io", record.MX_RECORD)
+                store.FindLabel("churn.zed.io")
+                store.Size()
+                store.Walk(func(record.Record) error { return nil })
+            }
+            done <- true
+        }()
+    }
+
+    for i := 0 ; i < 8 ; i++ {
+        <-done
+    }
+
+    if store.Size() != 2 || store.Labels != 2 {
+        t.Errorf("Incorrect Counts:\n\tExpected: %d labels, %d records\n\tGot: %d labels, %d records\n", 2, 2, store.Labels, store.Size())
+    }
+}
+
+func TestMapStore_WalkMayModify(t *testing.T) {
+    var store = testMapStore(t)
+
+    // deleting from within the walk must not deadlock
+    var err = store.Walk(func(rec record.Record) error {
+        return store.Delete(rec)
+    })
+    if err != nil { t.Fatal(err) }
+
+    if store.Size() != 0 {
+        t.Errorf("Incorrect Size:\n\tExpected: %d\n\tGot: %d\n", 0, store.Size())
+    }
+}
+
+//----------------------------------------------
+// Batch Tests
+//----------------------------------------------
+
+func TestMapStore_Batch(t *testing.T) {
+    var store = testMapStore(t)
+    var failure = fmt.Errorf("abandoned")
+
+    // a failed batch leaves no trace
+    var err = Batch(store, func(batch DNSStore) error {
+        var a, _ = record.A("new.zed.io", 10 * time.Second, net.ParseIP("10.0.0.1"))
+        if err := batch.Add(a) ; err != nil { return err }
+        if err := batch.FindAndDelete("zed.io", record.MX_RECORD) ; err != nil { return err }
+        return failure
+    })
+    testLookupError(t, "Batch", err, failure)
+
+    if _, err = store.Find("new.zed.io", record.A_RECORD) ; err != ErrNotFound {
+        t.Errorf("Abandoned batch added a record:\n\tGot: %v\n", err)
+    }
+    if _, err = store.Find("zed.io", record.MX_RECORD) ; err != nil {
+        t.Errorf("Abandoned batch deleted a record:\n\tGot: %v\n", err)
+    }
+
+    // a successful one is applied whole
+    err = Batch(store, func(batch DNSStore) error {
+        var a, _ = record.A("new.zed.io", 10 * time.Second, net.ParseIP("10.0.0.1"))
+        if err := batch.Add(a) ; err != nil { return err }
+        return batch.FindAndDelete("zed.io", record.MX_RECORD)
+    })
+    if err != nil { t.Fatal(err) }
+
+    if _, err = store.Find("new.zed.io", record.A_RECORD) ; err != nil {
+        t.Errorf("Batch did not add a record:\n\tGot: %v\n", err)
+    }
+    // zed.io remains as an empty non-terminal above app.production
+    if _, err = store.Find("zed.io", record.MX_RECORD) ; err != ErrNoData {
+        t.Errorf("Batch did not delete a record:\n\tGot: %v\n", err)
+    }
+
+    if _, err = store.Find("app.production.zed.io", record.A_RECORD) ; err != nil {
+        t.Errorf("Batch lost an untouched record:\n\tGot: %v\n", err)
+    }
+}
+
+func TestJournal_Since(t *testing.T) {
+    var journal = NewJournal(2)
+    var soa = func(serial uint32) *record.SOARecord {
+        var rec, _ = record.SOA("zed.io", "ns1.zed.io", "admin.zed.io", time.Minute, serial, time.Hour, time.Minute, time.Hour, time.Minute)
+        return rec
+    }
+
+    journal.Append("zed.io", Change{ From: soa(1), To: soa(2) })
+    journal.Append("ZED.io.", Change{ From: soa(2), To: soa(3) })
+
+    var cases = []struct {
+        serial      uint32
+        changes     int
+        found       bool
+    }{
+        { 1, 2, true },
+        { 2, 1, true },
+        { 3, 0, false },        // up to date -- nothing follows on from it
+        { 9, 0, false },
+    }
+
+    for _, test := range cases {
+        changes, found := journal.Since("zed.io", test.serial)
+        if len(changes) != test.changes || found != test.found {
+            t.Errorf("Incorrect Changes:\n\tSerial: %d\n\tExpected: %d %v\n\tGot: %d %v\n", test.serial, test.changes, test.found, len(changes), found)
+        }
+    }
+
+    // the oldest are forgotten past the limit
+    journal.Append("zed.io", Change{ From: soa(3), To: soa(4) })
+    if _, found := journal.Since("zed.io", 1) ; found {
+        t.Errorf("Journal kept more than its limit\n")
+    }
+    if changes, _ := journal.Since("zed.io", 2) ; len(changes) != 2 {
+        t.Errorf("Incorrect Changes:\n\tExpected: %d\n\tGot: %d\n", 2, len(changes))
+    }
+
+    // a gap starts the history over
+    journal.Append("zed.io", Change{ From: soa(7), To: soa(8) })
+    if _, found := journal.Since("zed.io", 3) ; found {
+        t.Errorf("Journal bridged a gap in the history\n")
+    }
+    if changes, found := journal.Since("zed.io", 7) ; !found || len(changes) != 1 {
+        t.Errorf("Incorrect Changes:\n\tExpected: %d\n\tGot: %d %v\n", 1, len(changes), found)
+    }
+
+    journal.Clear("zed.io")
+    if _, found := journal.Since("zed.io", 7) ; found {
+        t.Errorf("Journal kept a cleared zone\n")
+    }
+
+    // a nil journal keeps nothing
+    var none *Journal
+    none.Append("zed.io", Change{ From: soa(1), To: soa(2) })
+    if _, found := none.Since("zed.io", 1) ; found {
+        t.Errorf("Nil journal found changes\n")
+    }
+}
+
+//----------------------------------------------
+// Directory Tests
+//----------------------------------------------
+
+var testDirectoryZone = "$TTL 60\n@ SOA ns1 hostmaster ( %d 3600 600 86400 60 )\n  NS ns1\nwww A 10.0.0.%d\n"
+
+func testWriteZone(t *testing.T, dir, name, contents string) {
+    if err := os.WriteFile(filepath.Join(dir, name), []byte(contents), 0644) ; err != nil { t.Fatal(err) }
+}
+
+func testRecordCount(t *testing.T, store DNSStore, name string, rType uint16, expected int) {
+    var records, _ = store.FindLabel(name)
+    var count = 0
+    for _, rec := range records {
+        if rec.GetType() == rType { count += 1 }
+    }
+
+    if count != expected {
+        t.Errorf("Incorrect Records:\n\tName: %s\n\tExpected: %d\n\tGot: %d\n", name, expected, count)
+    }
+}
+
+func TestDirectoryStore_Load(t *testing.T) {
+    var dir = t.TempDir()
+    testWriteZone(t, dir, "zed.io.zone", fmt.Sprintf(testDirectoryZone, 1, 1))
+    testWriteZone(t, dir, "example.com.zone", fmt.Sprintf(testDirectoryZone, 1, 2))
+    testWriteZone(t, dir, ".example.org.zone", fmt.Sprintf(testDirectoryZone, 1, 3))
+    testWriteZone(t, dir, "README", "not a zone")
+
+    var store = Directory(dir)
+    if err := store.Load() ; err != nil { t.Fatal(err) }
+
+    // the file names are the origins, and only zone files are read
+    testRecordCount(t, store, "www.zed.io", record.A_RECORD, 1)
+    testRecordCount(t, store, "www.example.com", record.A_RECORD, 1)
+    if store.Size() != 6 {
+        t.Errorf("Incorrect Size:\n\tExpected: %d\n\tGot: %d\n", 6, store.Size())
+    }
+
+    var zones = store.Zones()
+    if len(zones) != 2 || zones[0].Origin != "example.com" || len(zones[1].NS) != 1 {
+        t.Errorf("Incorrect Zones:\n\tExpected: %s\n\tGot: %+v\n", "example.com and zed.io", zones)
+    }
+
+    // records added otherwise survive reloads
+    var a, _ = record.A("api.zed.io", 10 * time.Second, net.ParseIP("10.1.0.1"))
+    if err := store.Add(a) ; err != nil { t.Fatal(err) }
+
+    // a changed file swaps its records, leaving the other's alone
+    testWriteZone(t, dir, "zed.io.zone", fmt.Sprintf(testDirectoryZone, 2, 1) + "www A 10.0.0.11\n")
+    if err := store.Load() ; err != nil { t.Fatal(err) }
+
+    testRecordCount(t, store, "www.zed.io", record.A_RECORD, 2)
+    testRecordCount(t, store, "zed.io", record.SOA_RECORD, 1)
+    testRecordCount(t, store, "api.zed.io", record.A_RECORD, 1)
+    testRecordCount(t, store, "www.example.com", record.A_RECORD, 1)
+
+    // one that fails to parse keeps its previous records
+    testWriteZone(t, dir, "zed.io.zone", "www A not-an-address\n")
+    if err := store.Load() ; err == nil {
+        t.Errorf("Expected Error:\n\tCase: %s\n", "unparsable file")
+    }
+    testRecordCount(t, store, "www.zed.io", record.A_RECORD, 2)
+
+    // and a removed one takes them along
+    if err := os.Remove(filepath.Join(dir, "zed.io.zone")) ; err != nil { t.Fatal(err) }
+    if err := store.Load() ; err != nil { t.Fatal(err) }
+
+    testRecordCount(t, store, "www.zed.io", record.A_RECORD, 0)
+    testRecordCount(t, store, "zed.io", record.SOA_RECORD, 0)
+    testRecordCount(t, store, "api.zed.io", record.A_RECORD, 1)
+    if zones = store.Zones() ; len(zones) != 1 {
+        t.Errorf("Incorrect Zones:\n\tExpected: %s\n\tGot: %+v\n", "example.com", zones)
+    }
+}
+
+func TestDirectoryStore_RemoveReplaced(t *testing.T) {
+    var dir = t.TempDir()
+    testWriteZone(t, dir, "zed.io.zone", fmt.Sprintf(testDirectoryZone, 1, 1) + "mail A 10.0.0.25\nftp A 10.0.0.21\n")
+
+    var store = Directory(dir)
+    if err := store.Load() ; err != nil { t.Fatal(err) }
+
+    // replaced since the file was read: one with only a new TTL, one deleted and added again, one with new data
+    var www, _ = record.A("www.zed.io", 300 * time.Second, net.ParseIP("10.0.0.1"))
+    var mail, _ = record.A("mail.zed.io", 60 * time.Second, net.ParseIP("10.0.0.25"))
+    var ftp, _ = record.A("ftp.zed.io", 60 * time.Second, net.ParseIP("10.0.0.99"))
+    if err := store.FindAndReplace("www.zed.io", record.A_RECORD, www) ; err != nil { t.Fatal(err) }
+    if err := store.FindAndDelete("mail.zed.io", record.A_RECORD) ; err != nil { t.Fatal(err) }
+    if err := store.Add(mail) ; err != nil { t.Fatal(err) }
+    if err := store.FindAndReplace("ftp.zed.io", record.A_RECORD, ftp) ; err != nil { t.Fatal(err) }
+
+    // removing the file takes the records holding its data along -- the one changed since stays
+    if err := os.Remove(filepath.Join(dir, "zed.io.zone")) ; err != nil { t.Fatal(err) }
+    if err := store.Load() ; err != nil { t.Fatal(err) }
+
+    testRecordCount(t, store, "www.zed.io", record.A_RECORD, 0)
+    testRecordCount(t, store, "mail.zed.io", record.A_RECORD, 0)
+    testRecordCount(t, store, "ftp.zed.io", record.A_RECORD, 1)
+    if store.Size() != 1 {
+        t.Errorf("Incorrect Size:\n\tExpected: %d\n\tGot: %d\n", 1, store.Size())
+    }
+}
+
+func TestDirectoryStore_Watch(t *testing.T) {
+    var dir = t.TempDir()
+    testWriteZone(t, dir, "zed.io.zone", fmt.Sprintf(testDirectoryZone, 1, 1))
+
+    var store = Directory(dir)
+    store.Debounce = 20 * time.Millisecond
+
+    var reloads = make(chan struct{}, 10)
+    store.Reloaded = func() { reloads <- struct{}{} }
+
+    if err := store.Watch() ; err != nil { t.Fatal(err) }
+    defer store.Close()
+    <-reloads
+
+    if err := store.Watch() ; err != ErrWatching {
+        t.Errorf("Incorrect Error:\n\tExpected: %v\n\tGot: %v\n", ErrWatching, err)
+    }
+
+    // a burst of writes, moved into place the way config-management tools do, is one reload
+    var staging = filepath.Join(dir, ".staging")
+    for i := 2 ; i < 6 ; i++ {
+        if err := os.WriteFile(staging, []byte(fmt.Sprintf(testDirectoryZone, i, i)), 0644) ; err != nil { t.Fatal(err) }
+        if err := os.Rename(staging, filepath.Join(dir, "zed.io.zone")) ; err != nil { t.Fatal(err) }
+    }
+
+    select {
+        case <-reloads:
+        case <-time.After(10 * time.Second):
+            t.Fatal("Zone file change not noticed")
+    }
+
+    if soa, err := store.Find("zed.io", record.SOA_RECORD) ; err != nil || soa.(*record.SOARecord).Serial != 5 {
+        t.Errorf("Incorrect SOA:\n\tExpected: serial %d\n\tGot: %+v %v\n", 5, soa, err)
+    }
+
+    // nothing is noticed once closed
+    if err := store.Close() ; err != nil { t.Fatal(err) }
+    testWriteZone(t, dir, "example.com.zone", fmt.Sprintf(testDirectoryZone, 1, 2))
+    time.Sleep(100 * time.Millisecond)
+
+    if len(reloads) != 0 || store.LabelSize("example.com") != 0 {
+        t.Errorf("Reloaded after being closed\n")
+    }
+}
+
+//----------------------------------------------
+// Disk Tests
+//----------------------------------------------
+
+func testOpenDisk(t testing.TB, path string) *DiskStore {
+    var store, err = OpenDisk(path)
+    if err != nil { t.Fatal(err) }
+    return store
+}
+
+//
+// Every record in the store, in order, as text
+//
+func testContents(t *testing.T, store DNSStore) []string {
+    var result = make([]string, 0)
+    var err = store.Walk(func(rec record.Record) error {
+        var data, err = rec.Data()
+        if err != nil { return err }
+
+        result = append(result, fmt.Sprintf("%s %d %v %x", record.Canonical(rec.GetLabel()), rec.GetType(), record.HeaderOf(rec).TTL, data))
+        return nil
+    })
+    if err != nil { t.Fatal(err) }
+
+    return result
+}
+
+func testSameContents(t *testing.T, got, expected DNSStore) {
+    var gotContents, expectedContents = testContents(t, got), testContents(t, expected)
+    if strings.Join(gotContents, "\n") != strings.Join(expectedContents, "\n") {
+        t.Errorf("Incorrect Contents:\n\tExpected: %v\n\tGot: %v\n", expectedContents, gotContents)
+    }
+}
+
+func TestDiskStore_Reopen(t *testing.T) {
+    var path = filepath.Join(t.TempDir(), "records.db")
+    var store = testOpenDisk(t, path)
+
+    // every kind of change, each made to a map store alongside
+    var expected = Map()
+    var records, err = ParseZone(strings.NewReader(testZoneFile), "zed.io")
+    if err != nil { t.Fatal(err) }
+
+    for _, rec := range records {
+        if err = store.Add(rec) ; err != nil { t.Fatal(err) }
+        expected.Add(rec)
+    }
+
+    var a, _ = record.A("mail.zed.io", 30 * time.Second, net.ParseIP("10.0.0.9"))
+    for _, target := range []DNSStore{ store, expected } {
+        if err = target.FindAndReplace("mail.zed.io", record.A_RECORD, a) ; err != nil { t.Fatal(err) }
+        if err = target.FindAndDelete("zed.io", record.MX_RECORD) ; err != nil { t.Fatal(err) }
+
+        err = Batch(target, func(batch DNSStore) error {
+            var aaaa, _ = record.AAAA("v6.zed.io", 10 * time.Second, net.ParseIP("::1"))
+            if err := batch.Add(aaaa) ; err != nil { return err }
+            return batch.Delete(a)
+        })
+        if err != nil { t.Fatal(err) }
+    }
+
+    // changes that fail are not written
+    testLookupError(t, "FindAndDelete", store.FindAndDelete("missing.zed.io", record.A_RECORD), ErrNotFound)
+    testLookupError(t, "Batch", Batch(store, func(batch DNSStore) error {
+        batch.Add(a)
+        return ErrNoData
+    }), ErrNoData)
+
+    testSameContents(t, store, expected)
+    if err = store.Close() ; err != nil { t.Fatal(err) }
+    testLookupError(t, "Add", store.Add(a), ErrStoreClosed)
+
+    var reopened = testOpenDisk(t, path)
+    defer reopened.Close()
+    testSameContents(t, reopened, expected)
+
+    // lookups behave as a map store's do
+    for _, name := range []string{ "www.zed.io", "ns1.zed.io", "missing.zed.io" } {
+        var answers, err = reopened.FindRecursively(name, record.A_RECORD)
+        var expectedAnswers, expectedErr = expected.FindRecursively(name, record.A_RECORD)
+
+        if len(answers) != len(expectedAnswers) || err != expectedErr {
+            t.Errorf("Incorrect Recursive Lookup:\n\tName: %s\n\tExpected: %+v %v\n\tGot: %+v %v\n", name, expectedAnswers, expectedErr, answers, err)
+        }
+    }
+}
+
+func TestDiskStore_TornWrite(t *testing.T) {
+    var path = filepath.Join(t.TempDir(), "records.db")
+    var store = testOpenDisk(t, path)
+
+    for i := 0 ; i < 3 ; i++ {
+        var a, _ = record.A(fmt.Sprintf("host%d.zed.io", i), 10 * time.Second, net.ParseIP("10.0.0.1"))
+        if err := store.Add(a) ; err != nil { t.Fatal(err) }
+    }
+    store.Close()
+
+    // lose the end of the last entry, as a crash part way through writing it would
+    info, err := os.Stat(path)
+    if err != nil { t.Fatal(err) }
+    if err = os.Truncate(path, info.Size() - 3) ; err != nil { t.Fatal(err) }
+
+    store = testOpenDisk(t, path)
+    if store.Size() != 2 || store.LabelSize("host2.zed.io") != 0 {
+        t.Errorf("Incorrect Size:\n\tExpected: %d\n\tGot: %d\n", 2, store.Size())
+    }
+
+    // and entries written after it are kept
+    var a, _ = record.A("host3.zed.io", 10 * time.Second, net.ParseIP("10.0.0.1"))
+    if err = store.Add(a) ; err != nil { t.Fatal(err) }
+    store.Close()
+
+    store = testOpenDisk(t, path)
+    defer store.Close()
+    if store.Size() != 3 || store.LabelSize("host3.zed.io") != 1 {
+        t.Errorf("Incorrect Size:\n\tExpected: %d\n\tGot: %d\n", 3, store.Size())
+    }
+
+    // anything else is not read at all
+    var other = filepath.Join(t.TempDir(), "other.db")
+    if err = os.WriteFile(other, []byte("; a zone file\n"), 0600) ; err != nil { t.Fatal(err) }
+    if _, err = OpenDisk(other) ; err != ErrDiskFormat {
+        t.Errorf("Incorrect Error:\n\tExpected: %v\n\tGot: %v\n", ErrDiskFormat, err)
+    }
+}
+
+func TestDiskStore_Corrupt(t *testing.T) {
+    var path = filepath.Join(t.TempDir(), "records.db")
+    var store = testOpenDisk(t, path)
+
+    // three entries of the same size
+    for i := 0 ; i < 3 ; i++ {
+        var a, _ = record.A(fmt.Sprintf("host%d.zed.io", i), 10 * time.Second, net.ParseIP("10.0.0.1"))
+        if err := store.Add(a) ; err != nil { t.Fatal(err) }
+    }
+    store.Close()
+
+    contents, err := os.ReadFile(path)
+    if err != nil { t.Fatal(err) }
+    var entry = (len(contents) - DISK_HEADER_SIZE) / 3
+
+    // damage in the middle of the file fails the open, leaving the file as it was
+    var damaged = append([]byte(nil), contents...)
+    damaged[DISK_HEADER_SIZE + entry + entry / 2] ^= 0xff
+    if err = os.WriteFile(path, damaged, 0600) ; err != nil { t.Fatal(err) }
+
+    if _, err = OpenDisk(path) ; err != ErrDiskCorrupt {
+        t.Errorf("Incorrect Error:\n\tExpected: %v\n\tGot: %v\n", ErrDiskCorrupt, err)
+    }
+    if after, _ := os.ReadFile(path) ; !bytes.Equal(after, damaged) {
+        t.Errorf("Damaged file was modified by opening it\n")
+    }
+
+    // as does a damaged length, claiming more than is left in the file
+    damaged = append([]byte(nil), contents...)
+    damaged[DISK_HEADER_SIZE + entry] ^= 0x7f
+    if err = os.WriteFile(path, damaged, 0600) ; err != nil { t.Fatal(err) }
+
+    if _, err = OpenDisk(path) ; err != ErrDiskCorrupt {
+        t.Errorf("Incorrect Error:\n\tExpected: %v\n\tGot: %v\n", ErrDiskCorrupt, err)
+    }
+    if after, _ := os.ReadFile(path) ; !bytes.Equal(after, damaged) {
+        t.Errorf("Damaged file was modified by opening it\n")
+    }
+
+    // while a last entry failing its checksum runs to the end of the file, as a torn write does
+    damaged = append([]byte(nil), contents...)
+    damaged[DISK_HEADER_SIZE + 2 * entry + entry / 2] ^= 0xff
+    if err = os.WriteFile(path, damaged, 0600) ; err != nil { t.Fatal(err) }
+
+    store = testOpenDisk(t, path)
+    defer store.Close()
+    if store.Size() != 2 || store.LabelSize("host2.zed.io") != 0 {
+        t.Errorf("Incorrect Size:\n\tExpected: %d\n\tGot: %d\n", 2, store.Size())
+    }
+}
+
+func TestDiskStore_Compact(t *testing.T) {
+    var path = filepath.Join(t.TempDir(), "records.db")
+    var store = testOpenDisk(t, path)
+
+    // churn well past the point the file is compacted by itself
+    for i := 0 ; i < DISK_COMPACT_AFTER + 100 ; i++ {
+        var a, _ = record.A("churn.zed.io", 10 * time.Second, net.ParseIP(fmt.Sprintf("10.0.%d.%d", i / 256, i % 256)))
+        if err := store.Add(a) ; err != nil { t.Fatal(err) }
+        if i % 2 == 0 { continue }
+        if err := store.FindAndDelete("churn.zed.io", record.A_RECORD) ; err != nil { t.Fatal(err) }
+    }
+
+    if store.changes >= DISK_COMPACT_AFTER {
+        t.Errorf("Incorrect Changes:\n\tExpected: fewer than %d\n\tGot: %d\n", DISK_COMPACT_AFTER, store.changes)
+    }
+
+    if err := store.Compact() ; err != nil { t.Fatal(err) }
+    if store.changes != int(store.Size()) {
+        t.Errorf("Incorrect Changes:\n\tExpected: %d\n\tGot: %d\n", store.Size(), store.changes)
+    }
+    store.Close()
+
+    var reopened = testOpenDisk(t, path)
+    defer reopened.Close()
+    testSameContents(t, reopened, store)
+
+    if _, err := os.Stat(path + ".compact") ; !os.IsNotExist(err) {
+        t.Errorf("Compaction left its temporary file: %v\n", err)
+    }
+}
+
+//----------------------------------------------
+// Benchmarks
+//----------------------------------------------
+
+func benchmarkAdd(b *testing.B, store DNSStore) {
+    for i := 0 ; i < b.N ; i++ {
+        var a, _ = record.A(fmt.Sprintf("host%d.zed.io", i), 10 * time.Second, net.ParseIP("10.0.0.1"))
+        if err := store.Add(a) ; err != nil { b.Fatal(err) }
+    }
+}
+
+func benchmarkFind(b *testing.B, store DNSStore) {
+    for i := 0 ; i < 10000 ; i++ {
+        var a, _ = record.A(fmt.Sprintf("host%d.zed.io", i), 10 * time.Second, net.ParseIP("10.0.0.1"))
+        if err := store.Add(a) ; err != nil { b.Fatal(err) }
+    }
+
+    b.ResetTimer()
+    for i := 0 ; i < b.N ; i++ {
+        if _, err := store.Find(fmt.Sprintf("host%d.zed.io", i % 10000), record.A_RECORD) ; err != nil { b.Fatal(err) }
+    }
+}
+
+func BenchmarkMapStore_Add(b *testing.B) {
+    benchmarkAdd(b, Map())
+}
+
+func BenchmarkDiskStore_Add(b *testing.B) {
+    var store = testOpenDisk(b, filepath.Join(b.TempDir(), "records.db"))
+    defer store.Close()
+    benchmarkAdd(b, store)
+}
+
+func BenchmarkMapStore_Find(b *testing.B) {
+    benchmarkFind(b, Map())
+}
+
+func BenchmarkDiskStore_Find(b *testing.B) {
+    var store = testOpenDisk(b, filepath.Join(b.TempDir(), "records.db"))
+    defer store.Close()
+    benchmarkFind(b, store)
+}
+
+//----------------------------------------------
+// Redis Store Tests
+//----------------------------------------------
+
+//
+// A client for PHONEBOOK_REDIS if set, or for a stand-in (also returned) otherwise,
+// with a prefix of the test's own so runs against a real server do not meet
+//
+func testRedis(t *testing.T) (*redis.Client, string, *redistest.Server) {
+    var prefix = fmt.Sprintf("phonebook-test:%s:%d:", t.Name(), time.Now().UnixNano())
+
+    if address := os.Getenv("PHONEBOOK_REDIS") ; address != "" {
+        var client = redis.NewClient(address)
+        t.Cleanup(func() { client.Close() })
+        return client, prefix, nil
+    }
+
+    server, err := redistest.NewServer()
+    if err != nil { t.Fatal(err) }
+
+    var client = redis.NewClient(server.Addr())
+    t.Cleanup(func() {
+        client.Close()
+        server.Close()
+    })
+
+    return client, prefix, server
+}
+
+func testOpenRedis(t *testing.T, client *redis.Client, prefix string) *RedisStore {
+    store, err := OpenRedis(client, prefix)
+    if err != nil { t.Fatal(err) }
+
+    t.Cleanup(func() { store.Close() })
+    return store
+}
+
+//
+// Wait for a change to reach a store through pub/sub
+//
+func testEventually(t *testing.T, description string, check func() bool) {
+    for deadline := time.Now().Add(5 * time.Second) ; !check() ; time.Sleep(10 * time.Millisecond) {
+        if time.Now().After(deadline) {
+            t.Fatalf("Timed Out Waiting:\n\tExpected: %s\n", description)
+        }
+    }
+}
+
+//
+// Whether a store answers a name and type as another does
+//
+func testSameAnswers(t *testing.T, got, expected DNSStore, label string, rType uint16) {
+    var describe = func(records []record.Record, err error) string {
+        if err != nil { return err.Error() }
+
+        var result = make([]string, len(records))
+        for i, rec := range records {
+            var data, _ = rec.Data()
+            result[i] = fmt.Sprintf("%s %d %x", rec.GetLabel(), rec.GetType(), data)
+        }
+        return strings.Join(result, ", ")
+    }
+    var single = func(rec record.Record, err error) string {
+        if err != nil { return describe(nil, err) }
+        return describe([]record.Record{ rec }, nil)
+    }
+
+    var answers = []struct{ operation, got, expected string }{
+        { "Find", single(got.Find(label, rType)), single(expected.Find(label, rType)) },
+        { "FindLabel", describe(got.FindLabel(label)), describe(expected.FindLabel(label)) },
+        { "FindRecursively", describe(got.FindRecursively(label, rType)), describe(expected.FindRecursively(label, rType)) },
+    }
+    for _, answer := range answers {
+        if answer.got != answer.expected {
+            t.Errorf("Incorrect %s (%s %d):\n\tExpected: %s\n\tGot: %s\n", answer.operation, label, rType, answer.expected, answer.got)
+        }
+    }
+}
+
+func TestRedisStore_Lookups(t *testing.T) {
+    var client, prefix, _ = testRedis(t)
+    var store = testOpenRedis(t, client, prefix)
+
+    // the zone and the wildcards, each added to a map store alongside
+    var expected = testWildcardStore(t)
+    var records, err = ParseZone(strings.NewReader(testZoneFile), "zed.io")
+    if err != nil { t.Fatal(err) }
+
+    expected.Walk(func(rec record.Record) error {
+        records = append(records, rec)
+        return nil
+    })
+    for _, rec := range records {
+        if err = store.Add(rec) ; err != nil { t.Fatal(err) }
+    }
+    for _, rec := range records[:len(records) - int(expected.Size())] {
+        expected.Add(rec)
+    }
+
+    var queries = []struct{ label string ; rType uint16 }{
+        { "zed.io", record.MX_RECORD },
+        { "ZED.io.", record.NS_RECORD },
+        { "www.zed.io", record.A_RECORD },
+        { "mail.zed.io", record.AAAA_RECORD },
+        { "mail.zed.io", record.TXT_RECORD },
+        { "_tcp.zed.io", record.SRV_RECORD },
+        { "missing.zed.io", record.A_RECORD },
+        { "feature-123.svc.internal", record.A_RECORD },
+        { "feature-123.svc.internal", record.MX_RECORD },
+        { "db.svc.internal", record.TXT_RECORD },
+        { "replica.db.svc.internal", record.A_RECORD },
+        { "images.cdn.internal", record.A_RECORD },
+        { "internal", record.A_RECORD },
+    }
+    var check = func() {
+        for _, query := range queries {
+            testSameAnswers(t, store, expected, query.label, query.rType)
+        }
+        testSameContents(t, store, expected)
+
+        if store.Size() != expected.Size() {
+            t.Errorf("Incorrect Size:\n\tExpected: %d\n\tGot: %d\n", expected.Size(), store.Size())
+        }
+    }
+    check()
+
+    // and again after every kind of change
+    var a, _ = record.A("mail.zed.io", 30 * time.Second, net.ParseIP("10.0.0.9"))
+    for _, target := range []DNSStore{ store, expected } {
+        if err = target.FindAndReplace("mail.zed.io", record.A_RECORD, a) ; err != nil { t.Fatal(err) }
+        if err = target.FindAndDelete("db.svc.internal", record.A_RECORD) ; err != nil { t.Fatal(err) }
+
+        err = Batch(target, func(batch DNSStore) error {
+            var aaaa, _ = record.AAAA("v6.cdn.internal", 10 * time.Second, net.ParseIP("::1"))
+            if err := batch.Add(aaaa) ; err != nil { return err }
+            if err := batch.FindAndDelete("zed.io", record.MX_RECORD) ; err != nil { return err }
+            return batch.Delete(a)
+        })
+        if err != nil { t.Fatal(err) }
+    }
+    queries = append(queries, struct{ label string ; rType uint16 }{ "v6.cdn.internal", record.AAAA_RECORD })
+    check()
+
+    testLookupError(t, "FindAndDelete", store.FindAndDelete("missing.zed.io", record.A_RECORD), ErrNotFound)
+    testLookupError(t, "Batch", Batch(store, func(batch DNSStore) error {
+        batch.Add(a)
+        return ErrNoData
+    }), ErrNoData)
+    check()
+
+    // a second store reads the same records from scratch
+    testSameContents(t, testOpenRedis(t, client, prefix), expected)
+}
+
+func TestRedisStore_Invalidation(t *testing.T) {
+    var client, prefix, _ = testRedis(t)
+    var first, second = testOpenRedis(t, client, prefix), testOpenRedis(t, client, prefix)
+
+    var a, _ = record.A("app.zed.io", 10 * time.Second, net.ParseIP("10.0.0.1"))
+    if err := first.Add(a) ; err != nil { t.Fatal(err) }
+
+    testEventually(t, "the second store to see the added record", func() bool {
+        var _, err = second.Find("app.zed.io", record.A_RECORD)
+        return err == nil
+    })
+
+    // the second store now holds the record in its cache -- a change through the first replaces it
+    var moved, _ = record.A("app.zed.io", 10 * time.Second, net.ParseIP("10.0.0.2"))
+    if err := first.FindAndReplace("app.zed.io", record.A_RECORD, moved) ; err != nil { t.Fatal(err) }
+
+    testEventually(t, "the second store to see the replaced record", func() bool {
+        var rec, err = second.Find("app.zed.io", record.A_RECORD)
+        return err == nil && rec.(*record.ARecord).IP.Equal(net.ParseIP("10.0.0.2"))
+    })
+
+    if err := second.FindAndDelete("app.zed.io", record.A_RECORD) ; err != nil { t.Fatal(err) }
+    testEventually(t, "the first store to see the deleted record", func() bool {
+        var _, err = first.Find("app.zed.io", record.A_RECORD)
+        return err == ErrNotFound
+    })
+}
+
+func TestRedisStore_Expiry(t *testing.T) {
+    var client, prefix, _ = testRedis(t)
+    var first, second = testOpenRedis(t, client, prefix), testOpenRedis(t, client, prefix)
+
+    var a, _ = record.A("ephemeral.zed.io", 10 * time.Second, net.ParseIP("10.0.0.1"))
+    testLookupError(t, "AddExpiring", first.AddExpiring(a, 0), ErrRedisExpiry)
+
+    if err := first.AddExpiring(a, 200 * time.Millisecond) ; err != nil { t.Fatal(err) }
+    if _, err := first.Find("ephemeral.zed.io", record.A_RECORD) ; err != nil { t.Fatal(err) }
+    testEventually(t, "the second store to see the expiring record", func() bool {
+        var _, err = second.Find("ephemeral.zed.io", record.A_RECORD)
+        return err == nil
+    })
+
+    // nothing is published as a key expires -- each store forgets the name by itself
+    time.Sleep(300 * time.Millisecond)
+    for _, store := range []*RedisStore{ first, second } {
+        var _, err = store.Find("ephemeral.zed.io", record.A_RECORD)
+        testLookupError(t, "Find", err, ErrNotFound)
+
+        _, err = store.Find("zed.io", record.A_RECORD)
+        testLookupError(t, "Find", err, ErrNotFound)
+    }
+
+    if size := first.Size() ; size != 0 {
+        t.Errorf("Incorrect Size:\n\tExpected: %d\n\tGot: %d\n", 0, size)
+    }
+}
+
+func TestRedisStore_ExpiryMixed(t *testing.T) {
+    var client, prefix, _ = testRedis(t)
+    var store = testOpenRedis(t, client, prefix)
+
+    var ephemeral, _ = record.A("app.zed.io", 10 * time.Second, net.ParseIP("10.0.0.1"))
+    var permanent, _ = record.TXT("app.zed.io", 10 * time.Second, "permanent")
+    var batched, _ = record.TXT("batch.zed.io", 10 * time.Second, "permanent")
+
+    // a permanent record added beside an expiring one keeps the name -- both records -- from expiring
+    if err := store.AddExpiring(ephemeral, 100 * time.Millisecond) ; err != nil { t.Fatal(err) }
+    if err := store.Add(permanent) ; err != nil { t.Fatal(err) }
+
+    // and so does one added in a batch
+    var expiring, _ = record.A("batch.zed.io", 10 * time.Second, net.ParseIP("10.0.0.2"))
+    if err := store.AddExpiring(expiring, 100 * time.Millisecond) ; err != nil { t.Fatal(err) }
+    if err := store.Batch(func(batch DNSStore) error { return batch.Add(batched) }) ; err != nil { t.Fatal(err) }
+
+    time.Sleep(200 * time.Millisecond)
+    for _, name := range []string{ "app.zed.io", "batch.zed.io" } {
+        if _, err := store.Find(name, record.TXT_RECORD) ; err != nil {
+            t.Errorf("Permanent record expired (%s): %v\n", name, err)
+        }
+        if _, err := store.Find(name, record.A_RECORD) ; err != nil {
+            t.Errorf("Record expired at a name made permanent (%s): %v\n", name, err)
+        }
+    }
+
+    // expiring records are refused at a name holding permanent ones, rather than take them along
+    testLookupError(t, "AddExpiring", store.AddExpiring(ephemeral, 100 * time.Millisecond), ErrRedisPermanent)
+    if size := store.LabelSize("app.zed.io") ; size != 2 {
+        t.Errorf("Incorrect LabelSize:\n\tExpected: %d\n\tGot: %d\n", 2, size)
+    }
+}
+
+func TestRedisStore_BatchConflict(t *testing.T) {
+    var client, prefix, _ = testRedis(t)
+    var first, second = testOpenRedis(t, client, prefix), testOpenRedis(t, client, prefix)
+
+    var a, _ = record.A("app.zed.io", 10 * time.Second, net.ParseIP("10.0.0.1"))
+    var b, _ = record.A("app.zed.io", 10 * time.Second, net.ParseIP("10.0.0.2"))
+    if err := first.Add(a) ; err != nil { t.Fatal(err) }
+
+    // another writer changes a name the batch read, so it is run again on what is there now
+    var attempts = 0
+    var err = first.Batch(func(batch DNSStore) error {
+        attempts += 1
+        var records, err = batch.FindLabel("app.zed.io")
+        if err != nil { return err }
+
+        if attempts == 1 {
+            if err = second.Add(b) ; err != nil { return err }
+        }
+
+        var txt, _ = record.TXT("app.zed.io", 10 * time.Second, fmt.Sprintf("%d records", len(records)))
+        return batch.Add(txt)
+    })
+    if err != nil { t.Fatal(err) }
+
+    if attempts != 2 {
+        t.Errorf("Incorrect Attempts:\n\tExpected: %d\n\tGot: %d\n", 2, attempts)
+    }
+
+    var txt, _ = first.Find("app.zed.io", record.TXT_RECORD)
+    if txt == nil || txt.(*record.TXTRecord).Text != "2 records" {
+        t.Errorf("Incorrect Batch Result:\n\tExpected: %s\n\tGot: %+v\n", "2 records", txt)
+    }
+    if size := first.LabelSize("app.zed.io") ; size != 3 {
+        t.Errorf("Incorrect LabelSize:\n\tExpected: %d\n\tGot: %d\n", 3, size)
+    }
+
+    // a writer that never stops getting in the way wins
+    err = first.Batch(func(batch DNSStore) error {
+        if _, err := batch.FindLabel("app.zed.io") ; err != nil { return err }
+        if err := second.Add(b) ; err != nil { return err }
+        return batch.Delete(a)
+    })
+    testLookupError(t, "Batch", err, ErrRedisConflict)
+}
+
+func TestRedisStore_Reconnect(t *testing.T) {
+    var client, prefix, server = testRedis(t)
+    if server == nil { t.Skip("needs the stand-in, to drop connections") }
+
+    var store = testOpenRedis(t, client, prefix)
+
+    // changes made while the store is not subscribed are read once it is again
+    server.Disconnect()
+
+    var other = redis.NewClient(server.Addr())
+    defer other.Close()
+    var writer = testOpenRedis(t, other, prefix)
+
+    var a, _ = record.A("app.zed.io", 10 * time.Second, net.ParseIP("10.0.0.1"))
+    if err := writer.Add(a) ; err != nil { t.Fatal(err) }
+
+    testEventually(t, "the store to resubscribe and see the record", func() bool {
+        var _, err = store.Find("app.zed.io", record.A_RECORD)
+        return err == nil
+    })
+}
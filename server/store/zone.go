@@ -0,0 +1,164 @@
+package store
+
+import (
+    "sync"
+    "errors"
+
+    "github.com/zmarcantel/phonebook/dns/record"
+)
+
+var ErrNoSOA        error   = errors.New("ERROR: A zone requires an SOA record")
+var ErrZoneExists   error   = errors.New("ERROR: That zone is already configured")
+
+//----------------------------------------------
+// Zone Structures
+//----------------------------------------------
+
+//
+// A zone the server is authoritative for, described by its SOA and name servers
+//
+type Zone struct {
+    Origin          string
+    SOA             *record.SOARecord
+    NS              []*record.NSRecord
+}
+
+//
+// Create a zone rooted at the owner of the SOA record
+//
+func NewZone(soa *record.SOARecord, ns ...*record.NSRecord) (*Zone, error) {
+    if soa == nil { return nil, ErrNoSOA }
+
+    return &Zone{
+        Origin:     zoneKey(soa.GetLabel()),
+        SOA:        soa,
+        NS:         ns,
+    }, nil
+}
+
+//
+// Check if a name is at or below the zone's origin
+//
+func (self *Zone) Contains(name string) bool {
+    return record.Name(zoneKey(name)).IsSubdomain(record.Name(self.Origin))
+}
+
+//----------------------------------------------
+// Zone Collection Structures
+//----------------------------------------------
+
+//
+// The set of zones a server is authoritative for
+// Safe for concurrent use -- zones may be added and removed while queries are answered
+//
+type Zones struct {
+    Backing         map[string]*Zone
+
+    lock            sync.RWMutex
+}
+
+func NewZones() *Zones {
+    return &Zones{
+        make(map[string]*Zone, 0),
+        sync.RWMutex{},
+    }
+}
+
+//
+// Add a zone to the set -- each origin may only be configured once
+//
+func (self *Zones) Add(zone *Zone) error {
+    self.lock.Lock()
+    defer self.lock.Unlock()
+
+    if _, exists := self.Backing[zone.Origin] ; exists {
+        return ErrZoneExists
+    }
+
+    self.Backing[zone.Origin] = zone
+    return nil
+}
+
+//
+// Add a zone, or replace the one configured with the same origin
+//
+func (self *Zones) Put(zone *Zone) {
+    self.lock.Lock()
+    defer self.lock.Unlock()
+
+    self.Backing[zone.Origin] = zone
+}
+
+//
+// Remove the zone with the given origin
+//
+func (self *Zones) Remove(origin string) error {
+    origin = zoneKey(origin)
+
+    self.lock.Lock()
+    defer self.lock.Unlock()
+
+    if _, exists := self.Backing[origin] ; !exists {
+        return ErrNotFound
+    }
+
+    delete(self.Backing, origin)
+    return nil
+}
+
+//
+// Find the closest zone enclosing the name, or nil if it falls outside all of them
+//
+func (self *Zones) Find(name string) *Zone {
+    var current = record.Name(zoneKey(name))
+
+    self.lock.RLock()
+    defer self.lock.RUnlock()
+
+    // walk up the name one label at a time -- the first hit is the closest
+    for {
+        if zone, exists := self.Backing[string(current)] ; exists {
+            return zone
+        }
+        if current == "" { return nil }
+
+        current = current.Parent()
+    }
+}
+
+//
+// Swap in a new SOA for a zone (after its serial changes)
+// Zones are never modified in place -- the zone is replaced by a copy, so holders of the old one are unaffected
+//
+func (self *Zones) SetSOA(origin string, soa *record.SOARecord) error {
+    if soa == nil { return ErrNoSOA }
+    origin = zoneKey(origin)
+
+    self.lock.Lock()
+    defer self.lock.Unlock()
+
+    var zone, exists = self.Backing[origin]
+    if !exists { return ErrNotFound }
+
+    var replaced = *zone
+    replaced.SOA = soa
+    self.Backing[origin] = &replaced
+    return nil
+}
+
+//
+// The number of configured zones
+//
+func (self *Zones) Size() int {
+    self.lock.RLock()
+    defer self.lock.RUnlock()
+
+    return len(self.Backing)
+}
+
+//
+// Zones are matched by canonical name -- case-insensitively and without a trailing dot
+//
+func zoneKey(name string) string {
+    return record.Canonical(name)
+}
@@ -0,0 +1,613 @@
+package store
+
+import (
+    "io"
+    "fmt"
+    "net"
+    "sort"
+    "time"
+    "bufio"
+    "errors"
+    "strconv"
+    "strings"
+    "encoding/hex"
+
+    "github.com/zmarcantel/phonebook/dns/record"
+)
+
+//----------------------------------------------
+// Zone File Structures
+//----------------------------------------------
+
+// a single word of a zone file -- quoted strings keep their spaces
+type zoneToken struct {
+    text            string
+    quoted          bool
+}
+
+// one logical entry (parentheses join physical lines)
+type zoneEntry struct {
+    line            int
+    blankOwner      bool            // began with whitespace, so the owner is inherited
+    tokens          []zoneToken
+}
+
+// state carried from entry to entry while parsing
+type zoneParser struct {
+    origin          string
+    ttl             time.Duration
+    lastOwner       string
+    lastTTL         time.Duration
+}
+
+//----------------------------------------------
+// Loading
+//----------------------------------------------
+
+//
+// Parse an RFC 1035 master file into records
+//    origin: the starting $ORIGIN, used for "@" and relative names (may be "")
+//
+// Supports $ORIGIN, $TTL, relative names, parentheses, comments, and the record
+// types in the record package. Names are returned without a trailing dot
+//
+func ParseZone(source io.Reader, origin string) ([]record.Record, error) {
+    entries, err := readZoneEntries(source)
+    if err != nil { return nil, err }
+
+    var parser = &zoneParser{ origin: strings.TrimSuffix(origin, "."), ttl: -1, lastTTL: -1 }
+    var result = make([]record.Record, 0)
+
+    for _, entry := range entries {
+        rec, err := parser.parse(entry)
+        if err != nil { return nil, zoneError(entry.line, err.Error()) }
+
+        if rec != nil {
+            result = append(result, rec)
+        }
+    }
+
+    return result, nil
+}
+
+//
+// Parse an RFC 1035 master file and add every record in it to the store
+// Nothing is added if any part of the file fails to parse
+//
+func LoadZone(source io.Reader, origin string, into DNSStore) error {
+    records, err := ParseZone(source, origin)
+    if err != nil { return err }
+
+    for _, rec := range records {
+        err = into.Add(rec)
+        if err != nil { return err }
+    }
+
+    return nil
+}
+
+//
+// Split a zone file into entries of tokens
+//
+func readZoneEntries(source io.Reader) ([]zoneEntry, error) {
+    var reader = bufio.NewReader(source)
+    var entries = make([]zoneEntry, 0)
+
+    var line = 1
+    var depth = 0
+    var lineStart = true
+    var current *zoneEntry
+
+    var finish = func() {
+        if current != nil && len(current.tokens) > 0 {
+            entries = append(entries, *current)
+        }
+        current = nil
+    }
+
+    for {
+        c, _, err := reader.ReadRune()
+        if err == io.EOF { break }
+        if err != nil { return nil, err }
+
+        // a new entry starts at the beginning of any line outside of parentheses
+        if lineStart && depth == 0 {
+            finish()
+            current = &zoneEntry{ line: line, blankOwner: c == ' ' || c == '\t' }
+        }
+        lineStart = false
+
+        switch {
+            case c == '\n':
+                line += 1
+                lineStart = true
+
+            case c == ' ' || c == '\t' || c == '\r':
+                continue
+
+            case c == ';':
+                // comments run to the end of the line
+                for {
+                    next, _, err := reader.ReadRune()
+                    if err != nil { break }
+                    if next == '\n' {
+                        reader.UnreadRune()
+                        break
+                    }
+                }
+
+            case c == '(':
+                depth += 1
+
+            case c == ')':
+                depth -= 1
+                if depth < 0 { return nil, zoneError(line, "unbalanced ')'") }
+
+            case c == '"':
+                var text = make([]rune, 0)
+                for {
+                    next, _, err := reader.ReadRune()
+                    if err != nil { return nil, zoneError(current.line, "unterminated quoted string") }
+                    if next == '"' { break }
+                    if next == '\n' { line += 1 }
+
+                    if next == '\\' {
+                        escaped, _, err := reader.ReadRune()
+                        if err != nil { return nil, zoneError(current.line, "unterminated quoted string") }
+                        text = append(text, next, escaped)
+                        continue
+                    }
+                    text = append(text, next)
+                }
+                current.tokens = append(current.tokens, zoneToken{ unescapeText(string(text)), true })
+
+            default:
+                // bare words keep their escapes -- names are interpreted later
+                var text = []rune{ c }
+                for {
+                    next, _, err := reader.ReadRune()
+                    if err != nil { break }
+
+                    if c == '\\' {
+                        text = append(text, next)
+                        c = 0
+                        continue
+                    }
+                    if strings.ContainsRune(" \t\r\n;()\"", next) {
+                        reader.UnreadRune()
+                        break
+                    }
+
+                    text = append(text, next)
+                    c = next
+                }
+                current.tokens = append(current.tokens, zoneToken{ string(text), false })
+        }
+    }
+
+    if depth != 0 { return nil, zoneError(line, "unbalanced '('") }
+    finish()
+
+    return entries, nil
+}
+
+//
+// Turn a single entry into a record (or apply it, if it is a directive)
+// Directives return a nil record
+//
+func (self *zoneParser) parse(entry zoneEntry) (record.Record, error) {
+    var tokens = entry.tokens
+
+    // directives
+    if !entry.blankOwner && !tokens[0].quoted && strings.HasPrefix(tokens[0].text, "$") {
+        var directive = strings.ToUpper(tokens[0].text)
+        if len(tokens) < 2 { return nil, errors.New(directive + " requires an argument") }
+
+        switch directive {
+            case "$ORIGIN":
+                origin, err := self.absolute(tokens[1].text)
+                if err != nil { return nil, err }
+                self.origin = origin
+
+            case "$TTL":
+                ttl, err := parseTTL(tokens[1].text)
+                if err != nil { return nil, err }
+                self.ttl = ttl
+
+            default:
+                return nil, errors.New("unsupported directive " + directive)
+        }
+
+        return nil, nil
+    }
+
+    // the owner is either given or carried over from the previous record
+    var owner = self.lastOwner
+    if entry.blankOwner && owner == "" {
+        return nil, errors.New("no owner to inherit")
+    }
+    if !entry.blankOwner {
+        var err error
+        owner, err = self.absolute(tokens[0].text)
+        if err != nil { return nil, err }
+        tokens = tokens[1:]
+    }
+    self.lastOwner = owner
+
+    // TTL and class may come in either order, and are both optional
+    var ttl time.Duration = -1
+    for i := 0 ; i < 2 && len(tokens) > 0 ; i++ {
+        var word = strings.ToUpper(tokens[0].text)
+
+        if parsed, err := parseTTL(word) ; err == nil && ttl < 0 {
+            ttl = parsed
+        } else if word == "IN" {
+            // the only class we serve
+        } else if word == "CH" || word == "HS" || word == "CS" {
+            return nil, errors.New("unsupported class " + word)
+        } else {
+            break
+        }
+        tokens = tokens[1:]
+    }
+
+    if ttl < 0 { ttl = self.ttl }
+    if ttl < 0 { ttl = self.lastTTL }
+    if ttl < 0 { return nil, errors.New("no TTL given and no $TTL set") }
+    self.lastTTL = ttl
+
+    if len(tokens) < 1 { return nil, errors.New("missing record type") }
+    var rType = strings.ToUpper(tokens[0].text)
+    var data = tokens[1:]
+
+    return self.build(owner, ttl, rType, data)
+}
+
+//
+// Create a record of the named type from its presentation-format data
+//
+func (self *zoneParser) build(owner string, ttl time.Duration, rType string, data []zoneToken) (record.Record, error) {
+    if len(data) > 0 && !data[0].quoted && data[0].text == "\\#" {
+        return generic(owner, ttl, rType, data[1:])
+    }
+
+    var fields = map[string]int{ "A": 1, "AAAA": 1, "CNAME": 1, "PTR": 1, "NS": 1, "MX": 2, "SRV": 4, "SOA": 7 }
+    if count, known := fields[rType] ; known && len(data) != count {
+        return nil, errors.New(fmt.Sprintf("%s records take %d fields, got %d", rType, count, len(data)))
+    }
+
+    switch rType {
+        case "A":
+            var ip = net.ParseIP(data[0].text)
+            if ip == nil || ip.To4() == nil { return nil, errors.New("invalid IPv4 address " + data[0].text) }
+            return record.A(owner, ttl, ip)
+
+        case "AAAA":
+            var ip = net.ParseIP(data[0].text)
+            if ip == nil || ip.To4() != nil { return nil, errors.New("invalid IPv6 address " + data[0].text) }
+            return record.AAAA(owner, ttl, ip)
+
+        case "CNAME", "PTR", "NS":
+            target, err := self.absolute(data[0].text)
+            if err != nil { return nil, err }
+
+            if rType == "CNAME" { return record.CNAME(owner, target, ttl) }
+            if rType == "PTR" { return record.PTR(owner, target, ttl) }
+            return record.NS(owner, target, ttl)
+
+        case "MX":
+            priority, err := parseUint16(data[0].text)
+            if err != nil { return nil, err }
+
+            target, err := self.absolute(data[1].text)
+            if err != nil { return nil, err }
+
+            return record.MX(owner, target, priority, ttl)
+
+        case "SRV":
+            var numbers = make([]uint16, 3)
+            for i := range numbers {
+                number, err := parseUint16(data[i].text)
+                if err != nil { return nil, err }
+                numbers[i] = number
+            }
+
+            target, err := self.absolute(data[3].text)
+            if err != nil { return nil, err }
+
+            return record.SRV(owner, target, ttl, numbers[0], numbers[1], numbers[2])
+
+        case "TXT":
+            if len(data) < 1 { return nil, errors.New("TXT records take at least 1 field") }
+
+            // multiple strings are joined, as they are on the wire
+            var text string
+            for _, part := range data {
+                if len(part.text) > record.TXT_STRING_MAX {
+                    return nil, errors.New(fmt.Sprintf("TXT strings may not exceed %d bytes, got %d", record.TXT_STRING_MAX, len(part.text)))
+                }
+                text += part.text
+            }
+            return record.TXT(owner, ttl, text)
+
+        case "SOA":
+            mname, err := self.absolute(data[0].text)
+            if err != nil { return nil, err }
+
+            rname, err := self.absolute(data[1].text)
+            if err != nil { return nil, err }
+
+            serial, err := strconv.ParseUint(data[2].text, 10, 32)
+            if err != nil { return nil, errors.New("invalid serial " + data[2].text) }
+
+            var timers = make([]time.Duration, 4)
+            for i := range timers {
+                timer, err := parseTTL(data[3 + i].text)
+                if err != nil { return nil, err }
+                timers[i] = timer
+            }
+
+            return record.SOA(owner, mname, rname, ttl, uint32(serial), timers[0], timers[1], timers[2], timers[3])
+    }
+
+    return nil, errors.New("unsupported record type " + rType)
+}
+
+//
+// Create a record from the RFC 3597 generic form -- "TYPEnnn \# length hex" -- which any type may be written in,
+// and which types without a presentation format of their own are exported in
+//
+func generic(owner string, ttl time.Duration, rType string, data []zoneToken) (record.Record, error) {
+    var number, known = record.TypeFromString(rType)
+    if !known && strings.HasPrefix(rType, "TYPE") {
+        parsed, err := strconv.ParseUint(rType[4:], 10, 16)
+        if err != nil { return nil, errors.New("unsupported record type " + rType) }
+        number, known = uint16(parsed), true
+    }
+    if !known { return nil, errors.New("unsupported record type " + rType) }
+
+    if len(data) < 1 { return nil, errors.New("generic data needs a length") }
+    length, err := parseUint16(data[0].text)
+    if err != nil { return nil, err }
+
+    // the hex may be split into any number of words
+    var digits string
+    for _, word := range data[1:] {
+        digits += word.text
+    }
+
+    rdata, err := hex.DecodeString(digits)
+    if err != nil { return nil, errors.New("invalid generic data " + digits) }
+    if len(rdata) != int(length) {
+        return nil, errors.New(fmt.Sprintf("generic data is %d bytes, but claims %d", len(rdata), length))
+    }
+
+    // decoded as though it arrived on the wire, so known types come back as themselves
+    label, err := record.CreateMessageLabel(owner)
+    if err != nil { return nil, err }
+
+    var wire = append(label, record.Uint16ToBytes(number)...)
+    wire = append(wire, record.Uint16ToBytes(record.CLASS_IN)...)
+    wire = append(wire, record.Uint32ToBytes(uint32(ttl.Seconds()))...)
+    wire = append(wire, record.Uint16ToBytes(length)...)
+    wire = append(wire, rdata...)
+
+    rec, _, err := record.UnpackRecord(wire, 0)
+    if err != nil { return nil, err }
+
+    return record.Rename(rec, owner), nil
+}
+
+//
+// Qualify a name against the current origin
+// "@" is the origin itself, names ending in "." are already absolute
+//
+func (self *zoneParser) absolute(name string) (string, error) {
+    if name == "" { return "", errors.New("empty name") }
+
+    if name == "@" {
+        if self.origin == "" { return "", errors.New("'@' used without an $ORIGIN") }
+        return self.origin, nil
+    }
+
+    if strings.HasSuffix(name, ".") && !strings.HasSuffix(name, "\\.") {
+        name = strings.TrimSuffix(name, ".")
+    } else if self.origin != "" {
+        name = name + "." + self.origin
+    }
+
+    // validate escapes and lengths, but keep the name as written
+    if _, err := record.ParseName(name) ; err != nil { return "", err }
+    return name, nil
+}
+
+//
+// Parse a TTL as plain seconds or with units (1w2d3h4m5s)
+//
+func parseTTL(source string) (time.Duration, error) {
+    if source == "" { return 0, errors.New("empty TTL") }
+
+    if seconds, err := strconv.ParseUint(source, 10, 32) ; err == nil {
+        return time.Duration(seconds) * time.Second, nil
+    }
+
+    var units = map[byte]time.Duration{ 'S': time.Second, 'M': time.Minute, 'H': time.Hour, 'D': 24 * time.Hour, 'W': 7 * 24 * time.Hour }
+    var result time.Duration
+    var number uint64
+    var digits = false
+
+    for _, c := range []byte(strings.ToUpper(source)) {
+        if c >= '0' && c <= '9' {
+            number = number * 10 + uint64(c - '0')
+            digits = true
+            continue
+        }
+
+        var unit, known = units[c]
+        if !known || !digits { return 0, errors.New("invalid TTL " + source) }
+
+        result += time.Duration(number) * unit
+        number = 0
+        digits = false
+    }
+
+    if digits { return 0, errors.New("invalid TTL " + source) }
+    return result, nil
+}
+
+//
+// Parse a 16 bit field (priorities, weights, and ports)
+//
+func parseUint16(source string) (uint16, error) {
+    number, err := strconv.ParseUint(source, 10, 16)
+    if err != nil { return 0, errors.New("invalid number " + source) }
+
+    return uint16(number), nil
+}
+
+//
+// Resolve \" and \\ (and \DDD) escapes within a quoted string
+//
+func unescapeText(source string) string {
+    var result = make([]byte, 0, len(source))
+
+    for i := 0 ; i < len(source) ; i++ {
+        if source[i] != '\\' || i + 1 >= len(source) {
+            result = append(result, source[i])
+            continue
+        }
+
+        // \DDD is a decimal byte value
+        if i + 3 < len(source) && isDigits(source[i + 1:i + 4]) {
+            value, _ := strconv.Atoi(source[i + 1:i + 4])
+            result = append(result, byte(value))
+            i += 3
+            continue
+        }
+
+        result = append(result, source[i + 1])
+        i += 1
+    }
+
+    return string(result)
+}
+
+func isDigits(source string) bool {
+    for _, c := range []byte(source) {
+        if c < '0' || c > '9' { return false }
+    }
+    return len(source) > 0
+}
+
+func zoneError(line int, message string) error {
+    return errors.New(fmt.Sprintf("ERROR: zone file line %d: %s", line, message))
+}
+
+//----------------------------------------------
+// Exporting
+//----------------------------------------------
+
+//
+// Write the records of a store out as an RFC 1035 master file
+//    origin: only export records at or beneath this name ("" exports everything)
+//
+// The zone's SOA (if any) is written first, every name is written fully qualified
+//
+func ExportZone(destination io.Writer, from DNSStore, origin string) error {
+    var zone = &Zone{ Origin: zoneKey(origin) }
+    var records = make([]record.Record, 0)
+
+    var err = from.Walk(func(rec record.Record) error {
+        if rec.GetType() == record.OPT_RECORD || !zone.Contains(rec.GetLabel()) {
+            return nil
+        }
+
+        records = append(records, rec)
+        return nil
+    })
+    if err != nil { return err }
+
+    // the SOA leads the zone, everything else keeps the store's order
+    sort.SliceStable(records, func(i, j int) bool {
+        return records[i].GetType() == record.SOA_RECORD && records[j].GetType() != record.SOA_RECORD
+    })
+
+    var writer = bufio.NewWriter(destination)
+    if zone.Origin != "" {
+        fmt.Fprintf(writer, "$ORIGIN %s\n", qualify(zone.Origin))
+    }
+
+    for _, rec := range records {
+        line, err := presentRecord(rec)
+        if err != nil { return err }
+
+        fmt.Fprintln(writer, line)
+    }
+
+    return writer.Flush()
+}
+
+//
+// Format a record as a single master file line
+//
+func presentRecord(rec record.Record) (string, error) {
+    var ttl time.Duration
+    var data string
+
+    switch typed := rec.(type) {
+        case *record.ARecord:
+            ttl, data = typed.TTL, typed.IP.String()
+        case *record.AAAARecord:
+            ttl, data = typed.TTL, typed.IP.String()
+        case *record.CNAMERecord:
+            ttl, data = typed.TTL, qualify(typed.Target)
+        case *record.PTRRecord:
+            ttl, data = typed.TTL, qualify(typed.Target)
+        case *record.NSRecord:
+            ttl, data = typed.TTL, qualify(typed.Target)
+        case *record.MXRecord:
+            ttl, data = typed.TTL, fmt.Sprintf("%d %s", typed.Priority, qualify(typed.Target))
+        case *record.SRVRecord:
+            ttl, data = typed.TTL, fmt.Sprintf("%d %d %d %s", typed.Priority, typed.Weight, typed.Port, qualify(typed.Target))
+        case *record.TXTRecord:
+            ttl, data = typed.TTL, quoteText(typed.Text)
+        case *record.SOARecord:
+            ttl, data = typed.TTL, fmt.Sprintf("%s %s ( %d %d %d %d %d )", qualify(typed.MName), qualify(typed.RName), typed.Serial,
+                uint32(typed.Refresh.Seconds()), uint32(typed.Retry.Seconds()), uint32(typed.Expire.Seconds()), uint32(typed.Minimum.Seconds()))
+        case *record.UnknownRecord:
+            // RFC 3597 generic form
+            ttl, data = typed.TTL, fmt.Sprintf("\\# %d %s", len(typed.RData), hex.EncodeToString(typed.RData))
+        default:
+            return "", ErrInvalidType
+    }
+
+    var name = record.TypeIntToString[rec.GetType()]
+    if name == "" { name = fmt.Sprintf("TYPE%d", rec.GetType()) }
+
+    return fmt.Sprintf("%s\t%d\tIN\t%s\t%s", qualify(rec.GetLabel()), uint32(ttl.Seconds()), name, data), nil
+}
+
+//
+// Write a name fully qualified (with its trailing dot)
+//
+func qualify(name string) string {
+    return strings.TrimSuffix(name, ".") + "."
+}
+
+//
+// Quote TXT data, escaping quotes and backslashes, as strings short enough to be read back
+//
+func quoteText(text string) string {
+    var replacer = strings.NewReplacer("\\", "\\\\", "\"", "\\\"")
+
+    var parts = make([]string, 0, 1)
+    for {
+        var part = text[:min(len(text), record.TXT_STRING_MAX)]
+        parts = append(parts, "\"" + replacer.Replace(part) + "\"")
+
+        text = text[len(part):]
+        if len(text) == 0 { break }
+    }
+
+    return strings.Join(parts, " ")
+}
@@ -0,0 +1,299 @@
+package server
+
+import (
+    "bytes"
+
+    "github.com/zmarcantel/phonebook/dns"
+    "github.com/zmarcantel/phonebook/dns/record"
+    "github.com/zmarcantel/phonebook/server/store"
+)
+
+// the (uncompressed) size of the records packed into each message of a zone transfer,
+// well within the 64KB a TCP message may hold
+const TRANSFER_MESSAGE_SIZE int = 16 * 1024
+
+//----------------------------------------------
+// Zone Transfers (AXFR, IXFR)
+//----------------------------------------------
+
+//
+// Check if a query asks for a zone transfer
+//
+func isTransfer(message *dns.Message) bool {
+    for _, question := range message.Questions {
+        if int(question.Type) == DNS_QUERY_AXFR || int(question.Type) == DNS_QUERY_IXFR { return true }
+    }
+
+    return false
+}
+
+//
+// Answer a zone transfer with the messages carrying the zone (RFC 5936), or its changes (RFC 1995)
+//
+func (self *Server) transfer(request *Request, message *dns.Message, badVersion bool) []dns.Message {
+    var zone *store.Zone
+    if !badVersion {
+        zone, message.Header.Rcode = self.checkTransfer(request, message)
+    }
+
+    if zone == nil {
+        var response = generateAnswerMessage(message, nil)
+        response.Header.Authoritative = false
+        return []dns.Message{ response }
+    }
+
+    var records []record.Record
+    if int(message.Questions[0].Type) == DNS_QUERY_IXFR {
+        records = self.incremental(request, message, zone)
+    } else {
+        records = self.zoneRecords(zone)
+    }
+
+    return packTransfer(message, records)
+}
+
+//
+// Check the form of a transfer request, that the zone is ours, and that the client may have it
+// Returns the zone, or nil and the rcode refusing the transfer
+//
+func (self *Server) checkTransfer(request *Request, message *dns.Message) (*store.Zone, int) {
+    if len(message.Questions) != 1 || countOPT(message) > 1 { return nil, ERR_FORMAT }
+    var question = message.Questions[0]
+
+    // a whole zone will not fit in a datagram (RFC 5936 4.2) -- IXFR answers those with the SOA alone
+    if int(question.Type) == DNS_QUERY_AXFR && request.Protocol == PROTO_UDP { return nil, ERR_NOIMPL }
+
+    // IXFR carries the client's SOA in the authority section (RFC 1995 3)
+    if int(question.Type) == DNS_QUERY_IXFR && clientSOA(message) == nil { return nil, ERR_FORMAT }
+
+    var zone *store.Zone
+    if self.Zones != nil { zone = self.Zones.Find(question.Name) }
+    if zone == nil || zone.Origin != record.Canonical(question.Name) {
+        return nil, ERR_NOTAUTH
+    }
+
+    if self.AllowTransfer == nil || !self.AllowTransfer(request, zone) {
+        return nil, ERR_REFUSED
+    }
+
+    return zone, 0
+}
+
+//
+// The whole zone as AXFR sends it: the SOA, every other record in the zone, and the SOA again (RFC 5936 2.2)
+// Names within another of our zones are left to it, except for the NS records delegating to it
+//
+func (self *Server) zoneRecords(zone *store.Zone) []record.Record {
+    var result = []record.Record{ record.Copy(zone.SOA) }
+
+    var err = self.Store.Walk(func(rec record.Record) error {
+        if rec.GetType() == record.SOA_RECORD || !zone.Contains(rec.GetLabel()) { return nil }
+
+        var closest = self.Zones.Find(rec.GetLabel())
+        if closest != nil && closest.Origin != zone.Origin {
+            var delegation = rec.GetType() == record.NS_RECORD && closest.Origin == record.Canonical(rec.GetLabel())
+            if !delegation { return nil }
+        }
+
+        result = append(result, rec)
+        return nil
+    })
+    if err != nil { self.reportError(err) }
+
+    return append(result, record.Copy(zone.SOA))
+}
+
+//
+// The changes since the client's serial as IXFR sends them (RFC 1995 4): the current SOA, then for each change
+// the old SOA and the records deleted followed by the new SOA and the records added, then the current SOA again
+//
+// Clients already up to date, and those asking over UDP, get the current SOA alone (the latter retry over TCP)
+// The whole zone is sent instead when the journal does not reach back to the client's serial
+//
+func (self *Server) incremental(request *Request, message *dns.Message, zone *store.Zone) []record.Record {
+    var current = zone.SOA
+    var client = clientSOA(message)
+
+    if !serialNewer(current.Serial, client.Serial) || request.Protocol == PROTO_UDP {
+        return []record.Record{ record.Copy(current) }
+    }
+
+    var changes, found = self.Journal.Since(zone.Origin, client.Serial)
+    if !found || changes[len(changes) - 1].To.Serial != current.Serial {
+        return self.zoneRecords(zone)
+    }
+
+    var result = []record.Record{ record.Copy(current) }
+    for _, change := range changes {
+        result = append(result, record.Copy(change.From))
+        result = append(result, copyRecords(change.Deleted)...)
+        result = append(result, record.Copy(change.To))
+        result = append(result, copyRecords(change.Added)...)
+    }
+
+    return append(result, record.Copy(current))
+}
+
+//
+// The SOA an IXFR client sent as the version it holds, or nil
+//
+func clientSOA(message *dns.Message) *record.SOARecord {
+    for _, rec := range message.Ns {
+        if soa, isSOA := rec.(*record.SOARecord) ; isSOA { return soa }
+    }
+
+    return nil
+}
+
+//
+// Split the records of a transfer into messages, the question only in the first
+//
+func packTransfer(message *dns.Message, records []record.Record) []dns.Message {
+    var result = make([]dns.Message, 0)
+    var chunk = make([]record.Record, 0)
+    var size int
+
+    var flush = func() {
+        var response = generateAnswerMessage(message, chunk)
+        if len(result) > 0 {
+            response.Questions = nil
+            response.Header.QDCount = 0
+        }
+
+        result = append(result, response)
+        chunk = make([]record.Record, 0)
+        size = 0
+    }
+
+    for _, rec := range records {
+        // anything that cannot be serialized fails with the message it lands in
+        if serialized, err := rec.Serialize() ; err == nil { size += len(serialized) }
+
+        chunk = append(chunk, rec)
+        if size >= TRANSFER_MESSAGE_SIZE { flush() }
+    }
+    if len(chunk) > 0 || len(result) == 0 { flush() }
+
+    return result
+}
+
+//
+// Copy records shared with other requests before they are serialized
+//
+func copyRecords(records []record.Record) []record.Record {
+    var result = make([]record.Record, 0, len(records))
+    for _, rec := range records {
+        result = append(result, record.Copy(rec))
+    }
+
+    return result
+}
+
+//----------------------------------------------
+// Journaling Updates
+//----------------------------------------------
+
+//
+// A store that notes the records deleted from and added to it, so the changes of an UPDATE can be journaled
+//
+type recorder struct {
+    store.DNSStore
+
+    deleted         []record.Record
+    added           []record.Record
+}
+
+func (self *recorder) Add(rec record.Record) error {
+    if err := self.DNSStore.Add(rec) ; err != nil { return err }
+
+    self.added = append(self.added, record.Copy(rec))
+    return nil
+}
+
+func (self *recorder) Delete(rec record.Record) error {
+    return self.deleting(rec.GetLabel(), rec.GetType(), func() error {
+        return self.DNSStore.Delete(rec)
+    })
+}
+
+func (self *recorder) FindAndDelete(name string, rType uint16) error {
+    return self.deleting(name, rType, func() error {
+        return self.DNSStore.FindAndDelete(name, rType)
+    })
+}
+
+func (self *recorder) FindAndReplace(name string, rType uint16, newer record.Record) error {
+    var err = self.deleting(name, rType, func() error {
+        return self.DNSStore.FindAndReplace(name, rType, newer)
+    })
+    if err != nil { return err }
+
+    self.added = append(self.added, record.Copy(newer))
+    return nil
+}
+
+//
+// Run a deletion, noting whichever record of the type at the name it removed
+//
+func (self *recorder) deleting(name string, rType uint16, fn func() error) error {
+    var before = rrset(self.DNSStore, name, rType)
+    if err := fn() ; err != nil { return err }
+
+    var after = rrset(self.DNSStore, name, rType)
+    for _, rec := range before {
+        if i := indexOfRecord(after, rec) ; i >= 0 {
+            after = append(after[:i:i], after[i + 1:]...)
+            continue
+        }
+        self.deleted = append(self.deleted, record.Copy(rec))
+    }
+
+    return nil
+}
+
+//
+// The change recorded between two SOAs, less any record deleted and put back as it was
+//
+func (self *recorder) change(from, to *record.SOARecord) store.Change {
+    var deleted = withoutSOA(self.deleted)
+    var added = withoutSOA(self.added)
+
+    for i := 0 ; i < len(deleted) ; {
+        if j := indexOfRecord(added, deleted[i]) ; j >= 0 {
+            added = append(added[:j:j], added[j + 1:]...)
+            deleted = append(deleted[:i:i], deleted[i + 1:]...)
+            continue
+        }
+        i += 1
+    }
+
+    return store.Change{ From: from, To: to, Deleted: deleted, Added: added }
+}
+
+func withoutSOA(records []record.Record) []record.Record {
+    var result = make([]record.Record, 0, len(records))
+    for _, rec := range records {
+        if rec.GetType() != record.SOA_RECORD { result = append(result, rec) }
+    }
+
+    return result
+}
+
+//
+// Find the record with the same name, type, TTL, and data, or -1
+//
+func indexOfRecord(records []record.Record, rec record.Record) int {
+    var header = record.HeaderOf(rec)
+    var data, err = rec.Data()
+    if err != nil { return -1 }
+
+    for i, curr := range records {
+        var currHeader = record.HeaderOf(curr)
+        if currHeader.Type != header.Type || currHeader.TTL != header.TTL || !record.NamesEqual(currHeader.Name, header.Name) { continue }
+
+        var currData, err = curr.Data()
+        if err == nil && bytes.Equal(currData, data) { return i }
+    }
+
+    return -1
+}
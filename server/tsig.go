@@ -0,0 +1,79 @@
+package server
+
+import (
+    "time"
+
+    "github.com/zmarcantel/phonebook/dns"
+    "github.com/zmarcantel/phonebook/dns/record"
+)
+
+//----------------------------------------------
+// Transaction Signatures (TSIG)
+//----------------------------------------------
+
+//
+// Check if a request that changes or copies whole zones arrived without the signature we require
+// Signatures are only required once the server has keys
+//
+func (self *Server) unsigned(request *Request, message *dns.Message) bool {
+    if len(self.Keys) == 0 || request.Key != nil { return false }
+    if message.Header.Opcode == dns.OPCODE_UPDATE { return true }
+
+    for _, question := range message.Questions {
+        if int(question.Type) == DNS_QUERY_AXFR || int(question.Type) == DNS_QUERY_IXFR { return true }
+    }
+    return false
+}
+
+//
+// Answer a request that failed verification (NOTAUTH, the reason in our TSIG record)
+// or that needed a signature and had none (REFUSED)
+//
+func (self *Server) unauthorized(request *Request, message *dns.Message, failure dns.TSIGError) dns.Message {
+    if failure != 0 {
+        message.Header.Rcode = ERR_NOTAUTH
+        self.reportError(failure)
+    } else {
+        message.Header.Rcode = ERR_REFUSED
+    }
+
+    var response = generateAnswerMessage(message, nil)
+    response.Header.Authoritative = false
+    return response
+}
+
+//
+// Sign a serialized response to a signed request (RFC 8945 5.3)
+// Failures to verify the request are answered with the error, signed only if the request's MAC was good
+// Messages after the first of a zone transfer are chained to the one before (previous), rather than the request
+// Returns the signed response and its signature
+//
+func (self *Server) sign(request *Request, response []byte, failure dns.TSIGError, previous *record.TSIGRecord) ([]byte, *record.TSIGRecord, error) {
+    var now = time.Now()
+
+    signature, err := record.TSIG(request.signature.Name, request.signature.Algorithm, now, dns.TSIG_FUDGE)
+    if err != nil { return nil, nil, err }
+    signature.Error = uint16(failure)
+
+    switch failure {
+        case dns.TSIG_BADKEY, dns.TSIG_BADSIG:
+            // the client could not check a signature made with a key we do not share
+            response, err = dns.SignTSIG(response, signature, nil, nil)
+            return response, signature, err
+
+        case dns.TSIG_BADTIME:
+            // echo the client's time, and tell it ours
+            signature.TimeSigned = request.signature.TimeSigned
+            signature.OtherData = record.TSIGTime(now)
+    }
+
+    var key = request.Key
+    if key == nil { key = self.Keys.Find(request.signature.Name) }
+
+    if previous != nil {
+        response, err = dns.SignTSIGContinued(response, signature, key, previous.MAC)
+    } else {
+        response, err = dns.SignTSIG(response, signature, key, request.signature.MAC)
+    }
+    return response, signature, err
+}
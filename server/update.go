@@ -0,0 +1,382 @@
+package server
+
+import (
+    "strconv"
+
+    "github.com/zmarcantel/phonebook/dns"
+    "github.com/zmarcantel/phonebook/dns/record"
+    "github.com/zmarcantel/phonebook/server/store"
+)
+
+// response codes of dynamic UPDATE (RFC 2136 2.2)
+const (
+    ERR_YXDOMAIN    int             = 6         // a name that should not exist does
+    ERR_YXRRSET     int             = 7         // an RRset that should not exist does
+    ERR_NXRRSET     int             = 8         // an RRset that should exist does not
+    ERR_NOTAUTH     int             = 9         // we are not authoritative for the zone
+    ERR_NOTZONE     int             = 10        // a name is outside of the zone
+)
+
+// types that describe queries rather than data, and so can never be added
+var metaTypes = map[uint16]bool{
+    record.OPT_RECORD:      true,
+    record.TSIG_RECORD:     true,
+    uint16(DNS_QUERY_IXFR): true,
+    uint16(DNS_QUERY_AXFR): true,
+    253:                    true,       // MAILB
+    254:                    true,       // MAILA
+    uint16(DNS_QUERY_ALL):  true,
+}
+
+//
+// An rcode that ends an UPDATE, carried out of a store batch as an error so the batch is discarded
+//
+type updateError int
+
+func (self updateError) Error() string {
+    return "ERROR: Update failed with rcode " + strconv.Itoa(int(self))
+}
+
+//----------------------------------------------
+// Dynamic UPDATE (RFC 2136)
+//----------------------------------------------
+
+//
+// Apply a dynamic UPDATE and build the response
+// The message's sections are read as zone (questions), prerequisites (answers), and updates (authority)
+//
+func (self *Server) update(request *Request, message *dns.Message, badVersion bool) dns.Message {
+    if countOPT(message) > 1 {
+        message.Header.Rcode = ERR_FORMAT
+    } else if !badVersion {
+        message.Header.Rcode = self.applyUpdate(request, message)
+    }
+
+    var response = generateAnswerMessage(message, nil)
+    response.Header.Authoritative = false
+    return response
+}
+
+//
+// Check the zone, policy, prerequisites, and updates, then apply the updates as one
+// Returns the rcode of the outcome
+//
+func (self *Server) applyUpdate(request *Request, message *dns.Message) int {
+    // exactly one zone, named by an SOA question (RFC 2136 3.1.1)
+    if len(message.Questions) != 1 || message.Questions[0].Type != record.SOA_RECORD {
+        return ERR_FORMAT
+    }
+
+    var zone *store.Zone
+    if self.Zones != nil { zone = self.Zones.Find(message.Questions[0].Name) }
+    if zone == nil || zone.Origin != record.Canonical(message.Questions[0].Name) {
+        return ERR_NOTAUTH
+    }
+
+    if self.AllowUpdate == nil || !self.AllowUpdate(request, zone) {
+        return ERR_REFUSED
+    }
+
+    // every update is checked before any is applied (RFC 2136 3.4.1)
+    if rcode := prescanUpdates(zone, message.Ns) ; rcode != 0 {
+        return rcode
+    }
+
+    // prerequisites are checked against the same store the updates are applied to
+    self.updating.Lock()
+    defer self.updating.Unlock()
+
+    var soa *record.SOARecord
+    var change store.Change
+    var err = store.Batch(self.Store, func(backing store.DNSStore) error {
+        if rcode := checkPrerequisites(backing, zone, message.Answers) ; rcode != 0 {
+            return updateError(rcode)
+        }
+
+        // the SOA in the store, rather than the zone's copy, is the version the changes start from
+        var previous = zone.SOA
+        if current, found := firstSOA(rrset(backing, zone.Origin, record.SOA_RECORD)) ; found {
+            previous = record.Copy(current).(*record.SOARecord)
+        }
+
+        var recorded = &recorder{ DNSStore: backing }
+        var err error
+        soa, err = applyUpdates(recorded, zone, message.Ns)
+        if err == nil && soa != nil { change = recorded.change(previous, soa) }
+        return err
+    })
+
+    if rcode, failed := err.(updateError) ; failed {
+        return int(rcode)
+    }
+    if err != nil {
+        self.reportError(err)
+        return ERR_INTERNAL
+    }
+
+    if soa != nil {
+        self.Zones.SetSOA(zone.Origin, soa)
+        self.Journal.Append(zone.Origin, change)
+    }
+    return 0
+}
+
+//
+// The first SOA of a set of records, if any
+//
+func firstSOA(records []record.Record) (*record.SOARecord, bool) {
+    for _, rec := range records {
+        if soa, isSOA := rec.(*record.SOARecord) ; isSOA { return soa, true }
+    }
+
+    return nil, false
+}
+
+//
+// Check the form of every update before any are applied (RFC 2136 3.4.1.3)
+//
+func prescanUpdates(zone *store.Zone, updates []record.Record) int {
+    for _, rec := range updates {
+        var header = record.HeaderOf(rec)
+        if !zone.Contains(header.Name) { return ERR_NOTZONE }
+
+        switch header.Class {
+            case record.CLASS_IN:
+                if metaTypes[header.Type] { return ERR_FORMAT }
+            case record.CLASS_ANY:
+                if header.TTL != 0 || header.RDataLength != 0 { return ERR_FORMAT }
+                if metaTypes[header.Type] && header.Type != uint16(DNS_QUERY_ALL) { return ERR_FORMAT }
+            case record.CLASS_NONE:
+                if header.TTL != 0 || metaTypes[header.Type] { return ERR_FORMAT }
+            default:
+                return ERR_FORMAT
+        }
+    }
+
+    return 0
+}
+
+//
+// Check the prerequisites against the store (RFC 2136 3.2)
+//
+func checkPrerequisites(backing store.DNSStore, zone *store.Zone, prerequisites []record.Record) int {
+    // RRsets that must exist exactly as given, by name and type
+    var expected = make(map[string][]record.Record, 0)
+    var order = make([]string, 0)
+
+    for _, rec := range prerequisites {
+        var header = record.HeaderOf(rec)
+        if header.TTL != 0 { return ERR_FORMAT }
+        if !zone.Contains(header.Name) { return ERR_NOTZONE }
+
+        var anyType = header.Type == uint16(DNS_QUERY_ALL)
+
+        switch header.Class {
+            case record.CLASS_ANY:
+                // the name is in use, or the RRset exists
+                if header.RDataLength != 0 { return ERR_FORMAT }
+                if anyType && backing.LabelSize(header.Name) == 0 { return ERR_NOEXIST }
+                if !anyType && len(rrset(backing, header.Name, header.Type)) == 0 { return ERR_NXRRSET }
+
+            case record.CLASS_NONE:
+                // the name is not in use, or the RRset does not exist
+                if header.RDataLength != 0 { return ERR_FORMAT }
+                if anyType && backing.LabelSize(header.Name) > 0 { return ERR_YXDOMAIN }
+                if !anyType && len(rrset(backing, header.Name, header.Type)) > 0 { return ERR_YXRRSET }
+
+            case record.CLASS_IN:
+                if anyType { return ERR_FORMAT }
+
+                var key = record.Canonical(header.Name) + " " + strconv.Itoa(int(header.Type))
+                if _, seen := expected[key] ; !seen { order = append(order, key) }
+                expected[key] = append(expected[key], rec)
+
+            default:
+                return ERR_FORMAT
+        }
+    }
+
+    // value dependent prerequisites must match the RRset exactly
+    for _, key := range order {
+        var given = expected[key]
+        var existing = rrset(backing, given[0].GetLabel(), given[0].GetType())
+
+        if len(existing) != len(given) { return ERR_NXRRSET }
+        for _, rec := range given {
+            if record.IndexOfData(existing, rec) < 0 { return ERR_NXRRSET }
+        }
+    }
+
+    return 0
+}
+
+//
+// Apply the updates to the store (RFC 2136 3.4.2)
+// Returns the zone's new SOA when anything changed -- the serial is incremented unless an update replaced the SOA itself
+//
+func applyUpdates(backing store.DNSStore, zone *store.Zone, updates []record.Record) (*record.SOARecord, error) {
+    var changed bool
+    var replacedSOA *record.SOARecord
+
+    for _, rec := range updates {
+        var header = record.HeaderOf(rec)
+        var apex = record.NamesEqual(header.Name, zone.Origin)
+
+        // the SOA and NS records of the zone's apex are never deleted wholesale
+        var protected = func(rType uint16) bool {
+            return apex && (rType == record.SOA_RECORD || rType == record.NS_RECORD)
+        }
+
+        var updated bool
+        var err error
+        switch header.Class {
+            case record.CLASS_IN:
+                updated, err = addRecord(backing, apex, rec)
+                if soa, isSOA := rec.(*record.SOARecord) ; isSOA && updated { replacedSOA = soa }
+
+            case record.CLASS_ANY:
+                // delete an RRset, or every RRset at the name
+                for _, rType := range presentTypes(backing, header.Name) {
+                    if header.Type != uint16(DNS_QUERY_ALL) && header.Type != rType { continue }
+                    if protected(rType) { continue }
+
+                    if err = setRRset(backing, header.Name, rType, nil) ; err != nil { break }
+                    updated = true
+                }
+
+            case record.CLASS_NONE:
+                // delete a single record -- but never the SOA, nor the apex's last NS
+                if header.Type == record.SOA_RECORD { continue }
+
+                var existing = rrset(backing, header.Name, header.Type)
+                var i = record.IndexOfData(existing, rec)
+                if i < 0 { continue }
+
+                var kept = append(existing[:i:i], existing[i + 1:]...)
+                if protected(header.Type) && len(kept) == 0 { continue }
+
+                err = setRRset(backing, header.Name, header.Type, kept)
+                updated = err == nil
+        }
+
+        if err != nil { return nil, err }
+        changed = changed || updated
+    }
+
+    if !changed { return nil, nil }
+    if replacedSOA != nil { return replacedSOA, nil }
+
+    // bump the serial so secondaries notice the change (RFC 2136 3.6)
+    var current, _ = backing.Find(zone.Origin, record.SOA_RECORD)
+    var soa, isSOA = current.(*record.SOARecord)
+    if !isSOA {
+        var copied = *zone.SOA
+        soa = &copied
+    }
+    soa.Serial += 1
+
+    if err := setRRset(backing, zone.Origin, record.SOA_RECORD, []record.Record{ soa }) ; err != nil {
+        return nil, err
+    }
+    return soa, nil
+}
+
+//
+// Add a record unless it conflicts with a CNAME, replacing any record with the same data (a new TTL)
+// An SOA is only taken at the apex, and only with a newer serial
+//
+func addRecord(backing store.DNSStore, apex bool, rec record.Record) (bool, error) {
+    var name, rType = rec.GetLabel(), rec.GetType()
+    var existing = rrset(backing, name, uint16(DNS_QUERY_ALL))
+
+    // a CNAME may not share its name with other data (RFC 2136 3.4.2.2)
+    for _, curr := range existing {
+        if (rType == record.CNAME_RECORD) != (curr.GetType() == record.CNAME_RECORD) { return false, nil }
+    }
+
+    switch rType {
+        case record.SOA_RECORD:
+            if !apex { return false, nil }
+
+            var current = rrset(backing, name, record.SOA_RECORD)
+            if len(current) > 0 && !serialNewer(rec.(*record.SOARecord).Serial, current[0].(*record.SOARecord).Serial) {
+                return false, nil
+            }
+            return true, setRRset(backing, name, rType, []record.Record{ rec })
+
+        case record.CNAME_RECORD:
+            return true, setRRset(backing, name, rType, []record.Record{ rec })
+    }
+
+    var same = rrset(backing, name, rType)
+    if i := record.IndexOfData(same, rec) ; i >= 0 {
+        if record.HeaderOf(same[i]).TTL == record.HeaderOf(rec).TTL { return false, nil }
+        same = append(same[:i:i], same[i + 1:]...)
+    }
+
+    return true, setRRset(backing, name, rType, append(same, rec))
+}
+
+//----------------------------------------------
+// RRset Helpers
+//----------------------------------------------
+
+//
+// The records of a type (or every type) stored at exactly the name -- wildcards are not consulted
+//
+func rrset(backing store.DNSStore, name string, rType uint16) []record.Record {
+    if backing.LabelSize(name) == 0 { return nil }
+
+    var records, err = backing.FindLabel(name)
+    if err != nil { return nil }
+
+    var result = make([]record.Record, 0, len(records))
+    for _, rec := range records {
+        if rType == uint16(DNS_QUERY_ALL) || rec.GetType() == rType {
+            result = append(result, rec)
+        }
+    }
+
+    return result
+}
+
+//
+// The types with records at exactly the name, in the order first stored
+//
+func presentTypes(backing store.DNSStore, name string) []uint16 {
+    var result = make([]uint16, 0)
+    var seen = make(map[uint16]bool, 0)
+
+    for _, rec := range rrset(backing, name, uint16(DNS_QUERY_ALL)) {
+        if !seen[rec.GetType()] {
+            seen[rec.GetType()] = true
+            result = append(result, rec.GetType())
+        }
+    }
+
+    return result
+}
+
+//
+// Replace every record of a type at a name with the given records (none deletes the RRset)
+//
+func setRRset(backing store.DNSStore, name string, rType uint16, records []record.Record) error {
+    for {
+        var err = backing.FindAndDelete(name, rType)
+        if err == store.ErrNotFound { break }
+        if err != nil { return err }
+    }
+
+    for _, rec := range records {
+        if err := backing.Add(rec) ; err != nil { return err }
+    }
+
+    return nil
+}
+
+//
+// Compare serial numbers in sequence space arithmetic (RFC 1982)
+//
+func serialNewer(serial, than uint32) bool {
+    return int32(serial - than) > 0
+}
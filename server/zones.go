@@ -0,0 +1,238 @@
+package server
+
+import (
+    "io"
+
+    "github.com/zmarcantel/phonebook/dns"
+    "github.com/zmarcantel/phonebook/dns/record"
+    "github.com/zmarcantel/phonebook/server/store"
+)
+
+//
+// Make the server authoritative for a zone, adding its SOA and NS records to the store
+//
+func (self *Server) AddZone(zone *store.Zone) error {
+    if self.Zones == nil { self.Zones = store.NewZones() }
+
+    var err = self.Zones.Add(zone)
+    if err != nil { return err }
+
+    err = self.Store.Add(zone.SOA)
+    if err != nil { return err }
+
+    for _, ns := range zone.NS {
+        err = self.Store.Add(ns)
+        if err != nil { return err }
+    }
+
+    return nil
+}
+
+//
+// Parse an RFC 1035 master file into the zone it describes (its SOA and NS records at the origin)
+// and the rest of its records
+//
+func ReadZone(source io.Reader, origin string) (*store.Zone, []record.Record, error) {
+    records, err := store.ParseZone(source, origin)
+    if err != nil { return nil, nil, err }
+
+    var soa *record.SOARecord
+    var ns = make([]*record.NSRecord, 0)
+    var rest = make([]record.Record, 0, len(records))
+
+    for _, rec := range records {
+        var apex = record.NamesEqual(rec.GetLabel(), origin)
+        switch typed := rec.(type) {
+            case *record.SOARecord:
+                if apex && soa == nil {
+                    soa = typed
+                    continue
+                }
+            case *record.NSRecord:
+                if apex {
+                    ns = append(ns, typed)
+                    continue
+                }
+        }
+        rest = append(rest, rec)
+    }
+
+    zone, err := store.NewZone(soa, ns...)
+    if err != nil { return nil, nil, err }
+
+    return zone, rest, nil
+}
+
+//
+// Serve the zone in an RFC 1035 master file, adding every record in it to the store
+// Nothing is added if the file fails to parse
+//
+func (self *Server) LoadZone(source io.Reader, origin string) (*store.Zone, error) {
+    zone, records, err := ReadZone(source, origin)
+    if err != nil { return nil, err }
+
+    if err = self.AddZone(zone) ; err != nil { return nil, err }
+
+    for _, rec := range records {
+        if err = self.Store.Add(rec) ; err != nil { return zone, err }
+    }
+
+    return zone, nil
+}
+
+//
+// Swap every record of a zone for its SOA, NS, and the given records, adding the zone if it is new
+// The store changes as one batch (see store.Batcher), so queries see the zone entirely before or after
+// Names in other zones we serve beneath it are left to them, and the zone's journal is forgotten
+//
+func (self *Server) ReplaceZone(zone *store.Zone, records []record.Record) error {
+    return self.ReplaceZones([]*store.Zone{ zone }, [][]record.Record{ records }, nil)
+}
+
+//
+// Stop serving a zone, deleting its records from the store (as one batch)
+//
+func (self *Server) RemoveZone(origin string) error {
+    if self.Zones == nil { return store.ErrNotFound }
+    if zone := self.Zones.Find(origin) ; zone == nil || zone.Origin != record.Canonical(origin) { return store.ErrNotFound }
+
+    return self.ReplaceZones(nil, nil, []string{ origin })
+}
+
+//
+// Replace several zones (see ReplaceZone, records[i] going with zones[i]) and stop serving others, all in one batch:
+// if any change fails the store is left as it was and no zone is added or removed, so a reload is never half applied
+// Origins to remove that are not served are ignored
+//
+func (self *Server) ReplaceZones(zones []*store.Zone, records [][]record.Record, removed []string) error {
+    if self.Zones == nil { self.Zones = store.NewZones() }
+
+    // only zones actually served are cleared -- the closest zone of another origin is not theirs to empty
+    var removing = make([]string, 0, len(removed))
+    for _, origin := range removed {
+        origin = record.Canonical(origin)
+        if zone := self.Zones.Find(origin) ; zone != nil && zone.Origin == origin {
+            removing = append(removing, origin)
+        }
+    }
+
+    // UPDATEs are held off so none is lost between the old records and the new
+    self.updating.Lock()
+    defer self.updating.Unlock()
+
+    var err = store.Batch(self.Store, func(backing store.DNSStore) error {
+        for _, origin := range removing {
+            if err := self.clearZone(backing, origin) ; err != nil { return err }
+        }
+
+        for i, zone := range zones {
+            if err := self.clearZone(backing, zone.Origin) ; err != nil { return err }
+
+            var apex = append([]record.Record{ zone.SOA }, nsRecords(zone)...)
+            for _, rec := range append(apex, records[i]...) {
+                // records for the zones beneath are theirs to serve
+                if !self.owns(zone.Origin, rec.GetLabel()) { continue }
+                if err := backing.Add(rec) ; err != nil { return err }
+            }
+        }
+
+        return nil
+    })
+    if err != nil { return err }
+
+    for _, origin := range removing {
+        self.Journal.Clear(origin)
+        self.Zones.Remove(origin)
+    }
+    for _, zone := range zones {
+        self.Zones.Put(zone)
+        self.Journal.Clear(zone.Origin)
+    }
+
+    return nil
+}
+
+//
+// Delete every record the zone with the given origin owns
+//
+func (self *Server) clearZone(backing store.DNSStore, origin string) error {
+    type rrsetKey struct {
+        name        string
+        rType       uint16
+    }
+
+    var seen = make(map[rrsetKey]bool, 0)
+    var order = make([]rrsetKey, 0)
+
+    var err = backing.Walk(func(rec record.Record) error {
+        if !self.owns(origin, rec.GetLabel()) { return nil }
+
+        var key = rrsetKey{ record.Canonical(rec.GetLabel()), rec.GetType() }
+        if !seen[key] {
+            seen[key] = true
+            order = append(order, key)
+        }
+        return nil
+    })
+    if err != nil { return err }
+
+    for _, key := range order {
+        if err = setRRset(backing, key.name, key.rType, nil) ; err != nil { return err }
+    }
+
+    return nil
+}
+
+//
+// Check if a name belongs to the zone with the given origin: it is at or beneath the origin,
+// and not in (nor delegated to) another zone we serve beneath it
+//
+func (self *Server) owns(origin, name string) bool {
+    if !record.Name(record.Canonical(name)).IsSubdomain(record.Name(origin)) { return false }
+
+    var closest = self.Zones.Find(name)
+    return closest == nil || closest.Origin == origin || !record.Name(closest.Origin).IsSubdomain(record.Name(origin))
+}
+
+func nsRecords(zone *store.Zone) []record.Record {
+    var result = make([]record.Record, 0, len(zone.NS))
+    for _, ns := range zone.NS {
+        result = append(result, ns)
+    }
+
+    return result
+}
+
+//
+// Find the zone the questions fall under
+// With no zones configured the server answers for every name (and zone is nil),
+// otherwise refused is true when the questions fall outside all of them
+//
+func (self *Server) findZone(questions []dns.Question) (zone *store.Zone, refused bool) {
+    if self.Zones == nil || self.Zones.Size() == 0 || len(questions) == 0 {
+        return nil, false
+    }
+
+    zone = self.Zones.Find(questions[0].Name)
+    return zone, zone == nil
+}
+
+//
+// Add the zone's SOA to the authority section of NXDOMAIN and NODATA responses
+// so resolvers know how long to cache the negative answer (RFC 2308 3)
+//
+func negativeAuthority(response *dns.Message, zone *store.Zone) {
+    if zone == nil || len(response.Answers) > 0 {
+        return
+    }
+    if response.Header.Rcode != 0 && response.Header.Rcode != ERR_NOEXIST {
+        return
+    }
+
+    // copy the SOA so the stored record keeps its own TTL
+    var soa = *zone.SOA
+    soa.TTL = zone.SOA.NegativeTTL()
+
+    response.Ns = append(response.Ns, record.Record(&soa))
+    response.Header.NSCount = uint16(len(response.Ns))
+}